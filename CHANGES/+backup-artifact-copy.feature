@@ -0,0 +1 @@
+Added the `artifact_copy` field to PulpBackup and PulpBackupSchedule to copy, fully or incrementally, the content stored in Azure, S3, or GCS into the backup, which is copied back into the bucket during a restore.
@@ -0,0 +1 @@
+Added the `consistency_mode` field to PulpBackup and PulpBackupSchedule to scale down the workers (or the API and workers) while the database and the content are backed up.
@@ -0,0 +1 @@
+Added the database_dump_format, database_dump_compression and database_dump_jobs fields to PulpBackup and PulpBackupSchedule to make compressed and parallel database dumps, and the database_restore_jobs field to PulpRestore to restore them with parallel pg_restore jobs.
@@ -0,0 +1 @@
+Added the deletion_policy field to PulpBackup and PulpBackupSchedule to remove the backup data (backup directory, object storage copy and VolumeSnapshots) when the PulpBackup CR is deleted.
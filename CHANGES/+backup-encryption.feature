@@ -0,0 +1 @@
+Added the `encryption_secret` field to PulpBackup, PulpBackupSchedule and PulpRestore to encrypt the backup files with a passphrase and decrypt them during the restore.
@@ -0,0 +1 @@
+Added backup format versioning: backups made by older operator versions (including the ones without manifest) are converted on restore, and backups made by a newer operator are rejected.
@@ -0,0 +1 @@
+Added the pre_backup_hooks and post_backup_hooks fields to PulpBackup and PulpBackupSchedule to run commands, with an Abort or Continue failure policy, before and after the database and Pulp dir backups. The post_backup_hooks also run, with BACKUP_FAILED=true, when the backup fails.
@@ -0,0 +1 @@
+Added the pulp_dir_copy field to PulpBackup and PulpBackupSchedule to hard link the unmodified files of /var/lib/pulp from the previous backup and copy only the new or modified ones.
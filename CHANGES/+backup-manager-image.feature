@@ -0,0 +1 @@
+Selected the image of the backup and restore jobs from the database configuration of Pulp (detecting the server version of external databases), and added the backup_manager_image, image_pull_policy, image_pull_secrets and resource_requirements fields to PulpBackup, PulpBackupSchedule and PulpRestore.
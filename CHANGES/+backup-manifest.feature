@@ -0,0 +1 @@
+Added a manifest.json with checksums of the backup files, which is verified before running a restore.
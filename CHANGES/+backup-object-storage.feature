@@ -0,0 +1 @@
+Added the object_storage field to PulpBackup and PulpRestore to upload backups to, and restore them from, an S3-compatible object storage, with the access keys from the s3_secret or the credentials from the environment.
@@ -0,0 +1 @@
+Added the per-phase records (timing, files and bytes processed, and last error) to the PulpBackup and PulpRestore status and the Events emitted on each phase transition.
@@ -0,0 +1 @@
+Backup and restore steps now run in Jobs tracked by `.status.phase`, so the operator no longer blocks while they run and resumes them after a restart.
@@ -0,0 +1 @@
+Added the PulpBackupSchedule CR to run periodic backups, defined by its backup_template field, with keep-last, keep-daily and keep-weekly retention rules.
@@ -0,0 +1 @@
+Added the backup_mode field to PulpBackup and PulpBackupSchedule to take CSI VolumeSnapshots of the file storage and database PVCs instead of copying /var/lib/pulp, and restore the PVCs from them.
@@ -0,0 +1 @@
+Added restore verification drills to PulpBackupSchedule: the `verify` field periodically restores the latest scheduled backup into a throwaway namespace and checks the status endpoint of the restored Pulp API. The drills require a cluster-wide installation and the opt-in restore-drill-role ClusterRole.
@@ -0,0 +1 @@
+Added `database.high_availability` to deploy the database with streaming replicas and an automatic failover of the primary, the database Service points to the primary through a role label maintained by the operator and a PDB covers the database pods.
@@ -0,0 +1 @@
+Added `database.pooler` to deploy a PgBouncer connection pooler, in session or transaction mode, between the Pulp components and the managed or external database.
@@ -0,0 +1 @@
+Added `database.tls` to serve the database deployed by the operator over TLS, with a certificate issued by the operator or provided in a Secret, verified by the Pulp components.
@@ -0,0 +1 @@
+Added `database.tuning` to generate the PostgreSQL parameters of the managed database from its resources and the Pulp components.
@@ -0,0 +1 @@
+Added an automated upgrade of the PostgreSQL major version of the database deployed by the operator: the database is dumped into a new PVC and reloaded with the new image, the previous PVC is kept for a rollback.
@@ -0,0 +1 @@
+Added the `components` field to PulpRestore to restore only the selected components of a backup (like the database, or the secrets and the Pulp CR) onto an existing Pulp.
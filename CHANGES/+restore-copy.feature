@@ -0,0 +1 @@
+Added the `ingress_host` and `route_host` fields to PulpRestore and support to restore a copy of Pulp with a different name or into a different namespace, renaming the restored Secrets, ConfigMaps and PVCs.
@@ -0,0 +1 @@
+Added the `dry_run` field to PulpRestore to report, in `.status.dryRunReport`, the objects that a restore would create or overwrite, the backup size, and Pulp image mismatches without modifying Pulp.
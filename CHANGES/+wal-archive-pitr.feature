@@ -0,0 +1 @@
+Added WAL archiving to a PVC or an S3-compatible object storage for the database deployed by the operator, and point-in-time recovery with PulpRestore `point_in_time`.
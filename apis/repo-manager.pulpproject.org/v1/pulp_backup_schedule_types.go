@@ -0,0 +1,193 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PulpBackupScheduleSpec defines the desired state of PulpBackupSchedule
+type PulpBackupScheduleSpec struct {
+
+	// The schedule in Cron format, see https://en.wikipedia.org/wiki/Cron.
+	// For example, "0 2 * * *" will run a backup every day at 2:00 AM.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Schedule string `json:"schedule"`
+
+	// Suspend tells the controller to stop creating new PulpBackups.
+	// Retention rules are still applied to the existing backups.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:booleanSwitch"}
+	Suspend bool `json:"suspend,omitempty"`
+
+	// BackupTemplate is the spec of the PulpBackups created by the schedule, deployment_name is required.
+	// All the scheduled backups share the same backup_pvc.
+	// Default backup_pvc: <PulpBackupSchedule name>-backup-claim
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	BackupTemplate PulpBackupSpec `json:"backup_template"`
+
+	// Retention defines which of the scheduled backups should be kept.
+	// If not provided, all the backups are kept.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Retention *BackupRetention `json:"retention,omitempty"`
+
+	// Verify periodically restores the most recent scheduled backup into a throwaway namespace
+	// to make sure that the backups can be restored.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Verify *BackupVerification `json:"verify,omitempty"`
+}
+
+// BackupVerification defines the restore verification drills of the scheduled backups.
+// Each drill restores the most recent successful scheduled backup, from object_storage, into a new
+// namespace, waits for Pulp to be ready, checks the status endpoint of its API and removes the namespace.
+type BackupVerification struct {
+
+	// The schedule of the drills in Cron format, see https://en.wikipedia.org/wiki/Cron.
+	// For example, "0 6 * * 0" will run a drill every Sunday at 6:00 AM.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Schedule string `json:"schedule"`
+
+	// Name of the namespace created for each drill. It should not exist, since it is deleted after the drill.
+	// Default: <PulpBackupSchedule name>-verify
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Namespace string `json:"namespace,omitempty"`
+
+	// Ingress hostname of the restored Pulp, required if the backed up Pulp defines ingress_host.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	IngressHost string `json:"ingress_host,omitempty"`
+
+	// Route hostname of the restored Pulp, required if the backed up Pulp defines route_host.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	RouteHost string `json:"route_host,omitempty"`
+
+	// Maximum time, in minutes, for the restore, Pulp to get ready and the smoke check.
+	// Default: 120
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum:=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:number"}
+	TimeoutMinutes int32 `json:"timeout_minutes,omitempty"`
+
+	// Keep the namespace of a failed drill for inspection. It is deleted before the next drill.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:booleanSwitch"}
+	KeepOnFailure bool `json:"keep_on_failure,omitempty"`
+}
+
+// BackupVerificationResult is the result of a restore verification drill
+type BackupVerificationResult struct {
+	// Name of the PulpBackup restored
+	Backup string `json:"backup"`
+
+	// Directory of the backup restored
+	BackupDirectory string `json:"backupDirectory,omitempty"`
+
+	// Namespace where the backup was restored
+	Namespace string `json:"namespace"`
+
+	// State of the drill (Running, Succeeded, or Failed)
+	State string `json:"state"`
+
+	// Time the drill started
+	StartTime metav1.Time `json:"startTime"`
+
+	// Time the drill finished
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Versions of the Pulp components reported by the status endpoint of the restored Pulp,
+	// or the reason of the failure
+	Message string `json:"message,omitempty"`
+}
+
+// BackupRetention defines the rules used to prune old scheduled backups.
+// A backup is kept if it matches any of the rules.
+type BackupRetention struct {
+
+	// Number of most recent backups to keep
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum:=0
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:number"}
+	KeepLast int `json:"keep_last,omitempty"`
+
+	// Number of days for which the most recent backup of the day is kept
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum:=0
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:number"}
+	KeepDaily int `json:"keep_daily,omitempty"`
+
+	// Number of weeks for which the most recent backup of the week is kept
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum:=0
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:number"}
+	KeepWeekly int `json:"keep_weekly,omitempty"`
+}
+
+// PulpBackupScheduleStatus defines the observed state of PulpBackupSchedule
+type PulpBackupScheduleStatus struct {
+	//+operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors={"urn:alm:descriptor:io.kubernetes.conditions"}
+	Conditions []metav1.Condition `json:"conditions"`
+
+	// Last time a PulpBackup was created by this schedule
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// Name of the last PulpBackup created by this schedule
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	LastBackup string `json:"lastBackup,omitempty"`
+
+	// The PVC name used for the scheduled backups
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	BackupClaim string `json:"backupClaim,omitempty"`
+
+	// Last time a restore verification drill was started
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	LastVerificationTime *metav1.Time `json:"lastVerificationTime,omitempty"`
+
+	// Results of the most recent restore verification drills, the last one can still be running
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	VerificationResults []BackupVerificationResult `json:"verificationResults,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+
+// PulpBackupSchedule is the Schema for the pulpbackupschedules API
+type PulpBackupSchedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PulpBackupScheduleSpec   `json:"spec,omitempty"`
+	Status PulpBackupScheduleStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PulpBackupScheduleList contains a list of PulpBackupSchedule
+type PulpBackupScheduleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PulpBackupSchedule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PulpBackupSchedule{}, &PulpBackupScheduleList{})
+}
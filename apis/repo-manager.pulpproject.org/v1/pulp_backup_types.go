@@ -70,6 +70,231 @@ type PulpBackupSpec struct {
 	// +kubebuilder:validation:Optional
 	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced"}
 	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// ObjectStorage defines an S3-compatible object storage where a copy of the
+	// backup directory is uploaded after all the backup tasks finish.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ObjectStorage *BackupObjectStorage `json:"object_storage,omitempty"`
+
+	// Copy the content stored in the object storage used by Pulp (object_storage_azure_secret,
+	// object_storage_s3_secret, or object_storage_gcs_secret) into the backup.
+	// Full copies all the objects in every backup.
+	// Incremental hard links the objects from the previous backup and transfers only the new or modified ones.
+	// If not defined, the content from object storage is not copied.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum:=Full;Incremental
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ArtifactCopy string `json:"artifact_copy,omitempty"`
+
+	// Defines how Pulp is quiesced while the database, the Pulp dir, and the object storage content
+	// are backed up, so the database dump does not reference content missing from the backup.
+	// None: Pulp keeps running normally.
+	// StopWorkers: the pulp-worker deployment is scaled to zero, so no task runs during the backup.
+	// StopApiAndWorkers: the pulp-api and pulp-worker deployments are scaled to zero, only the content app keeps
+	// serving the content.
+	// Pulp has no read-only mode for its API, StopApiAndWorkers is used to stop the writes through the API.
+	// The replicas and HPA settings are restored after the backup, even if it fails.
+	// Default: None
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum:=None;StopWorkers;StopApiAndWorkers
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ConsistencyMode string `json:"consistency_mode,omitempty"`
+
+	// Name of the Secret with the passphrase (passphrase key) used to encrypt the backup files.
+	// The database dump, the backed up Secrets and Pulp CR, the content of /var/lib/pulp and the content
+	// copied from object storage are encrypted before they are written into the backup PVC.
+	// The same passphrase is needed to restore the backup.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:io.kubernetes:Secret"}
+	EncryptionSecret string `json:"encryption_secret,omitempty"`
+
+	// Defines how the content of /var/lib/pulp is copied into the backup PVC.
+	// Full copies all the files in every backup.
+	// Incremental hard links the files from the previous backup (in the style of rsync --link-dest)
+	// and copies only the new or modified ones, each backup directory can still be restored on its own.
+	// Incremental can not be used with encryption_secret, the encrypted Pulp dir is a single archive.
+	// Default: Full
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum:=Full;Incremental
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	PulpDirCopy string `json:"pulp_dir_copy,omitempty"`
+
+	// Defines how the Pulp dir is backed up.
+	// Copy: the content of /var/lib/pulp is copied into the backup PVC.
+	// Snapshot: a CSI VolumeSnapshot of the file storage PVC is taken instead of the copy. The database
+	// PVC is also snapshotted when the database is managed by the operator and consistency_mode is StopApiAndWorkers.
+	// The database dump, the secrets and the Pulp CR are still stored in the backup PVC.
+	// Snapshot can not be used with encryption_secret.
+	// Default: Copy
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum:=Copy;Snapshot
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	BackupMode string `json:"backup_mode,omitempty"`
+
+	// Name of the VolumeSnapshotClass used by backup_mode Snapshot.
+	// If not defined, the default VolumeSnapshotClass of the CSI driver is used.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	VolumeSnapshotClass string `json:"volume_snapshot_class,omitempty"`
+
+	// Archive format of the database dump (pg_dump --format).
+	// Tar: a single uncompressed tar archive.
+	// Custom: a single compressed archive, which can be restored with parallel jobs.
+	// Directory: a directory with one compressed file per table, which can be dumped and restored with
+	// parallel jobs. Directory can not be used with encryption_secret.
+	// Default: Tar
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum:=Tar;Custom;Directory
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	DatabaseDumpFormat string `json:"database_dump_format,omitempty"`
+
+	// Compression method and, optionally, level of the database dump (for example gzip:6 or zstd:3).
+	// It can not be used with database_dump_format Tar.
+	// lz4 and zstd require pg_dump 16 or newer (a database server 16 or newer with the images selected by the operator).
+	// Default: gzip with the default level of pg_dump
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern:=`^(none|gzip|lz4|zstd)(:[0-9]+)?$`
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	DatabaseDumpCompression string `json:"database_dump_compression,omitempty"`
+
+	// Number of tables dumped in parallel (pg_dump --jobs), each job opens a connection to the database.
+	// It can only be used with database_dump_format Directory.
+	// The restore runs the same number of pg_restore jobs by default.
+	// Default: 1
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum:=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	DatabaseDumpJobs int32 `json:"database_dump_jobs,omitempty"`
+
+	// Image with bash and the PostgreSQL client tools (pg_dump, pg_restore and psql) used by the backup jobs.
+	// If not defined, the postgres_image of the database deployed by the operator is used or, for an
+	// external database, the postgres image with the major version of the database server.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	BackupManagerImage string `json:"backup_manager_image,omitempty"`
+
+	// Image pull policy of the backup-manager image.
+	// Default: IfNotPresent
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum:=IfNotPresent;Always;Never
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:imagePullPolicy"}
+	ImagePullPolicy string `json:"image_pull_policy,omitempty"`
+
+	// Image pull secrets for the backup-manager image.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced"}
+	ImagePullSecrets []string `json:"image_pull_secrets,omitempty"`
+
+	// Resource requirements for the backup-manager container.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:resourceRequirements","urn:alm:descriptor:com.tectonic.ui:advanced"}
+	ResourceRequirements corev1.ResourceRequirements `json:"resource_requirements,omitempty"`
+
+	// Defines what happens to the backup when the PulpBackup CR is deleted.
+	// Retain: the backup directory, the copy uploaded to object_storage and the VolumeSnapshots are kept.
+	// Delete: the backup directory is removed from the backup PVC (the PVC itself is removed if it was
+	// provisioned for this backup, i.e., backup_pvc is not defined), the copy uploaded to object_storage
+	// and the VolumeSnapshots are also removed.
+	// Default: Retain
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum:=Retain;Delete
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	DeletionPolicy string `json:"deletion_policy,omitempty"`
+
+	// Hooks run, in order, before the database backup. They run after Pulp is quiesced by consistency_mode.
+	// There are no hooks per phase: the pre_backup_hooks and post_backup_hooks run once, around all the
+	// database, Pulp dir, and object storage content backups, which are done with Pulp quiesced.
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=name
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	PreBackupHooks []BackupHook `json:"pre_backup_hooks,omitempty"`
+
+	// Hooks run, in order, after the database, Pulp dir, and object storage content backups, before Pulp
+	// is resumed. If one of these backups, or a pre_backup_hook, fails they run after Pulp is resumed,
+	// with BACKUP_FAILED=true, before the backup is set Failed.
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=name
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	PostBackupHooks []BackupHook `json:"post_backup_hooks,omitempty"`
+}
+
+// BackupHook is a command run in a Job before or after the database and Pulp dir backups.
+// The backup PVC is mounted in /backups and the hook container gets the BACKUP_DIR and DEPLOYMENT_NAME
+// environment variables, BACKUP_FAILED ("true" if the post_backup_hooks run after the backup failed), and
+// the libpq ones (PGHOST, PGUSER, ...) from the database configuration.
+type BackupHook struct {
+	// Name of the hook, it is appended to the name of the Job that runs it
+	// +kubebuilder:validation:Pattern:=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength:=30
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Name string `json:"name"`
+
+	// Image of the hook container.
+	// Default: the backup-manager image or, if pulp_settings is true, the image of Pulp
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Image string `json:"image,omitempty"`
+
+	// Command run by the hook container, for example ["bash", "-c", "curl -d ... https://example.com"]
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Command []string `json:"command"`
+
+	// Environment variables of the hook container
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced"}
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// Mount the Pulp settings (settings.py and the database fields encryption key) in /etc/pulp, so that
+	// pulpcore-manager commands can be run by the hook.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	PulpSettings bool `json:"pulp_settings,omitempty"`
+
+	// Defines what happens when the hook fails (or exceeds timeout_seconds).
+	// Abort: the backup fails, Pulp is resumed and the pre_backup_hooks not run yet are skipped (the
+	// post_backup_hooks run with BACKUP_FAILED=true, a failed post_backup_hook does not stop them).
+	// Continue: the failure is recorded in .status.hookResults and the backup continues.
+	// Default: Abort
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum:=Abort;Continue
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	FailurePolicy string `json:"failure_policy,omitempty"`
+
+	// Maximum time, in seconds, the hook can run.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum:=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	TimeoutSeconds int64 `json:"timeout_seconds,omitempty"`
+}
+
+// BackupObjectStorage defines an S3-compatible object storage used to store the backups
+type BackupObjectStorage struct {
+
+	// Secret with the object storage credentials, in the same format as object_storage_s3_secret
+	// from Pulp CR (s3-access-key-id, s3-secret-access-key, s3-bucket-name, s3-endpoint, s3-region).
+	// Without the access keys, the credentials are gathered from the environment (for example, the IAM role of the service account).
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:io.kubernetes:Secret"}
+	S3Secret string `json:"s3_secret"`
+
+	// Name of the bucket where the backups are stored.
+	// Default: s3-bucket-name from s3_secret
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Bucket string `json:"bucket,omitempty"`
+
+	// Path, inside the bucket, where the backup directories are stored.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Prefix string `json:"prefix,omitempty"`
+
+	// Object storage endpoint, for example, the address of a MinIO server.
+	// Default: s3-endpoint from s3_secret
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Endpoint string `json:"endpoint,omitempty"`
 }
 
 // PulpBackupStatus defines the observed state of PulpBackup
@@ -96,6 +321,129 @@ type PulpBackupStatus struct {
 	// Administrator password secret used by the deployed instance
 	//+operator-sdk:csv:customresourcedefinitions:type=status
 	AdminPasswordSecret string `json:"adminPasswordSecret"`
+
+	// The object storage location the backup was uploaded to
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	ObjectStorageLocation string `json:"objectStorageLocation,omitempty"`
+
+	// Current step of the backup process.
+	// It is used to resume the backup in case the operator is restarted.
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	Phase string `json:"phase,omitempty"`
+
+	// Replicas and HPA settings of the Pulp components scaled down by consistency_mode.
+	// They are restored after the backup.
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	QuiescedComponents []QuiescedComponent `json:"quiescedComponents,omitempty"`
+
+	// VolumeSnapshots taken by backup_mode Snapshot
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	VolumeSnapshots []BackupVolumeSnapshot `json:"volumeSnapshots,omitempty"`
+
+	// Image used by the backup jobs, selected from the database configuration before the first job runs
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	BackupManagerImage string `json:"backupManagerImage,omitempty"`
+
+	// Records of the phases run by the backup, in the order they started
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	PhaseRecords []PhaseRecord `json:"phaseRecords,omitempty"`
+
+	// Results of the pre_backup_hooks and post_backup_hooks run
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	HookResults []BackupHookResult `json:"hookResults,omitempty"`
+}
+
+// BackupHookResult describes the result of a backup hook
+type BackupHookResult struct {
+	// Name of the hook
+	Name string `json:"name"`
+
+	// PreBackup or PostBackup
+	Stage string `json:"stage"`
+
+	// Succeeded or Failed
+	State string `json:"state"`
+
+	// Name of the Job that ran the hook
+	Job string `json:"job"`
+
+	// Time the hook finished
+	CompletionTime metav1.Time `json:"completionTime"`
+
+	// Last lines of the output of the failed hook
+	Message string `json:"message,omitempty"`
+
+	// True if the post_backup_hook ran after the backup failed
+	BackupFailed bool `json:"backupFailed,omitempty"`
+}
+
+// PhaseRecord describes a step of the backup or restore process
+type PhaseRecord struct {
+	// Name of the phase
+	Name string `json:"name"`
+
+	// Running, Succeeded, or Failed
+	State string `json:"state"`
+
+	// Time the phase started
+	StartTime metav1.Time `json:"startTime"`
+
+	// Time the phase finished (successfully or not)
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Name of the Job that runs the phase
+	Job string `json:"job,omitempty"`
+
+	// Number of files in the directory written by the Job, updated while it runs
+	FilesProcessed int64 `json:"filesProcessed,omitempty"`
+
+	// Size (in bytes) of the directory written by the Job, updated while it runs
+	BytesProcessed int64 `json:"bytesProcessed,omitempty"`
+
+	// Number of files to be copied by the Job, if it is known
+	TotalFiles int64 `json:"totalFiles,omitempty"`
+
+	// Size (in bytes) of the files to be copied by the Job, if it is known
+	TotalBytes int64 `json:"totalBytes,omitempty"`
+
+	// Last lines of the output of the failed Job, or the last error found while running the phase
+	LastError string `json:"lastError,omitempty"`
+}
+
+// BackupVolumeSnapshot describes a VolumeSnapshot taken during the backup and the PVC it was taken from
+type BackupVolumeSnapshot struct {
+	// Name of the VolumeSnapshot
+	Name string `json:"name"`
+
+	// Volume snapshotted (PulpDir or Database)
+	Volume string `json:"volume"`
+
+	// Name of the PVC snapshotted
+	PVC string `json:"pvc"`
+
+	// Storage class of the PVC snapshotted
+	StorageClass string `json:"storageClass,omitempty"`
+
+	// Access modes of the PVC snapshotted
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+
+	// Minimum size of a PVC provisioned from the VolumeSnapshot
+	Size string `json:"size,omitempty"`
+
+	// True when the VolumeSnapshot can be used to provision a PVC
+	ReadyToUse bool `json:"readyToUse,omitempty"`
+}
+
+// QuiescedComponent stores the settings of a Pulp component before it was scaled down
+type QuiescedComponent struct {
+	// Name of the component (Api, Content, Worker, or Web)
+	Name string `json:"name"`
+
+	// Number of replicas of the component
+	Replicas int32 `json:"replicas"`
+
+	// HPA configuration of the component
+	HPA *HPA `json:"hpa,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -17,6 +17,7 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -49,6 +50,100 @@ type PulpRestoreSpec struct {
 	// +kubebuilder:default:=false
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	KeepBackupReplicasCount bool `json:"keep_replicas"`
+
+	// ObjectStorage defines the S3-compatible object storage from where the backup directory
+	// will be downloaded into the backup PVC before running the restore.
+	// If the backup PVC is not found, it will be provisioned.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ObjectStorage *BackupObjectStorage `json:"object_storage,omitempty"`
+
+	// Storage requirements for the backup PVC provisioned to download the backup from object_storage
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	BackupStorageReq string `json:"backup_storage_requirements,omitempty"`
+
+	// Storage class to use when provisioning the backup PVC to download the backup from object_storage
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:io.kubernetes:StorageClass"}
+	BackupSC string `json:"backup_storage_class,omitempty"`
+
+	// Name of the Secret with the passphrase (passphrase key) used to decrypt the backup files.
+	// Required to restore a backup made with encryption_secret.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:io.kubernetes:Secret"}
+	EncryptionSecret string `json:"encryption_secret,omitempty"`
+
+	// Hostname of the ingress of the restored Pulp.
+	// Required to restore a copy (with a different deployment_name or into a different namespace) of a Pulp
+	// with an ingress_host, so that the copy does not take over the hostname of the original instance.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	IngressHost string `json:"ingress_host,omitempty"`
+
+	// Hostname of the route of the restored Pulp.
+	// Required to restore a copy (with a different deployment_name or into a different namespace) of a Pulp
+	// with a route_host, so that the copy does not take over the hostname of the original instance.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	RouteHost string `json:"route_host,omitempty"`
+
+	// Components of the backup to restore. If not defined, all of them are restored.
+	// Restoring only some of them (like the Database, or the Secrets and the PulpCR) allows to
+	// recover an existing Pulp deployment, in which case the selected secrets, configmaps and Pulp CR
+	// replace the existing ones and Pulp is scaled down while its data is restored.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:items:Enum:=Secrets;ConfigMaps;PulpCR;Database;PulpDir;Artifacts
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Components []string `json:"components,omitempty"`
+
+	// Verify the backup and report what the restore would do in .status.dryRunReport (the objects that
+	// would be created or overwritten, the size of the database dump, the number of files, and if the
+	// Pulp image differs from the one backed up) without modifying Pulp.
+	// Set it to false to run the restore after reviewing the report.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Recover the database to this point in time from the WAL archive of Pulp (database.wal_archive),
+	// instead of restoring the database dump from backup. It requires the database deployed by the operator.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Format=date-time
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	PointInTime *metav1.Time `json:"point_in_time,omitempty"`
+
+	// Number of parallel pg_restore jobs used to restore a database dump in the Custom or Directory
+	// format, each job opens a connection to the database. Dumps in the Tar format and encrypted dumps
+	// are restored with a single job.
+	// Default: the database_dump_jobs of the backup
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum:=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	DatabaseRestoreJobs int32 `json:"database_restore_jobs,omitempty"`
+
+	// Image with bash and the PostgreSQL client tools (pg_dump, pg_restore and psql) used by the restore jobs.
+	// If not defined, the postgres_image of the database deployed by the operator is used or, for an
+	// external database, the postgres image with the major version of the database server.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	BackupManagerImage string `json:"backup_manager_image,omitempty"`
+
+	// Image pull policy of the backup-manager image.
+	// Default: IfNotPresent
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum:=IfNotPresent;Always;Never
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:imagePullPolicy"}
+	ImagePullPolicy string `json:"image_pull_policy,omitempty"`
+
+	// Image pull secrets for the backup-manager image.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced"}
+	ImagePullSecrets []string `json:"image_pull_secrets,omitempty"`
+
+	// Resource requirements for the backup-manager container.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:resourceRequirements","urn:alm:descriptor:com.tectonic.ui:advanced"}
+	ResourceRequirements corev1.ResourceRequirements `json:"resource_requirements,omitempty"`
 }
 
 // PulpRestoreStatus defines the observed state of PulpRestore
@@ -58,6 +153,68 @@ type PulpRestoreStatus struct {
 
 	//+operator-sdk:csv:customresourcedefinitions:type=status
 	PostgresSecret string `json:"postgres_secret"`
+
+	// Current step of the restore process.
+	// It is used to resume the restore in case the operator is restarted.
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	Phase string `json:"phase,omitempty"`
+
+	// Replicas and HPA settings of the components of an existing Pulp, which is scaled down while
+	// its data is restored. They are restored at the end of the restore.
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	QuiescedComponents []QuiescedComponent `json:"quiescedComponents,omitempty"`
+
+	// What the restore would do, reported by a dry run
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	DryRunReport *RestoreDryRunReport `json:"dryRunReport,omitempty"`
+
+	// Image used by the restore jobs, selected once the backup is verified
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	BackupManagerImage string `json:"backupManagerImage,omitempty"`
+
+	// Records of the phases run by the restore, in the order they started
+	//+operator-sdk:csv:customresourcedefinitions:type=status
+	PhaseRecords []PhaseRecord `json:"phaseRecords,omitempty"`
+}
+
+// RestoreDryRunReport describes the changes that a restore would make
+type RestoreDryRunReport struct {
+	// Secrets, ConfigMaps and Pulp CR that would be restored
+	Objects []RestoreDryRunObject `json:"objects,omitempty"`
+
+	// Size (in bytes) of the database dump
+	DatabaseDumpSize int64 `json:"databaseDumpSize"`
+
+	// Number of files in the backup directory
+	FileCount int64 `json:"fileCount"`
+
+	// Total size (in bytes) of the files in the backup directory
+	BackupSize int64 `json:"backupSize"`
+
+	// Pulp image deployed when the backup was made
+	BackupImage string `json:"backupImage,omitempty"`
+
+	// Pulp image that would be deployed after the restore
+	PulpImage string `json:"pulpImage,omitempty"`
+
+	// True if the Pulp image that would be deployed is different from the one backed up
+	ImageMismatch bool `json:"imageMismatch,omitempty"`
+
+	// True if the restore-lock ConfigMap was found, in which case the restore will not run until it is deleted
+	RestoreLocked bool `json:"restoreLocked,omitempty"`
+}
+
+// RestoreDryRunObject is an object that would be restored
+type RestoreDryRunObject struct {
+	// Kind of the object (Secret, ConfigMap, or Pulp)
+	Kind string `json:"kind"`
+
+	// Name of the restored object
+	Name string `json:"name"`
+
+	// What the restore would do with the object: Create (not found), Overwrite (found and selected in
+	// components), or Keep (found and not selected in components)
+	Action string `json:"action"`
 }
 
 //+kubebuilder:object:root=true
@@ -762,7 +762,7 @@ type Database struct {
 	PostgresPort int `json:"postgres_port,omitempty"`
 
 	// Configure PostgreSQL connection sslmode option.
-	// Default: "prefer"
+	// Default: "prefer", or "verify-full" if tls is defined
 	// +kubebuilder:validation:Optional
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	PostgresSSLMode string `json:"postgres_ssl_mode,omitempty"`
@@ -850,6 +850,161 @@ type Database struct {
 	// +kubebuilder:validation:Optional
 	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced"}
 	PodLabels map[string]string `json:"pod_labels,omitempty"`
+
+	// Continuous archiving of the WAL of the database deployed by the operator, with periodic base
+	// backups, to allow point-in-time recovery with PulpRestore point_in_time.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced"}
+	WALArchive *WALArchive `json:"wal_archive,omitempty"`
+
+	// Streaming replicas of the database deployed by the operator, with automatic failover of the primary.
+	// It cannot be used with pvc or wal_archive, nor on a database upgraded to a new PostgreSQL major version.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced"}
+	HighAvailability *DatabaseHighAvailability `json:"high_availability,omitempty"`
+
+	// PgBouncer deployed by the operator in front of the database, used by pulpcore to share a small
+	// number of server connections between its processes.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced"}
+	Pooler *DatabasePooler `json:"pooler,omitempty"`
+
+	// PostgreSQL parameters of the database deployed by the operator, sized from the database resource
+	// requirements and the number of Pulp processes.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced"}
+	Tuning *DatabaseTuning `json:"tuning,omitempty"`
+
+	// TLS of the database deployed by the operator. The pulpcore containers verify the certificate of the
+	// database with its CA.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced"}
+	TLS *DatabaseTLS `json:"tls,omitempty"`
+}
+
+// WALArchive defines where the WAL segments and the base backups of the database are archived.
+// One of pvc or object_storage must be defined.
+type WALArchive struct {
+	// Name of the PersistentVolumeClaim, provisioned by the user, where the WAL and the base backups are stored
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:io.kubernetes:PersistentVolumeClaim"}
+	PVC string `json:"pvc,omitempty"`
+
+	// S3-compatible object storage where the WAL and the base backups are uploaded. They are spooled in
+	// the database PVC until uploaded.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ObjectStorage *BackupObjectStorage `json:"object_storage,omitempty"`
+
+	// Maximum number of seconds before a WAL segment is archived, even if it is not full.
+	// Default: 60
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:number"}
+	ArchiveTimeout int32 `json:"archive_timeout,omitempty"`
+
+	// Number of hours between two base backups.
+	// Default: 24
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:number"}
+	BaseBackupInterval int32 `json:"base_backup_interval,omitempty"`
+
+	// Number of days the base backups, and the WAL needed to recover them, are kept. The latest base
+	// backup is always kept.
+	// Default: 7
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:number"}
+	RetentionDays int32 `json:"retention_days,omitempty"`
+}
+
+// DatabaseHighAvailability defines the streaming replication of the database deployed by the operator.
+// The database Service points to the primary pod and the standbys replicate it. If the primary pod is
+// unavailable for longer than the failover timeout, the most up to date standby is promoted.
+type DatabaseHighAvailability struct {
+	// Number of database pods, the primary and its standbys.
+	// Default: 2
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=2
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:podCount"}
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// Number of seconds the primary pod can be unavailable before a standby is promoted.
+	// Default: 30
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:number"}
+	FailoverTimeout int32 `json:"failover_timeout,omitempty"`
+
+	// PodDisruptionBudget of the database pods.
+	// Default: maxUnavailable: 1
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced"}
+	PDB *policy.PodDisruptionBudgetSpec `json:"pdb,omitempty"`
+}
+
+// DatabasePooler defines the PgBouncer connection pooler deployed in front of the database
+type DatabasePooler struct {
+	// The image name for the PgBouncer image.
+	// Default: "docker.io/edoburu/pgbouncer:v1.23.1-p2"
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced"}
+	Image string `json:"image,omitempty"`
+
+	// Number of PgBouncer pods.
+	// Default: 1
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:podCount"}
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// When a server connection is given back to the pool: at the end of the client session
+	// or at the end of each transaction. In transaction mode, the pulpcore workers and the
+	// migrations connect directly to the database because they rely on session features.
+	// Default: "session"
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum:=session;transaction
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:select:session","urn:alm:descriptor:com.tectonic.ui:select:transaction"}
+	PoolMode string `json:"pool_mode,omitempty"`
+
+	// Number of server connections opened by each PgBouncer pod to the database.
+	// Default: 20
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:number"}
+	DefaultPoolSize int32 `json:"default_pool_size,omitempty"`
+
+	// Maximum number of client connections accepted by each PgBouncer pod.
+	// Default: 1000
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:number"}
+	MaxClientConn int32 `json:"max_client_conn,omitempty"`
+
+	// Resource requirements for the PgBouncer container.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:resourceRequirements","urn:alm:descriptor:com.tectonic.ui:advanced"}
+	ResourceRequirements corev1.ResourceRequirements `json:"resource_requirements,omitempty"`
+}
+
+// DatabaseTuning defines the PostgreSQL parameters generated by the operator
+type DatabaseTuning struct {
+	// PostgreSQL parameters overriding the ones computed by the operator, or added to them.
+	// Example: {"work_mem": "16MB", "random_page_cost": "1.1"}
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:com.tectonic.ui:advanced"}
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// DatabaseTLS defines the certificate of the database
+type DatabaseTLS struct {
+	// Name of the Secret with the certificate (tls.crt), the private key (tls.key) and the CA (ca.crt) of the
+	// database. The certificate must be valid for the names of the database Service (and of the pooler Service).
+	// If not defined, the operator issues a self-signed CA and the certificate of the database.
+	// +kubebuilder:validation:Optional
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors={"urn:alm:descriptor:io.kubernetes:Secret"}
+	Secret string `json:"secret,omitempty"`
 }
 
 // Cache defines desired state of redis resources
@@ -1043,6 +1198,51 @@ type PulpStatus struct {
 	RedirectToObjectStorage bool `json:"redirect_to_object_storage,omitempty"`
 	// The current HIDE_GUARDED_DISTRIBUTIONS definition
 	HideGuardedDistributions bool `json:"hide_guarded_distributions,omitempty"`
+	// Major version of the data of the database deployed by pulp-operator
+	DatabaseVersion string `json:"database_version,omitempty"`
+	// PVC used by the database deployed by pulp-operator since its last major version upgrade
+	DatabasePVC string `json:"database_pvc,omitempty"`
+	// Progress of the major version upgrade of the database deployed by pulp-operator
+	DatabaseUpgrade *DatabaseUpgrade `json:"database_upgrade,omitempty"`
+	// Replication state of the database deployed by pulp-operator with high_availability
+	DatabaseHA *DatabaseHAStatus `json:"database_ha,omitempty"`
+}
+
+// DatabaseHAStatus is the replication state of the database deployed by pulp-operator with high_availability.
+type DatabaseHAStatus struct {
+	// Name of the database pod running the primary
+	Primary string `json:"primary"`
+	// True while the primary has not been promoted yet after a failover
+	Promoting bool `json:"promoting,omitempty"`
+	// Time the primary pod was found unavailable
+	PrimaryUnavailableSince *metav1.Time `json:"primary_unavailable_since,omitempty"`
+	// Time of the last promotion of a standby
+	LastFailoverTime *metav1.Time `json:"last_failover_time,omitempty"`
+}
+
+// DatabaseUpgrade is the progress of a major version upgrade of the database deployed by pulp-operator.
+// The database is dumped with the previous version and reloaded into a new PVC with the new version.
+type DatabaseUpgrade struct {
+	// Major version of the database before the upgrade
+	FromVersion string `json:"from_version"`
+	// Image of the database before the upgrade
+	FromImage string `json:"from_image"`
+	// Major version of the database after the upgrade
+	ToVersion string `json:"to_version"`
+	// Image of the database after the upgrade
+	ToImage string `json:"to_image"`
+	// PVC with the data of the database before the upgrade, kept for rollback
+	PreviousPVC string `json:"previous_pvc"`
+	// PVC where the database is reloaded. The dump of the database is kept in its upgrade directory.
+	PVC string `json:"pvc"`
+	// Phase of the upgrade (ScalingDown, Dumping, Switching, Restoring, or Failed)
+	Phase string `json:"phase"`
+	// Time the upgrade started
+	StartTime metav1.Time `json:"start_time"`
+	// Replicas of the Pulp components before they were scaled down for the upgrade
+	ScaledDownComponents []QuiescedComponent `json:"scaled_down_components,omitempty"`
+	// Reason of the failure
+	Message string `json:"message,omitempty"`
 }
 
 // +kubebuilder:object:root=true
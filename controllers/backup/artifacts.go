@@ -0,0 +1,92 @@
+package repo_manager_backup
+
+import (
+	"context"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	artifactsJobSuffix = "-backup-artifacts"
+
+	// artifactCopyIncremental hard links the objects from the previous backup before the copy
+	artifactCopyIncremental = "Incremental"
+)
+
+// backupArtifacts copies the content stored in the object storage used by Pulp into the backup PVC
+func (r *RepoManagerBackupReconciler) backupArtifacts(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
+	log := r.RawLogger
+	backupDir := pulpBackup.Status.BackupDirectory
+
+	if len(pulpBackup.Spec.ArtifactCopy) == 0 {
+		return true, nil
+	}
+
+	pulp := &pulpv1.Pulp{}
+	if err := r.Get(ctx, types.NamespacedName{Name: getDeploymentName(pulpBackup), Namespace: pulpBackup.Namespace}, pulp); err != nil {
+		log.Error(err, "Failed to get Pulp")
+		return false, err
+	}
+
+	// the content is already copied by the pulp dir backup if Pulp is not deployed with object storage
+	if len(pulp.Spec.ObjectStorageAzureSecret) == 0 && len(pulp.Spec.ObjectStorageS3Secret) == 0 && len(pulp.Spec.ObjectStorageGCSSecret) == 0 {
+		log.Info("Pulp is not deployed with object storage, ignoring artifact_copy")
+		return true, nil
+	}
+
+	remote, err := controllers.NewPulpStorageRemote(ctx, r.Client, pulp)
+	if err != nil {
+		log.Error(err, "Failed to get the object storage configuration from Pulp")
+		return false, err
+	}
+
+	job := controllers.ObjectStorageScriptJob(pulpBackup.Name+artifactsJobSuffix, pulpBackup.Namespace, getBackupPVC(pulpBackup), controllers.BackupMountPath, remote, pulpBackup.Spec.Affinity,
+		controllers.ProgressScript(backupDir+"/"+controllers.ObjectStorageContentDir, "")+
+			artifactCopyScript(backupDir, remote.Root(), pulpBackup.Spec.ArtifactCopy == artifactCopyIncremental, isEncrypted(pulpBackup)),
+	)
+	encryptJob(job, pulpBackup)
+	// the credentials can also be provided through the service account (like in Pulp pods)
+	job.Spec.Template.Spec.ServiceAccountName = settings.PulpServiceAccount(pulp.Name)
+
+	finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpBackup, job)
+	if finished {
+		log.Info("Object storage content backup finished!", "Source", remote.Root())
+	}
+	return finished, err
+}
+
+// artifactCopyScript returns the script that mirrors source (a rclone path) into the backup directory.
+// If incremental is true, the objects are first hard linked from the most recent complete backup (the
+// ones with a manifest) found in the backup PVC, so only the new or modified objects are transferred
+// and the unmodified ones do not use more space.
+// If encrypted is true, the objects are encrypted (rclone crypt) with the backup passphrase and only
+// the objects from previous encrypted backups are linked.
+func artifactCopyScript(backupDir, source string, incremental, encrypted bool) string {
+	dest := backupDir + "/" + controllers.ObjectStorageContentDir
+	script := "set -e\n"
+	if incremental {
+		sameEncryption := `! grep -q '"encryption": "` + controllers.BackupEncryptionGPG + `"' "$manifest"`
+		if encrypted {
+			sameEncryption = `grep -q '"encryption": "` + controllers.BackupEncryptionGPG + `"' "$manifest"`
+		}
+		script += `if [ ! -d ` + dest + ` ]; then
+  PREVIOUS=""
+  for dir in ` + controllers.BackupMountPath + `/*/` + controllers.ObjectStorageContentDir + `; do
+    manifest="${dir%/` + controllers.ObjectStorageContentDir + `}/` + controllers.BackupManifestFileName + `"
+    if [ -f "$manifest" ] && ` + sameEncryption + `; then PREVIOUS="$dir"; fi
+  done
+  if [ -n "$PREVIOUS" ]; then
+    echo "Linking objects from $PREVIOUS"
+    cp -al "$PREVIOUS" ` + dest + `
+  fi
+fi
+`
+	}
+	if encrypted {
+		return script + controllers.EncryptedRemoteScript(dest) + "rclone sync " + source + " " + controllers.EncryptedRemote + ":\n"
+	}
+	return script + "rclone sync " + source + " " + dest + "\n"
+}
@@ -0,0 +1,103 @@
+package repo_manager_backup
+
+import (
+	"context"
+	"strings"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const databaseVersionJobSuffix = "-backup-db-version"
+
+// backupManagerJob returns the job that runs script in the backup-manager image selected for the backup
+func backupManagerJob(pulpBackup *pulpv1.PulpBackup, jobSuffix, script string) *batchv1.Job {
+	job := controllers.BackupManagerJob(pulpBackup.Name+jobSuffix, pulpBackup.Namespace, getBackupPVC(pulpBackup), pulpBackup.Spec.Affinity, script)
+	setBackupManager(job, pulpBackup, pulpBackup.Status.BackupManagerImage)
+	return job
+}
+
+// setBackupManager sets the backup-manager container settings from pulpBackup, the backup_manager_image
+// takes precedence over image
+func setBackupManager(job *batchv1.Job, pulpBackup *pulpv1.PulpBackup, image string) {
+	if len(pulpBackup.Spec.BackupManagerImage) > 0 {
+		image = pulpBackup.Spec.BackupManagerImage
+	}
+	controllers.SetBackupManager(job, image, pulpBackup.Spec.ImagePullPolicy, pulpBackup.Spec.ImagePullSecrets, pulpBackup.Spec.ResourceRequirements)
+}
+
+// selectBackupManagerImage stores, in the status, the image used by the backup jobs.
+// The version of an external database server is detected by a job, so that the database is dumped
+// with a pg_dump of the same major version.
+func (r *RepoManagerBackupReconciler) selectBackupManagerImage(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
+	log := r.RawLogger
+	if len(pulpBackup.Status.BackupManagerImage) > 0 {
+		return true, nil
+	}
+
+	image := pulpBackup.Spec.BackupManagerImage
+	if len(image) == 0 {
+		pulp := &pulpv1.Pulp{}
+		if err := r.Get(ctx, types.NamespacedName{Name: getDeploymentName(pulpBackup), Namespace: pulpBackup.Namespace}, pulp); err != nil {
+			log.Error(err, "Failed to get Pulp")
+			return false, err
+		}
+		serverVersion := ""
+		if len(pulp.Spec.Database.ExternalDBSecret) > 0 {
+			version, finished, err := r.databaseServerVersion(ctx, pulpBackup, pulp)
+			if !finished || err != nil {
+				return false, err
+			}
+			serverVersion = version
+		}
+		image = controllers.BackupManagerImage(pulp, serverVersion)
+		log.Info("Backup manager image selected", "Image", image, "DatabaseVersion", serverVersion)
+	}
+
+	// the compression method can only be checked once the version of pg_dump is known
+	if err := checkDatabaseDumpCompression(pulpBackup, controllers.ImageMajorVersion(image)); err != nil {
+		return false, err
+	}
+
+	pulpBackup.Status.BackupManagerImage = image
+	if err := r.Status().Update(ctx, pulpBackup); err != nil {
+		log.Error(err, "Failed to store the backup manager image")
+		return false, err
+	}
+	return true, nil
+}
+
+// databaseServerVersion runs a job that prints the version of the database server and returns it once
+// the job is finished
+func (r *RepoManagerBackupReconciler) databaseServerVersion(ctx context.Context, pulpBackup *pulpv1.PulpBackup, pulp *pulpv1.Pulp) (string, bool, error) {
+	log := r.RawLogger
+	postgresConfigurationSecret := getPostgresCfgSecret(pulpBackup)
+
+	// the job would not start without the secret, so we fail early if it is not found
+	pgConfig := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: postgresConfigurationSecret, Namespace: pulpBackup.Namespace}, pgConfig); err != nil {
+		log.Error(err, "Failed to find postgres-configuration secret")
+		return "", false, err
+	}
+
+	// any psql can query the version, the one from Pulp CR database version is used if defined
+	job := controllers.BackupManagerJob(pulpBackup.Name+databaseVersionJobSuffix, pulpBackup.Namespace, getBackupPVC(pulpBackup), pulpBackup.Spec.Affinity,
+		"psql -Atc 'SHOW server_version'",
+	)
+	setBackupManager(job, pulpBackup, controllers.BackupManagerImage(pulp, ""))
+	job.Spec.Template.Spec.Containers[0].Env = controllers.PostgresEnv(postgresConfigurationSecret)
+
+	finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpBackup, job)
+	if !finished || err != nil {
+		return "", false, err
+	}
+	logs, err := controllers.JobLogs(ctx, r.Client, r.RESTClient, job.Name, job.Namespace)
+	if err != nil {
+		log.Error(err, "Failed to get the logs from database version job")
+		return "", false, err
+	}
+	return strings.TrimSpace(logs), true, nil
+}
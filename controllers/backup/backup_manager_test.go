@@ -0,0 +1,134 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_backup
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestSelectBackupManagerImage verifies the image selected for the backup jobs and the job that detects
+// the version of an external database
+func TestSelectBackupManagerImage(t *testing.T) {
+	tests := []struct {
+		name           string
+		database       pulpv1.Database
+		spec           pulpv1.PulpBackupSpec
+		expectImage    string
+		expectJobImage string
+		expectReason   string
+	}{
+		{
+			name:        "database deployed by the operator",
+			database:    pulpv1.Database{PostgresImage: "registry.example.com/postgres:16"},
+			expectImage: "registry.example.com/postgres:16",
+		},
+		{
+			name:        "backup_manager_image",
+			database:    pulpv1.Database{ExternalDBSecret: "external-database"},
+			spec:        pulpv1.PulpBackupSpec{BackupManagerImage: "registry.example.com/postgres:17"},
+			expectImage: "registry.example.com/postgres:17",
+		},
+		{
+			name:        "zstd compression with pg_dump 16",
+			database:    pulpv1.Database{PostgresImage: "registry.example.com/postgres:16"},
+			spec:        pulpv1.PulpBackupSpec{DatabaseDumpFormat: databaseDumpCustom, DatabaseDumpCompression: "zstd:3"},
+			expectImage: "registry.example.com/postgres:16",
+		},
+		{
+			name:         "zstd compression with pg_dump 15",
+			database:     pulpv1.Database{PostgresImage: "registry.example.com/postgres:15"},
+			spec:         pulpv1.PulpBackupSpec{DatabaseDumpFormat: databaseDumpCustom, DatabaseDumpCompression: "zstd:3"},
+			expectReason: "DatabaseDumpCompressionUnsupported",
+		},
+		{
+			name:        "lz4 compression with a backup_manager_image without version",
+			database:    pulpv1.Database{PostgresImage: "registry.example.com/postgres:15"},
+			spec:        pulpv1.PulpBackupSpec{DatabaseDumpFormat: databaseDumpCustom, DatabaseDumpCompression: "lz4", BackupManagerImage: "registry.example.com/backup-manager:latest"},
+			expectImage: "registry.example.com/backup-manager:latest",
+		},
+		{
+			name:           "external database",
+			database:       pulpv1.Database{ExternalDBSecret: "external-database", PostgresVersion: "14"},
+			expectJobImage: "docker.io/library/postgres:14",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = corev1.AddToScheme(scheme)
+			_ = batchv1.AddToScheme(scheme)
+			_ = pulpv1.AddToScheme(scheme)
+			pulp := &pulpv1.Pulp{
+				ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+				Spec:       pulpv1.PulpSpec{Database: tt.database},
+			}
+			pgConfig := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "pulp-postgres-configuration", Namespace: "test-namespace"}}
+			tt.spec.DeploymentName = "pulp"
+			pulpBackup := &pulpv1.PulpBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "test-namespace"},
+				Spec:       tt.spec,
+			}
+			r := &RepoManagerBackupReconciler{
+				Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulp, pgConfig, pulpBackup).WithStatusSubresource(pulpBackup).Build(),
+				RawLogger: logr.Discard(),
+				Scheme:    scheme,
+			}
+
+			finished, err := r.selectBackupManagerImage(context.TODO(), pulpBackup)
+			if len(tt.expectReason) > 0 {
+				backupErr := &backupFailedError{}
+				if !errors.As(err, &backupErr) || backupErr.reason != tt.expectReason {
+					t.Errorf("expected %v, got %v", tt.expectReason, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if finished != (len(tt.expectImage) > 0) || pulpBackup.Status.BackupManagerImage != tt.expectImage {
+				t.Errorf("expected image %q (finished %v), got %q (finished %v)", tt.expectImage, len(tt.expectImage) > 0, pulpBackup.Status.BackupManagerImage, finished)
+			}
+
+			job := &batchv1.Job{}
+			err = r.Get(context.TODO(), types.NamespacedName{Name: "backup" + databaseVersionJobSuffix, Namespace: "test-namespace"}, job)
+			if len(tt.expectJobImage) == 0 {
+				if err == nil {
+					t.Errorf("the database version should not be detected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("database version job not found: %v", err)
+			}
+			if image := job.Spec.Template.Spec.Containers[0].Image; image != tt.expectJobImage {
+				t.Errorf("expected job image %v, got %v", tt.expectJobImage, image)
+			}
+		})
+	}
+}
@@ -3,10 +3,8 @@ package repo_manager_backup
 import (
 	"bytes"
 	"context"
-	"fmt"
 
 	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
-	"github.com/pulp/pulp-operator/controllers"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/cli-runtime/pkg/printers"
@@ -21,21 +19,18 @@ type configMapType struct {
 	// PulpBackup instance
 	pulpBackup *pulpv1.PulpBackup
 
-	// path of where the backup will be stored (PVC mount point)
-	backupDir string
-
 	// name of the backup file
 	backupFile string
 
 	// name of the configmap that will be copied
 	configMapName string
 
-	// backup-manager pod
-	pod *corev1.Pod
+	// content of the backup files, by file name
+	files map[string][]byte
 }
 
 // backupConfigMap makes a copy of the ConfigMaps used by Pulp components
-func (r *RepoManagerBackupReconciler) backupConfigMap(ctx context.Context, pulpBackup *pulpv1.PulpBackup, backupDir string, pod *corev1.Pod) error {
+func (r *RepoManagerBackupReconciler) backupConfigMap(ctx context.Context, pulpBackup *pulpv1.PulpBackup, files map[string][]byte) error {
 	log := r.RawLogger
 	deploymentName := getDeploymentName(pulpBackup)
 
@@ -54,7 +49,7 @@ func (r *RepoManagerBackupReconciler) backupConfigMap(ctx context.Context, pulpB
 	}
 
 	// CUSTOM PULP SETTINGS
-	if err := r.createConfigMapBackupFile(ctx, configMapType{"custom_pulp_settings", pulpBackup, backupDir, "custom_pulp_settings.yaml", custom_pulp_settings, pod}); err != nil {
+	if err := r.createConfigMapBackupFile(ctx, configMapType{"custom_pulp_settings", pulpBackup, "custom_pulp_settings.yaml", custom_pulp_settings, files}); err != nil {
 		return err
 	}
 	log.Info("custom_pulp_settings ConfigMap backup finished")
@@ -76,14 +71,7 @@ func (r *RepoManagerBackupReconciler) createConfigMapBackupFile(ctx context.Cont
 	ymlPrinter := printers.YAMLPrinter{}
 	ymlPrinter.PrintObj(configMap, configMapYaml)
 
-	execCmd := []string{
-		"bash", "-c", fmt.Sprintf("cat<<EOF> %s/%s \n%sEOF", configMapType.backupDir, configMapType.backupFile, configMapYaml.String()),
-	}
-	_, err = controllers.ContainerExec(ctx, r, configMapType.pod, execCmd, configMapType.pulpBackup.Name+"-backup-manager", configMapType.pod.Namespace)
-	if err != nil {
-		log.Error(err, "Failed to backup "+configMapType.configMapName+" configmap")
-		return err
-	}
+	configMapType.files[configMapType.backupFile] = configMapYaml.Bytes()
 
 	log.Info("ConfigMap " + configMapType.configMapName + " backup finished")
 	return nil
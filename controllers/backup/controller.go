@@ -18,11 +18,13 @@ package repo_manager_backup
 
 import (
 	"context"
+	goerrors "errors"
 	"time"
 
 	"github.com/go-logr/logr"
 	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
 	"github.com/pulp/pulp-operator/controllers"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/api/meta"
@@ -31,8 +33,11 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 // RepoManagerBackupReconciler reconciles a PulpBackup object
@@ -42,28 +47,36 @@ type RepoManagerBackupReconciler struct {
 	RESTClient rest.Interface
 	RESTConfig *rest.Config
 	Scheme     *runtime.Scheme
+	recorder   record.EventRecorder
+
+	// OperatorVersion is stored in the backup manifest
+	OperatorVersion string
 }
 
 //+kubebuilder:rbac:groups=repo-manager.pulpproject.org,namespace=pulp-operator-system,resources=pulpbackups,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=repo-manager.pulpproject.org,namespace=pulp-operator-system,resources=pulpbackups/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=repo-manager.pulpproject.org,namespace=pulp-operator-system,resources=pulpbackups/finalizers,verbs=update
 //+kubebuilder:rbac:groups=core,namespace=pulp-operator-system,resources=pods;persistentvolumes;persistentvolumeclaims,verbs=create;update;patch;delete;watch;get;list;
-//+kubebuilder:rbac:groups=core,namespace=pulp-operator-system,resources=pods/exec,verbs=create;
+//+kubebuilder:rbac:groups=core,namespace=pulp-operator-system,resources=secrets,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=batch,namespace=pulp-operator-system,resources=jobs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=repo-manager.pulpproject.org,namespace=pulp-operator-system,resources=pulps,verbs=get;list;
+//+kubebuilder:rbac:groups=snapshot.storage.k8s.io,namespace=pulp-operator-system,resources=volumesnapshots,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=core,namespace=pulp-operator-system,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
+// Each step of the backup is stored in .status.phase and the long running steps are executed in Jobs,
+// so the reconciliation does not wait for them and the backup can be resumed if the operator is restarted.
 func (r *RepoManagerBackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.RawLogger
 
-	formattedCurrentTime := time.Now().Format("2006-01-02-150405")
 	pulpBackup := &pulpv1.PulpBackup{}
 	err := r.Get(ctx, req.NamespacedName, pulpBackup)
 
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Request object not found, could have been deleted after reconcile request.
-			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Owned objects are automatically garbage collected. The backup data is handled by the finalizer.
 			// Return and don't requeue
 			log.Info("PulpBackup resource not found. Ignoring since object must be deleted")
 			return ctrl.Result{}, nil
@@ -72,263 +85,152 @@ func (r *RepoManagerBackupReconciler) Reconcile(ctx context.Context, req ctrl.Re
 		log.Error(err, "Failed to get PulpBackup")
 		return ctrl.Result{}, err
 	}
-	backupDir := getBackupDir(formattedCurrentTime)
 
-	if err := checkRequiredFields(pulpBackup); err != nil {
-		log.Error(err, "Required field not filled in backup CR!")
-		return ctrl.Result{}, nil
+	if !pulpBackup.DeletionTimestamp.IsZero() {
+		return r.deleteBackup(ctx, pulpBackup)
 	}
-
-	r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Backup process running ...", "StartingBackupProcess")
-	r.cleanup(ctx, pulpBackup)
-
-	r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Creating backup pvc ...", "CreatingPVC")
-	err = r.createBackupPVC(ctx, pulpBackup)
-	if err != nil {
-		r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Failed to create backup pvc!", "FailedCreatingPVC")
-		return ctrl.Result{}, err
+	if controllerutil.AddFinalizer(pulpBackup, backupFinalizer) {
+		if err := r.Update(ctx, pulpBackup); err != nil {
+			log.Error(err, "Failed to add PulpBackup finalizer")
+			return ctrl.Result{}, err
+		}
 	}
 
-	r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Creating backup pod ...", "CreatingPod")
-	pod, err := r.createBackupPod(ctx, pulpBackup, "/backups")
-	if err != nil {
-		r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Failed to create backup pod!", "FailedCreatingPod")
-		return ctrl.Result{}, err
+	// backups finished before the phases were stored in the status should not run again
+	if len(pulpBackup.Status.Phase) == 0 && v1.IsStatusConditionTrue(pulpBackup.Status.Conditions, "BackupComplete") {
+		pulpBackup.Status.Phase = phaseCompleted
+		return ctrl.Result{}, r.Status().Update(ctx, pulpBackup)
 	}
 
-	if err = r.createBackupDir(ctx, pulpBackup, backupDir, pod); err != nil {
-		r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Failed to create backup directory!", "FailedCreateBkpDir")
-		return ctrl.Result{}, err
+	if pulpBackup.Status.Phase == phaseCompleted || pulpBackup.Status.Phase == phaseFailed {
+		return ctrl.Result{}, nil
 	}
-
-	r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Running configmap backup ...", "BackupConfigMap")
-	err = r.backupConfigMap(ctx, pulpBackup, backupDir, pod)
-	if err != nil {
-		r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Failed to backup configmaps!", "FailedBackupConfigMaps")
-		return ctrl.Result{}, err
+	if pulpBackup.Status.Phase == phaseFailureHooks {
+		return r.runFailureHooks(ctx, pulpBackup)
 	}
 
-	r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Running database backup ...", "BackupDB")
-	err = r.backupDatabase(ctx, pulpBackup, backupDir, pod)
-	if err != nil {
-		r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Failed to backup database!", "FailedBackupDB")
-		return ctrl.Result{}, err
-	}
+	phases := r.phases()
+	if len(pulpBackup.Status.Phase) == 0 {
+		if err := checkRequiredFields(pulpBackup); err != nil {
+			log.Error(err, "Required field not filled in backup CR!")
+			return ctrl.Result{}, nil
+		}
+		if err := checkBackupMode(pulpBackup); err != nil {
+			return r.backupFailed(ctx, pulpBackup, err, err.message, err.reason)
+		}
+		if err := checkBackupHooks(pulpBackup); err != nil {
+			return r.backupFailed(ctx, pulpBackup, err, err.message, err.reason)
+		}
 
-	r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Running CR backup ...", "BackupCR")
-	err = r.backupCR(ctx, pulpBackup, backupDir, pod)
-	if err != nil {
-		r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Failed to backup CR!", "FailedBackupCR")
-		return ctrl.Result{}, err
+		// the backup directory is defined only once so that the same one is used if the backup is resumed
+		setStatusFields(pulpBackup, time.Now().Format("2006-01-02-150405"))
+		pulpBackup.Status.Phase = phases[0].name
+		r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Backup process running ...", "StartingBackupProcess")
 	}
 
-	r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Running secrets backup ...", "BackupSecrets")
-	err = r.backupSecret(ctx, pulpBackup, backupDir, pod)
-	if err != nil {
-		r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Failed to backup secrets!", "FailedBackupSecrets")
-		return ctrl.Result{}, err
-	}
+	for _, phase := range phases[phaseIndex(phases, pulpBackup.Status.Phase):] {
+		// the condition is updated only when the phase starts (or after a failed attempt)
+		if condition := v1.FindStatusCondition(pulpBackup.Status.Conditions, "BackupComplete"); pulpBackup.Status.Phase != phase.name || condition == nil || condition.Reason != phase.name {
+			pulpBackup.Status.Phase = phase.name
+			r.startPhase(pulpBackup, phase)
+			r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", phase.message, phase.name)
+		}
 
-	r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Running Pulp dir backup ...", "BackupDir")
-	err = r.backupPulpDir(ctx, pulpBackup, backupDir, pod)
-	if err != nil {
-		r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Failed to backup Pulp dir!", "FailedBackupDir")
-		return ctrl.Result{}, err
+		finished, err := phase.run(ctx, pulpBackup)
+		jobErr := &controllers.JobFailedError{}
+		backupErr := &backupFailedError{}
+		if goerrors.As(err, &jobErr) {
+			r.phaseProgress(ctx, pulpBackup, phase)
+			return r.backupFailed(ctx, pulpBackup, err, phase.failedMessage+" "+jobErr.Message, "Failed"+phase.name)
+		} else if goerrors.As(err, &backupErr) {
+			return r.backupFailed(ctx, pulpBackup, err, phase.failedMessage+" "+backupErr.message, backupErr.reason)
+		} else if err != nil {
+			// the phase is retried, the error is kept in its record
+			if record := controllers.LastPhaseRecord(pulpBackup.Status.PhaseRecords, phase.name); record != nil {
+				record.LastError = err.Error()
+			}
+			r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", phase.failedMessage, "Failed"+phase.name)
+			return ctrl.Result{}, err
+		}
+		if !finished {
+			if r.phaseProgress(ctx, pulpBackup, phase) {
+				if err := r.Status().Update(ctx, pulpBackup); err != nil {
+					log.Error(err, "Failed to update the progress of phase "+phase.name)
+				}
+			}
+			return ctrl.Result{RequeueAfter: jobPollInterval}, nil
+		}
+		// the record is stored with the next condition update
+		r.finishPhase(ctx, pulpBackup, phase)
 	}
 
 	log.Info("Cleaning up backup resources ...")
-	r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", "Cleaning up backup resources ...", "DeletingBkpPod")
-	r.cleanup(ctx, pulpBackup)
-	if err := r.setStatusFields(ctx, pulpBackup, formattedCurrentTime); err != nil {
-		log.Error(err, "Failed to update backup CR status!")
-	}
+	r.cleanup(ctx, pulpBackup, phases, "")
+
+	pulpBackup.Status.Phase = phaseCompleted
 	r.updateStatus(ctx, pulpBackup, metav1.ConditionTrue, "BackupComplete", "All backup tasks run!", "BackupTasksFinished")
+	r.recorder.Event(pulpBackup, corev1.EventTypeNormal, "BackupCompleted", "All backup tasks run!")
 	log.Info("Pulp CR Backup finished!")
 
 	return ctrl.Result{}, nil
 }
 
-// waitPodReady waits until container gets into a "READY" state
-func (r *RepoManagerBackupReconciler) waitPodReady(ctx context.Context, namespace, podName string) (*corev1.Pod, error) {
-	var err error
-	for timeout := 0; timeout < 120; timeout++ {
-		pod := &corev1.Pod{}
-		err = r.Get(ctx, types.NamespacedName{Name: podName, Namespace: namespace}, pod)
-
-		if len(pod.Status.ContainerStatuses) > 0 && pod.Status.ContainerStatuses[0].Ready {
-			return pod, nil
-		}
-		time.Sleep(time.Second)
-	}
-	return &corev1.Pod{}, err
-}
-
-// createBackupPod provisions the backup-manager pod where the backup steps will run
-func (r *RepoManagerBackupReconciler) createBackupPod(ctx context.Context, pulpBackup *pulpv1.PulpBackup, backupDir string) (*corev1.Pod, error) {
-	log := r.RawLogger
-
-	deploymentName := getDeploymentName(pulpBackup)
-	backupPVC := getBackupPVC(pulpBackup)
-
-	// we are considering that pulp CR instance is running in the same namespace as pulpbackup and
-	// that there is only a single instance of pulp CR available
-	// we could also let users pass the name of pulp instance
-	pulp := &pulpv1.Pulp{}
-	err := r.Get(ctx, types.NamespacedName{Name: deploymentName, Namespace: pulpBackup.Namespace}, pulp)
-	if err != nil {
-		log.Error(err, "Failed to get Pulp")
-		return nil, err
-	}
-
-	labels := map[string]string{
-		"app.kubernetes.io/name":       "pulp-backup-storage",
-		"app.kubernetes.io/instance":   "pulp-backup-storage-" + pulpBackup.Name,
-		"app.kubernetes.io/component":  "backup-storage",
-		"app.kubernetes.io/part-of":    "pulp",
-		"app.kubernetes.io/managed-by": "pulp-operator",
-	}
-
-	affinity := &corev1.Affinity{}
-	if pulpBackup.Spec.Affinity != nil {
-		affinity = pulpBackup.Spec.Affinity
-	}
-
-	// [TO-DO] define postgres image based on the database implementation type
-	// if external database: we should gather from an user input (pulpbackup CR) postgres version
-	// if provisioned by operator: we should gather, for example, from pulp CR spec or from database deployment spec
-	postgresImage := "docker.io/library/postgres:15"
-	volumeMounts := []corev1.VolumeMount{{
-		Name:      pulpBackup.Name + "-backup",
-		ReadOnly:  false,
-		MountPath: backupDir,
-	}}
-
-	volumes := []corev1.Volume{{
-		Name: pulpBackup.Name + "-backup",
-		VolumeSource: corev1.VolumeSource{
-			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-				ClaimName: backupPVC,
-			},
-		},
-	}}
-
-	// fileStorageMount will be added to the list of mounts if there is a
-	// SC or PVC defined for Pulp
-	fileStorageMount := corev1.VolumeMount{
-		Name:      "file-storage",
-		ReadOnly:  false,
-		MountPath: "/var/lib/pulp",
+// backupFailed stops the backup, setting the Failed phase (after the post_backup_hooks run by the
+// RunningFailureHooks phase, if the backup failed before them) and the BackupComplete condition with
+// message and reason
+func (r *RepoManagerBackupReconciler) backupFailed(ctx context.Context, pulpBackup *pulpv1.PulpBackup, err error, message, reason string) (ctrl.Result, error) {
+	r.RawLogger.Error(err, "Backup failed", "Phase", pulpBackup.Status.Phase)
+	// Pulp should not be kept scaled down after a failed backup
+	if _, err := r.resumePulp(ctx, pulpBackup); err != nil {
+		return ctrl.Result{}, err
 	}
-
-	// fileStorageVolume will be added to the list of volumes if there is a
-	// SC or PVC defined for Pulp
-	fileStorageVolume := corev1.Volume{
-		Name: "file-storage",
-		VolumeSource: corev1.VolumeSource{
-			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{},
-		},
+	// the failed job is kept for inspection, not the other jobs nor the copy of the backed up secrets
+	failedJob := ""
+	jobErr := &controllers.JobFailedError{}
+	if goerrors.As(err, &jobErr) {
+		failedJob = jobErr.Name
 	}
-
-	_, storageType := controllers.MultiStorageConfigured(pulp, "Pulp")
-
-	// if SC defined, we should mount the PVC provisioned by the operator
-	if storageType[0] == controllers.SCNameType {
-		volumeMounts = append(volumeMounts, fileStorageMount)
-		fileStorageVolume.VolumeSource.PersistentVolumeClaim.ClaimName = pulp.Name + "-file-storage"
-		volumes = append(volumes, fileStorageVolume)
-
-		// if .spec.Api.PVC defined we should mount the PVC provisioned by user
-	} else if storageType[0] == controllers.PVCType {
-		volumeMounts = append(volumeMounts, fileStorageMount)
-		fileStorageVolume.VolumeSource.PersistentVolumeClaim.ClaimName = pulp.Spec.PVC
-		volumes = append(volumes, fileStorageVolume)
-	}
-
-	// running a dumb command on bkp mount point just to make sure that
-	// the pod is ready to execute the backup commands (mkdir,cp,echo,etc)
-	readinessProbe := &corev1.Probe{
-		ProbeHandler: corev1.ProbeHandler{
-			Exec: &corev1.ExecAction{Command: []string{"ls", backupDir}},
-		},
-		FailureThreshold:    10,
-		InitialDelaySeconds: 3,
-		PeriodSeconds:       10,
-		SuccessThreshold:    1,
-		TimeoutSeconds:      10,
+	r.cleanup(ctx, pulpBackup, r.phases(), failedJob)
+	controllers.FinishPhaseRecord(pulpBackup.Status.PhaseRecords, pulpBackup.Status.Phase, controllers.PhaseFailed, err.Error())
+	r.recorder.Event(pulpBackup, corev1.EventTypeWarning, reason, message)
+	// the post_backup_hooks are run (once Pulp is resumed) so that they can report the failure
+	if runsFailureHooks(r.phases(), pulpBackup, pulpBackup.Status.Phase) {
+		pulpBackup.Status.Phase = phaseFailureHooks
+		r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", message, reason)
+		return ctrl.Result{Requeue: true}, nil
 	}
+	pulpBackup.Status.Phase = phaseFailed
+	r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", message, reason)
+	return ctrl.Result{}, nil
+}
 
-	runAsUser := int64(700)
-	fsGroup := int64(700)
-
-	bkpPod := &corev1.Pod{}
-	err = r.Get(ctx, types.NamespacedName{Name: pulpBackup.Name + "-backup-manager", Namespace: pulpBackup.Namespace}, bkpPod)
-	pod := &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      pulpBackup.Name + "-backup-manager",
-			Namespace: pulpBackup.Namespace,
-			Labels:    labels,
-		},
-		Spec: corev1.PodSpec{
-			Affinity: affinity,
-			Containers: []corev1.Container{{
-				Name:            pulpBackup.Name + "-backup-manager",
-				Image:           postgresImage,
-				ImagePullPolicy: corev1.PullAlways,
-				Command: []string{
-					"sleep",
-					"infinity",
-				},
-				VolumeMounts:    volumeMounts,
-				ReadinessProbe:  readinessProbe,
-				SecurityContext: controllers.SetDefaultSecurityContext(),
-			}},
-			Volumes:         volumes,
-			RestartPolicy:   corev1.RestartPolicyNever,
-			SecurityContext: &corev1.PodSecurityContext{RunAsUser: &runAsUser, FSGroup: &fsGroup},
-		},
-	}
-	if err != nil && errors.IsNotFound(err) {
-		log.Info("Creating a new backup manager Pod", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
-		ctrl.SetControllerReference(pulpBackup, pod, r.Scheme)
-		err = r.Create(ctx, pod)
-		if err != nil {
-			log.Error(err, "Failed to create new backup manager Pod", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
-			return &corev1.Pod{}, err
+// cleanup deletes the jobs (including the ones of the hooks), except keepJob, and the temporary secret
+// created during the backup
+func (r *RepoManagerBackupReconciler) cleanup(ctx context.Context, pulpBackup *pulpv1.PulpBackup, phases []backupPhase, keepJob string) {
+	for _, phase := range phases {
+		if len(phase.job) == 0 || pulpBackup.Name+phase.job == keepJob {
+			continue
+		}
+		if err := controllers.DeleteJob(ctx, r.Client, pulpBackup.Name+phase.job, pulpBackup.Namespace); err != nil {
+			r.RawLogger.Error(err, "Failed to remove backup job", "Job.Name", pulpBackup.Name+phase.job)
 		}
-	} else if err != nil {
-		log.Error(err, "Failed to get backup manager Pod")
-		return &corev1.Pod{}, err
-	}
-
-	pod, err = r.waitPodReady(ctx, pulpBackup.Namespace, pulpBackup.Name+"-backup-manager")
-	if err != nil {
-		log.Error(err, "Backup pod not found")
-		return &corev1.Pod{}, err
 	}
-	return pod, nil
-}
-
-// cleanup deletes the backup-manager pod
-func (r *RepoManagerBackupReconciler) cleanup(ctx context.Context, pulpBackup *pulpv1.PulpBackup) error {
-	bkpPod := &corev1.Pod{}
-	r.Get(ctx, types.NamespacedName{Name: pulpBackup.Name + "-backup-manager", Namespace: pulpBackup.Namespace}, bkpPod)
-	r.Delete(ctx, bkpPod)
-
-	// the Delete method is not synchronous, so this loop will wait until the pod is not present anymore or
-	// the 120 seconds timeout
-	for timeout := 0; timeout < 120; timeout++ {
-		err := r.Get(ctx, types.NamespacedName{Name: pulpBackup.Name + "-backup-manager", Namespace: pulpBackup.Namespace}, bkpPod)
-		if err != nil && errors.IsNotFound(err) {
-			break
+	for _, job := range hookJobs(pulpBackup) {
+		if job == keepJob {
+			continue
+		}
+		if err := controllers.DeleteJob(ctx, r.Client, job, pulpBackup.Namespace); err != nil {
+			r.RawLogger.Error(err, "Failed to remove backup hook job", "Job.Name", job)
 		}
-		time.Sleep(time.Second * 5)
 	}
-
-	return nil
+	resources := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: resourcesSecretName(pulpBackup), Namespace: pulpBackup.Namespace}}
+	if err := r.Delete(ctx, resources); err != nil && !errors.IsNotFound(err) {
+		r.RawLogger.Error(err, "Failed to remove backup resources secret")
+	}
 }
 
 // createBackupPVC provisions the pulp-backup-claim PVC that will store the backup
-func (r *RepoManagerBackupReconciler) createBackupPVC(ctx context.Context, pulpBackup *pulpv1.PulpBackup) error {
+func (r *RepoManagerBackupReconciler) createBackupPVC(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
 	log := r.RawLogger
 
 	backupPVC := getBackupPVC(pulpBackup)
@@ -381,14 +283,14 @@ func (r *RepoManagerBackupReconciler) createBackupPVC(ctx context.Context, pulpB
 		err = r.Create(ctx, pvc)
 		if err != nil {
 			log.Error(err, "Failed to create new PulpBackup PVC", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
-			return err
+			return false, err
 		}
 	} else if err != nil {
 		log.Error(err, "Failed to get PulpBackup PVC")
-		return err
+		return false, err
 	}
 
-	return nil
+	return true, nil
 }
 
 // updateStatus modifies a .status.condition from pulpbackup CR
@@ -405,8 +307,9 @@ func (r *RepoManagerBackupReconciler) updateStatus(ctx context.Context, pulpBack
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *RepoManagerBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.recorder = mgr.GetEventRecorderFor("PulpBackup")
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&pulpv1.PulpBackup{}).
-		WithEventFilter(controllers.IgnoreUpdateCRStatusPredicate()).
+		For(&pulpv1.PulpBackup{}, builder.WithPredicates(controllers.IgnoreUpdateCRStatusPredicate())).
+		Owns(&batchv1.Job{}).
 		Complete(r)
 }
@@ -0,0 +1,76 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_backup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestBackupFailed verifies that a failed backup keeps only the failed job and removes the other jobs
+// and the copy of the backed up secrets
+func TestBackupFailed(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = pulpv1.AddToScheme(scheme)
+	pulpBackup := &pulpv1.PulpBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "test-namespace"},
+		Spec:       pulpv1.PulpBackupSpec{DeploymentName: "pulp"},
+		Status:     pulpv1.PulpBackupStatus{Phase: phaseBackupDB},
+	}
+	resourcesJob := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "backup" + resourcesJobSuffix, Namespace: "test-namespace"}}
+	databaseJob := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "backup" + databaseJobSuffix, Namespace: "test-namespace"}}
+	resources := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: resourcesSecretName(pulpBackup), Namespace: "test-namespace"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulpBackup, resourcesJob, databaseJob, resources).WithStatusSubresource(pulpBackup).Build()
+	r := &RepoManagerBackupReconciler{Client: c, RawLogger: logr.Discard(), Scheme: scheme, recorder: record.NewFakeRecorder(10)}
+	ctx := context.TODO()
+
+	err := &controllers.JobFailedError{Name: databaseJob.Name, Message: "pg_dump failed"}
+	if _, err := r.backupFailed(ctx, pulpBackup, err, "Failed to backup database!", "Failed"+phaseBackupDB); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, obj := range []client.Object{resourcesJob, resources} {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); !errors.IsNotFound(err) {
+			t.Errorf("expected %s to be removed, got %v", obj.GetName(), err)
+		}
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(databaseJob), &batchv1.Job{}); err != nil {
+		t.Errorf("expected the failed job to be kept, got %v", err)
+	}
+	current := &pulpv1.PulpBackup{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "backup", Namespace: "test-namespace"}, current); err != nil {
+		t.Fatal(err)
+	}
+	if current.Status.Phase != phaseFailed {
+		t.Errorf("expected phase %s, got %s", phaseFailed, current.Status.Phase)
+	}
+}
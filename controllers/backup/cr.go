@@ -5,12 +5,11 @@ import (
 	"encoding/json"
 
 	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
-	"github.com/pulp/pulp-operator/controllers"
-	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
 
-func (r *RepoManagerBackupReconciler) backupCR(ctx context.Context, pulpBackup *pulpv1.PulpBackup, backupDir string, pod *corev1.Pod) error {
+// backupCR makes a copy of the Pulp CR spec
+func (r *RepoManagerBackupReconciler) backupCR(ctx context.Context, pulpBackup *pulpv1.PulpBackup, files map[string][]byte) error {
 	log := r.RawLogger
 	deploymentName := getDeploymentName(pulpBackup)
 
@@ -26,14 +25,11 @@ func (r *RepoManagerBackupReconciler) backupCR(ctx context.Context, pulpBackup *
 
 	// CR BACKUP
 	log.Info("Starting Pulp CR backup process ...")
-	pulpSpec, _ := json.Marshal(pulp.Spec)
-	execCmd := []string{
-		"bash", "-c", "echo '" + string(pulpSpec) + "' > " + backupDir + "/cr_object",
-	}
-	_, err = controllers.ContainerExec(ctx, r, pod, execCmd, pulpBackup.Name+"-backup-manager", pod.Namespace)
+	pulpSpec, err := json.Marshal(pulp.Spec)
 	if err != nil {
 		log.Error(err, "Failed to backup Pulp CR")
 		return err
 	}
+	files["cr_object"] = pulpSpec
 	return nil
 }
@@ -2,6 +2,8 @@ package repo_manager_backup
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
 	"github.com/pulp/pulp-operator/controllers"
@@ -9,46 +11,91 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 )
 
-// backupDatabase runs a pg_dump inside backup-manager pod and store it in backup PVC
-func (r *RepoManagerBackupReconciler) backupDatabase(ctx context.Context, pulpBackup *pulpv1.PulpBackup, backupDir string, pod *corev1.Pod) error {
+const (
+	databaseJobSuffix = "-backup-db"
+
+	// database_dump_format values
+	databaseDumpTar       = "Tar"
+	databaseDumpCustom    = "Custom"
+	databaseDumpDirectory = "Directory"
+)
+
+// backupDatabase runs a pg_dump in a job and store it in backup PVC
+func (r *RepoManagerBackupReconciler) backupDatabase(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
 	log := r.RawLogger
-	backupFile := "pulp.db"
 	postgresConfigurationSecret := getPostgresCfgSecret(pulpBackup)
-	backupPod := pulpBackup.Name + "-backup-manager"
-
-	log.Info("Starting database backup process ...")
-	execCmd := []string{"touch", backupDir + "/" + backupFile}
-	_, err := controllers.ContainerExec(ctx, r, pod, execCmd, backupPod, pod.Namespace)
-	if err != nil {
-		log.Error(err, "Failed to create pulp.db backup file")
-		return err
+
+	// the job would not start without the secret, so we fail early if it is not found
+	pgConfig := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: postgresConfigurationSecret, Namespace: pulpBackup.Namespace}, pgConfig); err != nil {
+		log.Error(err, "Failed to find postgres-configuration secret")
+		return false, err
 	}
 
-	execCmd = []string{"chmod", "0600", backupDir + "/" + backupFile}
-	_, err = controllers.ContainerExec(ctx, r, pod, execCmd, backupPod, pod.Namespace)
-	if err != nil {
-		log.Error(err, "Failed to modify backup file permissions")
-		return err
+	backupFile := pulpBackup.Status.BackupDirectory + "/" + controllers.DatabaseDumpFile
+	job := backupManagerJob(pulpBackup, databaseJobSuffix,
+		controllers.ProgressScript(backupFile, "")+databaseDumpScript(backupFile, pulpBackup.Spec, isEncrypted(pulpBackup)),
+	)
+	job.Spec.Template.Spec.Containers[0].Env = controllers.PostgresEnv(postgresConfigurationSecret)
+	encryptJob(job, pulpBackup)
+
+	finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpBackup, job)
+	if finished {
+		log.Info("Database Backup finished!")
 	}
+	return finished, err
+}
 
-	pgConfig := &corev1.Secret{}
-	err = r.Get(ctx, types.NamespacedName{Name: postgresConfigurationSecret, Namespace: pulpBackup.Namespace}, pgConfig)
-	if err != nil {
-		log.Error(err, "Failed to find postgres-configuration secret")
-		return err
+// databaseDumpScript returns the script that dumps the database into backupFile.
+// Encrypted dumps are written through encrypt, which is why they can not be made in the directory format.
+func databaseDumpScript(backupFile string, spec pulpv1.PulpBackupSpec, encrypted bool) string {
+	// pg_dump creates the directory, it fails if a previous attempt left files in it
+	if spec.DatabaseDumpFormat == databaseDumpDirectory {
+		return "set -e\nrm -rf " + backupFile + "\n" + pgDumpCommand(spec) + " -f " + backupFile
 	}
-	execCmd = []string{
-		"pg_dump", "--clean", "--create", "-Ft",
-		"-d", "postgresql://" + string(pgConfig.Data["username"]) + ":" + string(pgConfig.Data["password"]) + "@" + string(pgConfig.Data["host"]) + ":" + string(pgConfig.Data["port"]) + "/" + string(pgConfig.Data["database"]),
-		"-f", backupDir + "/" + backupFile,
+	script := "set -e\ntouch " + backupFile + "\nchmod 0600 " + backupFile + "\n" + pgDumpCommand(spec) + " -f " + backupFile
+	if encrypted {
+		script = "set -eo pipefail\ntouch " + backupFile + "\nchmod 0600 " + backupFile + "\n" + controllers.EncryptionScript +
+			pgDumpCommand(spec) + " | encrypt > " + backupFile
 	}
+	return script
+}
 
-	_, err = controllers.ContainerExec(ctx, r, pod, execCmd, backupPod, pod.Namespace)
-	if err != nil {
-		log.Error(err, "Failed to run pg_dump")
-		return err
+// databaseDumpJobs returns the number of jobs used by pg_dump
+func databaseDumpJobs(spec pulpv1.PulpBackupSpec) int32 {
+	if spec.DatabaseDumpFormat == databaseDumpDirectory && spec.DatabaseDumpJobs > 1 {
+		return spec.DatabaseDumpJobs
 	}
+	return 1
+}
 
-	log.Info("Database Backup finished!")
-	return nil
+// pgDumpCommand returns the pg_dump command with the format, compression and number of jobs from spec
+func pgDumpCommand(spec pulpv1.PulpBackupSpec) string {
+	command := "pg_dump --clean --create"
+	switch spec.DatabaseDumpFormat {
+	case databaseDumpCustom:
+		command += " -Fc"
+	case databaseDumpDirectory:
+		command += " -Fd"
+		if jobs := databaseDumpJobs(spec); jobs > 1 {
+			command += fmt.Sprintf(" -j %d", jobs)
+		}
+	default:
+		command += " -Ft"
+	}
+
+	// "-Z level" is used for gzip because pg_dump older than 16 does not accept a compression method
+	method, level, _ := strings.Cut(spec.DatabaseDumpCompression, ":")
+	switch method {
+	case "":
+	case "none":
+		command += " -Z 0"
+	case "gzip":
+		if len(level) > 0 {
+			command += " -Z " + level
+		}
+	default:
+		command += " --compress=" + spec.DatabaseDumpCompression
+	}
+	return command
 }
@@ -0,0 +1,109 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_backup
+
+import (
+	"testing"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+)
+
+// TestPgDumpCommand verifies the pg_dump options used for each database dump format and compression
+func TestPgDumpCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   pulpv1.PulpBackupSpec
+		expect string
+	}{
+		{
+			name:   "default",
+			expect: "pg_dump --clean --create -Ft",
+		},
+		{
+			name:   "custom format with gzip level",
+			spec:   pulpv1.PulpBackupSpec{DatabaseDumpFormat: databaseDumpCustom, DatabaseDumpCompression: "gzip:9"},
+			expect: "pg_dump --clean --create -Fc -Z 9",
+		},
+		{
+			name:   "custom format without compression",
+			spec:   pulpv1.PulpBackupSpec{DatabaseDumpFormat: databaseDumpCustom, DatabaseDumpCompression: "none"},
+			expect: "pg_dump --clean --create -Fc -Z 0",
+		},
+		{
+			name:   "directory format with parallel jobs and zstd",
+			spec:   pulpv1.PulpBackupSpec{DatabaseDumpFormat: databaseDumpDirectory, DatabaseDumpJobs: 8, DatabaseDumpCompression: "zstd:3"},
+			expect: "pg_dump --clean --create -Fd -j 8 --compress=zstd:3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if command := pgDumpCommand(tt.spec); command != tt.expect {
+				t.Errorf("expected %q, got %q", tt.expect, command)
+			}
+		})
+	}
+}
+
+// TestCheckDatabaseDumpSettings verifies that the backup fails early with database dump settings
+// that pg_dump does not support
+func TestCheckDatabaseDumpSettings(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         pulpv1.PulpBackupSpec
+		expectReason string
+	}{
+		{
+			name: "directory format with parallel jobs",
+			spec: pulpv1.PulpBackupSpec{DatabaseDumpFormat: databaseDumpDirectory, DatabaseDumpJobs: 4, DatabaseDumpCompression: "gzip"},
+		},
+		{
+			name: "tar format without compression",
+			spec: pulpv1.PulpBackupSpec{DatabaseDumpCompression: "none"},
+		},
+		{
+			name:         "compressed tar format",
+			spec:         pulpv1.PulpBackupSpec{DatabaseDumpFormat: databaseDumpTar, DatabaseDumpCompression: "zstd"},
+			expectReason: "DatabaseDumpCompressionUnsupported",
+		},
+		{
+			name:         "parallel jobs with custom format",
+			spec:         pulpv1.PulpBackupSpec{DatabaseDumpFormat: databaseDumpCustom, DatabaseDumpJobs: 4},
+			expectReason: "DatabaseDumpJobsUnsupported",
+		},
+		{
+			name:         "encrypted directory format",
+			spec:         pulpv1.PulpBackupSpec{DatabaseDumpFormat: databaseDumpDirectory, EncryptionSecret: "backup-passphrase"},
+			expectReason: "DirectoryEncryptionUnsupported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBackupMode(&pulpv1.PulpBackup{Spec: tt.spec})
+			if len(tt.expectReason) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err.message)
+				}
+				return
+			}
+			if err == nil || err.reason != tt.expectReason {
+				t.Errorf("expected %v, got %+v", tt.expectReason, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package repo_manager_backup
+
+import (
+	"context"
+	goerrors "errors"
+	"path/filepath"
+	"strings"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// backupFinalizer keeps the PulpBackup CR until Pulp is resumed and the backup data is handled
+	// according to deletion_policy
+	backupFinalizer = "repo-manager.pulpproject.org/backup-cleanup"
+
+	deletionPolicyDelete = "Delete"
+
+	deleteJobSuffix       = "-backup-delete"
+	deleteRemoteJobSuffix = "-backup-delete-remote"
+
+	// rcloneDirNotFound is the exit code of rclone when the directory does not exist
+	rcloneDirNotFound = "3"
+)
+
+// deleteBackup runs when the PulpBackup CR is deleted. It resumes Pulp if it was quiesced by the
+// backup, removes the backup data if deletion_policy is Delete and then removes the finalizer.
+func (r *RepoManagerBackupReconciler) deleteBackup(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (ctrl.Result, error) {
+	log := r.RawLogger
+	if !controllerutil.ContainsFinalizer(pulpBackup, backupFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	// Pulp should not be kept scaled down if the backup is removed while it is running
+	if len(pulpBackup.Status.QuiescedComponents) > 0 {
+		if _, err := r.resumePulp(ctx, pulpBackup); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Status().Update(ctx, pulpBackup); err != nil {
+			log.Error(err, "Failed to update PulpBackup status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// the jobs of a running backup are stopped before its files are removed
+	r.cleanup(ctx, pulpBackup, r.phases(), "")
+
+	if pulpBackup.Spec.DeletionPolicy == deletionPolicyDelete {
+		deleted, err := r.deleteBackupData(ctx, pulpBackup)
+		jobErr, deletionErr := &controllers.JobFailedError{}, &backupFailedError{}
+		if goerrors.As(err, &jobErr) {
+			// the finalizer is kept, the failed job should be removed to try again or deletion_policy
+			// set to Retain to keep the data
+			log.Error(err, "Failed to remove the backup data")
+			r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupDeleted", "Failed to remove the backup data! "+jobErr.Message+" Remove "+jobErr.Name+" job to try again or set deletion_policy to Retain.", "FailedDeletingBackup")
+			return ctrl.Result{}, nil
+		} else if goerrors.As(err, &deletionErr) {
+			// the secrets are not watched, so the configuration is checked again after a while
+			log.Error(err, "Failed to remove the backup data")
+			r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupDeleted", deletionErr.message, deletionErr.reason)
+			return ctrl.Result{RequeueAfter: jobPollInterval}, nil
+		} else if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !deleted {
+			if condition := v1.FindStatusCondition(pulpBackup.Status.Conditions, "BackupDeleted"); condition == nil || condition.Reason != "DeletingBackup" {
+				r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupDeleted", "Removing backup data ...", "DeletingBackup")
+			}
+			return ctrl.Result{RequeueAfter: jobPollInterval}, nil
+		}
+		log.Info("Backup data removed!", "Directory", pulpBackup.Status.BackupDirectory)
+	}
+
+	log.Info("Removing PulpBackup finalizer")
+	controllerutil.RemoveFinalizer(pulpBackup, backupFinalizer)
+	if err := r.Update(ctx, pulpBackup); err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to remove PulpBackup finalizer")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// deleteBackupData removes the VolumeSnapshots, the copy uploaded to object storage and the backup
+// directory (or the backup PVC if it was provisioned only for this backup).
+// It returns true when all of them are removed.
+func (r *RepoManagerBackupReconciler) deleteBackupData(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
+	log := r.RawLogger
+	for _, backupSnapshot := range pulpBackup.Status.VolumeSnapshots {
+		snapshot := controllers.VolumeSnapshot(backupSnapshot.Name, pulpBackup.Namespace)
+		if err := r.Delete(ctx, snapshot); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to remove VolumeSnapshot", "VolumeSnapshot.Name", backupSnapshot.Name)
+			return false, err
+		}
+	}
+
+	// make sure that we will never remove anything outside of the backup mount point
+	backupDir := filepath.Clean(pulpBackup.Status.BackupDirectory)
+	validBackupDir := strings.HasPrefix(backupDir, controllers.BackupMountPath+"/")
+
+	// the remote copy is removed first because the job mounts the backup PVC
+	if len(pulpBackup.Status.ObjectStorageLocation) > 0 && pulpBackup.Spec.ObjectStorage != nil && !validBackupDir {
+		log.Info("Invalid backup directory, the copy uploaded to object storage will not be removed", "Location", pulpBackup.Status.ObjectStorageLocation)
+	} else if len(pulpBackup.Status.ObjectStorageLocation) > 0 && pulpBackup.Spec.ObjectStorage != nil {
+		remote, err := controllers.NewObjectStorageRemote(ctx, r.Client, pulpBackup.Namespace, pulpBackup.Spec.ObjectStorage)
+		if err != nil {
+			log.Error(err, "Invalid object_storage configuration")
+			return false, &backupFailedError{"FailedDeletingBackup", "Failed to remove the copy uploaded to object storage! " + err.Error() +
+				" Fix the object_storage configuration to try again, remove object_storage to keep the copy, or set deletion_policy to Retain."}
+		}
+		job := controllers.ObjectStorageScriptJob(pulpBackup.Name+deleteRemoteJobSuffix, pulpBackup.Namespace, getBackupPVC(pulpBackup), controllers.BackupMountPath, remote, pulpBackup.Spec.Affinity,
+			"rclone purge "+remote.Path(backupDir)+" || [ $? -eq "+rcloneDirNotFound+" ]",
+		)
+		if finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpBackup, job); !finished {
+			return false, err
+		}
+	}
+
+	// the PVC provisioned for this backup has no other backups
+	if pvc, err := r.provisionedBackupPVC(ctx, pulpBackup); err != nil {
+		return false, err
+	} else if pvc != nil {
+		log.Info("Removing backup PVC", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+		if err := r.Delete(ctx, pvc); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to remove backup PVC", "PVC.Name", pvc.Name)
+			return false, err
+		}
+		return true, nil
+	}
+
+	if !validBackupDir {
+		log.Info("Backup directory is not in the backup PVC, it will not be removed", "Directory", pulpBackup.Status.BackupDirectory)
+		return true, nil
+	}
+	job := backupManagerJob(pulpBackup, deleteJobSuffix, "rm -rf -- "+backupDir)
+	return controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpBackup, job)
+}
+
+// provisionedBackupPVC returns the backup PVC if it was provisioned by createBackupPVC only for this
+// backup (the default <PulpBackup name>-backup-claim created with the labels of this backup), so it
+// has no other backups. A backup_pvc provided by the user, or shared with other backups, is never returned.
+func (r *RepoManagerBackupReconciler) provisionedBackupPVC(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (*corev1.PersistentVolumeClaim, error) {
+	if pulpBackup.Status.BackupClaim != pulpBackup.Name+"-backup-claim" {
+		return nil, nil
+	}
+	namespace := pulpBackup.Status.BackupNamespace
+	if len(namespace) == 0 {
+		namespace = pulpBackup.Namespace
+	}
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := r.Get(ctx, types.NamespacedName{Name: pulpBackup.Status.BackupClaim, Namespace: namespace}, pvc)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if pvc.Labels["app.kubernetes.io/instance"] != "pulp-backup-storage-"+pulpBackup.Name {
+		return nil, nil
+	}
+	return pvc, nil
+}
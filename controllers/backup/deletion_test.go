@@ -0,0 +1,190 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_backup
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestDeleteBackup verifies the backup data removed (or kept) according to deletion_policy and the
+// Pulp components resumed when a PulpBackup is deleted
+func TestDeleteBackup(t *testing.T) {
+	tests := []struct {
+		name            string
+		deletionPolicy  string
+		backupPVC       string
+		provisioned     bool
+		backupDirectory string
+		expectJob       bool
+		expectPVC       bool
+		expectSnapshots bool
+	}{
+		{name: "retain", backupPVC: "shared-backup-claim", expectPVC: true, expectSnapshots: true},
+		{name: "delete with the PVC of the backup", deletionPolicy: deletionPolicyDelete, provisioned: true},
+		{name: "delete with the PVC of the backup and an invalid directory", deletionPolicy: deletionPolicyDelete, provisioned: true, backupDirectory: "/"},
+		{name: "delete from a shared PVC", deletionPolicy: deletionPolicyDelete, backupPVC: "shared-backup-claim", expectJob: true, expectPVC: true},
+		{name: "delete from a PVC not provisioned for the backup", deletionPolicy: deletionPolicyDelete, backupPVC: "backup-backup-claim", expectJob: true, expectPVC: true},
+		{name: "delete from a shared PVC with an invalid directory", deletionPolicy: deletionPolicyDelete, backupPVC: "shared-backup-claim", backupDirectory: "/backups/../etc", expectPVC: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = corev1.AddToScheme(scheme)
+			_ = batchv1.AddToScheme(scheme)
+			_ = pulpv1.AddToScheme(scheme)
+			scheme.AddKnownTypeWithName(controllers.VolumeSnapshotGVK, &unstructured.Unstructured{})
+
+			pulp := &pulpv1.Pulp{
+				ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+				Spec:       pulpv1.PulpSpec{Worker: pulpv1.Worker{Replicas: 0}},
+			}
+			now := metav1.Now()
+			pulpBackup := &pulpv1.PulpBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "test-namespace", Finalizers: []string{backupFinalizer}, DeletionTimestamp: &now},
+				Spec:       pulpv1.PulpBackupSpec{DeploymentName: "pulp", BackupPVC: tt.backupPVC, DeletionPolicy: tt.deletionPolicy},
+			}
+			setStatusFields(pulpBackup, "2026-01-01-000000")
+			if len(tt.backupDirectory) > 0 {
+				pulpBackup.Status.BackupDirectory = tt.backupDirectory
+			}
+			pulpBackup.Status.Phase = phaseBackupDir
+			pulpBackup.Status.QuiescedComponents = []pulpv1.QuiescedComponent{{Name: "Worker", Replicas: 3}}
+			pulpBackup.Status.VolumeSnapshots = []pulpv1.BackupVolumeSnapshot{{Name: "backup-file-storage", Volume: volumePulpDir}}
+			backupPVC := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: pulpBackup.Status.BackupClaim, Namespace: "test-namespace"}}
+			if tt.provisioned {
+				backupPVC.Labels = map[string]string{"app.kubernetes.io/instance": "pulp-backup-storage-backup"}
+			}
+			snapshot := controllers.VolumeSnapshot("backup-file-storage", "test-namespace")
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulp, pulpBackup, backupPVC, snapshot).WithStatusSubresource(pulpBackup).Build()
+			r := &RepoManagerBackupReconciler{Client: c, RawLogger: logr.Discard(), Scheme: scheme}
+			ctx := context.TODO()
+
+			if _, err := r.deleteBackup(ctx, pulpBackup); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resumed := &pulpv1.Pulp{}
+			if err := c.Get(ctx, types.NamespacedName{Name: "pulp", Namespace: "test-namespace"}, resumed); err != nil {
+				t.Fatal(err)
+			}
+			if resumed.Spec.Worker.Replicas != 3 {
+				t.Errorf("expected the workers to be resumed with 3 replicas, got %d", resumed.Spec.Worker.Replicas)
+			}
+
+			job := &batchv1.Job{}
+			err := c.Get(ctx, types.NamespacedName{Name: "backup" + deleteJobSuffix, Namespace: "test-namespace"}, job)
+			if tt.expectJob != (err == nil) {
+				t.Fatalf("expected delete job %v, got %v", tt.expectJob, err)
+			}
+			if tt.expectJob {
+				if command := strings.Join(job.Spec.Template.Spec.Containers[0].Command, " "); !strings.Contains(command, "rm -rf -- /backups/openshift-backup-2026-01-01-000000") {
+					t.Errorf("expected the job to remove the backup directory, got %v", command)
+				}
+				// the finalizer is kept until the job finishes
+				if err := c.Get(ctx, client.ObjectKeyFromObject(pulpBackup), pulpBackup); err != nil {
+					t.Fatalf("expected the PulpBackup to be kept while the job runs: %v", err)
+				}
+				job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+				if err := c.Status().Update(ctx, job); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := r.deleteBackup(ctx, pulpBackup); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			if err := c.Get(ctx, client.ObjectKeyFromObject(pulpBackup), &pulpv1.PulpBackup{}); !errors.IsNotFound(err) {
+				t.Errorf("expected the PulpBackup to be removed, got %v", err)
+			}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(backupPVC), backupPVC); tt.expectPVC != (err == nil) {
+				t.Errorf("expected backup PVC %v, got %v", tt.expectPVC, err)
+			}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(snapshot), snapshot); tt.expectSnapshots != (err == nil) {
+				t.Errorf("expected VolumeSnapshot %v, got %v", tt.expectSnapshots, err)
+			}
+		})
+	}
+}
+
+// TestDeleteBackupObjectStorageError verifies that the PulpBackup is kept, with the reason in the
+// BackupDeleted condition, while the object_storage secret is missing and released once object_storage
+// is removed
+func TestDeleteBackupObjectStorageError(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = pulpv1.AddToScheme(scheme)
+
+	now := metav1.Now()
+	pulpBackup := &pulpv1.PulpBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "test-namespace", Finalizers: []string{backupFinalizer}, DeletionTimestamp: &now},
+		Spec: pulpv1.PulpBackupSpec{
+			DeploymentName: "pulp",
+			BackupPVC:      "shared-backup-claim",
+			DeletionPolicy: deletionPolicyDelete,
+			ObjectStorage:  &pulpv1.BackupObjectStorage{S3Secret: "backup-s3", Bucket: "dr"},
+		},
+	}
+	setStatusFields(pulpBackup, "2026-01-01-000000")
+	pulpBackup.Status.ObjectStorageLocation = "s3://dr/openshift-backup-2026-01-01-000000"
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulpBackup).WithStatusSubresource(pulpBackup).Build()
+	r := &RepoManagerBackupReconciler{Client: c, RawLogger: logr.Discard(), Scheme: scheme}
+	ctx := context.TODO()
+
+	result, err := r.deleteBackup(ctx, pulpBackup)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RequeueAfter == 0 {
+		t.Error("expected the deletion to be retried")
+	}
+	current := &pulpv1.PulpBackup{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(pulpBackup), current); err != nil {
+		t.Fatalf("expected the PulpBackup to be kept: %v", err)
+	}
+	if condition := v1.FindStatusCondition(current.Status.Conditions, "BackupDeleted"); condition == nil || condition.Reason != "FailedDeletingBackup" {
+		t.Errorf("expected the FailedDeletingBackup reason, got %+v", condition)
+	}
+
+	// without object_storage the remote copy is kept and the directory removed
+	current.Spec.ObjectStorage = nil
+	if _, err := r.deleteBackup(ctx, current); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Name: "backup" + deleteJobSuffix, Namespace: "test-namespace"}, &batchv1.Job{}); err != nil {
+		t.Errorf("expected the delete job, got %v", err)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Name: "backup" + deleteRemoteJobSuffix, Namespace: "test-namespace"}, &batchv1.Job{}); !errors.IsNotFound(err) {
+		t.Errorf("expected no delete remote job, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package repo_manager_backup
+
+import (
+	"context"
+	goerrors "errors"
+	"strconv"
+	"strings"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// stages of the backup hooks
+	hookStagePreBackup  = "PreBackup"
+	hookStagePostBackup = "PostBackup"
+
+	preBackupHookJobSuffix  = "-backup-pre-hook-"
+	postBackupHookJobSuffix = "-backup-post-hook-"
+
+	hookFailurePolicyContinue = "Continue"
+)
+
+// runPreBackupHooks runs the pre_backup_hooks
+func (r *RepoManagerBackupReconciler) runPreBackupHooks(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
+	return r.runHooks(ctx, pulpBackup, hookStagePreBackup, pulpBackup.Spec.PreBackupHooks)
+}
+
+// runPostBackupHooks runs the post_backup_hooks
+func (r *RepoManagerBackupReconciler) runPostBackupHooks(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
+	return r.runHooks(ctx, pulpBackup, hookStagePostBackup, pulpBackup.Spec.PostBackupHooks)
+}
+
+// runFailureHooks runs the post_backup_hooks of a failed backup (in the RunningFailureHooks phase), then
+// removes their jobs and sets the Failed phase, keeping the BackupComplete condition set by backupFailed
+func (r *RepoManagerBackupReconciler) runFailureHooks(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (ctrl.Result, error) {
+	if finished, err := r.runPostBackupHooks(ctx, pulpBackup); err != nil {
+		return ctrl.Result{}, err
+	} else if !finished {
+		return ctrl.Result{RequeueAfter: jobPollInterval}, nil
+	}
+
+	for _, hook := range pulpBackup.Spec.PostBackupHooks {
+		job := pulpBackup.Name + postBackupHookJobSuffix + hook.Name
+		if err := controllers.DeleteJob(ctx, r.Client, job, pulpBackup.Namespace); err != nil {
+			r.RawLogger.Error(err, "Failed to remove backup hook job", "Job.Name", job)
+		}
+	}
+	pulpBackup.Status.Phase = phaseFailed
+	condition := v1.FindStatusCondition(pulpBackup.Status.Conditions, "BackupComplete")
+	r.updateStatus(ctx, pulpBackup, metav1.ConditionFalse, "BackupComplete", condition.Message, condition.Reason)
+	return ctrl.Result{}, nil
+}
+
+// runsFailureHooks returns true if the post_backup_hooks should run after the backup failed in phase:
+// the backup-manager image and the backup PVC are ready and the post_backup_hooks did not run yet
+func runsFailureHooks(phases []backupPhase, pulpBackup *pulpv1.PulpBackup, phase string) bool {
+	index := phaseIndex(phases, phase)
+	return len(pulpBackup.Spec.PostBackupHooks) > 0 &&
+		index >= phaseIndex(phases, phasePreBackupHooks) && index < phaseIndex(phases, phasePostBackupHooks)
+}
+
+// runHooks runs the hooks of the stage in order, one job at a time, and stores their results in the status.
+// The hooks with a result are not run again if the backup is resumed.
+// A failed hook stops the backup unless its failure_policy is Continue or the backup already failed.
+func (r *RepoManagerBackupReconciler) runHooks(ctx context.Context, pulpBackup *pulpv1.PulpBackup, stage string, hooks []pulpv1.BackupHook) (bool, error) {
+	log := r.RawLogger
+	backupFailed := pulpBackup.Status.Phase == phaseFailureHooks
+	for _, hook := range hooks {
+		if hookResult(pulpBackup, stage, hook.Name) != nil {
+			continue
+		}
+		job, err := r.hookJob(ctx, pulpBackup, stage, hook)
+		if err != nil {
+			return false, err
+		}
+
+		finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpBackup, job)
+		jobErr := &controllers.JobFailedError{}
+		if goerrors.As(err, &jobErr) {
+			setHookResult(pulpBackup, stage, hook.Name, job.Name, controllers.PhaseFailed, jobErr.Message, backupFailed)
+			if hook.FailurePolicy != hookFailurePolicyContinue && !backupFailed {
+				return false, &backupFailedError{"FailedBackupHook", stage + " hook " + hook.Name + " failed: " + jobErr.Message}
+			}
+			log.Error(err, "Backup hook failed, continuing the backup", "Stage", stage, "Hook", hook.Name)
+			r.recorder.Event(pulpBackup, corev1.EventTypeWarning, "BackupHookFailed", stage+" hook "+hook.Name+" failed: "+jobErr.Message)
+		} else if !finished || err != nil {
+			return false, err
+		} else {
+			log.Info("Backup hook finished", "Stage", stage, "Hook", hook.Name)
+			setHookResult(pulpBackup, stage, hook.Name, job.Name, controllers.PhaseSucceeded, "", backupFailed)
+		}
+		if err := r.Status().Update(ctx, pulpBackup); err != nil {
+			log.Error(err, "Failed to store the result of backup hook "+hook.Name)
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// checkBackupHooks returns the error that stops the backup if a hook name is not a valid (DNS-1123) label,
+// it is used in the name of the hook job, or if it is used by more than one hook of the same stage
+func checkBackupHooks(pulpBackup *pulpv1.PulpBackup) *backupFailedError {
+	for _, stage := range []string{hookStagePreBackup, hookStagePostBackup} {
+		hooks := pulpBackup.Spec.PreBackupHooks
+		if stage == hookStagePostBackup {
+			hooks = pulpBackup.Spec.PostBackupHooks
+		}
+		names := map[string]bool{}
+		for _, hook := range hooks {
+			if errs := validation.IsDNS1123Label(hook.Name); len(errs) > 0 {
+				return &backupFailedError{"InvalidBackupHook", stage + " hook name " + hook.Name + " is not valid: " + strings.Join(errs, ", ")}
+			}
+			if names[hook.Name] {
+				return &backupFailedError{"InvalidBackupHook", stage + " hook name " + hook.Name + " is used by more than one hook."}
+			}
+			names[hook.Name] = true
+		}
+	}
+	return nil
+}
+
+// hookJob returns the job that runs the hook. It runs in the backup-manager image, with the backup PVC
+// mounted, unless another image is defined by the hook. BACKUP_FAILED is "true" if the post_backup_hooks
+// run after the backup failed.
+func (r *RepoManagerBackupReconciler) hookJob(ctx context.Context, pulpBackup *pulpv1.PulpBackup, stage string, hook pulpv1.BackupHook) (*batchv1.Job, error) {
+	job := backupManagerJob(pulpBackup, hookJobSuffix(stage)+hook.Name, "")
+	container := &job.Spec.Template.Spec.Containers[0]
+	container.Command = hook.Command
+	container.Env = append(controllers.PostgresEnv(getPostgresCfgSecret(pulpBackup)),
+		corev1.EnvVar{Name: "BACKUP_DIR", Value: pulpBackup.Status.BackupDirectory},
+		corev1.EnvVar{Name: "DEPLOYMENT_NAME", Value: getDeploymentName(pulpBackup)},
+		corev1.EnvVar{Name: "BACKUP_FAILED", Value: strconv.FormatBool(pulpBackup.Status.Phase == phaseFailureHooks)},
+	)
+	container.Env = append(container.Env, hook.Env...)
+
+	if hook.PulpSettings {
+		pulp := &pulpv1.Pulp{}
+		if err := r.Get(ctx, types.NamespacedName{Name: getDeploymentName(pulpBackup), Namespace: pulpBackup.Namespace}, pulp); err != nil {
+			r.RawLogger.Error(err, "Failed to get Pulp")
+			return nil, err
+		}
+		container.Image = controllers.PulpImage(*pulp)
+		controllers.MountPulpSettings(job, pulp)
+	}
+	if len(hook.Image) > 0 {
+		container.Image = hook.Image
+	}
+	if hook.TimeoutSeconds > 0 {
+		timeout := hook.TimeoutSeconds
+		job.Spec.ActiveDeadlineSeconds = &timeout
+	}
+	return job, nil
+}
+
+// hookJobSuffix returns the suffix, followed by the hook name, of the jobs that run the hooks of the stage
+func hookJobSuffix(stage string) string {
+	if stage == hookStagePostBackup {
+		return postBackupHookJobSuffix
+	}
+	return preBackupHookJobSuffix
+}
+
+// hookJobs returns the names of the jobs of all the hooks
+func hookJobs(pulpBackup *pulpv1.PulpBackup) []string {
+	jobs := []string{}
+	for _, hook := range pulpBackup.Spec.PreBackupHooks {
+		jobs = append(jobs, pulpBackup.Name+preBackupHookJobSuffix+hook.Name)
+	}
+	for _, hook := range pulpBackup.Spec.PostBackupHooks {
+		jobs = append(jobs, pulpBackup.Name+postBackupHookJobSuffix+hook.Name)
+	}
+	return jobs
+}
+
+// hookResult returns the result of the hook of the stage, or nil if it did not run yet
+func hookResult(pulpBackup *pulpv1.PulpBackup, stage, name string) *pulpv1.BackupHookResult {
+	for i, result := range pulpBackup.Status.HookResults {
+		if result.Stage == stage && result.Name == name {
+			return &pulpBackup.Status.HookResults[i]
+		}
+	}
+	return nil
+}
+
+// setHookResult stores the result of the hook of the stage
+func setHookResult(pulpBackup *pulpv1.PulpBackup, stage, name, job, state, message string, backupFailed bool) {
+	pulpBackup.Status.HookResults = append(pulpBackup.Status.HookResults, pulpv1.BackupHookResult{
+		Name:           name,
+		Stage:          stage,
+		State:          state,
+		Job:            job,
+		CompletionTime: metav1.Now(),
+		Message:        message,
+		BackupFailed:   backupFailed,
+	})
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_backup
+
+import (
+	"context"
+	goerrors "errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestRunHooks verifies that the hooks run in order and that a failed hook stops the backup unless its
+// failure_policy is Continue
+func TestRunHooks(t *testing.T) {
+	tests := []struct {
+		name          string
+		failurePolicy string
+		expectError   bool
+		expectStates  []string
+	}{
+		{name: "abort", expectError: true, expectStates: []string{controllers.PhaseSucceeded, controllers.PhaseFailed}},
+		{name: "continue", failurePolicy: hookFailurePolicyContinue, expectStates: []string{controllers.PhaseSucceeded, controllers.PhaseFailed, controllers.PhaseSucceeded}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = corev1.AddToScheme(scheme)
+			_ = batchv1.AddToScheme(scheme)
+			_ = pulpv1.AddToScheme(scheme)
+
+			hooks := []pulpv1.BackupHook{
+				{Name: "notify", Command: []string{"true"}},
+				{Name: "check", Command: []string{"false"}, FailurePolicy: tt.failurePolicy},
+				{Name: "flush", Command: []string{"true"}},
+			}
+			pulpBackup := &pulpv1.PulpBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "test-namespace"},
+				Spec:       pulpv1.PulpBackupSpec{DeploymentName: "pulp", PreBackupHooks: hooks},
+			}
+			setStatusFields(pulpBackup, "2026-01-01-000000")
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulpBackup).WithStatusSubresource(pulpBackup).Build()
+			r := &RepoManagerBackupReconciler{Client: c, RawLogger: logr.Discard(), Scheme: scheme, recorder: record.NewFakeRecorder(10)}
+			ctx := context.TODO()
+
+			var finished bool
+			var err error
+			for _, hook := range hooks {
+				if finished, err = r.runPreBackupHooks(ctx, pulpBackup); finished || err != nil {
+					break
+				}
+				job := &batchv1.Job{}
+				if err := c.Get(ctx, types.NamespacedName{Name: "backup" + preBackupHookJobSuffix + hook.Name, Namespace: "test-namespace"}, job); err != nil {
+					t.Fatalf("expected the job of hook %v: %v", hook.Name, err)
+				}
+				if !reflect.DeepEqual(job.Spec.Template.Spec.Containers[0].Command, hook.Command) {
+					t.Errorf("expected command %v, got %v", hook.Command, job.Spec.Template.Spec.Containers[0].Command)
+				}
+				condition := batchv1.JobComplete
+				if hook.Name == "check" {
+					condition = batchv1.JobFailed
+				}
+				job.Status.Conditions = []batchv1.JobCondition{{Type: condition, Status: corev1.ConditionTrue, Message: "hook failed"}}
+				if err := c.Status().Update(ctx, job); err != nil {
+					t.Fatal(err)
+				}
+				finished, err = r.runPreBackupHooks(ctx, pulpBackup)
+				if finished || err != nil {
+					break
+				}
+			}
+
+			backupErr := &backupFailedError{}
+			if tt.expectError != goerrors.As(err, &backupErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectError, err)
+			}
+			if finished == tt.expectError {
+				t.Errorf("expected finished %v, got %v", !tt.expectError, finished)
+			}
+			states := []string{}
+			for _, result := range pulpBackup.Status.HookResults {
+				states = append(states, result.State)
+			}
+			if !reflect.DeepEqual(states, tt.expectStates) {
+				t.Errorf("expected hook results %v, got %+v", tt.expectStates, pulpBackup.Status.HookResults)
+			}
+		})
+	}
+}
+
+// TestHookJob verifies the image, settings and timeout of the job that runs a hook
+func TestHookJob(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = pulpv1.AddToScheme(scheme)
+	pulp := &pulpv1.Pulp{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+		Spec:       pulpv1.PulpSpec{Image: "quay.io/pulp/pulp-minimal", ImageVersion: "3.65"},
+	}
+	pulpBackup := &pulpv1.PulpBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "test-namespace"},
+		Spec:       pulpv1.PulpBackupSpec{DeploymentName: "pulp"},
+		Status:     pulpv1.PulpBackupStatus{BackupManagerImage: "docker.io/library/postgres:16"},
+	}
+	r := &RepoManagerBackupReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulp).Build(), RawLogger: logr.Discard(), Scheme: scheme}
+
+	tests := []struct {
+		name           string
+		hook           pulpv1.BackupHook
+		expectImage    string
+		expectSettings bool
+	}{
+		{name: "backup manager", hook: pulpv1.BackupHook{Name: "notify", Command: []string{"true"}}, expectImage: "docker.io/library/postgres:16"},
+		{name: "custom image", hook: pulpv1.BackupHook{Name: "notify", Image: "quay.io/curl/curl", Command: []string{"curl"}, TimeoutSeconds: 60}, expectImage: "quay.io/curl/curl"},
+		{name: "pulp settings", hook: pulpv1.BackupHook{Name: "check", Command: []string{"pulpcore-manager", "check"}, PulpSettings: true}, expectImage: "quay.io/pulp/pulp-minimal:3.65", expectSettings: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := r.hookJob(context.TODO(), pulpBackup, hookStagePostBackup, tt.hook)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if job.Name != "backup"+postBackupHookJobSuffix+tt.hook.Name {
+				t.Errorf("unexpected job name %v", job.Name)
+			}
+			container := job.Spec.Template.Spec.Containers[0]
+			if container.Image != tt.expectImage {
+				t.Errorf("expected image %v, got %v", tt.expectImage, container.Image)
+			}
+			settingsMounted := false
+			for _, mount := range container.VolumeMounts {
+				settingsMounted = settingsMounted || mount.MountPath == "/etc/pulp/settings.py"
+			}
+			if settingsMounted != tt.expectSettings {
+				t.Errorf("expected settings mounted %v, got %+v", tt.expectSettings, container.VolumeMounts)
+			}
+			if (job.Spec.ActiveDeadlineSeconds != nil) != (tt.hook.TimeoutSeconds > 0) {
+				t.Errorf("expected timeout %v, got %v", tt.hook.TimeoutSeconds, job.Spec.ActiveDeadlineSeconds)
+			}
+		})
+	}
+}
+
+// TestRunFailureHooks verifies that the post_backup_hooks run, with BACKUP_FAILED, after the backup failed
+// and that the backup is set Failed, keeping the failure in the BackupComplete condition, once they finished
+func TestRunFailureHooks(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = pulpv1.AddToScheme(scheme)
+
+	hooks := []pulpv1.BackupHook{
+		{Name: "notify", Command: []string{"false"}},
+		{Name: "flush", Command: []string{"true"}},
+	}
+	pulpBackup := &pulpv1.PulpBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "test-namespace"},
+		Spec:       pulpv1.PulpBackupSpec{DeploymentName: "pulp", PostBackupHooks: hooks},
+		Status:     pulpv1.PulpBackupStatus{Phase: phaseBackupDB},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulpBackup).WithStatusSubresource(pulpBackup).Build()
+	r := &RepoManagerBackupReconciler{Client: c, RawLogger: logr.Discard(), Scheme: scheme, recorder: record.NewFakeRecorder(10)}
+	ctx := context.TODO()
+
+	err := &controllers.JobFailedError{Name: "backup" + databaseJobSuffix, Message: "pg_dump failed"}
+	if _, err := r.backupFailed(ctx, pulpBackup, err, "Failed to backup database!", "Failed"+phaseBackupDB); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pulpBackup.Status.Phase != phaseFailureHooks {
+		t.Fatalf("expected phase %s, got %s", phaseFailureHooks, pulpBackup.Status.Phase)
+	}
+
+	for _, hook := range hooks {
+		if _, err := r.runFailureHooks(ctx, pulpBackup); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		job := &batchv1.Job{}
+		if err := c.Get(ctx, types.NamespacedName{Name: "backup" + postBackupHookJobSuffix + hook.Name, Namespace: "test-namespace"}, job); err != nil {
+			t.Fatalf("expected the job of hook %v: %v", hook.Name, err)
+		}
+		env := corev1.EnvVar{Name: "BACKUP_FAILED", Value: "true"}
+		found := false
+		for _, e := range job.Spec.Template.Spec.Containers[0].Env {
+			found = found || e == env
+		}
+		if !found {
+			t.Errorf("expected %v in the env of the hook %v", env, hook.Name)
+		}
+		// the failure of a hook (with the Abort failure_policy) does not stop the other ones
+		condition := batchv1.JobComplete
+		if hook.Name == "notify" {
+			condition = batchv1.JobFailed
+		}
+		job.Status.Conditions = []batchv1.JobCondition{{Type: condition, Status: corev1.ConditionTrue, Message: "hook failed"}}
+		if err := c.Status().Update(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.runFailureHooks(ctx, pulpBackup); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if pulpBackup.Status.Phase != phaseFailed {
+		t.Errorf("expected phase %s, got %s", phaseFailed, pulpBackup.Status.Phase)
+	}
+	if condition := v1.FindStatusCondition(pulpBackup.Status.Conditions, "BackupComplete"); condition == nil || condition.Reason != "Failed"+phaseBackupDB {
+		t.Errorf("expected the BackupComplete condition of the failed backup, got %+v", condition)
+	}
+	states := []string{}
+	for _, result := range pulpBackup.Status.HookResults {
+		if !result.BackupFailed {
+			t.Errorf("expected the hook %v to be recorded as run after the failure", result.Name)
+		}
+		states = append(states, result.State)
+	}
+	if !reflect.DeepEqual(states, []string{controllers.PhaseFailed, controllers.PhaseSucceeded}) {
+		t.Errorf("unexpected hook results %+v", pulpBackup.Status.HookResults)
+	}
+	for _, hook := range hooks {
+		if err := c.Get(ctx, types.NamespacedName{Name: "backup" + postBackupHookJobSuffix + hook.Name, Namespace: "test-namespace"}, &batchv1.Job{}); !errors.IsNotFound(err) {
+			t.Errorf("expected the job of hook %v to be removed, got %v", hook.Name, err)
+		}
+	}
+}
+
+// TestCheckBackupHooks verifies that the backup fails if a hook name is not a DNS-1123 label or is used
+// by more than one hook of the same stage
+func TestCheckBackupHooks(t *testing.T) {
+	tests := []struct {
+		name        string
+		pre, post   []string
+		expectError bool
+	}{
+		{name: "valid", pre: []string{"check"}, post: []string{"check", "notify-1"}},
+		{name: "invalid name", pre: []string{"Check_DB"}, expectError: true},
+		{name: "duplicated name", post: []string{"notify", "notify"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pulpBackup := &pulpv1.PulpBackup{}
+			for _, name := range tt.pre {
+				pulpBackup.Spec.PreBackupHooks = append(pulpBackup.Spec.PreBackupHooks, pulpv1.BackupHook{Name: name})
+			}
+			for _, name := range tt.post {
+				pulpBackup.Spec.PostBackupHooks = append(pulpBackup.Spec.PostBackupHooks, pulpv1.BackupHook{Name: name})
+			}
+			err := checkBackupHooks(pulpBackup)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("expected error %v, got %v", tt.expectError, err)
+			}
+			if err != nil && err.reason != "InvalidBackupHook" {
+				t.Errorf("unexpected reason %v", err.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package repo_manager_backup
+
+import (
+	"context"
+	"fmt"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const manifestJobSuffix = "-backup-manifest"
+
+// createBackupManifest stores, in the backup directory, a manifest with the backup metadata
+// and the size and checksum of each backup file so that the backup can be verified before a restore
+func (r *RepoManagerBackupReconciler) createBackupManifest(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
+	log := r.RawLogger
+
+	pulp := &pulpv1.Pulp{}
+	if err := r.Get(ctx, types.NamespacedName{Name: getDeploymentName(pulpBackup), Namespace: pulpBackup.Namespace}, pulp); err != nil {
+		log.Error(err, "Failed to get Pulp")
+		return false, err
+	}
+	pulpImage := pulp.Status.Image
+	if len(pulpImage) == 0 {
+		pulpImage = pulp.Spec.Image + ":" + pulp.Spec.ImageVersion
+	}
+
+	job := backupManagerJob(pulpBackup, manifestJobSuffix, controllers.BackupManifestScript(pulpBackup.Status.BackupDirectory))
+	job.Spec.Template.Spec.Containers[0].Env = []corev1.EnvVar{
+		{Name: "OPERATOR_VERSION", Value: r.OperatorVersion},
+		{Name: "DEPLOYMENT_NAME", Value: getDeploymentName(pulpBackup)},
+		{Name: "NAMESPACE", Value: pulpBackup.Namespace},
+		{Name: "PULP_IMAGE", Value: pulpImage},
+		{Name: "DATABASE_DUMP_JOBS", Value: fmt.Sprint(databaseDumpJobs(pulpBackup.Spec))},
+	}
+	if isEncrypted(pulpBackup) {
+		job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "ENCRYPTION", Value: controllers.BackupEncryptionGPG})
+	}
+	encryptJob(job, pulpBackup)
+
+	finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpBackup, job)
+	if finished {
+		log.Info("Backup manifest created!")
+	}
+	return finished, err
+}
@@ -0,0 +1,38 @@
+package repo_manager_backup
+
+import (
+	"context"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+)
+
+const uploadJobSuffix = "-backup-upload"
+
+// uploadBackup copies the backup directory from the backup PVC into the object storage
+func (r *RepoManagerBackupReconciler) uploadBackup(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
+	log := r.RawLogger
+	backupDir := pulpBackup.Status.BackupDirectory
+
+	if pulpBackup.Spec.ObjectStorage == nil {
+		return true, nil
+	}
+
+	remote, err := controllers.NewObjectStorageRemote(ctx, r.Client, pulpBackup.Namespace, pulpBackup.Spec.ObjectStorage)
+	if err != nil {
+		log.Error(err, "Invalid object_storage configuration")
+		return false, err
+	}
+
+	job := controllers.ObjectStorageJob(pulpBackup.Name+uploadJobSuffix, pulpBackup.Namespace, getBackupPVC(pulpBackup), controllers.BackupMountPath, remote, pulpBackup.Spec.Affinity,
+		"copy", backupDir, remote.Path(backupDir),
+	)
+	finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpBackup, job)
+	if !finished {
+		return false, err
+	}
+
+	pulpBackup.Status.ObjectStorageLocation = remote.Location(backupDir)
+	log.Info("Backup upload finished!", "Location", pulpBackup.Status.ObjectStorageLocation)
+	return true, nil
+}
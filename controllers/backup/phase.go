@@ -0,0 +1,91 @@
+package repo_manager_backup
+
+import (
+	"context"
+	"time"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+)
+
+const (
+	phaseCreatingPVC     = "CreatingPVC"
+	phaseBackupManager   = "SelectingBackupManagerImage"
+	phaseBackupResources = "BackupResources"
+	phaseQuiescingPulp   = "QuiescingPulp"
+	phasePreBackupHooks  = "PreBackupHooks"
+	phaseBackupDB        = "BackupDB"
+	phaseBackupDir       = "BackupDir"
+	phaseBackupArtifacts = "BackupArtifacts"
+	phasePostBackupHooks = "PostBackupHooks"
+	phaseResumingPulp    = "ResumingPulp"
+	phaseWaitingSnapshot = "WaitingVolumeSnapshots"
+	phaseBackupManifest  = "BackupManifest"
+	phaseUploadBackup    = "UploadBackup"
+	phaseCompleted       = "Completed"
+	phaseFailed          = "Failed"
+
+	// phaseFailureHooks runs the post_backup_hooks of a failed backup, before the Failed phase
+	phaseFailureHooks = "RunningFailureHooks"
+
+	// jobPollInterval is how long to wait before checking a running job again
+	// in case no event from the job is received
+	jobPollInterval = 30 * time.Second
+)
+
+// backupPhase is a step of the backup process
+type backupPhase struct {
+	// name of the phase, it is also used as the BackupComplete condition reason
+	name string
+
+	// suffix of the name of the job that runs the phase (if any)
+	job string
+
+	// message of the BackupComplete condition while the phase is running
+	message string
+
+	// message of the BackupComplete condition if the phase fails
+	failedMessage string
+
+	// run executes the phase and returns true when it is finished.
+	// It should not wait for the jobs, it will be called again until the phase is finished.
+	run func(context.Context, *pulpv1.PulpBackup) (bool, error)
+}
+
+// backupFailedError is returned by a phase when the backup can not continue
+type backupFailedError struct {
+	// reason and message of the BackupComplete condition
+	reason, message string
+}
+
+func (e *backupFailedError) Error() string {
+	return e.message
+}
+
+// phases returns the steps of the backup process in the order they run
+func (r *RepoManagerBackupReconciler) phases() []backupPhase {
+	return []backupPhase{
+		{phaseCreatingPVC, "", "Creating backup pvc ...", "Failed to create backup pvc!", r.createBackupPVC},
+		{phaseBackupManager, databaseVersionJobSuffix, "Selecting backup manager image ...", "Failed to select backup manager image!", r.selectBackupManagerImage},
+		{phaseBackupResources, resourcesJobSuffix, "Running secrets, configmaps and CR backup ...", "Failed to backup secrets, configmaps and CR!", r.backupResources},
+		{phaseQuiescingPulp, "", "Scaling down Pulp components ...", "Failed to scale down Pulp components!", r.quiescePulp},
+		{phasePreBackupHooks, "", "Running pre-backup hooks ...", "Failed to run pre-backup hooks!", r.runPreBackupHooks},
+		{phaseBackupDB, databaseJobSuffix, "Running database backup ...", "Failed to backup database!", r.backupDatabase},
+		{phaseBackupDir, pulpDirJobSuffix, "Running Pulp dir backup ...", "Failed to backup Pulp dir!", r.backupPulpDir},
+		{phaseBackupArtifacts, artifactsJobSuffix, "Copying content from object storage ...", "Failed to copy content from object storage!", r.backupArtifacts},
+		{phasePostBackupHooks, "", "Running post-backup hooks ...", "Failed to run post-backup hooks!", r.runPostBackupHooks},
+		{phaseResumingPulp, "", "Restoring the replicas of Pulp components ...", "Failed to restore the replicas of Pulp components!", r.resumePulp},
+		{phaseWaitingSnapshot, "", "Waiting for the volume snapshots to be ready ...", "Failed to take the volume snapshots!", r.waitVolumeSnapshots},
+		{phaseBackupManifest, manifestJobSuffix, "Creating backup manifest ...", "Failed to create backup manifest!", r.createBackupManifest},
+		{phaseUploadBackup, uploadJobSuffix, "Uploading backup to object storage ...", "Failed to upload backup to object storage!", r.uploadBackup},
+	}
+}
+
+// phaseIndex returns the position of the phase named name, or 0 if it is not found
+func phaseIndex(phases []backupPhase, name string) int {
+	for i, phase := range phases {
+		if phase.name == name {
+			return i
+		}
+	}
+	return 0
+}
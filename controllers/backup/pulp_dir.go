@@ -2,46 +2,131 @@ package repo_manager_backup
 
 import (
 	"context"
+	"path/filepath"
+	"strings"
 
 	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
 	"github.com/pulp/pulp-operator/controllers"
-	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// backupPulpDir copies the content of /var/lib/pulp into the backup PVC
-func (r *RepoManagerBackupReconciler) backupPulpDir(ctx context.Context, pulpBackup *pulpv1.PulpBackup, backupDir string, pod *corev1.Pod) error {
+const (
+	pulpDirJobSuffix = "-backup-dir"
+
+	// pulpDirCopyIncremental hard links the files from the previous backup before the copy
+	pulpDirCopyIncremental = "Incremental"
+)
+
+// backupPulpDir copies the content of /var/lib/pulp into the backup PVC, or takes snapshots of the
+// volumes in case of backup_mode Snapshot
+func (r *RepoManagerBackupReconciler) backupPulpDir(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
 	log := r.RawLogger
+	if pulpBackup.Spec.BackupMode == controllers.BackupModeSnapshot {
+		return r.snapshotVolumes(ctx, pulpBackup)
+	}
 	deploymentName := getDeploymentName(pulpBackup)
-	backupPod := pulpBackup.Name + "-backup-manager"
+	backupDir := pulpBackup.Status.BackupDirectory
 
 	pulp := &pulpv1.Pulp{}
 	if err := r.Get(ctx, types.NamespacedName{Name: deploymentName, Namespace: pulpBackup.Namespace}, pulp); err != nil {
 		log.Error(err, "Failed to get Pulp")
-		return err
+		return false, err
 	}
 
-	if len(pulp.Spec.ObjectStorageAzureSecret) == 0 && len(pulp.Spec.ObjectStorageS3Secret) == 0 && len(pulp.Spec.ObjectStorageGCSSecret) == 0 {
-		log.Info("Starting pulp dir backup ...")
-		execCmd := []string{
-			"mkdir", "-p", backupDir + "/pulp",
-		}
-		_, err := controllers.ContainerExec(ctx, r, pod, execCmd, backupPod, pod.Namespace)
+	// there is nothing to copy if Pulp is deployed with object storage or without a PVC
+	fileStoragePVC := controllers.FileStoragePVC(pulp)
+	if len(fileStoragePVC) == 0 {
+		return true, nil
+	}
+
+	incremental, previousDir := pulpBackup.Spec.PulpDirCopy == pulpDirCopyIncremental, ""
+	if incremental {
+		previous, err := r.previousBackup(ctx, pulpBackup)
 		if err != nil {
-			log.Error(err, "Failed to create pulp backup dir")
-			return err
+			return false, err
+		}
+		if previous != nil {
+			previousDir = previous.Status.BackupDirectory
 		}
+	}
+	script := controllers.ProgressScript(backupDir+"/pulp", controllers.FileStorageMountPath) +
+		pulpDirCopyScript(controllers.FileStorageMountPath, backupDir, previousDir, incremental)
+	// the files are archived so that their names and attributes are encrypted too
+	if isEncrypted(pulpBackup) {
+		script = controllers.ProgressScript(backupDir+"/"+controllers.EncryptedPulpDir, controllers.FileStorageMountPath) + "set -eo pipefail\n" + controllers.EncryptionScript +
+			"tar -C " + controllers.FileStorageMountPath + " -cf - . | encrypt > " + backupDir + "/" + controllers.EncryptedPulpDir
+	}
 
-		execCmd = []string{
-			"bash", "-c", "cp -fa /var/lib/pulp/. " + backupDir + "/pulp",
+	job := backupManagerJob(pulpBackup, pulpDirJobSuffix, script)
+	encryptJob(job, pulpBackup)
+	controllers.MountFileStorage(job, fileStoragePVC)
+
+	finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpBackup, job)
+	if finished {
+		log.Info("Pulp's directory backup finished!")
+	}
+	return finished, err
+}
+
+// previousBackup returns the most recent successful PulpBackup of the same Pulp (deployment_name) stored
+// in the same PVC, whose Pulp dir can be linked by an incremental copy, or nil if there is none.
+// Backups being deleted, encrypted or taken with backup_mode Snapshot are not used.
+func (r *RepoManagerBackupReconciler) previousBackup(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (*pulpv1.PulpBackup, error) {
+	backups := &pulpv1.PulpBackupList{}
+	if err := r.List(ctx, backups, client.InNamespace(pulpBackup.Namespace)); err != nil {
+		r.RawLogger.Error(err, "Failed to list PulpBackups")
+		return nil, err
+	}
+	var previous *pulpv1.PulpBackup
+	var previousTime metav1.Time
+	for i := range backups.Items {
+		backup := &backups.Items[i]
+		condition := v1.FindStatusCondition(backup.Status.Conditions, "BackupComplete")
+		if backup.Name == pulpBackup.Name || !backup.DeletionTimestamp.IsZero() || condition == nil || condition.Status != metav1.ConditionTrue ||
+			backup.Status.DeploymentName != pulpBackup.Status.DeploymentName ||
+			backup.Status.BackupClaim != pulpBackup.Status.BackupClaim || backup.Status.BackupNamespace != pulpBackup.Status.BackupNamespace ||
+			backup.Spec.BackupMode == controllers.BackupModeSnapshot || isEncrypted(backup) ||
+			!strings.HasPrefix(filepath.Clean(backup.Status.BackupDirectory), controllers.BackupMountPath+"/") {
+			continue
 		}
-		_, err = controllers.ContainerExec(ctx, r, pod, execCmd, backupPod, pod.Namespace)
-		if err != nil {
-			log.Error(err, "Failed to backup pulp dir")
-			return err
+		if previous == nil || previousTime.Before(&condition.LastTransitionTime) {
+			previous, previousTime = backup, condition.LastTransitionTime
 		}
-		log.Info("Pulp's directory backup finished!")
 	}
+	return previous, nil
+}
 
-	return nil
+// pulpDirCopyScript returns the script that copies source (the Pulp dir) into the pulp folder of the
+// backup directory.
+// If incremental is true, the files are first hard linked from the pulp folder of previousDir (the
+// backup directory of previousBackup), in the style of rsync --link-dest, if it is complete (it has a
+// manifest). Only the new or modified files (based on their modification time) are copied and the files
+// removed from source are unlinked. The linked files are replaced instead of written in place, so the
+// previous backup is not modified. Without previousDir, all the files are copied.
+func pulpDirCopyScript(source, backupDir, previousDir string, incremental bool) string {
+	dest := backupDir + "/pulp"
+	if !incremental {
+		return "set -e\nmkdir -p " + dest + "\ncp -fa " + source + "/. " + dest
+	}
+	return `set -e
+mkdir -p ` + backupDir + `
+PREVIOUS="` + previousDir + `"
+if [ ! -d ` + dest + ` ]; then
+  if [ -n "$PREVIOUS" ] && [ -d "$PREVIOUS/pulp" ] && [ -f "$PREVIOUS/` + controllers.BackupManifestFileName + `" ]; then
+    echo "Linking files from $PREVIOUS/pulp"
+    cp -al "$PREVIOUS/pulp" ` + dest + `
+  else
+    echo "No previous backup found, copying all the files"
+  fi
+fi
+mkdir -p ` + dest + `
+cp -au --remove-destination ` + source + `/. ` + dest + `
+cd ` + dest + `
+find . -mindepth 1 -depth -print0 | while IFS= read -r -d '' path; do
+  if [ ! -e "` + source + `/$path" ] && [ ! -L "` + source + `/$path" ]; then rm -rf "$path"; fi
+done
+`
 }
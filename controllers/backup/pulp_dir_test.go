@@ -0,0 +1,153 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_backup
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestIncrementalPulpDirCopy verifies that an incremental backup links the unmodified files from the
+// previous backup without modifying it
+func TestIncrementalPulpDirCopy(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not found")
+	}
+	source, backupRoot := t.TempDir(), t.TempDir()
+	writeFile := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runCopy := func(backupDir, previousDir string) {
+		t.Helper()
+		out, err := exec.Command("bash", "-c", pulpDirCopyScript(source, backupDir, previousDir, true)).CombinedOutput()
+		if err != nil {
+			t.Fatalf("copy failed: %v\n%s", err, out)
+		}
+	}
+
+	writeFile(filepath.Join(source, "media/artifact/ab/cdef"), "artifact")
+	writeFile(filepath.Join(source, "media/artifact/12/3456"), "removed artifact")
+	writeFile(filepath.Join(source, "assets/index.html"), "v1")
+	previous := filepath.Join(backupRoot, "openshift-backup-2026-01-01-020000")
+	runCopy(previous, "")
+	writeFile(filepath.Join(previous, controllers.BackupManifestFileName), "{}")
+
+	// only the directory of the previous PulpBackup is used, not the most recent one in the PVC
+	writeFile(filepath.Join(backupRoot, "openshift-backup-2026-01-02-020000/pulp/media/artifact/ab/cdef"), "another pulp")
+	writeFile(filepath.Join(backupRoot, "openshift-backup-2026-01-02-020000", controllers.BackupManifestFileName), "{}")
+
+	if err := os.Remove(filepath.Join(source, "media/artifact/12/3456")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(filepath.Join(source, "media/artifact/78/9abc"), "new artifact")
+	writeFile(filepath.Join(source, "assets/index.html"), "v2")
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(source, "assets/index.html"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	current := filepath.Join(backupRoot, "openshift-backup-2026-01-03-020000")
+	runCopy(current, previous)
+
+	previousArtifact, err := os.Stat(filepath.Join(previous, "pulp/media/artifact/ab/cdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentArtifact, err := os.Stat(filepath.Join(current, "pulp/media/artifact/ab/cdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(previousArtifact, currentArtifact) {
+		t.Errorf("expected the unmodified artifact to be linked from the previous backup")
+	}
+
+	for path, expected := range map[string]string{
+		filepath.Join(current, "pulp/media/artifact/78/9abc"):  "new artifact",
+		filepath.Join(current, "pulp/assets/index.html"):       "v2",
+		filepath.Join(previous, "pulp/assets/index.html"):      "v1",
+		filepath.Join(previous, "pulp/media/artifact/12/3456"): "removed artifact",
+	} {
+		if content, err := os.ReadFile(path); err != nil || string(content) != expected {
+			t.Errorf("expected %v to contain %q, got %q (%v)", path, expected, content, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(current, "pulp/media/artifact/12/3456")); !os.IsNotExist(err) {
+		t.Errorf("expected the removed artifact not to be in the backup, got %v", err)
+	}
+}
+
+// TestPreviousBackup verifies that the incremental copy links the files of the most recent successful
+// backup of the same Pulp stored in the same PVC
+func TestPreviousBackup(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = pulpv1.AddToScheme(scheme)
+	backup := func(name, deploymentName, claim, directory string, completed time.Time, status metav1.ConditionStatus) *pulpv1.PulpBackup {
+		return &pulpv1.PulpBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test-namespace"},
+			Spec:       pulpv1.PulpBackupSpec{DeploymentName: deploymentName},
+			Status: pulpv1.PulpBackupStatus{
+				DeploymentName:  deploymentName,
+				BackupClaim:     claim,
+				BackupNamespace: "test-namespace",
+				BackupDirectory: directory,
+				Conditions:      []metav1.Condition{{Type: "BackupComplete", Status: status, LastTransitionTime: metav1.NewTime(completed)}},
+			},
+		}
+	}
+	day := time.Date(2026, 1, 1, 2, 0, 0, 0, time.UTC)
+	current := backup("current", "pulp", "shared", "/backups/openshift-backup-2026-01-05-020000", day.AddDate(0, 0, 4), metav1.ConditionFalse)
+	objects := []client.Object{
+		current,
+		backup("older", "pulp", "shared", "/backups/openshift-backup-2026-01-01-020000", day, metav1.ConditionTrue),
+		backup("previous", "pulp", "shared", "/backups/openshift-backup-2026-01-02-020000", day.AddDate(0, 0, 1), metav1.ConditionTrue),
+		backup("failed", "pulp", "shared", "/backups/openshift-backup-2026-01-03-020000", day.AddDate(0, 0, 2), metav1.ConditionFalse),
+		backup("other-pulp", "other", "shared", "/backups/openshift-backup-2026-01-03-030000", day.AddDate(0, 0, 2), metav1.ConditionTrue),
+		backup("other-pvc", "pulp", "other", "/backups/openshift-backup-2026-01-04-020000", day.AddDate(0, 0, 3), metav1.ConditionTrue),
+	}
+	r := &RepoManagerBackupReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(), RawLogger: logr.Discard(), Scheme: scheme}
+
+	previous, err := r.previousBackup(context.TODO(), current)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if previous == nil || previous.Name != "previous" {
+		t.Errorf("expected the previous backup, got %+v", previous)
+	}
+
+	// the first backup of a Pulp copies all the files
+	current.Status.DeploymentName = "new"
+	if previous, err := r.previousBackup(context.TODO(), current); err != nil || previous != nil {
+		t.Errorf("expected no previous backup, got %+v (%v)", previous, err)
+	}
+}
@@ -0,0 +1,145 @@
+package repo_manager_backup
+
+import (
+	"context"
+	goerrors "errors"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	consistencyModeStopWorkers       = "StopWorkers"
+	consistencyModeStopApiAndWorkers = "StopApiAndWorkers"
+)
+
+// quiescedComponents returns the Pulp components that should be scaled down during the backup
+func quiescedComponents(consistencyMode string) []settings.PulpcoreType {
+	switch consistencyMode {
+	case consistencyModeStopWorkers:
+		return []settings.PulpcoreType{settings.WORKER}
+	case consistencyModeStopApiAndWorkers:
+		return []settings.PulpcoreType{settings.API, settings.WORKER}
+	}
+	return nil
+}
+
+// quiescePulp scales down (and disables the HPA of) the components defined by consistency_mode
+// and returns true when all their pods are terminated
+func (r *RepoManagerBackupReconciler) quiescePulp(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
+	log := r.RawLogger
+	components := quiescedComponents(pulpBackup.Spec.ConsistencyMode)
+	if len(components) == 0 {
+		return true, nil
+	}
+
+	pulp := &pulpv1.Pulp{}
+	if err := r.Get(ctx, types.NamespacedName{Name: getDeploymentName(pulpBackup), Namespace: pulpBackup.Namespace}, pulp); err != nil {
+		log.Error(err, "Failed to get Pulp")
+		return false, err
+	}
+
+	// another backup scaled down the components, so the replicas found in Pulp CR are not the original ones
+	if locked, err := r.lockPulp(ctx, pulpBackup, pulp, components); !locked {
+		return false, err
+	}
+
+	// the settings are stored before modifying Pulp CR so that they can be restored even if the operator is restarted
+	if len(pulpBackup.Status.QuiescedComponents) == 0 {
+		original, err := controllers.OriginalComponents(pulp, components)
+		if err != nil {
+			log.Error(err, "Failed to read the "+controllers.QuiescedComponentsAnnotation+" annotation")
+			return false, err
+		}
+		pulpBackup.Status.QuiescedComponents = original
+		if err := r.Status().Update(ctx, pulpBackup); err != nil {
+			log.Error(err, "Failed to store the replicas of Pulp components")
+			return false, err
+		}
+	}
+
+	scaled := false
+	for _, component := range components {
+		if replicas, hpa := controllers.ComponentScale(pulp, component); *replicas != 0 || *hpa != nil {
+			*replicas, *hpa = 0, nil
+			scaled = true
+		}
+	}
+	if scaled {
+		log.Info("Scaling down Pulp components", "ConsistencyMode", pulpBackup.Spec.ConsistencyMode)
+		if err := r.Update(ctx, pulp); err != nil {
+			log.Error(err, "Failed to scale down Pulp components")
+			return false, err
+		}
+		return false, nil
+	}
+
+	for _, component := range components {
+		deployment := &appsv1.Deployment{}
+		err := r.Get(ctx, types.NamespacedName{Name: component.DeploymentName(pulp.Name), Namespace: pulp.Namespace}, deployment)
+		if err != nil && !errors.IsNotFound(err) {
+			return false, err
+		}
+		if err == nil && deployment.Status.Replicas > 0 {
+			log.Info("Waiting for " + deployment.Name + " pods to be terminated ...")
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// resumePulp restores the replicas and HPA settings of the components scaled down by quiescePulp
+// and removes the controllers.QuiescedByAnnotation set by this backup
+func (r *RepoManagerBackupReconciler) resumePulp(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
+	log := r.RawLogger
+	if len(quiescedComponents(pulpBackup.Spec.ConsistencyMode)) == 0 && len(pulpBackup.Status.QuiescedComponents) == 0 {
+		return true, nil
+	}
+
+	pulp := &pulpv1.Pulp{}
+	err := r.Get(ctx, types.NamespacedName{Name: getDeploymentName(pulpBackup), Namespace: pulpBackup.Namespace}, pulp)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Pulp")
+		return false, err
+	}
+
+	locked := err == nil && pulp.Annotations[controllers.QuiescedByAnnotation] == quiesceLockHolder(pulpBackup)
+	if locked || (err == nil && len(pulpBackup.Status.QuiescedComponents) > 0) {
+		for _, component := range pulpBackup.Status.QuiescedComponents {
+			if replicas, hpa := controllers.ComponentScale(pulp, settings.PulpcoreType(component.Name)); replicas != nil {
+				*replicas, *hpa = component.Replicas, component.HPA
+			}
+		}
+		controllers.UnlockPulp(pulp)
+		log.Info("Restoring the replicas of Pulp components")
+		if err := r.Update(ctx, pulp); err != nil {
+			log.Error(err, "Failed to restore the replicas of Pulp components")
+			return false, err
+		}
+	}
+
+	// the status is stored with the next condition update
+	pulpBackup.Status.QuiescedComponents = nil
+	return true, nil
+}
+
+// quiesceLockHolder returns the value of the controllers.QuiescedByAnnotation set by pulpBackup
+func quiesceLockHolder(pulpBackup *pulpv1.PulpBackup) string {
+	return controllers.QuiesceLockHolder("PulpBackup", pulpBackup.Name)
+}
+
+// lockPulp sets the controllers.QuiescedByAnnotation in Pulp CR and returns false while another PulpBackup
+// or PulpRestore holds it. The backup fails if the annotation of a removed PulpBackup or PulpRestore can
+// not be replaced, Pulp CR has to be fixed manually.
+func (r *RepoManagerBackupReconciler) lockPulp(ctx context.Context, pulpBackup *pulpv1.PulpBackup, pulp *pulpv1.Pulp, components []settings.PulpcoreType) (bool, error) {
+	locked, err := controllers.LockPulp(ctx, r.Client, r.RawLogger, quiesceLockHolder(pulpBackup), pulp, components)
+	removedErr := &controllers.QuiescedByRemovedError{}
+	if goerrors.As(err, &removedErr) {
+		return false, &backupFailedError{"QuiescedByRemovedBackup", removedErr.Error()}
+	}
+	return locked, err
+}
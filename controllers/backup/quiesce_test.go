@@ -0,0 +1,213 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_backup
+
+import (
+	"context"
+	goerrors "errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestQuiesceAndResumePulp verifies that the components scaled down during the backup get back
+// their replicas and HPA settings
+func TestQuiesceAndResumePulp(t *testing.T) {
+	minReplicas := int32(2)
+	workerHPA := &pulpv1.HPA{Enabled: true, MinReplicas: &minReplicas, MaxReplicas: 5}
+	originalSpec := pulpv1.PulpSpec{
+		Api:     pulpv1.Api{Replicas: 2},
+		Content: pulpv1.Content{Replicas: 2},
+		Worker:  pulpv1.Worker{Replicas: 3, HPA: workerHPA},
+	}
+
+	tests := []struct {
+		name            string
+		consistencyMode string
+		expectQuiesced  []string
+	}{
+		{name: "none"},
+		{name: "stop workers", consistencyMode: consistencyModeStopWorkers, expectQuiesced: []string{"Worker"}},
+		{name: "stop api and workers", consistencyMode: consistencyModeStopApiAndWorkers, expectQuiesced: []string{"Api", "Worker"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = pulpv1.AddToScheme(scheme)
+			_ = appsv1.AddToScheme(scheme)
+			pulp := &pulpv1.Pulp{
+				ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+				Spec:       *originalSpec.DeepCopy(),
+			}
+			pulpBackup := &pulpv1.PulpBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "test-namespace"},
+				Spec:       pulpv1.PulpBackupSpec{DeploymentName: "pulp", ConsistencyMode: tt.consistencyMode},
+			}
+			client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulp, pulpBackup).WithStatusSubresource(pulpBackup).Build()
+			r := &RepoManagerBackupReconciler{Client: client, RawLogger: logr.Discard(), Scheme: scheme}
+			ctx := context.TODO()
+
+			// the first call scales down the components, the next one waits for the pods (none in this case)
+			finished, err := r.quiescePulp(ctx, pulpBackup)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if finished != (len(tt.expectQuiesced) == 0) {
+				t.Fatalf("expected the first call to finish only without components to quiesce")
+			}
+			if finished, err := r.quiescePulp(ctx, pulpBackup); !finished || err != nil {
+				t.Fatalf("expected quiesce to finish, got %v, %v", finished, err)
+			}
+
+			quiesced := []string{}
+			for _, component := range pulpBackup.Status.QuiescedComponents {
+				quiesced = append(quiesced, component.Name)
+			}
+			if len(quiesced) != len(tt.expectQuiesced) || (len(quiesced) > 0 && !reflect.DeepEqual(quiesced, tt.expectQuiesced)) {
+				t.Errorf("expected quiesced components %v, got %v", tt.expectQuiesced, quiesced)
+			}
+
+			scaled := &pulpv1.Pulp{}
+			if err := client.Get(ctx, types.NamespacedName{Name: "pulp", Namespace: "test-namespace"}, scaled); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.expectQuiesced {
+				if replicas, hpa := controllers.ComponentScale(scaled, settings.PulpcoreType(name)); *replicas != 0 || *hpa != nil {
+					t.Errorf("expected %v to be scaled down, got %d replicas and HPA %v", name, *replicas, *hpa)
+				}
+			}
+			if scaled.Spec.Content.Replicas != 2 {
+				t.Errorf("content should not be scaled down")
+			}
+
+			if _, err := r.resumePulp(ctx, pulpBackup); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resumed := &pulpv1.Pulp{}
+			if err := client.Get(ctx, types.NamespacedName{Name: "pulp", Namespace: "test-namespace"}, resumed); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resumed.Spec, originalSpec) {
+				t.Errorf("expected %+v, got %+v", originalSpec, resumed.Spec)
+			}
+			if len(pulpBackup.Status.QuiescedComponents) != 0 {
+				t.Errorf("expected the quiesced components to be cleared")
+			}
+			if _, found := resumed.Annotations[controllers.QuiescedByAnnotation]; found {
+				t.Errorf("expected the %v annotation to be removed", controllers.QuiescedByAnnotation)
+			}
+		})
+	}
+}
+
+// TestQuiescePulpLock verifies that a backup does not store the replicas of components that are
+// scaled down by another backup of the same Pulp
+func TestQuiescePulpLock(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = pulpv1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	pulp := &pulpv1.Pulp{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+		Spec:       pulpv1.PulpSpec{Api: pulpv1.Api{Replicas: 2}, Worker: pulpv1.Worker{Replicas: 3}},
+	}
+	newBackup := func(name string) *pulpv1.PulpBackup {
+		return &pulpv1.PulpBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test-namespace"},
+			Spec:       pulpv1.PulpBackupSpec{DeploymentName: "pulp", ConsistencyMode: consistencyModeStopApiAndWorkers},
+		}
+	}
+	scheduled, manual := newBackup("scheduled"), newBackup("manual")
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulp, scheduled, manual).WithStatusSubresource(scheduled, manual).Build()
+	r := &RepoManagerBackupReconciler{Client: client, RawLogger: logr.Discard(), Scheme: scheme}
+	ctx := context.TODO()
+
+	if _, err := r.quiescePulp(ctx, scheduled); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if finished, err := r.quiescePulp(ctx, manual); finished || err != nil {
+		t.Fatalf("expected the second backup to wait, got %v, %v", finished, err)
+	}
+	if len(manual.Status.QuiescedComponents) != 0 {
+		t.Fatalf("expected no replicas to be stored while Pulp is quiesced by another backup, got %+v", manual.Status.QuiescedComponents)
+	}
+
+	if _, err := r.resumePulp(ctx, scheduled); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.quiescePulp(ctx, manual); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, component := range manual.Status.QuiescedComponents {
+		if component.Replicas == 0 {
+			t.Errorf("expected the original replicas of %v to be stored", component.Name)
+		}
+	}
+
+	// the annotation of a removed backup does not block the next ones
+	if err := client.Delete(ctx, manual); err != nil {
+		t.Fatal(err)
+	}
+	if finished, err := r.quiescePulp(ctx, scheduled); err != nil {
+		t.Fatalf("unexpected error: %v, %v", finished, err)
+	}
+	locked := &pulpv1.Pulp{}
+	if err := client.Get(ctx, types.NamespacedName{Name: "pulp", Namespace: "test-namespace"}, locked); err != nil {
+		t.Fatal(err)
+	}
+	if locked.Annotations[controllers.QuiescedByAnnotation] != quiesceLockHolder(scheduled) {
+		t.Errorf("expected Pulp to be quiesced by %v, got %v", quiesceLockHolder(scheduled), locked.Annotations[controllers.QuiescedByAnnotation])
+	}
+	// the replicas scaled down by the removed backup are recovered from the annotations
+	if _, err := r.quiescePulp(ctx, scheduled); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.resumePulp(ctx, scheduled); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resumed := &pulpv1.Pulp{}
+	if err := client.Get(ctx, types.NamespacedName{Name: "pulp", Namespace: "test-namespace"}, resumed); err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Spec.Api.Replicas != 2 || resumed.Spec.Worker.Replicas != 3 {
+		t.Errorf("expected the original replicas to be restored, got api %d and worker %d", resumed.Spec.Api.Replicas, resumed.Spec.Worker.Replicas)
+	}
+	if len(resumed.Annotations) != 0 {
+		t.Errorf("expected the annotations to be removed, got %v", resumed.Annotations)
+	}
+
+	// without the original replicas, the annotation of a removed backup is not replaced
+	resumed.Spec.Api.Replicas, resumed.Spec.Worker.Replicas = 0, 0
+	resumed.Annotations = map[string]string{controllers.QuiescedByAnnotation: "PulpBackup/removed"}
+	if err := client.Update(ctx, resumed); err != nil {
+		t.Fatal(err)
+	}
+	_, err := r.quiescePulp(ctx, newBackup("other"))
+	backupErr := &backupFailedError{}
+	if !goerrors.As(err, &backupErr) || backupErr.reason != "QuiescedByRemovedBackup" {
+		t.Errorf("expected the backup to fail with QuiescedByRemovedBackup, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package repo_manager_backup
+
+import (
+	"context"
+	goerrors "errors"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const resourcesJobSuffix = "-backup-resources"
+
+// resourcesSecretName returns the name of the temporary secret with the resources that will be
+// copied into the backup directory
+func resourcesSecretName(pulpBackup *pulpv1.PulpBackup) string {
+	return pulpBackup.Name + "-backup-resources"
+}
+
+// backupResources makes a copy of the ConfigMaps, Secrets and Pulp CR.
+// Their content is stored in a temporary secret that is copied into the backup directory by a job.
+func (r *RepoManagerBackupReconciler) backupResources(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
+	log := r.RawLogger
+	backupDir := pulpBackup.Status.BackupDirectory
+
+	// the secret is created only once so that a resumed backup copies the same content
+	resources := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: resourcesSecretName(pulpBackup), Namespace: pulpBackup.Namespace}, resources)
+	if err != nil && errors.IsNotFound(err) {
+		files := map[string][]byte{}
+		if err := r.backupConfigMap(ctx, pulpBackup, files); err != nil {
+			return false, err
+		}
+		if err := r.backupCR(ctx, pulpBackup, files); err != nil {
+			return false, err
+		}
+		if err := r.backupSecret(ctx, pulpBackup, files); err != nil {
+			return false, err
+		}
+
+		resources = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      resourcesSecretName(pulpBackup),
+				Namespace: pulpBackup.Namespace,
+			},
+			Data: files,
+		}
+		ctrl.SetControllerReference(pulpBackup, resources, r.Scheme)
+		if err := r.Create(ctx, resources); err != nil {
+			log.Error(err, "Failed to create backup resources secret")
+			return false, err
+		}
+	} else if err != nil {
+		log.Error(err, "Failed to get backup resources secret")
+		return false, err
+	}
+
+	script := "set -e\nmkdir -p " + backupDir + "\ncp -L /resources/* " + backupDir + "/"
+	if isEncrypted(pulpBackup) {
+		// the job would not start without the secret, so we fail early if it is not found
+		encryptionSecret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: pulpBackup.Spec.EncryptionSecret, Namespace: pulpBackup.Namespace}, encryptionSecret); err != nil {
+			log.Error(err, "Failed to find encryption secret")
+			return false, err
+		}
+		if len(encryptionSecret.Data[controllers.EncryptionPassphraseKey]) == 0 {
+			err := goerrors.New("the " + controllers.EncryptionPassphraseKey + " key is missing from " + pulpBackup.Spec.EncryptionSecret + " secret")
+			log.Error(err, "Invalid encryption secret")
+			return false, err
+		}
+		script = "set -eo pipefail\nmkdir -p " + backupDir + "\n" + controllers.EncryptionScript +
+			"for file in /resources/*; do\n  encrypt < \"$file\" > " + backupDir + "/$(basename \"$file\")\ndone\n"
+	}
+
+	job := backupManagerJob(pulpBackup, resourcesJobSuffix, script)
+	encryptJob(job, pulpBackup)
+	podSpec := &job.Spec.Template.Spec
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "resources",
+		MountPath: "/resources",
+		ReadOnly:  true,
+	})
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "resources",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: resourcesSecretName(pulpBackup)},
+		},
+	})
+
+	finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpBackup, job)
+	if finished {
+		log.Info("Secrets, configmaps and CR backup finished")
+	}
+	return finished, err
+}
@@ -5,7 +5,6 @@ import (
 	"context"
 
 	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
-	"github.com/pulp/pulp-operator/controllers"
 	"gopkg.in/yaml.v3"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -21,21 +20,18 @@ type secretType struct {
 	// PulpBackup instance
 	pulpBackup *pulpv1.PulpBackup
 
-	// path of where the backup will be stored (PVC mount point)
-	backupDir string
-
 	// name of the backup file
 	backupFile string
 
 	// name of the secret that will be copied
 	secretName string
 
-	// backup-manager pod
-	pod *corev1.Pod
+	// content of the backup files, by file name
+	files map[string][]byte
 }
 
 // backupSecrets makes a copy of the Secrets used by Pulp components
-func (r *RepoManagerBackupReconciler) backupSecret(ctx context.Context, pulpBackup *pulpv1.PulpBackup, backupDir string, pod *corev1.Pod) error {
+func (r *RepoManagerBackupReconciler) backupSecret(ctx context.Context, pulpBackup *pulpv1.PulpBackup, files map[string][]byte) error {
 	log := r.RawLogger
 	deploymentName := getDeploymentName(pulpBackup)
 
@@ -55,7 +51,7 @@ func (r *RepoManagerBackupReconciler) backupSecret(ctx context.Context, pulpBack
 	containerTokenSecret := getContainerTokenSecret(pulp)
 
 	// PULP-SECRET-KEY
-	if err := r.createSecretBackupFile(ctx, secretType{"pulp_secret_key", pulpBackup, backupDir, "pulp_secret_key.yaml", pulpSecretKey, pod}); err != nil {
+	if err := r.createSecretBackupFile(ctx, secretType{"pulp_secret_key", pulpBackup, "pulp_secret_key.yaml", pulpSecretKey, files}); err != nil {
 		return err
 	}
 	log.Info("PulpSecretKey secret backup finished")
@@ -63,43 +59,43 @@ func (r *RepoManagerBackupReconciler) backupSecret(ctx context.Context, pulpBack
 	// pulp-admin and pulp-postgres-configuration secrets will not be stored in secret.yaml file like in pulp-operator
 	// we are splitting them in admin_secret.yaml and postgres_configuration.yaml files
 	// PULP-ADMIN SECRET
-	if err := r.createBackupFile(ctx, secretType{"admin_password_secret", pulpBackup, backupDir, "admin_secret.yaml", adminPasswordSecret, pod}); err != nil {
+	if err := r.createBackupFile(ctx, secretType{"admin_password_secret", pulpBackup, "admin_secret.yaml", adminPasswordSecret, files}); err != nil {
 		return err
 	}
 	log.Info("Admin secret backup finished")
 
 	// POSTGRES SECRET (we are not following the same name for the keys that we defined in pulp-operator)
-	if err := r.createBackupFile(ctx, secretType{"postgres_secret", pulpBackup, backupDir, "postgres_configuration_secret.yaml", postgresCfgSecret, pod}); err != nil {
+	if err := r.createBackupFile(ctx, secretType{"postgres_secret", pulpBackup, "postgres_configuration_secret.yaml", postgresCfgSecret, files}); err != nil {
 		return err
 	}
 	log.Info("Postgres configuration secret backup finished")
 
 	// FIELDS ENCRYPTION SECRET
-	if err := r.createBackupFile(ctx, secretType{"db_fields_encryption_secret", pulpBackup, backupDir, "db_fields_encryption_secret.yaml", dbFieldsEncryption, pod}); err != nil {
+	if err := r.createBackupFile(ctx, secretType{"db_fields_encryption_secret", pulpBackup, "db_fields_encryption_secret.yaml", dbFieldsEncryption, files}); err != nil {
 		return err
 	}
 	log.Info("Fields encryption secret backup finished")
 
 	// SIGNING SECRET
 	if len(pulp.Spec.SigningSecret) > 0 {
-		if err := r.createBackupFile(ctx, secretType{"signing_secret", pulpBackup, backupDir, "signing_secret.yaml", pulp.Spec.SigningSecret, pod}); err != nil {
+		if err := r.createBackupFile(ctx, secretType{"signing_secret", pulpBackup, "signing_secret.yaml", pulp.Spec.SigningSecret, files}); err != nil {
 			return err
 		}
 		log.Info("Signing secret backup finished")
-		if err := r.createSecretBackupFile(ctx, secretType{"signing_scripts", pulpBackup, backupDir, "signing_scripts.yaml", pulp.Spec.SigningScripts, pod}); err != nil {
+		if err := r.createSecretBackupFile(ctx, secretType{"signing_scripts", pulpBackup, "signing_scripts.yaml", pulp.Spec.SigningScripts, files}); err != nil {
 			return err
 		}
 	}
 
 	// CONTAINER TOKEN SECRET
-	if err := r.createBackupFile(ctx, secretType{"container_token_secret", pulpBackup, backupDir, "container_token_secret.yaml", containerTokenSecret, pod}); err != nil {
+	if err := r.createBackupFile(ctx, secretType{"container_token_secret", pulpBackup, "container_token_secret.yaml", containerTokenSecret, files}); err != nil {
 		return err
 	}
 	log.Info("Container token secret backup finished")
 
 	// OBJECT STORAGE S3 SECRET
 	if len(pulp.Spec.ObjectStorageS3Secret) > 0 {
-		if err := r.createBackupFile(ctx, secretType{"storage_secret", pulpBackup, backupDir, "objectstorage_secret.yaml", pulp.Spec.ObjectStorageS3Secret, pod}); err != nil {
+		if err := r.createBackupFile(ctx, secretType{"storage_secret", pulpBackup, "objectstorage_secret.yaml", pulp.Spec.ObjectStorageS3Secret, files}); err != nil {
 			return err
 		}
 		log.Info("Object storage s3 secret backup finished")
@@ -107,7 +103,7 @@ func (r *RepoManagerBackupReconciler) backupSecret(ctx context.Context, pulpBack
 
 	// OBJECT STORAGE AZURE SECRET
 	if len(pulp.Spec.ObjectStorageAzureSecret) > 0 {
-		if err := r.createBackupFile(ctx, secretType{"storage_secret", pulpBackup, backupDir, "objectstorage_secret.yaml", pulp.Spec.ObjectStorageAzureSecret, pod}); err != nil {
+		if err := r.createBackupFile(ctx, secretType{"storage_secret", pulpBackup, "objectstorage_secret.yaml", pulp.Spec.ObjectStorageAzureSecret, files}); err != nil {
 			return err
 		}
 		log.Info("Object storage azure secret backup finished")
@@ -115,7 +111,7 @@ func (r *RepoManagerBackupReconciler) backupSecret(ctx context.Context, pulpBack
 
 	// OBJECT STORAGE GCS SECRET
 	if len(pulp.Spec.ObjectStorageGCSSecret) > 0 {
-		if err := r.createBackupFile(ctx, secretType{"storage_secret", pulpBackup, backupDir, "objectstorage_secret.yaml", pulp.Spec.ObjectStorageGCSSecret, pod}); err != nil {
+		if err := r.createBackupFile(ctx, secretType{"storage_secret", pulpBackup, "objectstorage_secret.yaml", pulp.Spec.ObjectStorageGCSSecret, files}); err != nil {
 			return err
 		}
 		log.Info("Object storage gcs secret backup finished")
@@ -123,7 +119,7 @@ func (r *RepoManagerBackupReconciler) backupSecret(ctx context.Context, pulpBack
 
 	// OBJECT SSO CONFIG SECRET
 	if len(pulp.Spec.SSOSecret) > 0 {
-		if err := r.createBackupFile(ctx, secretType{"sso_secret", pulpBackup, backupDir, "sso_secret.yaml", pulp.Spec.SSOSecret, pod}); err != nil {
+		if err := r.createBackupFile(ctx, secretType{"sso_secret", pulpBackup, "sso_secret.yaml", pulp.Spec.SSOSecret, files}); err != nil {
 			return err
 		}
 		log.Info("SSO secret backup finished")
@@ -131,14 +127,14 @@ func (r *RepoManagerBackupReconciler) backupSecret(ctx context.Context, pulpBack
 
 	// LDAP CONFIG SECRET
 	if len(pulp.Spec.LDAP.Config) > 0 {
-		if err := r.createSecretBackupFile(ctx, secretType{"ldap_secret", pulpBackup, backupDir, "ldap_secret.yaml", pulp.Spec.LDAP.Config, pod}); err != nil {
+		if err := r.createSecretBackupFile(ctx, secretType{"ldap_secret", pulpBackup, "ldap_secret.yaml", pulp.Spec.LDAP.Config, files}); err != nil {
 			return err
 		}
 		log.Info("LDAP secret backup finished")
 	}
 	// LDAP CA SECRET
 	if len(pulp.Spec.LDAP.CA) > 0 {
-		if err := r.createSecretBackupFile(ctx, secretType{"ldap_ca_secret", pulpBackup, backupDir, "ldap_ca_secret.yaml", pulp.Spec.LDAP.CA, pod}); err != nil {
+		if err := r.createSecretBackupFile(ctx, secretType{"ldap_ca_secret", pulpBackup, "ldap_ca_secret.yaml", pulp.Spec.LDAP.CA, files}); err != nil {
 			return err
 		}
 		log.Info("LDAP CA secret backup finished")
@@ -167,14 +163,7 @@ func (r *RepoManagerBackupReconciler) createBackupFile(ctx context.Context, secr
 	var secretSerialized []byte
 	secretSerialized, _ = yaml.Marshal(bkpContent)
 
-	execCmd := []string{
-		"bash", "-c", "echo '" + string(secretSerialized) + "' > " + secretType.backupDir + "/" + secretType.backupFile,
-	}
-	_, err = controllers.ContainerExec(ctx, r, secretType.pod, execCmd, secretType.pulpBackup.Name+"-backup-manager", secretType.pod.Namespace)
-	if err != nil {
-		log.Error(err, "Failed to backup "+secretType.secretName+" secret")
-		return err
-	}
+	secretType.files[secretType.backupFile] = secretSerialized
 
 	log.Info("Container token secret backup finished")
 	return nil
@@ -196,14 +185,7 @@ func (r *RepoManagerBackupReconciler) createSecretBackupFile(ctx context.Context
 	ymlPrinter := printers.YAMLPrinter{}
 	ymlPrinter.PrintObj(secret, secretYaml)
 
-	execCmd := []string{
-		"bash", "-c", "echo '" + secretYaml.String() + "' > " + secretType.backupDir + "/" + secretType.backupFile,
-	}
-	_, err = controllers.ContainerExec(ctx, r, secretType.pod, execCmd, secretType.pulpBackup.Name+"-backup-manager", secretType.pod.Namespace)
-	if err != nil {
-		log.Error(err, "Failed to backup "+secretType.secretName+" secret")
-		return err
-	}
+	secretType.files[secretType.backupFile] = secretYaml.Bytes()
 
 	log.Info("Secret " + secretType.secretName + " backup finished")
 	return nil
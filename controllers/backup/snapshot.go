@@ -0,0 +1,186 @@
+package repo_manager_backup
+
+import (
+	"context"
+	"slices"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	fileStorageSnapshotSuffix = "-file-storage"
+	databaseSnapshotSuffix    = "-database"
+
+	// volumes snapshotted, they match the PulpRestore components restored from the snapshots
+	volumePulpDir  = "PulpDir"
+	volumeDatabase = "Database"
+)
+
+// snapshotVolume is a PVC snapshotted by backup_mode Snapshot
+type snapshotVolume struct {
+	// volume snapshotted (volumePulpDir or volumeDatabase)
+	volume string
+
+	// suffix of the VolumeSnapshot name
+	suffix string
+
+	// name of the PVC
+	pvc string
+}
+
+// snapshotVolumes takes VolumeSnapshots of the file storage PVC and, if Pulp is quiesced with
+// consistency_mode StopApiAndWorkers, of the PVC of the database managed by the operator.
+// It returns true once the snapshots are taken (not when they are ready to use), so that Pulp
+// is not kept scaled down while the CSI driver finishes them.
+func (r *RepoManagerBackupReconciler) snapshotVolumes(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
+	log := r.RawLogger
+	pulp := &pulpv1.Pulp{}
+	if err := r.Get(ctx, types.NamespacedName{Name: getDeploymentName(pulpBackup), Namespace: pulpBackup.Namespace}, pulp); err != nil {
+		log.Error(err, "Failed to get Pulp")
+		return false, err
+	}
+
+	volumes := []snapshotVolume{{volumePulpDir, fileStorageSnapshotSuffix, controllers.FileStoragePVC(pulp)}}
+	// the database files are only snapshotted when no task or API request modifies the database
+	if pulpBackup.Spec.ConsistencyMode == consistencyModeStopApiAndWorkers {
+		volumes = append(volumes, snapshotVolume{volumeDatabase, databaseSnapshotSuffix, controllers.DatabasePVC(pulp)})
+	}
+
+	taken := true
+	for _, volume := range volumes {
+		if len(volume.pvc) == 0 {
+			continue
+		}
+		snapshot := controllers.VolumeSnapshot(pulpBackup.Name+volume.suffix, pulpBackup.Namespace)
+		err := r.Get(ctx, client.ObjectKeyFromObject(snapshot), snapshot)
+		if errors.IsNotFound(err) {
+			if err := r.createVolumeSnapshot(ctx, pulpBackup, snapshot.GetName(), volume); err != nil {
+				return false, err
+			}
+			taken = false
+			continue
+		} else if err != nil {
+			log.Error(err, "Failed to get VolumeSnapshot", "VolumeSnapshot.Name", snapshot.GetName())
+			return false, err
+		}
+
+		status := controllers.GetVolumeSnapshotStatus(snapshot)
+		if len(status.Error) > 0 {
+			return false, &backupFailedError{"FailedVolumeSnapshot", "VolumeSnapshot " + snapshot.GetName() + ": " + status.Error}
+		}
+		if !status.Created {
+			log.Info("Waiting for VolumeSnapshot " + snapshot.GetName() + " to be taken ...")
+			taken = false
+		}
+	}
+	if taken {
+		log.Info("Volume snapshots taken!")
+	}
+	return taken, nil
+}
+
+// createVolumeSnapshot creates the VolumeSnapshot of the volume.
+// The PVC settings needed to restore it are stored in the status before the snapshot is created,
+// so that they are not lost if the operator is restarted.
+func (r *RepoManagerBackupReconciler) createVolumeSnapshot(ctx context.Context, pulpBackup *pulpv1.PulpBackup, name string, volume snapshotVolume) error {
+	log := r.RawLogger
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Name: volume.pvc, Namespace: pulpBackup.Namespace}, pvc); err != nil {
+		log.Error(err, "Failed to get PVC", "PVC.Name", volume.pvc)
+		return err
+	}
+
+	if !slices.ContainsFunc(pulpBackup.Status.VolumeSnapshots, func(s pulpv1.BackupVolumeSnapshot) bool { return s.Name == name }) {
+		size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		if capacity, found := pvc.Status.Capacity[corev1.ResourceStorage]; found {
+			size = capacity
+		}
+		backupSnapshot := pulpv1.BackupVolumeSnapshot{
+			Name:        name,
+			Volume:      volume.volume,
+			PVC:         volume.pvc,
+			AccessModes: pvc.Spec.AccessModes,
+			Size:        size.String(),
+		}
+		if pvc.Spec.StorageClassName != nil {
+			backupSnapshot.StorageClass = *pvc.Spec.StorageClassName
+		}
+		pulpBackup.Status.VolumeSnapshots = append(pulpBackup.Status.VolumeSnapshots, backupSnapshot)
+		if err := r.Status().Update(ctx, pulpBackup); err != nil {
+			log.Error(err, "Failed to store the volume snapshots")
+			return err
+		}
+	}
+
+	labels := map[string]string{
+		"app.kubernetes.io/name":       "pulp-backup-snapshot",
+		"app.kubernetes.io/instance":   "pulp-backup-snapshot-" + pulpBackup.Name,
+		"app.kubernetes.io/component":  "backup-storage",
+		"app.kubernetes.io/part-of":    "pulp",
+		"app.kubernetes.io/managed-by": "pulp-operator",
+	}
+	snapshot := controllers.NewVolumeSnapshot(name, pulpBackup.Namespace, volume.pvc, pulpBackup.Spec.VolumeSnapshotClass, labels)
+	log.Info("Creating VolumeSnapshot", "VolumeSnapshot.Name", name, "PVC", volume.pvc)
+	if err := r.Create(ctx, snapshot); err != nil {
+		log.Error(err, "Failed to create VolumeSnapshot", "VolumeSnapshot.Name", name)
+		return err
+	}
+	return nil
+}
+
+// waitVolumeSnapshots returns true when all the VolumeSnapshots taken by snapshotVolumes are ready
+// to be used, updating their size with the restoreSize reported by the CSI driver
+func (r *RepoManagerBackupReconciler) waitVolumeSnapshots(ctx context.Context, pulpBackup *pulpv1.PulpBackup) (bool, error) {
+	log := r.RawLogger
+	ready, updated := true, false
+	for i := range pulpBackup.Status.VolumeSnapshots {
+		backupSnapshot := &pulpBackup.Status.VolumeSnapshots[i]
+		if backupSnapshot.ReadyToUse {
+			continue
+		}
+
+		snapshot := controllers.VolumeSnapshot(backupSnapshot.Name, pulpBackup.Namespace)
+		if err := r.Get(ctx, client.ObjectKeyFromObject(snapshot), snapshot); errors.IsNotFound(err) {
+			return false, &backupFailedError{"VolumeSnapshotNotFound", "VolumeSnapshot " + backupSnapshot.Name + " not found!"}
+		} else if err != nil {
+			log.Error(err, "Failed to get VolumeSnapshot", "VolumeSnapshot.Name", backupSnapshot.Name)
+			return false, err
+		}
+
+		status := controllers.GetVolumeSnapshotStatus(snapshot)
+		if len(status.Error) > 0 {
+			return false, &backupFailedError{"FailedVolumeSnapshot", "VolumeSnapshot " + backupSnapshot.Name + ": " + status.Error}
+		}
+		if !status.ReadyToUse {
+			log.Info("Waiting for VolumeSnapshot " + backupSnapshot.Name + " to be ready ...")
+			ready = false
+			continue
+		}
+
+		// a PVC provisioned from the snapshot can not be smaller than its restoreSize
+		if restoreSize, err := resource.ParseQuantity(status.RestoreSize); err == nil {
+			if size, err := resource.ParseQuantity(backupSnapshot.Size); err != nil || restoreSize.Cmp(size) > 0 {
+				backupSnapshot.Size = restoreSize.String()
+			}
+		}
+		backupSnapshot.ReadyToUse = true
+		updated = true
+	}
+
+	if updated {
+		if err := r.Status().Update(ctx, pulpBackup); err != nil {
+			log.Error(err, "Failed to update the volume snapshots")
+			return false, err
+		}
+	}
+	if ready && len(pulpBackup.Status.VolumeSnapshots) > 0 {
+		log.Info("Volume snapshots ready!")
+	}
+	return ready, nil
+}
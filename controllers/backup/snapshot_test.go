@@ -0,0 +1,187 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_backup
+
+import (
+	"context"
+	goerrors "errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestSnapshotVolumes verifies the VolumeSnapshots taken by backup_mode Snapshot and the PVC settings
+// recorded in the PulpBackup status
+func TestSnapshotVolumes(t *testing.T) {
+	storageClass := "csi-standard"
+	tests := []struct {
+		name            string
+		consistencyMode string
+		expectSnapshots []pulpv1.BackupVolumeSnapshot
+	}{
+		{
+			name: "file storage only",
+			expectSnapshots: []pulpv1.BackupVolumeSnapshot{
+				{Name: "backup-file-storage", Volume: volumePulpDir, PVC: "pulp-file-storage", StorageClass: storageClass, AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}, Size: "100Gi", ReadyToUse: true},
+			},
+		},
+		{
+			name:            "database quiesced",
+			consistencyMode: consistencyModeStopApiAndWorkers,
+			expectSnapshots: []pulpv1.BackupVolumeSnapshot{
+				{Name: "backup-file-storage", Volume: volumePulpDir, PVC: "pulp-file-storage", StorageClass: storageClass, AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}, Size: "100Gi", ReadyToUse: true},
+				{Name: "backup-database", Volume: volumeDatabase, PVC: "pulp-postgres-pulp-database-0", StorageClass: storageClass, AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}, Size: "12Gi", ReadyToUse: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = corev1.AddToScheme(scheme)
+			_ = pulpv1.AddToScheme(scheme)
+			scheme.AddKnownTypeWithName(controllers.VolumeSnapshotGVK, &unstructured.Unstructured{})
+			pulp := &pulpv1.Pulp{
+				ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+				Spec: pulpv1.PulpSpec{
+					FileStorageClass: storageClass,
+					Database:         pulpv1.Database{PostgresStorageClass: &storageClass},
+				},
+			}
+			pulpBackup := &pulpv1.PulpBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "test-namespace"},
+				Spec: pulpv1.PulpBackupSpec{
+					DeploymentName:      "pulp",
+					ConsistencyMode:     tt.consistencyMode,
+					BackupMode:          controllers.BackupModeSnapshot,
+					VolumeSnapshotClass: "csi-snapclass",
+				},
+			}
+			fileStorage := pvc("pulp-file-storage", storageClass, corev1.ReadWriteMany, "100Gi")
+			database := pvc("pulp-postgres-pulp-database-0", storageClass, corev1.ReadWriteOnce, "8Gi")
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulp, pulpBackup, fileStorage, database).WithStatusSubresource(pulpBackup).Build()
+			r := &RepoManagerBackupReconciler{Client: c, RawLogger: logr.Discard(), Scheme: scheme}
+			ctx := context.TODO()
+
+			// the first call creates the snapshots, the next ones wait for them to be taken
+			for range 2 {
+				if taken, err := r.backupPulpDir(ctx, pulpBackup); taken || err != nil {
+					t.Fatalf("expected to wait for the snapshots, got %v, %v", taken, err)
+				}
+			}
+			if len(pulpBackup.Status.VolumeSnapshots) != len(tt.expectSnapshots) {
+				t.Fatalf("expected %d snapshots, got %+v", len(tt.expectSnapshots), pulpBackup.Status.VolumeSnapshots)
+			}
+			for _, expected := range tt.expectSnapshots {
+				snapshot := controllers.VolumeSnapshot(expected.Name, "test-namespace")
+				if err := c.Get(ctx, client.ObjectKeyFromObject(snapshot), snapshot); err != nil {
+					t.Fatalf("expected VolumeSnapshot %v to be created: %v", expected.Name, err)
+				}
+				source, _, _ := unstructured.NestedString(snapshot.Object, "spec", "source", "persistentVolumeClaimName")
+				class, _, _ := unstructured.NestedString(snapshot.Object, "spec", "volumeSnapshotClassName")
+				if source != expected.PVC || class != "csi-snapclass" {
+					t.Errorf("expected a snapshot of %v with csi-snapclass, got %v with %v", expected.PVC, source, class)
+				}
+				setSnapshotStatus(t, c, snapshot, map[string]interface{}{"creationTime": "2026-01-01T00:00:00Z", "readyToUse": false})
+			}
+			if taken, err := r.backupPulpDir(ctx, pulpBackup); !taken || err != nil {
+				t.Fatalf("expected the snapshots to be taken, got %v, %v", taken, err)
+			}
+
+			if ready, err := r.waitVolumeSnapshots(ctx, pulpBackup); ready || err != nil {
+				t.Fatalf("expected to wait for the snapshots to be ready, got %v, %v", ready, err)
+			}
+			for _, expected := range tt.expectSnapshots {
+				snapshot := controllers.VolumeSnapshot(expected.Name, "test-namespace")
+				if err := c.Get(ctx, client.ObjectKeyFromObject(snapshot), snapshot); err != nil {
+					t.Fatal(err)
+				}
+				setSnapshotStatus(t, c, snapshot, map[string]interface{}{"creationTime": "2026-01-01T00:00:00Z", "readyToUse": true, "restoreSize": "12Gi"})
+			}
+			if ready, err := r.waitVolumeSnapshots(ctx, pulpBackup); !ready || err != nil {
+				t.Fatalf("expected the snapshots to be ready, got %v, %v", ready, err)
+			}
+			if !reflect.DeepEqual(pulpBackup.Status.VolumeSnapshots, tt.expectSnapshots) {
+				t.Errorf("expected %+v, got %+v", tt.expectSnapshots, pulpBackup.Status.VolumeSnapshots)
+			}
+		})
+	}
+}
+
+// TestSnapshotFailed verifies that the backup fails if the CSI driver can not take a snapshot
+func TestSnapshotFailed(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = pulpv1.AddToScheme(scheme)
+	scheme.AddKnownTypeWithName(controllers.VolumeSnapshotGVK, &unstructured.Unstructured{})
+	pulpBackup := &pulpv1.PulpBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "test-namespace"},
+		Spec:       pulpv1.PulpBackupSpec{DeploymentName: "pulp", BackupMode: controllers.BackupModeSnapshot},
+		Status: pulpv1.PulpBackupStatus{
+			VolumeSnapshots: []pulpv1.BackupVolumeSnapshot{{Name: "backup-file-storage", Volume: volumePulpDir, PVC: "pulp-file-storage", Size: "10Gi"}},
+		},
+	}
+	snapshot := controllers.NewVolumeSnapshot("backup-file-storage", "test-namespace", "pulp-file-storage", "", nil)
+	snapshot.Object["status"] = map[string]interface{}{"readyToUse": false, "error": map[string]interface{}{"message": "snapshot quota exceeded"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulpBackup, snapshot).Build()
+	r := &RepoManagerBackupReconciler{Client: c, RawLogger: logr.Discard(), Scheme: scheme}
+
+	_, err := r.waitVolumeSnapshots(context.TODO(), pulpBackup)
+	backupErr := &backupFailedError{}
+	if !goerrors.As(err, &backupErr) || backupErr.reason != "FailedVolumeSnapshot" {
+		t.Errorf("expected FailedVolumeSnapshot error, got %v", err)
+	}
+
+	pulpBackup.Spec.EncryptionSecret = "backup-passphrase"
+	if err := checkBackupMode(pulpBackup); err == nil || err.reason != "SnapshotEncryptionUnsupported" {
+		t.Errorf("expected SnapshotEncryptionUnsupported error, got %v", err)
+	}
+}
+
+// pvc returns a bound PVC with the capacity
+func pvc(name, storageClass string, accessMode corev1.PersistentVolumeAccessMode, capacity string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test-namespace"},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClass,
+			AccessModes:      []corev1.PersistentVolumeAccessMode{accessMode},
+			Resources:        corev1.VolumeResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")}},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Phase:    corev1.ClaimBound,
+			Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(capacity)},
+		},
+	}
+}
+
+// setSnapshotStatus updates the status of the VolumeSnapshot as the CSI snapshotter would
+func setSnapshotStatus(t *testing.T, c client.Client, snapshot *unstructured.Unstructured, status map[string]interface{}) {
+	snapshot.Object["status"] = status
+	if err := c.Update(context.TODO(), snapshot); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,28 +1,18 @@
 package repo_manager_backup
 
 import (
-	"context"
 	"errors"
+	"strconv"
+	"strings"
 
 	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
 	"github.com/pulp/pulp-operator/controllers"
-	corev1 "k8s.io/api/core/v1"
+	batchv1 "k8s.io/api/batch/v1"
 )
 
-// createBackupDir creates the directory to store the backup
-func (r *RepoManagerBackupReconciler) createBackupDir(ctx context.Context, pulpBackup *pulpv1.PulpBackup, backupDir string, pod *corev1.Pod) error {
-	log := r.RawLogger
-	backupPod := pulpBackup.Name + "-backup-manager"
-
-	log.Info("Creating backup folder ...")
-	execCmd := []string{"mkdir", "-p", backupDir}
-	_, err := controllers.ContainerExec(ctx, r, pod, execCmd, backupPod, pod.Namespace)
-	if err != nil {
-		log.Error(err, "Failed to create backup folder")
-		return err
-	}
-	return nil
-}
+// minCompressionMethodVersion is the first major version of pg_dump that supports the lz4 and zstd
+// compression methods
+const minCompressionMethodVersion = 16
 
 // checkRequiredFields will verify if all required fields are provided
 func checkRequiredFields(pulpBackup *pulpv1.PulpBackup) error {
@@ -32,6 +22,49 @@ func checkRequiredFields(pulpBackup *pulpv1.PulpBackup) error {
 	return nil
 }
 
+// checkBackupMode returns the error that stops the backup if backup_mode, pulp_dir_copy or the database
+// dump settings can not be used with the other settings
+func checkBackupMode(pulpBackup *pulpv1.PulpBackup) *backupFailedError {
+	spec := pulpBackup.Spec
+	compression, _, _ := strings.Cut(spec.DatabaseDumpCompression, ":")
+	tarFormat := spec.DatabaseDumpFormat != databaseDumpCustom && spec.DatabaseDumpFormat != databaseDumpDirectory
+	if tarFormat && len(compression) > 0 && compression != "none" {
+		return &backupFailedError{"DatabaseDumpCompressionUnsupported", "database_dump_compression can not be used with database_dump_format " + databaseDumpTar + ", pg_dump does not compress tar archives."}
+	}
+	if spec.DatabaseDumpFormat != databaseDumpDirectory && spec.DatabaseDumpJobs > 1 {
+		return &backupFailedError{"DatabaseDumpJobsUnsupported", "database_dump_jobs can only be used with database_dump_format " + databaseDumpDirectory + "."}
+	}
+	if !isEncrypted(pulpBackup) {
+		return nil
+	}
+	if spec.BackupMode == controllers.BackupModeSnapshot {
+		return &backupFailedError{"SnapshotEncryptionUnsupported", "backup_mode Snapshot can not be used with encryption_secret, the volume snapshots are not encrypted by the operator."}
+	}
+	if spec.PulpDirCopy == pulpDirCopyIncremental {
+		return &backupFailedError{"IncrementalEncryptionUnsupported", "pulp_dir_copy Incremental can not be used with encryption_secret, the encrypted Pulp dir is stored in a single archive."}
+	}
+	if spec.DatabaseDumpFormat == databaseDumpDirectory {
+		return &backupFailedError{"DirectoryEncryptionUnsupported", "database_dump_format Directory can not be used with encryption_secret, the encrypted database dump is stored in a single archive."}
+	}
+	return nil
+}
+
+// checkDatabaseDumpCompression returns the error that stops the backup if the database_dump_compression
+// method is not supported by the pg_dump of the backup-manager image with the major version pgDumpVersion
+// (the major version of the database server for the images selected by the operator).
+// The version is not checked if it is unknown, like in a backup_manager_image without a version tag.
+func checkDatabaseDumpCompression(pulpBackup *pulpv1.PulpBackup, pgDumpVersion string) *backupFailedError {
+	method, _, _ := strings.Cut(pulpBackup.Spec.DatabaseDumpCompression, ":")
+	if method != "lz4" && method != "zstd" {
+		return nil
+	}
+	if version, err := strconv.Atoi(pgDumpVersion); err == nil && version < minCompressionMethodVersion {
+		return &backupFailedError{"DatabaseDumpCompressionUnsupported", "database_dump_compression " + method + " requires PostgreSQL " + strconv.Itoa(minCompressionMethodVersion) +
+			" or newer, the database is dumped with pg_dump " + pgDumpVersion + "."}
+	}
+	return nil
+}
+
 // getDeploymentName returns the deployment_name
 func getDeploymentName(pulpBackup *pulpv1.PulpBackup) string {
 	return pulpBackup.Spec.DeploymentName
@@ -101,15 +134,23 @@ func getContainerTokenSecret(pulp *pulpv1.Pulp) string {
 	return pulp.Status.ContainerTokenSecret
 }
 
-// setStatusFields will populate all the related status but conditions[].
-func (r *RepoManagerBackupReconciler) setStatusFields(ctx context.Context, pulpBackup *pulpv1.PulpBackup, timestamp string) error {
+// setStatusFields will populate all the related status but conditions[] and phase.
+func setStatusFields(pulpBackup *pulpv1.PulpBackup, timestamp string) {
 	pulpBackup.Status.AdminPasswordSecret = getAdminPasswordSecret(pulpBackup)
 	pulpBackup.Status.BackupClaim = getBackupPVC(pulpBackup)
 	pulpBackup.Status.BackupDirectory = getBackupDir(timestamp)
 	pulpBackup.Status.BackupNamespace = getBackupPVCNamespace(pulpBackup)
 	pulpBackup.Status.DeploymentName = getDeploymentName(pulpBackup)
-	if err := r.Status().Update(ctx, pulpBackup); err != nil {
-		return err
+}
+
+// isEncrypted returns true if the backup files should be encrypted
+func isEncrypted(pulpBackup *pulpv1.PulpBackup) bool {
+	return len(pulpBackup.Spec.EncryptionSecret) > 0
+}
+
+// encryptJob mounts the secret with the encryption passphrase in the job if the backup should be encrypted
+func encryptJob(job *batchv1.Job, pulpBackup *pulpv1.PulpBackup) {
+	if isEncrypted(pulpBackup) {
+		controllers.MountEncryptionSecret(job, pulpBackup.Spec.EncryptionSecret)
 	}
-	return nil
 }
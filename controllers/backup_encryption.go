@@ -0,0 +1,82 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// BackupEncryptionGPG is the manifest encryption of the backups encrypted with a gpg passphrase
+	BackupEncryptionGPG = "gpg"
+
+	// EncryptionPassphraseKey is the key of the encryption secret with the passphrase
+	EncryptionPassphraseKey = "passphrase"
+
+	// EncryptedPulpDir is the (encrypted) tar archive with the content of /var/lib/pulp stored in
+	// encrypted backups, instead of the pulp directory
+	EncryptedPulpDir = "pulp.tar"
+
+	// EncryptedRemote is the name of the rclone remote that encrypts the object storage content
+	// stored in the backup directory
+	EncryptedRemote = "encrypted"
+
+	// encryptionMountPath is where the encryption secret is mounted in the jobs
+	encryptionMountPath = "/encryption"
+)
+
+// EncryptionScript defines the encrypt and decrypt shell functions, which read from stdin and
+// write to stdout, using the passphrase from the $ENCRYPTION_PASSPHRASE_FILE file
+const EncryptionScript = `export GNUPGHOME=$(mktemp -d)
+encrypt() { gpg --batch --quiet --no-symkey-cache --pinentry-mode loopback --passphrase-file "$ENCRYPTION_PASSPHRASE_FILE" --symmetric --cipher-algo AES256; }
+decrypt() { gpg --batch --quiet --no-symkey-cache --pinentry-mode loopback --passphrase-file "$ENCRYPTION_PASSPHRASE_FILE" --decrypt; }
+`
+
+// MountEncryptionSecret mounts the secret with the encryption passphrase in the job container and
+// sets the $ENCRYPTION_PASSPHRASE_FILE used by EncryptionScript and EncryptedRemoteScript
+func MountEncryptionSecret(job *batchv1.Job, secret string) {
+	podSpec := &job.Spec.Template.Spec
+	podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, corev1.EnvVar{
+		Name:  "ENCRYPTION_PASSPHRASE_FILE",
+		Value: encryptionMountPath + "/" + EncryptionPassphraseKey,
+	})
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "encryption",
+		MountPath: encryptionMountPath,
+		ReadOnly:  true,
+	})
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "encryption",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: secret,
+				Items:      []corev1.KeyToPath{{Key: EncryptionPassphraseKey, Path: EncryptionPassphraseKey}},
+			},
+		},
+	})
+}
+
+// EncryptedRemoteScript returns the script that configures the EncryptedRemote rclone remote, which
+// stores the content in dir encrypted (rclone crypt) with the passphrase from $ENCRYPTION_PASSPHRASE_FILE
+func EncryptedRemoteScript(dir string) string {
+	return `export RCLONE_CONFIG_ENCRYPTED_TYPE=crypt
+export RCLONE_CONFIG_ENCRYPTED_REMOTE=` + dir + `
+RCLONE_CONFIG_ENCRYPTED_PASSWORD=$(rclone obscure - < "$ENCRYPTION_PASSPHRASE_FILE")
+export RCLONE_CONFIG_ENCRYPTED_PASSWORD
+`
+}
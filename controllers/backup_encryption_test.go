@@ -0,0 +1,137 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestEncryptedBackupScripts verifies that an encrypted backup can only be verified (and its resources
+// read) with the passphrase used to encrypt it
+func TestEncryptedBackupScripts(t *testing.T) {
+	for _, tool := range []string{"gpg", "tar"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%v not found", tool)
+		}
+	}
+
+	keys := t.TempDir()
+	passphraseFile := filepath.Join(keys, "passphrase")
+	wrongPassphraseFile := filepath.Join(keys, "wrong")
+	if err := os.WriteFile(passphraseFile, []byte("correct horse battery staple\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(wrongPassphraseFile, []byte("wrong\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// encrypt the backup files in the same way as the backup jobs
+	backupDir := writeBackup(t)
+	encryptScript := "set -eo pipefail\ncd " + backupDir + "\n" + EncryptionScript + `for file in pulp.db cr_object admin_secret.yaml; do
+  encrypt < "$file" > "$file.tmp"
+  mv "$file.tmp" "$file"
+done
+tar -C pulp -cf - . | encrypt > ` + EncryptedPulpDir + `
+rm -rf pulp
+`
+	if _, stderr, exitCode := runScript(t, encryptScript, "ENCRYPTION_PASSPHRASE_FILE="+passphraseFile); exitCode != 0 {
+		t.Fatalf("failed to encrypt the backup files: %v", stderr)
+	}
+	if content, _ := os.ReadFile(filepath.Join(backupDir, "cr_object")); string(content) == "{}\n" {
+		t.Fatalf("cr_object was not encrypted")
+	}
+	if _, stderr, exitCode := runScript(t, BackupManifestScript(backupDir), "OPERATOR_VERSION=2.0.0", "DEPLOYMENT_NAME=pulp", "ENCRYPTION="+BackupEncryptionGPG, "ENCRYPTION_PASSPHRASE_FILE="+passphraseFile); exitCode != 0 {
+		t.Fatalf("unexpected exit code %d: %v", exitCode, stderr)
+	}
+
+	tests := []struct {
+		name           string
+		passphraseFile string
+		expectExitCode int
+		expectError    string
+	}{
+		{
+			name:           "passphrase used by the backup",
+			passphraseFile: passphraseFile,
+		},
+		{
+			name:           "passphrase not provided",
+			expectExitCode: VerifyBackupEncrypted,
+			expectError:    "encryption_secret",
+		},
+		{
+			name:           "wrong passphrase",
+			passphraseFile: wrongPassphraseFile,
+			expectExitCode: VerifyBackupDecryptionFailed,
+			expectError:    "failed to decrypt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := []string{}
+			if len(tt.passphraseFile) > 0 {
+				env = append(env, "ENCRYPTION_PASSPHRASE_FILE="+tt.passphraseFile)
+			}
+			output := filepath.Join(t.TempDir(), "backup-files")
+			stdout, stderr, exitCode := runScript(t, VerifyBackupScript(backupDir, output), env...)
+			if exitCode != tt.expectExitCode {
+				t.Fatalf("expected exit code %d, got %d: %v%v", tt.expectExitCode, exitCode, stdout, stderr)
+			}
+			// the job logs must not have the decrypted content
+			if strings.Contains(stdout+stderr, "password: password") || strings.Contains(stdout, backupFileContentsHeader) {
+				t.Errorf("expected no decrypted content in the job output, got %v%v", stdout, stderr)
+			}
+			if exitCode != 0 {
+				if !strings.Contains(stdout+stderr, tt.expectError) {
+					t.Errorf("expected error containing %q, got %v%v", tt.expectError, stdout, stderr)
+				}
+				return
+			}
+
+			files, err := ParseBackupFileContents(readOutput(t, output))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(files["cr_object"]) != "{}\n" || !strings.Contains(string(files["admin_secret.yaml"]), "password: password") {
+				t.Errorf("expected the decrypted content of the backed up resources, got %v", files)
+			}
+			manifest := &BackupManifest{}
+			if err := json.Unmarshal(files[BackupManifestFileName], manifest); err != nil {
+				t.Fatalf("invalid manifest: %v\n%s", err, files[BackupManifestFileName])
+			}
+			if manifest.Encryption != BackupEncryptionGPG {
+				t.Errorf("expected %v encryption, got %q", BackupEncryptionGPG, manifest.Encryption)
+			}
+		})
+	}
+
+	// the content of /var/lib/pulp is extracted in the same way as the restore job
+	pulpDir := t.TempDir()
+	extractScript := "set -eo pipefail\n" + EncryptionScript + "decrypt < " + filepath.Join(backupDir, EncryptedPulpDir) + " | tar -C " + pulpDir + " -xf -"
+	if _, stderr, exitCode := runScript(t, extractScript, "ENCRYPTION_PASSPHRASE_FILE="+passphraseFile); exitCode != 0 {
+		t.Fatalf("failed to extract %v: %v", EncryptedPulpDir, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(pulpDir, "media/artifact/ab/cdef")); err != nil {
+		t.Errorf("expected the pulp dir content to be restored: %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// postgresRepository is the repository of the postgres images used for the external databases
+	postgresRepository = "docker.io/library/postgres"
+
+	// DefaultPostgresImage is the image of the database deployed by the operator when neither
+	// postgres_image nor the RELATED_IMAGE_PULP_POSTGRES environment variable are defined
+	DefaultPostgresImage = postgresRepository + ":15"
+
+	// BackupMountPath is where the backup PVC is mounted in the backup and restore jobs
+	BackupMountPath = "/backups"
+
+	// FileStorageMountPath is where the Pulp file storage PVC is mounted in the backup and restore jobs
+	FileStorageMountPath = "/var/lib/pulp"
+)
+
+// JobFailedError is returned when a backup or restore job finishes with an error
+type JobFailedError struct {
+	// Name of the job
+	Name string
+
+	// ExitCode of the container from the last pod that ran
+	ExitCode int32
+
+	// Message is the termination message (or the last lines of the logs) of the container
+	Message string
+}
+
+func (e *JobFailedError) Error() string {
+	return fmt.Sprintf("job %v failed: %v", e.Name, e.Message)
+}
+
+// backupJob returns a Job with the backup PVC mounted in mountPath that runs container
+func backupJob(name, namespace, backupPVC, mountPath, appName string, affinity *corev1.Affinity, container corev1.Container) *batchv1.Job {
+	labels := map[string]string{
+		"app.kubernetes.io/name":       appName,
+		"app.kubernetes.io/instance":   appName + "-" + name,
+		"app.kubernetes.io/component":  "backup-storage",
+		"app.kubernetes.io/part-of":    "pulp",
+		"app.kubernetes.io/managed-by": "pulp-operator",
+	}
+
+	container.ImagePullPolicy = corev1.PullIfNotPresent
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      "backup",
+		MountPath: mountPath,
+	})
+	// in case of failure, the last lines of the logs will be used as the termination message
+	container.TerminationMessagePolicy = corev1.TerminationMessageFallbackToLogsOnError
+	container.SecurityContext = SetDefaultSecurityContext()
+
+	runAsUser := int64(700)
+	fsGroup := int64(700)
+	backoffLimit := int32(3)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Affinity:   affinity,
+					Containers: []corev1.Container{container},
+					Volumes: []corev1.Volume{{
+						Name: "backup",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+								ClaimName: backupPVC,
+							},
+						},
+					}},
+					RestartPolicy:   corev1.RestartPolicyNever,
+					SecurityContext: &corev1.PodSecurityContext{RunAsUser: &runAsUser, FSGroup: &fsGroup},
+				},
+			},
+		},
+	}
+}
+
+// BackupManagerJob returns a Job that runs script, with bash, in the default backup-manager image
+// (see SetBackupManager). The backup PVC is mounted in BackupMountPath.
+func BackupManagerJob(name, namespace, backupPVC string, affinity *corev1.Affinity, script string) *batchv1.Job {
+	return backupJob(name, namespace, backupPVC, BackupMountPath, "pulp-backup-manager", affinity, corev1.Container{
+		Name:    "backup-manager",
+		Image:   PostgresImage(nil),
+		Command: []string{"bash", "-c", script},
+	})
+}
+
+// SetBackupManager sets the image (if not empty), image pull policy (if not empty), pull secrets and
+// resource requirements of the backup-manager container of the job
+func SetBackupManager(job *batchv1.Job, image, pullPolicy string, pullSecrets []string, resources corev1.ResourceRequirements) {
+	podSpec := &job.Spec.Template.Spec
+	container := &podSpec.Containers[0]
+	if len(image) > 0 {
+		container.Image = image
+	}
+	if len(pullPolicy) > 0 {
+		container.ImagePullPolicy = corev1.PullPolicy(pullPolicy)
+	}
+	podSpec.ImagePullSecrets = nil
+	for _, secret := range pullSecrets {
+		podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, corev1.LocalObjectReference{Name: secret})
+	}
+	container.Resources = resources
+}
+
+// PostgresImage returns the image of the database deployed by the operator for pulp, or the default
+// one if pulp is nil
+func PostgresImage(pulp *pulpv1.Pulp) string {
+	if pulp != nil && len(pulp.Spec.Database.PostgresImage) > 0 {
+		return pulp.Spec.Database.PostgresImage
+	}
+	if image := os.Getenv("RELATED_IMAGE_PULP_POSTGRES"); len(image) > 0 {
+		return image
+	}
+	return DefaultPostgresImage
+}
+
+// BackupManagerImage returns the image with the PostgreSQL client tools used to dump and restore the
+// database of pulp. The database deployed by the operator is accessed with its own image. For an
+// external database, the postgres image with the major version of serverVersion (or, if it is not
+// known, of the version defined in Pulp CR) is used, since pg_dump refuses to dump a newer server.
+func BackupManagerImage(pulp *pulpv1.Pulp, serverVersion string) string {
+	if len(pulp.Spec.Database.ExternalDBSecret) == 0 {
+		return PostgresImage(pulp)
+	}
+	major := PostgresMajorVersion(serverVersion)
+	if len(major) == 0 {
+		major = PostgresMajorVersion(pulp.Spec.Database.PostgresVersion)
+	}
+	if len(major) == 0 {
+		return PostgresImage(nil)
+	}
+	return postgresRepository + ":" + major
+}
+
+// PostgresMajorVersion returns the major version of a PostgreSQL version (like "16.2 (Debian 16.2-1)"
+// or "16"), or an empty string if it can not be parsed
+func PostgresMajorVersion(version string) string {
+	major, _, _ := strings.Cut(strings.TrimSpace(version), " ")
+	major, _, _ = strings.Cut(major, ".")
+	if _, err := strconv.Atoi(major); err != nil {
+		return ""
+	}
+	return major
+}
+
+// ImageMajorVersion returns the major version from the tag of a postgres image (like "postgres:16" or
+// "postgres:16.2-alpine"), or an empty string if the tag is not a version
+func ImageMajorVersion(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	i := strings.LastIndex(image, ":")
+	if i < 0 || i < strings.LastIndex(image, "/") {
+		return ""
+	}
+	tag := image[i+1:]
+	end := strings.IndexFunc(tag, func(c rune) bool { return c < '0' || c > '9' })
+	if end < 0 {
+		end = len(tag)
+	}
+	return tag[:end]
+}
+
+// PostgresImageMajorVersion returns the major version of the database deployed by the operator for
+// pulp, from the tag of its image or, if the tag is not a version, from the version defined in Pulp CR
+func PostgresImageMajorVersion(pulp *pulpv1.Pulp) string {
+	if major := ImageMajorVersion(PostgresImage(pulp)); len(major) > 0 {
+		return major
+	}
+	return PostgresMajorVersion(pulp.Spec.Database.PostgresVersion)
+}
+
+// MountFileStorage mounts the Pulp file storage PVC (claimName) in FileStorageMountPath of the job container
+func MountFileStorage(job *batchv1.Job, claimName string) {
+	podSpec := &job.Spec.Template.Spec
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "file-storage",
+		MountPath: FileStorageMountPath,
+	})
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "file-storage",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: claimName,
+			},
+		},
+	})
+}
+
+// MountPulpSettings mounts the settings.py, the database fields encryption key and the database CA of pulp
+// in /etc/pulp of the job container, as in the pulpcore containers
+func MountPulpSettings(job *batchv1.Job, pulp *pulpv1.Pulp) {
+	podSpec := &job.Spec.Template.Spec
+	for _, file := range []struct{ volume, secret, key, path string }{
+		{"pulp-settings", settings.PulpServerSecret(pulp.Name), "settings.py", "/etc/pulp/settings.py"},
+		{"pulp-db-fields-encryption", GetDBFieldsEncryptionSecret(*pulp), "database_fields.symmetric.key", "/etc/pulp/keys/database_fields.symmetric.key"},
+	} {
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      file.volume,
+			MountPath: file.path,
+			SubPath:   file.key,
+			ReadOnly:  true,
+		})
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: file.volume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: file.secret,
+					Items:      []corev1.KeyToPath{{Key: file.key, Path: file.key}},
+				},
+			},
+		})
+	}
+	podSpec.Containers[0].VolumeMounts = SetDatabaseCAVolumeMounts(pulp, podSpec.Containers[0].VolumeMounts)
+	podSpec.Volumes = SetDatabaseCAVolumes(pulp, podSpec.Volumes)
+}
+
+// PostgresEnv returns the libpq environment variables to connect to the database defined in
+// the postgres configuration secret
+func PostgresEnv(postgresSecret string) []corev1.EnvVar {
+	env := []corev1.EnvVar{}
+	for _, key := range []struct{ name, secretKey string }{
+		{"PGHOST", "host"},
+		{"PGPORT", "port"},
+		{"PGUSER", "username"},
+		{"PGPASSWORD", "password"},
+		{"PGDATABASE", "database"},
+	} {
+		env = append(env, corev1.EnvVar{
+			Name: key.name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: postgresSecret},
+					Key:                  key.secretKey,
+				},
+			},
+		})
+	}
+	return env
+}
+
+// EnsureJob creates the job, owned by owner, if it is not found and returns true when it finished successfully.
+// It does not wait for the job, so it should be called again (in a later reconciliation) while it returns false.
+// A *JobFailedError is returned if the job failed.
+func EnsureJob(ctx context.Context, r client.Client, scheme *runtime.Scheme, owner metav1.Object, job *batchv1.Job) (bool, error) {
+	found := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, found)
+	if k8s_errors.IsNotFound(err) {
+		ctrl.SetControllerReference(owner, job, scheme)
+		return false, r.Create(ctx, job)
+	} else if err != nil {
+		return false, err
+	}
+
+	// a job left by a removed CR with the same name
+	if !metav1.IsControlledBy(found, owner) {
+		return false, DeleteJob(ctx, r, found.Name, found.Namespace)
+	}
+	if found.DeletionTimestamp != nil {
+		return false, nil
+	}
+
+	for _, condition := range found.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return true, nil
+		case batchv1.JobFailed:
+			jobErr := &JobFailedError{Name: found.Name, Message: condition.Message}
+			if pod, err := jobPod(ctx, r, found); err == nil && len(pod.Status.ContainerStatuses) > 0 {
+				if terminated := pod.Status.ContainerStatuses[0].State.Terminated; terminated != nil {
+					jobErr.ExitCode = terminated.ExitCode
+					if len(terminated.Message) > 0 {
+						jobErr.Message = StripProgress(terminated.Message)
+					}
+				}
+			}
+			return false, jobErr
+		}
+	}
+	return false, nil
+}
+
+// jobPod returns the most recent pod created by the job
+func jobPod(ctx context.Context, r client.Client, job *batchv1.Job) (*corev1.Pod, error) {
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(job.Namespace), client.MatchingLabels{"controller-uid": string(job.UID)}); err != nil {
+		return nil, err
+	}
+	if len(podList.Items) == 0 {
+		return nil, fmt.Errorf("no pods found for job %v", job.Name)
+	}
+	sort.Slice(podList.Items, func(i, j int) bool {
+		return podList.Items[j].CreationTimestamp.Before(&podList.Items[i].CreationTimestamp)
+	})
+	return &podList.Items[0], nil
+}
+
+// RunningJobPod returns the most recent pod created by the job if it is running, or nil
+func RunningJobPod(ctx context.Context, r client.Client, name, namespace string) (*corev1.Pod, error) {
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, job); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	pod, err := jobPod(ctx, r, job)
+	if err != nil || pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+		return nil, nil
+	}
+	return pod, nil
+}
+
+// JobLogs returns the logs from the most recent pod created by the job
+func JobLogs(ctx context.Context, r client.Client, restClient rest.Interface, name, namespace string) (string, error) {
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, job); err != nil {
+		return "", err
+	}
+	pod, err := jobPod(ctx, r, job)
+	if err != nil {
+		return "", err
+	}
+	logs, err := restClient.Get().
+		Namespace(namespace).
+		Resource("pods").
+		Name(pod.Name).
+		SubResource("log").
+		DoRaw(ctx)
+	if err != nil {
+		return "", err
+	}
+	return string(logs), nil
+}
+
+// DeleteJob removes the job and its pods. It does not wait for the job to be removed.
+func DeleteJob(ctx context.Context, r client.Client, name, namespace string) error {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	return client.IgnoreNotFound(r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)))
+}
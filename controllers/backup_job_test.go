@@ -0,0 +1,126 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// TestBackupManagerImage verifies the image selected from the database configuration of Pulp
+func TestBackupManagerImage(t *testing.T) {
+	t.Setenv("RELATED_IMAGE_PULP_POSTGRES", "")
+	tests := []struct {
+		name          string
+		database      pulpv1.Database
+		serverVersion string
+		expect        string
+	}{
+		{
+			name:   "database deployed by the operator",
+			expect: DefaultPostgresImage,
+		},
+		{
+			name:          "database deployed by the operator with postgres_image",
+			database:      pulpv1.Database{PostgresImage: "registry.example.com/postgres:16"},
+			serverVersion: "15.8",
+			expect:        "registry.example.com/postgres:16",
+		},
+		{
+			name:          "external database",
+			database:      pulpv1.Database{ExternalDBSecret: "external-database", PostgresVersion: "14"},
+			serverVersion: "16.4 (Debian 16.4-1.pgdg120+1)",
+			expect:        "docker.io/library/postgres:16",
+		},
+		{
+			name:     "external database with unknown server version",
+			database: pulpv1.Database{ExternalDBSecret: "external-database", PostgresVersion: "14"},
+			expect:   "docker.io/library/postgres:14",
+		},
+		{
+			name:          "external database with invalid server version",
+			database:      pulpv1.Database{ExternalDBSecret: "external-database"},
+			serverVersion: "psql: error: connection refused",
+			expect:        DefaultPostgresImage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pulp := &pulpv1.Pulp{Spec: pulpv1.PulpSpec{Database: tt.database}}
+			if image := BackupManagerImage(pulp, tt.serverVersion); image != tt.expect {
+				t.Errorf("expected %v, got %v", tt.expect, image)
+			}
+		})
+	}
+}
+
+// TestSetBackupManager verifies the container settings applied to the backup-manager jobs
+func TestSetBackupManager(t *testing.T) {
+	t.Setenv("RELATED_IMAGE_PULP_POSTGRES", "")
+	resources := corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")}}
+
+	job := BackupManagerJob("backup-db", "test-namespace", "backup-claim", nil, "true")
+	SetBackupManager(job, "docker.io/library/postgres:16", "", nil, corev1.ResourceRequirements{})
+	container := job.Spec.Template.Spec.Containers[0]
+	if container.Image != "docker.io/library/postgres:16" || container.ImagePullPolicy != corev1.PullIfNotPresent || len(job.Spec.Template.Spec.ImagePullSecrets) > 0 {
+		t.Errorf("unexpected default settings: image %v, pull policy %v, pull secrets %v", container.Image, container.ImagePullPolicy, job.Spec.Template.Spec.ImagePullSecrets)
+	}
+
+	job = BackupManagerJob("backup-db", "test-namespace", "backup-claim", nil, "true")
+	SetBackupManager(job, "registry.example.com/postgres:16", "Always", []string{"registry-credentials"}, resources)
+	container = job.Spec.Template.Spec.Containers[0]
+	if container.Image != "registry.example.com/postgres:16" || container.ImagePullPolicy != corev1.PullAlways {
+		t.Errorf("unexpected image %v (pull policy %v)", container.Image, container.ImagePullPolicy)
+	}
+	if expected := []corev1.LocalObjectReference{{Name: "registry-credentials"}}; !reflect.DeepEqual(job.Spec.Template.Spec.ImagePullSecrets, expected) {
+		t.Errorf("expected pull secrets %v, got %v", expected, job.Spec.Template.Spec.ImagePullSecrets)
+	}
+	if !reflect.DeepEqual(container.Resources, resources) {
+		t.Errorf("expected resources %v, got %v", resources, container.Resources)
+	}
+}
+
+// TestPostgresImageMajorVersion verifies the major version found in the image of the database
+func TestPostgresImageMajorVersion(t *testing.T) {
+	t.Setenv("RELATED_IMAGE_PULP_POSTGRES", "")
+	tests := []struct {
+		name     string
+		database pulpv1.Database
+		expect   string
+	}{
+		{name: "default image", expect: "15"},
+		{name: "version tag", database: pulpv1.Database{PostgresImage: "docker.io/library/postgres:16"}, expect: "16"},
+		{name: "minor version and variant tag", database: pulpv1.Database{PostgresImage: "registry.example.com:5000/postgres:16.2-alpine"}, expect: "16"},
+		{name: "digest", database: pulpv1.Database{PostgresImage: "postgres:17@sha256:0123456789abcdef"}, expect: "17"},
+		{name: "tag is not a version", database: pulpv1.Database{PostgresImage: "registry.example.com:5000/postgres:latest", PostgresVersion: "14"}, expect: "14"},
+		{name: "no tag", database: pulpv1.Database{PostgresImage: "registry.example.com:5000/postgres"}, expect: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pulp := &pulpv1.Pulp{Spec: pulpv1.PulpSpec{Database: tt.database}}
+			if major := PostgresImageMajorVersion(pulp); major != tt.expect {
+				t.Errorf("expected %q, got %q", tt.expect, major)
+			}
+		})
+	}
+}
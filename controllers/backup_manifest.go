@@ -0,0 +1,367 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const (
+	// BackupManifestFileName is the name of the file, in the backup directory, with the backup metadata
+	BackupManifestFileName = "manifest.json"
+
+	// BackupManifestVersion is the version of the backup format (the layout of the backup directory and
+	// the schema of the Pulp CR saved in cr_object) written by this operator. It must be increased, along
+	// with a converter in the restore controller, when a change prevents the restore of older backups as is.
+	BackupManifestVersion = 1
+
+	// LegacyBackupVersion is the version of the backups made before the manifest was introduced
+	LegacyBackupVersion = 0
+
+	// DatabaseDumpFile is the name of the database dump in the backup directory. It is a directory
+	// when the dump is made with database_dump_format Directory.
+	DatabaseDumpFile = "pulp.db"
+
+	// databaseDumpTOC is the table of contents of a database dump in the directory format
+	databaseDumpTOC = DatabaseDumpFile + "/toc.dat"
+)
+
+// requiredBackupFiles are the files that every backup should contain.
+// The database dump in the directory format is listed as its table of contents.
+var requiredBackupFiles = []string{DatabaseDumpFile, "cr_object"}
+
+// BackupManifest describes the content of a backup directory
+type BackupManifest struct {
+	// Version of the manifest format
+	Version int `json:"version"`
+
+	// Version of the operator that made the backup
+	OperatorVersion string `json:"operator_version"`
+
+	// Time the backup finished (RFC3339)
+	CreatedAt string `json:"created_at"`
+
+	// Name of the Pulp CR backed up
+	DeploymentName string `json:"deployment_name"`
+
+	// Namespace of the Pulp CR backed up
+	Namespace string `json:"namespace,omitempty"`
+
+	// Pulp image deployed when the backup was made
+	PulpImage string `json:"pulp_image"`
+
+	// Version of the database server the dump was taken from
+	DatabaseVersion string `json:"database_version"`
+
+	// Number of parallel jobs used to dump the database (0 for the backups that do not record it)
+	DatabaseDumpJobs int `json:"database_dump_jobs,omitempty"`
+
+	// How the backup files are encrypted (empty if they are not encrypted)
+	Encryption string `json:"encryption,omitempty"`
+
+	// Number and total size (in bytes) of the files in the backup directory.
+	// They are only set in the manifest written by VerifyBackupScript, which lists only the required files.
+	// The size of the database dump (the total size of its files in the directory format) is set likewise.
+	FileCount        int64 `json:"file_count,omitempty"`
+	TotalSize        int64 `json:"total_size,omitempty"`
+	DatabaseDumpSize int64 `json:"database_dump_size,omitempty"`
+
+	// Files in the backup directory (paths are relative to it)
+	Files []BackupManifestFile `json:"files"`
+}
+
+// BackupManifestFile describes a file from the backup directory
+type BackupManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Exit codes of VerifyBackupScript (verifyAwk exits with VerifyBackupFailed and VerifyBackupInvalidManifest)
+const (
+	VerifyBackupFailed           = 1
+	VerifyBackupManifestNotFound = 2
+	VerifyBackupDirNotFound      = 3
+	VerifyBackupInvalidManifest  = 4
+	VerifyBackupEncrypted        = 5
+	VerifyBackupDecryptionFailed = 6
+)
+
+// backupFileContentsHeader precedes the (base64 encoded) content of each file in the output file of VerifyBackupScript
+const backupFileContentsHeader = "--- "
+
+// backupFilesScript writes the size and the checksum of every file from the current directory
+// (except the manifest) into the $SIZES and $CHECKSUMS files
+const backupFilesScript = `export LC_ALL=C
+SIZES=$(mktemp)
+CHECKSUMS=$(mktemp)
+find . -type f ! -path ./` + BackupManifestFileName + ` ! -path ./` + BackupManifestFileName + `.tmp -printf '%s %P\n' | sort -k 2 > "$SIZES"
+find . -type f ! -path ./` + BackupManifestFileName + ` ! -path ./` + BackupManifestFileName + `.tmp -print0 | xargs -0 -r sha256sum > "$CHECKSUMS"
+`
+
+// manifestAwk builds the manifest (in the same layout as json.MarshalIndent) from the $CHECKSUMS and
+// $SIZES files. The metadata is read from the environment variables.
+const manifestAwk = `
+function quote(s,    out, i, c) {
+	if (s !~ /[\\"]/) return "\"" s "\""
+	out = ""
+	for (i = 1; i <= length(s); i++) {
+		c = substr(s, i, 1)
+		if (c == "\\" || c == "\"") out = out "\\"
+		out = out c
+	}
+	return "\"" out "\""
+}
+BEGIN {
+	print "{"
+	print "  \"version\": " ENVIRON["MANIFEST_VERSION"] ","
+	print "  \"operator_version\": " quote(ENVIRON["OPERATOR_VERSION"]) ","
+	print "  \"created_at\": " quote(ENVIRON["CREATED_AT"]) ","
+	print "  \"deployment_name\": " quote(ENVIRON["DEPLOYMENT_NAME"]) ","
+	print "  \"namespace\": " quote(ENVIRON["NAMESPACE"]) ","
+	print "  \"pulp_image\": " quote(ENVIRON["PULP_IMAGE"]) ","
+	print "  \"database_version\": " quote(ENVIRON["DATABASE_VERSION"]) ","
+	print "  \"database_dump_jobs\": " (ENVIRON["DATABASE_DUMP_JOBS"] + 0) ","
+	print "  \"encryption\": " quote(ENVIRON["ENCRYPTION"]) ","
+	printf "  \"files\": ["
+}
+FILENAME == ARGV[1] { checksum[substr($0, 69)] = substr($0, 1, 64); next }
+{
+	path = substr($0, length($1) + 2)
+	if (!(path in checksum)) {
+		print "could not find the checksum of " path > "/dev/stderr"
+		failed = 1
+		exit 1
+	}
+	printf "%s\n    {\n      \"path\": %s,\n      \"size\": %s,\n      \"sha256\": \"%s\"\n    }", (files++ ? "," : ""), quote(path), $1, checksum[path]
+}
+END {
+	if (failed) exit 1
+	print (files ? "\n  ]" : "]")
+	print "}"
+}
+`
+
+// verifyAwk compares the files listed in the manifest with the $CHECKSUMS and $SIZES files.
+// Files found that are not listed in the manifest are ignored.
+// It prints the manifest metadata with only the required files (and the number and total size of the
+// files listed) so that it can be validated by the operator.
+const verifyAwk = `
+function unquote(s,    out, i, c) {
+	s = substr(s, 2, length(s) - 2)
+	if (s !~ /\\/) return s
+	out = ""
+	for (i = 1; i <= length(s); i++) {
+		c = substr(s, i, 1)
+		if (c == "\\") c = substr(s, ++i, 1)
+		out = out c
+	}
+	return out
+}
+function problem(message) {
+	# keep the condition message readable when a lot of files are affected
+	if (++problems <= 5) report = report (problems > 1 ? "; " : "") message
+}
+BEGIN {
+	n = split(ENVIRON["REQUIRED_FILES"], list, " ")
+	for (i = 1; i <= n; i++) required[list[i]] = 1
+}
+FILENAME == ARGV[1] { checksum[substr($0, 69)] = substr($0, 1, 64); next }
+FILENAME == ARGV[2] { size[substr($0, length($1) + 2)] = $1; next }
+/^ *"[a-z0-9_]+": / {
+	key = $0; sub(/^ *"/, "", key); sub(/".*$/, "", key)
+	value = $0; sub(/^ *"[a-z0-9_]+": /, "", value); sub(/,? *$/, "", value)
+	if (key == "files") { in_files = 1; next }
+	if (!in_files) { header = header "  \"" key "\": " value ",\n"; next }
+	entry[key] = value
+	if (key != "sha256") next
+
+	path = unquote(entry["path"])
+	file_count++
+	total_size += entry["size"]
+	if (path == "` + DatabaseDumpFile + `" || index(path, "` + DatabaseDumpFile + `/") == 1) dump_size += entry["size"]
+	if (path in required) listed = listed (listed == "" ? "" : ",") "\n    {\"path\": " entry["path"] ", \"size\": " entry["size"] ", \"sha256\": " entry["sha256"] "}"
+	if (!(path in size)) problem(path " not found")
+	else if (size[path] + 0 != entry["size"] + 0) problem(path " size mismatch (expected " entry["size"] " bytes, found " size[path] ")")
+	else if (checksum[path] != unquote(entry["sha256"])) problem(path " checksum mismatch")
+	delete entry
+}
+END {
+	if (header == "") {
+		print "` + BackupManifestFileName + ` is not a valid backup manifest" > "/dev/stderr"
+		exit 4
+	}
+	if (problems > 5) report = report "; and " (problems - 5) " more"
+	if (problems > 0) {
+		print "backup verification failed: " report > "/dev/stderr"
+		exit 1
+	}
+	printf "{\n%s  \"file_count\": %d,\n  \"total_size\": %.0f,\n  \"database_dump_size\": %.0f,\n  \"files\": [%s\n  ]\n}\n", header, file_count, total_size, dump_size, listed
+}
+`
+
+// legacyManifestAwk prints, from the $SIZES file, the manifest of a backup made before the manifest was
+// introduced, whose files cannot be verified. The version is read from the version variable.
+const legacyManifestAwk = `
+{
+	path = substr($0, length($1) + 2)
+	file_count++
+	total_size += $1
+	if (path == "` + DatabaseDumpFile + `" || index(path, "` + DatabaseDumpFile + `/") == 1) dump_size += $1
+}
+END {
+	printf "{\n  \"version\": %d,\n  \"file_count\": %d,\n  \"total_size\": %.0f,\n  \"database_dump_size\": %.0f,\n  \"files\": []\n}\n", version, file_count, total_size, dump_size
+}
+`
+
+// BackupManifestScript returns the script that stores, in the backup directory, the manifest with the
+// backup metadata and the size and checksum of each backup file.
+// The metadata is read from the OPERATOR_VERSION, DEPLOYMENT_NAME, NAMESPACE, PULP_IMAGE, ENCRYPTION and
+// DATABASE_DUMP_JOBS environment variables.
+func BackupManifestScript(backupDir string) string {
+	return "set -eo pipefail\ncd " + backupDir + "\n" + EncryptionScript + backupFilesScript + `
+export MANIFEST_VERSION=` + fmt.Sprint(BackupManifestVersion) + `
+export CREATED_AT=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+database_version() { pg_restore -l "$@" 2>/dev/null | sed -n 's/^; *Dumped from database version: //p'; }
+if [ -n "$ENCRYPTION" ]; then
+  export DATABASE_VERSION=$(decrypt < ` + DatabaseDumpFile + ` 2>/dev/null | database_version || true)
+else
+  export DATABASE_VERSION=$(database_version ` + DatabaseDumpFile + ` || true)
+fi
+awk '` + manifestAwk + `' "$CHECKSUMS" "$SIZES" > ` + BackupManifestFileName + `.tmp
+mv ` + BackupManifestFileName + `.tmp ` + BackupManifestFileName + `
+`
+}
+
+// VerifyBackupScript returns the script that makes sure that the files in backupDir match the sizes and
+// checksums recorded in the manifest. The backups made before the manifest was introduced, which have a
+// database dump and a cr_object but no manifest, are not verified: a manifest with LegacyBackupVersion
+// and the sizes of their files is used instead. In case of success, it writes into outputFile the manifest (with
+// only the required files) and the content of the resources (Secrets, ConfigMaps and Pulp CR) backed up, which
+// can be parsed with ParseBackupFileContents. Nothing from the backup is printed, the resources hold the
+// credentials of Pulp and the logs of the job can be read by anyone allowed to read the pod logs.
+// The resources of encrypted backups are decrypted with the passphrase from $ENCRYPTION_PASSPHRASE_FILE.
+// In case of failure, it exits with one of the VerifyBackup* codes.
+func VerifyBackupScript(backupDir, outputFile string) string {
+	return fmt.Sprintf(`set -eo pipefail
+cd %[1]s 2>/dev/null || { echo "%[1]s not found"; exit %[2]d; }
+if [ ! -f %[3]s ] && { [ ! -f cr_object ] || [ ! -e %[5]s ]; }; then
+  echo "%[1]s/%[3]s not found, the backup is incomplete"
+  exit %[4]d
+fi
+`, backupDir, VerifyBackupDirNotFound, BackupManifestFileName, VerifyBackupManifestNotFound, DatabaseDumpFile) + backupFilesScript + `
+SUMMARY=$(mktemp)
+if [ -f ` + BackupManifestFileName + ` ]; then
+  REQUIRED_FILES="` + strings.Join(append(requiredBackupFiles, databaseDumpTOC), " ") + `" awk '` + verifyAwk + `' "$CHECKSUMS" "$SIZES" ` + BackupManifestFileName + ` > "$SUMMARY"
+else
+  awk -v version=` + fmt.Sprint(LegacyBackupVersion) + ` '` + legacyManifestAwk + `' "$SIZES" > "$SUMMARY"
+fi
+content() { cat "$1"; }
+if grep -q '"encryption": "` + BackupEncryptionGPG + `"' "$SUMMARY"; then
+  if [ -z "$ENCRYPTION_PASSPHRASE_FILE" ]; then
+    echo "the backup is encrypted, an encryption_secret with the passphrase is required to restore it"
+    exit ` + fmt.Sprint(VerifyBackupEncrypted) + `
+  fi
+  ` + strings.ReplaceAll(strings.TrimSpace(EncryptionScript), "\n", "\n  ") + `
+  content() { decrypt < "$1" || { echo "failed to decrypt $1, the encryption_secret does not have the passphrase used by the backup" >&2; exit ` + fmt.Sprint(VerifyBackupDecryptionFailed) + `; }; }
+fi
+{
+  echo "` + backupFileContentsHeader + BackupManifestFileName + `"
+  base64 < "$SUMMARY"
+  for file in cr_object *.yaml; do
+    if [ -f "$file" ]; then
+      echo "` + backupFileContentsHeader + `$file"
+      content "$file" | base64
+    fi
+  done
+} > ` + outputFile + `.tmp
+mv ` + outputFile + `.tmp ` + outputFile + `
+echo "Backup files verified"
+`
+}
+
+// ParseBackupFileContents converts the output file of VerifyBackupScript into a map with the content of each file
+func ParseBackupFileContents(output string) (map[string][]byte, error) {
+	encoded := map[string]*strings.Builder{}
+	var current *strings.Builder
+	for _, line := range strings.Split(output, "\n") {
+		if name, found := strings.CutPrefix(line, backupFileContentsHeader); found {
+			current = &strings.Builder{}
+			encoded[name] = current
+		} else if current != nil {
+			current.WriteString(strings.TrimSpace(line))
+		}
+	}
+
+	files := map[string][]byte{}
+	for name, content := range encoded {
+		decoded, err := base64.StdEncoding.DecodeString(content.String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %v: %v", name, err)
+		}
+		files[name] = decoded
+	}
+	return files, nil
+}
+
+// IsLegacy returns true if the manifest was written by VerifyBackupScript for a backup made before the
+// manifest was introduced
+func (m *BackupManifest) IsLegacy() bool {
+	return m.Version == LegacyBackupVersion && len(m.OperatorVersion) == 0 && len(m.Files) == 0
+}
+
+// Validate returns an error if the manifest is missing any of the required fields
+func (m *BackupManifest) Validate() error {
+	if m.Version <= 0 {
+		return fmt.Errorf("manifest version not found")
+	}
+	if m.Version > BackupManifestVersion {
+		return fmt.Errorf("manifest version %d is not supported (latest supported version: %d)", m.Version, BackupManifestVersion)
+	}
+	missing := []string{}
+	if len(m.OperatorVersion) == 0 {
+		missing = append(missing, "operator_version")
+	}
+	if len(m.CreatedAt) == 0 {
+		missing = append(missing, "created_at")
+	}
+	if len(m.DeploymentName) == 0 {
+		missing = append(missing, "deployment_name")
+	}
+	if len(m.Files) == 0 {
+		missing = append(missing, "files")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("manifest is incomplete, missing fields: %v", strings.Join(missing, ", "))
+	}
+
+	listed := map[string]bool{}
+	for _, file := range m.Files {
+		if len(file.Path) == 0 || len(file.SHA256) == 0 {
+			return fmt.Errorf("manifest is incomplete, file entry without path or sha256: %+v", file)
+		}
+		listed[file.Path] = true
+	}
+	for _, required := range requiredBackupFiles {
+		if !listed[required] && !(required == DatabaseDumpFile && listed[databaseDumpTOC]) {
+			return fmt.Errorf("manifest is incomplete, %v is not listed", required)
+		}
+	}
+	return nil
+}
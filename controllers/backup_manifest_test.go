@@ -0,0 +1,399 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	dbChecksum = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	crChecksum = "ca3d163bab055381827226140568f3bef7eaac187cebd76878e0b63e9e442356"
+)
+
+// runScript runs script with bash and returns its stdout, stderr and exit code.
+// The test is skipped if the tools used by the backup scripts are not available.
+func runScript(t *testing.T, script string, env ...string) (string, string, int) {
+	t.Helper()
+	for _, tool := range []string{"bash", "awk", "sha256sum", "base64"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%v not found", tool)
+		}
+	}
+	if err := exec.Command("find", ".", "-maxdepth", "0", "-printf", "").Run(); err != nil {
+		t.Skip("find does not support -printf")
+	}
+
+	cmd := exec.Command("bash", "-c", script)
+	cmd.Env = append(os.Environ(), env...)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	err := cmd.Run()
+	exitErr := &exec.ExitError{}
+	if errors.As(err, &exitErr) {
+		return stdout.String(), stderr.String(), exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatalf("failed to run script: %v", err)
+	}
+	return stdout.String(), stderr.String(), 0
+}
+
+// readOutput returns the content of the output file written by a script
+func readOutput(t *testing.T, path string) string {
+	t.Helper()
+	output, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %v: %v", path, err)
+	}
+	return string(output)
+}
+
+// writeBackup creates a backup directory with a database dump, a CR, a secret and a file from /var/lib/pulp
+func writeBackup(t *testing.T) string {
+	t.Helper()
+	backupDir := t.TempDir()
+	files := map[string]string{
+		"pulp.db":                     "test",
+		"cr_object":                   "{}\n",
+		"admin_secret.yaml":           "admin_password_secret: pulp-admin-password\npassword: password\n",
+		"pulp/media/artifact/ab/cdef": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(backupDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return backupDir
+}
+
+// TestBackupManifestScript verifies the manifest created from the files in the backup directory
+func TestBackupManifestScript(t *testing.T) {
+	backupDir := writeBackup(t)
+	if _, stderr, exitCode := runScript(t, BackupManifestScript(backupDir), "OPERATOR_VERSION=2.0.0", "DEPLOYMENT_NAME=pulp", "NAMESPACE=pulp-prod", "PULP_IMAGE=quay.io/pulp/pulp-minimal:stable"); exitCode != 0 {
+		t.Fatalf("unexpected exit code %d: %v", exitCode, stderr)
+	}
+
+	content, err := os.ReadFile(filepath.Join(backupDir, BackupManifestFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &BackupManifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		t.Fatalf("invalid manifest: %v\n%s", err, content)
+	}
+	if err := manifest.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if manifest.Version != BackupManifestVersion || manifest.OperatorVersion != "2.0.0" || manifest.DeploymentName != "pulp" || manifest.Namespace != "pulp-prod" || manifest.PulpImage != "quay.io/pulp/pulp-minimal:stable" {
+		t.Errorf("unexpected manifest metadata: %+v", manifest)
+	}
+
+	expected := []BackupManifestFile{
+		{Path: "admin_secret.yaml", Size: 62, SHA256: "e9ed9e7c177af1465b9bae542a1bf8b957351603d3b6f470b89c53967e8a6718"},
+		{Path: "cr_object", Size: 3, SHA256: crChecksum},
+		{Path: "pulp.db", Size: 4, SHA256: dbChecksum},
+		{Path: "pulp/media/artifact/ab/cdef", Size: 0, SHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	}
+	if !reflect.DeepEqual(manifest.Files, expected) {
+		t.Errorf("expected %+v, got %+v", expected, manifest.Files)
+	}
+}
+
+// TestVerifyBackupScript verifies the comparison between the manifest and the files found in the backup
+func TestVerifyBackupScript(t *testing.T) {
+	tests := []struct {
+		name           string
+		modify         func(backupDir string) error
+		expectExitCode int
+		expectError    string
+	}{
+		{
+			name:   "all files match",
+			modify: func(string) error { return nil },
+		},
+		{
+			name: "extra files are ignored",
+			modify: func(backupDir string) error {
+				return os.WriteFile(filepath.Join(backupDir, "extra"), []byte("extra"), 0644)
+			},
+		},
+		{
+			name: "truncated database dump",
+			modify: func(backupDir string) error {
+				return os.WriteFile(filepath.Join(backupDir, "pulp.db"), []byte("te"), 0644)
+			},
+			expectExitCode: VerifyBackupFailed,
+			expectError:    "pulp.db size mismatch (expected 4 bytes, found 2)",
+		},
+		{
+			name: "modified file",
+			modify: func(backupDir string) error {
+				return os.WriteFile(filepath.Join(backupDir, "cr_object"), []byte("[]\n"), 0644)
+			},
+			expectExitCode: VerifyBackupFailed,
+			expectError:    "cr_object checksum mismatch",
+		},
+		{
+			name: "missing file",
+			modify: func(backupDir string) error {
+				return os.Remove(filepath.Join(backupDir, "pulp/media/artifact/ab/cdef"))
+			},
+			expectExitCode: VerifyBackupFailed,
+			expectError:    "pulp/media/artifact/ab/cdef not found",
+		},
+		{
+			name: "missing manifest and cr_object",
+			modify: func(backupDir string) error {
+				os.Remove(filepath.Join(backupDir, "cr_object"))
+				return os.Remove(filepath.Join(backupDir, BackupManifestFileName))
+			},
+			expectExitCode: VerifyBackupManifestNotFound,
+			expectError:    "manifest.json not found",
+		},
+		{
+			name: "invalid manifest",
+			modify: func(backupDir string) error {
+				return os.WriteFile(filepath.Join(backupDir, BackupManifestFileName), []byte("{}"), 0644)
+			},
+			expectExitCode: VerifyBackupInvalidManifest,
+			expectError:    "not a valid backup manifest",
+		},
+		{
+			name:           "missing backup directory",
+			modify:         os.RemoveAll,
+			expectExitCode: VerifyBackupDirNotFound,
+			expectError:    "not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backupDir := writeBackup(t)
+			if _, stderr, exitCode := runScript(t, BackupManifestScript(backupDir), "OPERATOR_VERSION=2.0.0", "DEPLOYMENT_NAME=pulp"); exitCode != 0 {
+				t.Fatalf("unexpected exit code %d: %v", exitCode, stderr)
+			}
+			if err := tt.modify(backupDir); err != nil {
+				t.Fatal(err)
+			}
+
+			output := filepath.Join(t.TempDir(), "backup-files")
+			stdout, stderr, exitCode := runScript(t, VerifyBackupScript(backupDir, output))
+			if exitCode != tt.expectExitCode {
+				t.Fatalf("expected exit code %d, got %d: %v%v", tt.expectExitCode, exitCode, stdout, stderr)
+			}
+			if exitCode != 0 {
+				if !strings.Contains(stdout+stderr, tt.expectError) {
+					t.Errorf("expected error containing %q, got %v%v", tt.expectError, stdout, stderr)
+				}
+				return
+			}
+
+			if strings.Contains(stdout, backupFileContentsHeader) {
+				t.Errorf("the content of the backup files should not be printed, got %v", stdout)
+			}
+			files, err := ParseBackupFileContents(readOutput(t, output))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(files["cr_object"]) != "{}\n" || len(files["admin_secret.yaml"]) == 0 {
+				t.Errorf("expected the content of the backed up resources, got %v", files)
+			}
+			if _, found := files["pulp.db"]; found {
+				t.Errorf("the database dump should not be in the output")
+			}
+			manifest := &BackupManifest{}
+			if err := json.Unmarshal(files[BackupManifestFileName], manifest); err != nil {
+				t.Fatalf("invalid manifest: %v\n%s", err, files[BackupManifestFileName])
+			}
+			if err := manifest.Validate(); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if len(manifest.Files) != len(requiredBackupFiles) {
+				t.Errorf("expected only the required files in the manifest, got %+v", manifest.Files)
+			}
+			if manifest.FileCount != 4 || manifest.TotalSize != 69 {
+				t.Errorf("expected 4 files (69 bytes), got %d files (%d bytes)", manifest.FileCount, manifest.TotalSize)
+			}
+			if manifest.DatabaseDumpSize != 4 {
+				t.Errorf("expected a 4 bytes database dump, got %d bytes", manifest.DatabaseDumpSize)
+			}
+		})
+	}
+}
+
+// TestVerifyLegacyBackup verifies that a backup made before the manifest was introduced is accepted
+// with a legacy manifest
+func TestVerifyLegacyBackup(t *testing.T) {
+	backupDir := writeBackup(t)
+	output := filepath.Join(t.TempDir(), "backup-files")
+	stdout, stderr, exitCode := runScript(t, VerifyBackupScript(backupDir, output))
+	if exitCode != 0 {
+		t.Fatalf("unexpected exit code %d: %v%v", exitCode, stdout, stderr)
+	}
+
+	files, err := ParseBackupFileContents(readOutput(t, output))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(files["cr_object"]) != "{}\n" || len(files["admin_secret.yaml"]) == 0 {
+		t.Errorf("expected the content of the backed up resources, got %v", files)
+	}
+	manifest := &BackupManifest{}
+	if err := json.Unmarshal(files[BackupManifestFileName], manifest); err != nil {
+		t.Fatalf("invalid manifest: %v\n%s", err, files[BackupManifestFileName])
+	}
+	if !manifest.IsLegacy() {
+		t.Errorf("expected a legacy manifest, got %+v", manifest)
+	}
+	if manifest.FileCount != 4 || manifest.TotalSize != 69 || manifest.DatabaseDumpSize != 4 {
+		t.Errorf("expected 4 files (69 bytes) and a 4 bytes database dump, got %+v", manifest)
+	}
+}
+
+// TestVerifyDirectoryDatabaseDump verifies a backup with a database dump in the directory format
+func TestVerifyDirectoryDatabaseDump(t *testing.T) {
+	backupDir := writeBackup(t)
+	if err := os.Remove(filepath.Join(backupDir, DatabaseDumpFile)); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"toc.dat": "test", "3456.dat.gz": "table data"} {
+		path := filepath.Join(backupDir, DatabaseDumpFile, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, stderr, exitCode := runScript(t, BackupManifestScript(backupDir), "OPERATOR_VERSION=2.0.0", "DEPLOYMENT_NAME=pulp", "DATABASE_DUMP_JOBS=4"); exitCode != 0 {
+		t.Fatalf("unexpected exit code %d: %v", exitCode, stderr)
+	}
+
+	output := filepath.Join(t.TempDir(), "backup-files")
+	stdout, stderr, exitCode := runScript(t, VerifyBackupScript(backupDir, output))
+	if exitCode != 0 {
+		t.Fatalf("unexpected exit code %d: %v%v", exitCode, stdout, stderr)
+	}
+	files, err := ParseBackupFileContents(readOutput(t, output))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	manifest := &BackupManifest{}
+	if err := json.Unmarshal(files[BackupManifestFileName], manifest); err != nil {
+		t.Fatalf("invalid manifest: %v\n%s", err, files[BackupManifestFileName])
+	}
+	if err := manifest.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	expected := []BackupManifestFile{
+		{Path: "cr_object", Size: 3, SHA256: crChecksum},
+		{Path: databaseDumpTOC, Size: 4, SHA256: dbChecksum},
+	}
+	if !reflect.DeepEqual(manifest.Files, expected) {
+		t.Errorf("expected %+v, got %+v", expected, manifest.Files)
+	}
+	if manifest.DatabaseDumpSize != 14 || manifest.DatabaseDumpJobs != 4 {
+		t.Errorf("expected a 14 bytes dump made with 4 jobs, got %d bytes and %d jobs", manifest.DatabaseDumpSize, manifest.DatabaseDumpJobs)
+	}
+}
+
+// TestBackupManifestValidate verifies the detection of incomplete manifests
+func TestBackupManifestValidate(t *testing.T) {
+	validManifest := func() *BackupManifest {
+		return &BackupManifest{
+			Version:         BackupManifestVersion,
+			OperatorVersion: "2.0.0",
+			CreatedAt:       "2026-01-05T02:00:00Z",
+			DeploymentName:  "pulp",
+			Files: []BackupManifestFile{
+				{Path: "pulp.db", Size: 4, SHA256: dbChecksum},
+				{Path: "cr_object", Size: 3, SHA256: crChecksum},
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		modify      func(*BackupManifest)
+		expectError string
+	}{
+		{
+			name:   "valid manifest",
+			modify: func(m *BackupManifest) {},
+		},
+		{
+			name:        "missing version",
+			modify:      func(m *BackupManifest) { m.Version = 0 },
+			expectError: "version not found",
+		},
+		{
+			name:        "newer version",
+			modify:      func(m *BackupManifest) { m.Version = BackupManifestVersion + 1 },
+			expectError: "not supported",
+		},
+		{
+			name:        "missing fields",
+			modify:      func(m *BackupManifest) { m.OperatorVersion = ""; m.DeploymentName = "" },
+			expectError: "missing fields: operator_version, deployment_name",
+		},
+		{
+			name:        "missing database dump",
+			modify:      func(m *BackupManifest) { m.Files = m.Files[1:] },
+			expectError: "pulp.db is not listed",
+		},
+		{
+			name: "database dump in the directory format",
+			modify: func(m *BackupManifest) {
+				m.Files[0].Path = databaseDumpTOC
+			},
+		},
+		{
+			name:        "file without checksum",
+			modify:      func(m *BackupManifest) { m.Files[0].SHA256 = "" },
+			expectError: "without path or sha256",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest := validManifest()
+			tt.modify(manifest)
+			err := manifest.Validate()
+			if len(tt.expectError) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+				t.Errorf("expected error containing %q, got %v", tt.expectError, err)
+			}
+		})
+	}
+}
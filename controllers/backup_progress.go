@@ -0,0 +1,149 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// states of a PhaseRecord
+	PhaseRunning   = "Running"
+	PhaseSucceeded = "Succeeded"
+	PhaseFailed    = "Failed"
+
+	// progressLine and totalLine precede the number of files and the size (in KiB) printed by ProgressScript
+	progressLine = "pulp-backup-progress"
+	totalLine    = "pulp-backup-total"
+
+	// progressInterval is how often (in seconds) the jobs print their progress
+	progressInterval = "30"
+)
+
+// progressRegexp matches the lines printed by ProgressScript (busybox wc pads the numbers)
+var progressRegexp = regexp.MustCompile(`^(` + progressLine + `|` + totalLine + `) files=\s*(\d+) kbytes=\s*(\d*)$`)
+
+// JobProgress is the progress printed by ProgressScript in the logs of a job
+type JobProgress struct {
+	// number of files and size (in bytes) of the directory written by the job
+	Files, Bytes int64
+
+	// number of files and size (in bytes) of the directory copied by the job (0 if unknown)
+	TotalFiles, TotalBytes int64
+}
+
+// ProgressScript returns the commands, to be prepended to a job script, that print the number of files
+// and the size of dest every 30 seconds and when the script exits (the loop is stopped on exit, so that
+// it does not keep the job running).
+// If source is not empty, its number of files and size are also printed (once, in background) as the
+// total to be copied.
+func ProgressScript(dest, source string) string {
+	script := `dir_stats() { echo "$1 files=$(find "$2" -type f 2>/dev/null | wc -l) kbytes=$(du -sk "$2" 2>/dev/null | cut -f1)"; }
+(while sleep ` + progressInterval + ` >/dev/null 2>&1; do dir_stats ` + progressLine + ` ` + dest + `; done) &
+PROGRESS_PID=$!
+trap 'kill $PROGRESS_PID 2>/dev/null; dir_stats ` + progressLine + ` ` + dest + `' EXIT
+`
+	if len(source) > 0 {
+		script += "dir_stats " + totalLine + " " + source + " &\n"
+	}
+	return script
+}
+
+// ParseJobProgress returns the last progress (and the total) printed by ProgressScript in the job logs,
+// or nil if none is found
+func ParseJobProgress(logs string) *JobProgress {
+	var progress *JobProgress
+	for _, line := range strings.Split(logs, "\n") {
+		match := progressRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		if progress == nil {
+			progress = &JobProgress{}
+		}
+		files, _ := strconv.ParseInt(match[2], 10, 64)
+		kbytes, _ := strconv.ParseInt(match[3], 10, 64)
+		if match[1] == totalLine {
+			progress.TotalFiles, progress.TotalBytes = files, kbytes*1024
+		} else {
+			progress.Files, progress.Bytes = files, kbytes*1024
+		}
+	}
+	return progress
+}
+
+// StripProgress removes the lines printed by ProgressScript from the output of a job
+func StripProgress(output string) string {
+	lines := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if !progressRegexp.MatchString(strings.TrimSpace(line)) {
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// StartPhaseRecord appends a Running record of the phase, unless the last record is already the
+// Running record of the phase (the phase is resumed). It returns true if the record was appended.
+func StartPhaseRecord(records *[]pulpv1.PhaseRecord, name, job string) bool {
+	if record := LastPhaseRecord(*records, name); record != nil && record.State == PhaseRunning {
+		return false
+	}
+	*records = append(*records, pulpv1.PhaseRecord{Name: name, State: PhaseRunning, StartTime: metav1.Now(), Job: job})
+	return true
+}
+
+// LastPhaseRecord returns the last record if it belongs to the phase, or nil
+func LastPhaseRecord(records []pulpv1.PhaseRecord, name string) *pulpv1.PhaseRecord {
+	if len(records) == 0 || records[len(records)-1].Name != name {
+		return nil
+	}
+	return &records[len(records)-1]
+}
+
+// FinishPhaseRecord sets the state, the completion time and, for a failed phase, the error output of
+// the last record of the phase
+func FinishPhaseRecord(records []pulpv1.PhaseRecord, name, state, lastError string) {
+	record := LastPhaseRecord(records, name)
+	if record == nil {
+		return
+	}
+	now := metav1.Now()
+	record.State, record.CompletionTime = state, &now
+	if len(lastError) > 0 {
+		record.LastError = lastError
+	}
+}
+
+// SetPhaseProgress updates the record with the progress of its job. It returns true if the record changed.
+func SetPhaseProgress(record *pulpv1.PhaseRecord, progress *JobProgress) bool {
+	if record == nil || progress == nil {
+		return false
+	}
+	changed := record.FilesProcessed != progress.Files || record.BytesProcessed != progress.Bytes
+	record.FilesProcessed, record.BytesProcessed = progress.Files, progress.Bytes
+	if progress.TotalFiles > 0 || progress.TotalBytes > 0 {
+		changed = changed || record.TotalFiles != progress.TotalFiles || record.TotalBytes != progress.TotalBytes
+		record.TotalFiles, record.TotalBytes = progress.TotalFiles, progress.TotalBytes
+	}
+	return changed
+}
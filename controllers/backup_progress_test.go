@@ -0,0 +1,118 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+)
+
+// TestProgressScript verifies the progress printed when the job script exits, even if it fails
+func TestProgressScript(t *testing.T) {
+	for _, tool := range []string{"bash", "du", "find"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%v not found", tool)
+		}
+	}
+	source := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.WriteFile(filepath.Join(source, name), make([]byte, 4096), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dest := filepath.Join(t.TempDir(), "pulp")
+
+	tests := []struct {
+		name        string
+		script      string
+		expectError bool
+		expectFiles int64
+	}{
+		{name: "finished", script: "set -e\nmkdir -p " + dest + "\ncp -a " + source + "/. " + dest, expectFiles: 3},
+		{name: "failed", script: "set -e\nmkdir -p " + dest + "\ncp " + source + "/a " + dest + "\nfalse\ncp -a " + source + "/. " + dest, expectError: true, expectFiles: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.RemoveAll(dest)
+			output, err := exec.Command("bash", "-c", ProgressScript(dest, source)+tt.script).Output()
+			if (err != nil) != tt.expectError {
+				t.Fatalf("expected error %v, got %v", tt.expectError, err)
+			}
+			progress := ParseJobProgress(string(output))
+			if progress == nil {
+				t.Fatalf("expected the progress in %q", output)
+			}
+			if progress.Files != tt.expectFiles || progress.Bytes == 0 {
+				t.Errorf("expected %d files, got %+v", tt.expectFiles, progress)
+			}
+		})
+	}
+}
+
+// TestParseJobProgress verifies the last progress and the total parsed from the job logs
+func TestParseJobProgress(t *testing.T) {
+	logs := "Linking files from /backups/openshift-backup-2026-01-01-000000/pulp\n" +
+		"pulp-backup-total files=      120 kbytes=2048\n" +
+		"pulp-backup-progress files=10 kbytes=100\n" +
+		"pulp-backup-progress files=      60 kbytes=1024\n" +
+		"cp: cannot stat '/var/lib/pulp/media': Permission denied\n"
+
+	expected := &JobProgress{Files: 60, Bytes: 1024 * 1024, TotalFiles: 120, TotalBytes: 2048 * 1024}
+	if progress := ParseJobProgress(logs); !reflect.DeepEqual(progress, expected) {
+		t.Errorf("expected %+v, got %+v", expected, progress)
+	}
+	if progress := ParseJobProgress("pg_dump: error: connection failed\n"); progress != nil {
+		t.Errorf("expected no progress, got %+v", progress)
+	}
+	if output := StripProgress(logs); output != "Linking files from /backups/openshift-backup-2026-01-01-000000/pulp\ncp: cannot stat '/var/lib/pulp/media': Permission denied" {
+		t.Errorf("expected the progress lines to be removed, got %q", output)
+	}
+}
+
+// TestPhaseRecords verifies that a resumed phase keeps its record and that the records are finished
+// with the progress of the job
+func TestPhaseRecords(t *testing.T) {
+	records := []pulpv1.PhaseRecord{}
+	if !StartPhaseRecord(&records, "BackupDB", "") {
+		t.Fatal("expected the record to be added")
+	}
+	FinishPhaseRecord(records, "BackupDB", PhaseSucceeded, "")
+	if !StartPhaseRecord(&records, "BackupDir", "") || StartPhaseRecord(&records, "BackupDir", "") {
+		t.Fatal("expected a single record of the resumed phase")
+	}
+	if len(records) != 2 || records[0].State != PhaseSucceeded || records[0].CompletionTime == nil || records[1].State != PhaseRunning {
+		t.Fatalf("unexpected records %+v", records)
+	}
+
+	record := LastPhaseRecord(records, "BackupDir")
+	if !SetPhaseProgress(record, &JobProgress{Files: 10, Bytes: 1024}) || SetPhaseProgress(record, &JobProgress{Files: 10, Bytes: 1024}) {
+		t.Errorf("expected the record to change only with a new progress")
+	}
+	FinishPhaseRecord(records, "BackupDir", PhaseFailed, "job pulp-backup-dir failed: cp: No space left on device")
+	if records[1].State != PhaseFailed || records[1].FilesProcessed != 10 || records[1].LastError != "job pulp-backup-dir failed: cp: No space left on device" {
+		t.Errorf("unexpected record %+v", records[1])
+	}
+	if LastPhaseRecord(records, "BackupDB") != nil {
+		t.Errorf("expected only the last record to be returned")
+	}
+}
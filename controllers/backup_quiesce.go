@@ -0,0 +1,146 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// QuiescedByAnnotation is set in Pulp CR with the PulpBackup or PulpRestore (<kind>/<name>) that scaled
+	// down its components, so that only one of them at a time stores and restores their replicas
+	QuiescedByAnnotation = "repo-manager.pulpproject.org/quiesced-by"
+
+	// QuiescedComponentsAnnotation is set in Pulp CR, together with QuiescedByAnnotation, with the original
+	// replicas and HPA settings of the quiesced components, so that they can be restored by the backup
+	// replacing the annotation of a removed PulpBackup or PulpRestore
+	QuiescedComponentsAnnotation = "repo-manager.pulpproject.org/quiesced-components"
+)
+
+// QuiescedByRemovedError is returned by LockPulp when Pulp was quiesced by a PulpBackup or PulpRestore that
+// is not found anymore and the original settings of its components are unknown
+type QuiescedByRemovedError struct {
+	Holder string
+}
+
+func (e *QuiescedByRemovedError) Error() string {
+	return "Pulp was scaled down by " + e.Holder + ", which is not found anymore, and its original replicas are unknown. " +
+		"Restore the replicas of Pulp components and remove the " + QuiescedByAnnotation + " annotation from Pulp CR."
+}
+
+// QuiesceLockHolder returns the value of the QuiescedByAnnotation set by the PulpBackup or PulpRestore
+// named name
+func QuiesceLockHolder(kind, name string) string {
+	return kind + "/" + name
+}
+
+// LockPulp sets the QuiescedByAnnotation (with holder) and the QuiescedComponentsAnnotation in Pulp CR and
+// returns false while another PulpBackup or PulpRestore holds it.
+// The annotation of a PulpBackup or PulpRestore that is not found anymore is replaced, keeping the original
+// settings of the components it scaled down. A QuiescedByRemovedError is returned if they are not found.
+func LockPulp(ctx context.Context, r client.Client, log logr.Logger, holder string, pulp *pulpv1.Pulp, components []settings.PulpcoreType) (bool, error) {
+	current := pulp.Annotations[QuiescedByAnnotation]
+	if current == holder {
+		return true, nil
+	}
+
+	if len(current) > 0 {
+		kind, name, _ := strings.Cut(current, "/")
+		var obj client.Object = &pulpv1.PulpBackup{}
+		if kind == "PulpRestore" {
+			obj = &pulpv1.PulpRestore{}
+		}
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: pulp.Namespace}, obj)
+		if err == nil {
+			log.Info("Waiting for " + current + " to restore the replicas of Pulp components ...")
+			return false, nil
+		} else if !errors.IsNotFound(err) {
+			return false, err
+		}
+		// the components are scaled down, their replicas in Pulp CR are not the original ones
+		if _, found := pulp.Annotations[QuiescedComponentsAnnotation]; !found {
+			return false, &QuiescedByRemovedError{current}
+		}
+		log.Info(current + " not found, replacing the " + QuiescedByAnnotation + " annotation")
+	} else {
+		delete(pulp.Annotations, QuiescedComponentsAnnotation)
+	}
+
+	original, err := OriginalComponents(pulp, components)
+	if err != nil {
+		return false, err
+	}
+	originalJson, err := json.Marshal(original)
+	if err != nil {
+		return false, err
+	}
+
+	if pulp.Annotations == nil {
+		pulp.Annotations = map[string]string{}
+	}
+	pulp.Annotations[QuiescedByAnnotation] = holder
+	pulp.Annotations[QuiescedComponentsAnnotation] = string(originalJson)
+	// the update fails with a conflict if another backup or restore modified Pulp CR in the meantime
+	if err := r.Update(ctx, pulp); err != nil {
+		log.Error(err, "Failed to set the "+QuiescedByAnnotation+" annotation")
+		return false, err
+	}
+	return true, nil
+}
+
+// UnlockPulp removes the annotations set by LockPulp from Pulp CR (which is not updated)
+func UnlockPulp(pulp *pulpv1.Pulp) {
+	delete(pulp.Annotations, QuiescedByAnnotation)
+	delete(pulp.Annotations, QuiescedComponentsAnnotation)
+}
+
+// OriginalComponents returns the settings of components before they were scaled down: the ones stored in
+// the QuiescedComponentsAnnotation or, for the components not found in it, the ones of Pulp CR
+func OriginalComponents(pulp *pulpv1.Pulp, components []settings.PulpcoreType) ([]pulpv1.QuiescedComponent, error) {
+	original := []pulpv1.QuiescedComponent{}
+	if value, found := pulp.Annotations[QuiescedComponentsAnnotation]; found {
+		if err := json.Unmarshal([]byte(value), &original); err != nil {
+			return nil, err
+		}
+	}
+	for _, component := range components {
+		if !quiesced(original, string(component)) {
+			replicas, hpa := ComponentScale(pulp, component)
+			original = append(original, pulpv1.QuiescedComponent{Name: string(component), Replicas: *replicas, HPA: (*hpa).DeepCopy()})
+		}
+	}
+	return original, nil
+}
+
+// quiesced returns true if the component named name is found in components
+func quiesced(components []pulpv1.QuiescedComponent, name string) bool {
+	for _, component := range components {
+		if component.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_backup_schedule
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/robfig/cron/v3"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// RepoManagerBackupScheduleReconciler reconciles a PulpBackupSchedule object
+type RepoManagerBackupScheduleReconciler struct {
+	client.Client
+	RawLogger  logr.Logger
+	RESTClient rest.Interface
+	Scheme     *runtime.Scheme
+
+	// WatchNamespace is the namespace watched by the operator (empty for all the namespaces)
+	WatchNamespace string
+}
+
+//+kubebuilder:rbac:groups=repo-manager.pulpproject.org,namespace=pulp-operator-system,resources=pulpbackupschedules,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=repo-manager.pulpproject.org,namespace=pulp-operator-system,resources=pulpbackupschedules/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=repo-manager.pulpproject.org,namespace=pulp-operator-system,resources=pulpbackupschedules/finalizers,verbs=update
+//+kubebuilder:rbac:groups=repo-manager.pulpproject.org,namespace=pulp-operator-system,resources=pulpbackups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,namespace=pulp-operator-system,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=repo-manager.pulpproject.org,namespace=pulp-operator-system,resources=pulprestores;pulps,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=core,namespace=pulp-operator-system,resources=secrets,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=core,namespace=pulp-operator-system,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,namespace=pulp-operator-system,resources=pods/log,verbs=get
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+func (r *RepoManagerBackupScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.RawLogger
+
+	backupSchedule := &pulpv1.PulpBackupSchedule{}
+	err := r.Get(ctx, req.NamespacedName, backupSchedule)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Return and don't requeue
+			log.Info("PulpBackupSchedule resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get PulpBackupSchedule")
+		return ctrl.Result{}, err
+	}
+
+	if err := checkRequiredFields(backupSchedule); err != nil {
+		log.Error(err, "Required field not filled in backup schedule CR!")
+		r.updateStatus(ctx, backupSchedule, metav1.ConditionFalse, "BackupScheduled", err.Error(), "MissingRequiredField")
+		return ctrl.Result{}, nil
+	}
+
+	sched, err := cron.ParseStandard(backupSchedule.Spec.Schedule)
+	if err != nil {
+		log.Error(err, "Failed to parse schedule", "Schedule", backupSchedule.Spec.Schedule)
+		r.updateStatus(ctx, backupSchedule, metav1.ConditionFalse, "BackupScheduled", "Invalid schedule "+backupSchedule.Spec.Schedule+": "+err.Error(), "InvalidSchedule")
+		return ctrl.Result{}, nil
+	}
+
+	backups, err := r.listScheduledBackups(ctx, backupSchedule)
+	if err != nil {
+		log.Error(err, "Failed to list the PulpBackups created by the schedule")
+		return ctrl.Result{}, err
+	}
+
+	// retention rules are applied even if the schedule is suspended
+	// so that the backup PVC does not keep growing
+	if err := r.pruneBackups(ctx, backupSchedule, backups); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// a running restore verification drill is followed even if the schedule is suspended
+	now := time.Now()
+	verifyRequeue, err := r.verifyBackups(ctx, backupSchedule, backups, now)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if backupSchedule.Spec.Suspend {
+		r.updateStatus(ctx, backupSchedule, metav1.ConditionFalse, "BackupScheduled", "Backup schedule is suspended", "ScheduleSuspended")
+		return ctrl.Result{RequeueAfter: verifyRequeue}, nil
+	}
+
+	scheduledTime := mostRecentScheduleTime(sched, lastScheduleTime(backupSchedule), now)
+	if !scheduledTime.IsZero() {
+		if running := runningBackup(backups); running != "" {
+			log.Info("Skipping scheduled backup because a previous one is still running", "PulpBackup", running)
+			r.updateStatus(ctx, backupSchedule, metav1.ConditionFalse, "BackupScheduled", "Waiting for PulpBackup "+running+" to finish", "PreviousBackupRunning")
+			return ctrl.Result{RequeueAfter: time.Minute}, nil
+		}
+
+		if err := r.createScheduledBackup(ctx, backupSchedule, scheduledTime); err != nil {
+			r.updateStatus(ctx, backupSchedule, metav1.ConditionFalse, "BackupScheduled", "Failed to create PulpBackup!", "FailedCreatingBackup")
+			return ctrl.Result{}, err
+		}
+	}
+
+	next := sched.Next(now)
+	if next.IsZero() {
+		r.updateStatus(ctx, backupSchedule, metav1.ConditionFalse, "BackupScheduled", "No future execution found for schedule "+backupSchedule.Spec.Schedule, "InvalidSchedule")
+		return ctrl.Result{}, nil
+	}
+	r.updateStatus(ctx, backupSchedule, metav1.ConditionTrue, "BackupScheduled", "Next backup scheduled to "+next.Format(time.RFC3339), "BackupScheduled")
+
+	// the small delay is to make sure that, when the request is processed,
+	// the schedule time has already been reached
+	requeue := next.Sub(now) + time.Second
+	if verifyRequeue > 0 && verifyRequeue < requeue {
+		requeue = verifyRequeue
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+// createScheduledBackup creates a new PulpBackup CR based on the PulpBackupSchedule spec
+func (r *RepoManagerBackupScheduleReconciler) createScheduledBackup(ctx context.Context, backupSchedule *pulpv1.PulpBackupSchedule, scheduledTime time.Time) error {
+	log := r.RawLogger
+
+	pulpBackup := backupForSchedule(backupSchedule, scheduledTime)
+	ctrl.SetControllerReference(backupSchedule, pulpBackup, r.Scheme)
+	log.Info("Creating a new scheduled PulpBackup", "PulpBackup.Namespace", pulpBackup.Namespace, "PulpBackup.Name", pulpBackup.Name)
+	if err := r.Create(ctx, pulpBackup); err != nil && !errors.IsAlreadyExists(err) {
+		log.Error(err, "Failed to create scheduled PulpBackup", "PulpBackup.Namespace", pulpBackup.Namespace, "PulpBackup.Name", pulpBackup.Name)
+		return err
+	}
+
+	backupSchedule.Status.LastScheduleTime = &metav1.Time{Time: scheduledTime}
+	backupSchedule.Status.LastBackup = pulpBackup.Name
+	backupSchedule.Status.BackupClaim = getBackupPVC(backupSchedule)
+	if err := r.Status().Update(ctx, backupSchedule); err != nil {
+		log.Error(err, "Failed to update PulpBackupSchedule status")
+		return err
+	}
+	return nil
+}
+
+// listScheduledBackups returns the PulpBackups created by the backupSchedule
+func (r *RepoManagerBackupScheduleReconciler) listScheduledBackups(ctx context.Context, backupSchedule *pulpv1.PulpBackupSchedule) ([]pulpv1.PulpBackup, error) {
+	backupList := &pulpv1.PulpBackupList{}
+	listOpts := []client.ListOption{
+		client.InNamespace(backupSchedule.Namespace),
+		client.MatchingLabels(scheduleLabels(backupSchedule)),
+	}
+	if err := r.List(ctx, backupList, listOpts...); err != nil {
+		return nil, err
+	}
+	return backupList.Items, nil
+}
+
+// updateStatus modifies a .status.condition from pulpbackupschedule CR
+func (r *RepoManagerBackupScheduleReconciler) updateStatus(ctx context.Context, backupSchedule *pulpv1.PulpBackupSchedule, conditionStatus metav1.ConditionStatus, conditionType, conditionMessage, conditionReason string) {
+	v1.SetStatusCondition(&backupSchedule.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		Reason:             conditionReason,
+		LastTransitionTime: metav1.Now(),
+		Message:            conditionMessage,
+	})
+	r.Status().Update(ctx, backupSchedule)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RepoManagerBackupScheduleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&pulpv1.PulpBackupSchedule{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// status updates from PulpBackups are needed to apply the retention rules
+		Owns(&pulpv1.PulpBackup{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
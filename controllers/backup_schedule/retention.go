@@ -0,0 +1,108 @@
+package repo_manager_backup_schedule
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// deletionPolicyDelete is the deletion_policy of the pruned backups, so that their data is removed with
+// the PulpBackup CRs
+const deletionPolicyDelete = "Delete"
+
+// backupsToPrune returns the PulpBackups that do not match any of the retention rules.
+// Only the backups that finished successfully are considered, so a running or failed
+// backup is never pruned and is not counted by the rules (neither is a backup being removed).
+func backupsToPrune(backups []pulpv1.PulpBackup, retention *pulpv1.BackupRetention) []pulpv1.PulpBackup {
+	if retention == nil || (retention.KeepLast == 0 && retention.KeepDaily == 0 && retention.KeepWeekly == 0) {
+		return nil
+	}
+
+	completed := []pulpv1.PulpBackup{}
+	for _, pulpBackup := range backups {
+		if backupSucceeded(pulpBackup) && pulpBackup.DeletionTimestamp.IsZero() {
+			completed = append(completed, pulpBackup)
+		}
+	}
+
+	// newest first
+	sort.SliceStable(completed, func(i, j int) bool {
+		return completed[j].CreationTimestamp.Before(&completed[i].CreationTimestamp)
+	})
+
+	keep := map[string]bool{}
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	for i, pulpBackup := range completed {
+		if i < retention.KeepLast {
+			keep[pulpBackup.Name] = true
+		}
+
+		created := pulpBackup.CreationTimestamp.UTC()
+		day := created.Format("2006-01-02")
+		if !days[day] && len(days) < retention.KeepDaily {
+			days[day] = true
+			keep[pulpBackup.Name] = true
+		}
+
+		year, week := created.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%d", year, week)
+		if !weeks[weekKey] && len(weeks) < retention.KeepWeekly {
+			weeks[weekKey] = true
+			keep[pulpBackup.Name] = true
+		}
+	}
+
+	prune := []pulpv1.PulpBackup{}
+	for _, pulpBackup := range completed {
+		if !keep[pulpBackup.Name] {
+			prune = append(prune, pulpBackup)
+		}
+	}
+	return prune
+}
+
+// pruneBackups removes the backups that do not match the retention rules.
+// The data of the pruned backups (the directory in the backup PVC, the copy uploaded to object storage
+// and the VolumeSnapshots) is removed by the PulpBackup finalizer: their deletion_policy is set to
+// Delete before the PulpBackup CRs are deleted.
+func (r *RepoManagerBackupScheduleReconciler) pruneBackups(ctx context.Context, backupSchedule *pulpv1.PulpBackupSchedule, backups []pulpv1.PulpBackup) error {
+	log := r.RawLogger
+
+	pruned := []string{}
+	for _, pulpBackup := range backupsToPrune(backups, backupSchedule.Spec.Retention) {
+		// make sure that we will never remove anything outside of the backup mount point
+		backupDir := filepath.Clean(pulpBackup.Status.BackupDirectory)
+		if !strings.HasPrefix(backupDir, controllers.BackupMountPath+"/") {
+			log.Info("Skipping the prune of a backup outside of the backup PVC", "PulpBackup.Name", pulpBackup.Name, "BackupDirectory", pulpBackup.Status.BackupDirectory)
+			continue
+		}
+
+		if pulpBackup.Spec.DeletionPolicy != deletionPolicyDelete {
+			pulpBackup.Spec.DeletionPolicy = deletionPolicyDelete
+			if err := r.Update(ctx, &pulpBackup); err != nil {
+				log.Error(err, "Failed to set the deletion_policy of pruned PulpBackup", "PulpBackup.Name", pulpBackup.Name)
+				return err
+			}
+		}
+		log.Info("Removing pruned PulpBackup", "PulpBackup.Namespace", pulpBackup.Namespace, "PulpBackup.Name", pulpBackup.Name)
+		if err := r.Delete(ctx, &pulpBackup); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to remove pruned PulpBackup", "PulpBackup.Name", pulpBackup.Name)
+			r.updateStatus(ctx, backupSchedule, metav1.ConditionFalse, "BackupsPruned", "Failed to remove PulpBackup "+pulpBackup.Name+"!", "FailedPruningBackups")
+			return err
+		}
+		pruned = append(pruned, pulpBackup.Name)
+	}
+
+	if len(pruned) > 0 {
+		r.updateStatus(ctx, backupSchedule, metav1.ConditionTrue, "BackupsPruned", "Removed "+strings.Join(pruned, ","), "BackupsPruned")
+	}
+	return nil
+}
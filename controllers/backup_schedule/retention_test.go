@@ -0,0 +1,192 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_backup_schedule
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/robfig/cron/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// completedBackup returns a PulpBackup that finished successfully at created
+func completedBackup(name string, created time.Time) pulpv1.PulpBackup {
+	return pulpv1.PulpBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.Time{Time: created}},
+		Status: pulpv1.PulpBackupStatus{
+			BackupDirectory: "/backups/openshift-backup-" + created.Format("2006-01-02-150405"),
+			Conditions: []metav1.Condition{{
+				Type:   "BackupComplete",
+				Status: metav1.ConditionTrue,
+				Reason: "BackupTasksFinished",
+			}},
+		},
+	}
+}
+
+func names(backups []pulpv1.PulpBackup) []string {
+	n := []string{}
+	for _, b := range backups {
+		n = append(n, b.Name)
+	}
+	sort.Strings(n)
+	return n
+}
+
+// TestBackupsToPrune verifies the keep-last, keep-daily and keep-weekly rules
+func TestBackupsToPrune(t *testing.T) {
+	// 2026-01-05 is a Monday
+	base := time.Date(2026, 1, 5, 2, 0, 0, 0, time.UTC)
+
+	// two backups a day during 15 days
+	backups := []pulpv1.PulpBackup{}
+	for day := 0; day < 15; day++ {
+		for _, hour := range []int{0, 12} {
+			created := base.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour)
+			backups = append(backups, completedBackup(created.Format("d02-h15"), created))
+		}
+	}
+
+	running := pulpv1.PulpBackup{ObjectMeta: metav1.ObjectMeta{Name: "running", CreationTimestamp: metav1.Time{Time: base}}}
+	backups = append(backups, running)
+
+	tests := []struct {
+		name      string
+		retention *pulpv1.BackupRetention
+		kept      int
+		mustKeep  []string
+	}{
+		{
+			name:      "no retention keeps everything",
+			retention: nil,
+			kept:      31,
+		},
+		{
+			name:      "empty retention keeps everything",
+			retention: &pulpv1.BackupRetention{},
+			kept:      31,
+		},
+		{
+			name:      "keep last",
+			retention: &pulpv1.BackupRetention{KeepLast: 3},
+			kept:      3 + 1,
+			mustKeep:  []string{"d19-h14", "d19-h02", "d18-h14"},
+		},
+		{
+			name:      "keep daily keeps the most recent backup of each day",
+			retention: &pulpv1.BackupRetention{KeepDaily: 2},
+			kept:      2 + 1,
+			mustKeep:  []string{"d19-h14", "d18-h14"},
+		},
+		{
+			name:      "keep weekly keeps the most recent backup of each week",
+			retention: &pulpv1.BackupRetention{KeepWeekly: 3},
+			kept:      3 + 1,
+			mustKeep:  []string{"d19-h14", "d18-h14", "d11-h14"},
+		},
+		{
+			name:      "rules are combined",
+			retention: &pulpv1.BackupRetention{KeepLast: 1, KeepDaily: 3, KeepWeekly: 3},
+			kept:      4 + 1,
+			mustKeep:  []string{"d19-h14", "d18-h14", "d17-h14", "d11-h14"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prune := backupsToPrune(backups, tt.retention)
+			if kept := len(backups) - len(prune); kept != tt.kept {
+				t.Errorf("expected %d backups to be kept, got %d (pruned: %v)", tt.kept, kept, names(prune))
+			}
+			for _, b := range prune {
+				if b.Name == "running" {
+					t.Errorf("a running backup should never be pruned")
+				}
+				for _, k := range tt.mustKeep {
+					if b.Name == k {
+						t.Errorf("expected %s to be kept", k)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestPruneBackups verifies that the pruned PulpBackups are deleted with deletion_policy Delete, so that
+// their finalizer removes all their data, and that only the backups in the backup PVC are pruned
+func TestPruneBackups(t *testing.T) {
+	now := time.Now()
+	backupSchedule := verifySchedule(nil)
+	backupSchedule.Spec.Retention = &pulpv1.BackupRetention{KeepLast: 1}
+	backups := []pulpv1.PulpBackup{completedBackup("latest", now), completedBackup("old", now.Add(-time.Hour)), completedBackup("outside", now.Add(-2*time.Hour))}
+	backups[2].Status.BackupDirectory = "/backups/../var/lib/pulp"
+	objects := []client.Object{backupSchedule}
+	for i := range backups {
+		backups[i].Namespace = backupSchedule.Namespace
+		backups[i].Finalizers = []string{"repo-manager.pulpproject.org/backup-cleanup"}
+		backups[i].Spec.DeletionPolicy = "Retain"
+		objects = append(objects, &backups[i])
+	}
+	r := newVerifyReconciler(objects...)
+	ctx := context.TODO()
+
+	if err := r.pruneBackups(ctx, backupSchedule, backups); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name         string
+		expectPruned bool
+	}{{"latest", false}, {"old", true}, {"outside", false}} {
+		pulpBackup := &pulpv1.PulpBackup{}
+		if err := r.Get(ctx, types.NamespacedName{Name: tt.name, Namespace: backupSchedule.Namespace}, pulpBackup); err != nil {
+			t.Fatal(err)
+		}
+		if pruned := !pulpBackup.DeletionTimestamp.IsZero(); pruned != tt.expectPruned {
+			t.Errorf("expected PulpBackup %v pruned %v, got %v", tt.name, tt.expectPruned, pruned)
+		}
+		if tt.expectPruned && pulpBackup.Spec.DeletionPolicy != deletionPolicyDelete {
+			t.Errorf("expected deletion_policy %v for the pruned PulpBackup %v, got %v", deletionPolicyDelete, tt.name, pulpBackup.Spec.DeletionPolicy)
+		}
+	}
+}
+
+// TestMostRecentScheduleTime verifies the detection of missed schedules
+func TestMostRecentScheduleTime(t *testing.T) {
+	sched, err := cron.ParseStandard("0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := time.Date(2026, 1, 5, 2, 0, 0, 0, time.UTC)
+
+	if got := mostRecentScheduleTime(sched, last, last.Add(23*time.Hour)); !got.IsZero() {
+		t.Errorf("expected no missed schedule, got %v", got)
+	}
+
+	// operator was down for three days, only the most recent execution should run
+	now := last.AddDate(0, 0, 3).Add(time.Hour)
+	expected := last.AddDate(0, 0, 3)
+	if got := mostRecentScheduleTime(sched, last, now); !got.Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
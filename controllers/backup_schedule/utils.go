@@ -0,0 +1,104 @@
+package repo_manager_backup_schedule
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/robfig/cron/v3"
+	v1 "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ScheduleLabel is the label added to the PulpBackups created by a PulpBackupSchedule
+const ScheduleLabel = "repo-manager.pulpproject.org/backup-schedule"
+
+// checkRequiredFields will verify if all required fields are provided
+func checkRequiredFields(backupSchedule *pulpv1.PulpBackupSchedule) error {
+	if len(backupSchedule.Spec.BackupTemplate.DeploymentName) == 0 {
+		return errors.New("error! backup_template.deployment_name not provided")
+	}
+	if len(backupSchedule.Spec.Schedule) == 0 {
+		return errors.New("error! schedule not provided")
+	}
+	return nil
+}
+
+// getBackupPVC returns the backup_template.backup_pvc if provided, if not will return the default one based
+// on the PulpBackupSchedule name
+func getBackupPVC(backupSchedule *pulpv1.PulpBackupSchedule) string {
+	backupPVC := backupSchedule.Spec.BackupTemplate.BackupPVC
+	if len(backupPVC) == 0 {
+		backupPVC = backupSchedule.Name + "-backup-claim"
+	}
+	return backupPVC
+}
+
+// scheduleLabels returns the labels used to identify the PulpBackups created by backupSchedule
+func scheduleLabels(backupSchedule *pulpv1.PulpBackupSchedule) map[string]string {
+	return map[string]string{
+		ScheduleLabel:                  backupSchedule.Name,
+		"app.kubernetes.io/part-of":    "pulp",
+		"app.kubernetes.io/managed-by": "pulp-operator",
+	}
+}
+
+// backupForSchedule returns the PulpBackup that should be created for the scheduledTime.
+// The name is derived from the scheduled time so that a missed reconciliation does not
+// create the same backup twice.
+func backupForSchedule(backupSchedule *pulpv1.PulpBackupSchedule, scheduledTime time.Time) *pulpv1.PulpBackup {
+	spec := *backupSchedule.Spec.BackupTemplate.DeepCopy()
+	spec.BackupPVC = getBackupPVC(backupSchedule)
+	return &pulpv1.PulpBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%d", backupSchedule.Name, scheduledTime.Unix()/60),
+			Namespace: backupSchedule.Namespace,
+			Labels:    scheduleLabels(backupSchedule),
+		},
+		Spec: spec,
+	}
+}
+
+// lastScheduleTime returns the last time a backup was scheduled or, if no backup has been
+// scheduled yet, the time the PulpBackupSchedule was created
+func lastScheduleTime(backupSchedule *pulpv1.PulpBackupSchedule) time.Time {
+	if backupSchedule.Status.LastScheduleTime != nil {
+		return backupSchedule.Status.LastScheduleTime.Time
+	}
+	return backupSchedule.CreationTimestamp.Time
+}
+
+// mostRecentScheduleTime returns the most recent time, after last and not after now,
+// in which a backup should have been started. If there is no missed execution it returns
+// a zero time.
+func mostRecentScheduleTime(sched cron.Schedule, last, now time.Time) time.Time {
+	mostRecent := time.Time{}
+	for t := sched.Next(last); !t.IsZero() && !t.After(now); t = sched.Next(t) {
+		mostRecent = t
+	}
+	return mostRecent
+}
+
+// backupFailed returns true if the PulpBackup finished with an error
+func backupFailed(pulpBackup pulpv1.PulpBackup) bool {
+	condition := v1.FindStatusCondition(pulpBackup.Status.Conditions, "BackupComplete")
+	return condition != nil && condition.Status == metav1.ConditionFalse && strings.HasPrefix(condition.Reason, "Failed")
+}
+
+// backupSucceeded returns true if all the backup tasks finished
+func backupSucceeded(pulpBackup pulpv1.PulpBackup) bool {
+	return v1.IsStatusConditionTrue(pulpBackup.Status.Conditions, "BackupComplete") && len(pulpBackup.Status.BackupDirectory) > 0
+}
+
+// runningBackup returns the name of a PulpBackup that did not finish yet.
+// A PulpBackup being deleted is stopped, so it does not count as running.
+func runningBackup(backups []pulpv1.PulpBackup) string {
+	for _, pulpBackup := range backups {
+		if pulpBackup.DeletionTimestamp.IsZero() && !backupSucceeded(pulpBackup) && !backupFailed(pulpBackup) {
+			return pulpBackup.Name
+		}
+	}
+	return ""
+}
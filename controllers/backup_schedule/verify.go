@@ -0,0 +1,439 @@
+package repo_manager_backup_schedule
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"strings"
+	"time"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	"github.com/robfig/cron/v3"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ScheduleNamespaceLabel is the label, with the namespace of the PulpBackupSchedule, added to the
+	// namespaces created for the restore verification drills
+	ScheduleNamespaceLabel = "repo-manager.pulpproject.org/backup-schedule-namespace"
+
+	verifyNamespaceSuffix = "-verify"
+	verifyRestoreSuffix   = "-verify"
+	smokeCheckJobSuffix   = "-verify-smoke-check"
+
+	// restorePhaseCompleted is the phase of a PulpRestore that finished successfully
+	restorePhaseCompleted = "Completed"
+
+	defaultVerifyTimeout = 120
+
+	// maxVerificationResults is the number of drill results kept in the status
+	maxVerificationResults = 10
+
+	// verifyRequeueInterval is how long to wait before checking a running drill again
+	verifyRequeueInterval = 30 * time.Second
+)
+
+// smokeCheckScript requests the status endpoint of the restored Pulp API (from $STATUS_URL) until the
+// database is connected and a worker is online, then prints the versions of the Pulp components
+const smokeCheckScript = `import json, os, sys, time, urllib.request
+error = ""
+for attempt in range(20):
+    try:
+        with urllib.request.urlopen(os.environ["STATUS_URL"], timeout=30) as response:
+            status = json.load(response)
+        if not status.get("database_connection", {}).get("connected"):
+            error = "the database is not connected"
+        elif not status.get("online_workers"):
+            error = "no online workers"
+        else:
+            print(", ".join(v["component"] + " " + v["version"] for v in status.get("versions", [])))
+            sys.exit(0)
+    except Exception as e:
+        error = str(e)
+    time.sleep(15)
+sys.exit(os.environ["STATUS_URL"] + ": " + error)
+`
+
+// verifyNamespace returns the namespace where the backups are restored by the drills
+func verifyNamespace(backupSchedule *pulpv1.PulpBackupSchedule) string {
+	if len(backupSchedule.Spec.Verify.Namespace) > 0 {
+		return backupSchedule.Spec.Verify.Namespace
+	}
+	return backupSchedule.Name + verifyNamespaceSuffix
+}
+
+// verifyLabels returns the labels of the namespaces created for the drills of backupSchedule
+func verifyLabels(backupSchedule *pulpv1.PulpBackupSchedule) map[string]string {
+	labels := scheduleLabels(backupSchedule)
+	labels[ScheduleNamespaceLabel] = backupSchedule.Namespace
+	return labels
+}
+
+// ownsNamespace returns true if the namespace was created for the drills of backupSchedule
+func ownsNamespace(backupSchedule *pulpv1.PulpBackupSchedule, namespace *corev1.Namespace) bool {
+	return namespace.Labels[ScheduleLabel] == backupSchedule.Name && namespace.Labels[ScheduleNamespaceLabel] == backupSchedule.Namespace
+}
+
+// lastVerificationTime returns the last time a drill was started or, if no drill has been started yet,
+// the time the PulpBackupSchedule was created
+func lastVerificationTime(backupSchedule *pulpv1.PulpBackupSchedule) time.Time {
+	if backupSchedule.Status.LastVerificationTime != nil {
+		return backupSchedule.Status.LastVerificationTime.Time
+	}
+	return backupSchedule.CreationTimestamp.Time
+}
+
+// runningVerification returns the result of the drill that did not finish yet, or nil
+func runningVerification(backupSchedule *pulpv1.PulpBackupSchedule) *pulpv1.BackupVerificationResult {
+	results := backupSchedule.Status.VerificationResults
+	if len(results) > 0 && results[len(results)-1].State == controllers.PhaseRunning {
+		return &results[len(results)-1]
+	}
+	return nil
+}
+
+// latestBackup returns the most recent successful backup, or nil if there is none
+func latestBackup(backups []pulpv1.PulpBackup) *pulpv1.PulpBackup {
+	var latest *pulpv1.PulpBackup
+	for i, pulpBackup := range backups {
+		if !pulpBackup.DeletionTimestamp.IsZero() || !backupSucceeded(pulpBackup) {
+			continue
+		}
+		if latest == nil || latest.CreationTimestamp.Before(&pulpBackup.CreationTimestamp) {
+			latest = &backups[i]
+		}
+	}
+	return latest
+}
+
+// verifyBackups runs the restore verification drills defined in verify. A drill is started when it is
+// due, unless the schedule is suspended, and is followed in the next reconciliations.
+// It returns how long to wait before the schedule should be reconciled again (0 if there is no drill).
+// The drills need the restore-drill-role ClusterRole (config/rbac/restore_drill_role.yaml), which is not
+// bound to the operator by default, and an operator watching all the namespaces.
+func (r *RepoManagerBackupScheduleReconciler) verifyBackups(ctx context.Context, backupSchedule *pulpv1.PulpBackupSchedule, backups []pulpv1.PulpBackup, now time.Time) (time.Duration, error) {
+	log := r.RawLogger
+	verify := backupSchedule.Spec.Verify
+	if verify == nil {
+		return 0, nil
+	}
+	if result := runningVerification(backupSchedule); result != nil {
+		return r.runVerification(ctx, backupSchedule, result, now)
+	}
+
+	sched, err := cron.ParseStandard(verify.Schedule)
+	if err != nil {
+		log.Error(err, "Failed to parse verify schedule", "Schedule", verify.Schedule)
+		r.updateStatus(ctx, backupSchedule, metav1.ConditionFalse, "RestoreVerified", "Invalid verify schedule "+verify.Schedule+": "+err.Error(), "InvalidSchedule")
+		return 0, nil
+	}
+	if backupSchedule.Spec.BackupTemplate.ObjectStorage == nil {
+		r.updateStatus(ctx, backupSchedule, metav1.ConditionFalse, "RestoreVerified", "object_storage is required to restore the backups into another namespace", "ObjectStorageRequired")
+		return 0, nil
+	}
+	if len(r.WatchNamespace) > 0 {
+		r.updateStatus(ctx, backupSchedule, metav1.ConditionFalse, "RestoreVerified", "the operator should watch all the namespaces (empty WATCH_NAMESPACE) to restore the backups into another namespace", "ClusterWideInstallationRequired")
+		return 0, nil
+	}
+	if verifyNamespace(backupSchedule) == backupSchedule.Namespace {
+		r.updateStatus(ctx, backupSchedule, metav1.ConditionFalse, "RestoreVerified", "verify namespace should not be the namespace of the PulpBackupSchedule", "InvalidNamespace")
+		return 0, nil
+	}
+	next := sched.Next(now).Sub(now) + time.Second
+	scheduledTime := mostRecentScheduleTime(sched, lastVerificationTime(backupSchedule), now)
+	if scheduledTime.IsZero() || backupSchedule.Spec.Suspend {
+		return next, nil
+	}
+
+	// the namespace kept by a failed drill is removed before the next one
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: verifyNamespace(backupSchedule)}, namespace); err == nil && ownsNamespace(backupSchedule, namespace) {
+		if namespace.DeletionTimestamp.IsZero() {
+			log.Info("Removing namespace " + namespace.Name + " of the previous restore verification drill")
+			if err := r.Delete(ctx, namespace); err != nil {
+				return 0, client.IgnoreNotFound(err)
+			}
+		}
+		return verifyRequeueInterval, nil
+	} else if err != nil && !errors.IsNotFound(err) {
+		return 0, err
+	}
+
+	backupSchedule.Status.LastVerificationTime = &metav1.Time{Time: scheduledTime}
+	pulpBackup := latestBackup(backups)
+	if pulpBackup == nil {
+		log.Info("Skipping restore verification drill, no successful backup found")
+		r.updateStatus(ctx, backupSchedule, metav1.ConditionFalse, "RestoreVerified", "No successful backup to verify", "NoBackupToVerify")
+		return next, nil
+	}
+
+	log.Info("Starting restore verification drill", "PulpBackup", pulpBackup.Name, "Namespace", verifyNamespace(backupSchedule))
+	backupSchedule.Status.VerificationResults = append(backupSchedule.Status.VerificationResults, pulpv1.BackupVerificationResult{
+		Backup:          pulpBackup.Name,
+		BackupDirectory: pulpBackup.Status.BackupDirectory,
+		Namespace:       verifyNamespace(backupSchedule),
+		State:           controllers.PhaseRunning,
+		StartTime:       metav1.Now(),
+	})
+	if err := r.Status().Update(ctx, backupSchedule); err != nil {
+		log.Error(err, "Failed to update PulpBackupSchedule status")
+		return 0, err
+	}
+	return r.runVerification(ctx, backupSchedule, runningVerification(backupSchedule), now)
+}
+
+// runVerification follows the steps of a running drill: it creates the namespace, with the secrets
+// needed by the restore, restores the backup, waits for Pulp to be ready and checks the status endpoint
+// of its API. The result is recorded once the drill finishes (or times out).
+func (r *RepoManagerBackupScheduleReconciler) runVerification(ctx context.Context, backupSchedule *pulpv1.PulpBackupSchedule, result *pulpv1.BackupVerificationResult, now time.Time) (time.Duration, error) {
+	step, finished, err := r.verificationStep(ctx, backupSchedule, result)
+	jobErr := &controllers.JobFailedError{}
+	verifyErr := &verificationFailedError{}
+	switch {
+	case goerrors.As(err, &jobErr):
+		return 0, r.finishVerification(ctx, backupSchedule, result, controllers.PhaseFailed, "smoke check failed: "+jobErr.Message)
+	case goerrors.As(err, &verifyErr):
+		return 0, r.finishVerification(ctx, backupSchedule, result, controllers.PhaseFailed, verifyErr.Error())
+	case err != nil:
+		return 0, err
+	case finished:
+		return 0, r.finishVerification(ctx, backupSchedule, result, controllers.PhaseSucceeded, step)
+	}
+
+	timeout := backupSchedule.Spec.Verify.TimeoutMinutes
+	if timeout == 0 {
+		timeout = defaultVerifyTimeout
+	}
+	if now.After(result.StartTime.Add(time.Duration(timeout) * time.Minute)) {
+		return 0, r.finishVerification(ctx, backupSchedule, result, controllers.PhaseFailed, fmt.Sprintf("timed out after %d minutes while %s", timeout, step))
+	}
+	r.RawLogger.V(1).Info("Restore verification drill running", "Namespace", result.Namespace, "Step", step)
+	r.updateStatus(ctx, backupSchedule, metav1.ConditionUnknown, "RestoreVerified", "Restore verification drill running: "+step, "VerificationRunning")
+	return verifyRequeueInterval, nil
+}
+
+// verificationFailedError is returned by verificationStep when the drill failed
+type verificationFailedError struct {
+	message string
+}
+
+func (e *verificationFailedError) Error() string {
+	return e.message
+}
+
+// verificationStep runs the next step of the drill and returns its description while it is running or,
+// once the drill finished, the versions of the Pulp components reported by the status endpoint
+func (r *RepoManagerBackupScheduleReconciler) verificationStep(ctx context.Context, backupSchedule *pulpv1.PulpBackupSchedule, result *pulpv1.BackupVerificationResult) (string, bool, error) {
+	log := r.RawLogger
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: result.Namespace}, namespace); errors.IsNotFound(err) {
+		namespace = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: result.Namespace, Labels: verifyLabels(backupSchedule)}}
+		log.Info("Creating namespace " + result.Namespace + " for the restore verification drill")
+		return "creating namespace " + result.Namespace, false, r.Create(ctx, namespace)
+	} else if err != nil {
+		return "", false, err
+	}
+	if !ownsNamespace(backupSchedule, namespace) {
+		return "", false, &verificationFailedError{"namespace " + namespace.Name + " already exists and was not created for the restore verification drills"}
+	}
+	if !namespace.DeletionTimestamp.IsZero() {
+		return "waiting for namespace " + namespace.Name + " to be removed", false, nil
+	}
+
+	for _, secret := range verifySecrets(backupSchedule) {
+		if err := r.copySecret(ctx, secret, backupSchedule.Namespace, namespace.Name); err != nil {
+			return "", false, err
+		}
+	}
+
+	pulpRestore := &pulpv1.PulpRestore{}
+	if err := r.Get(ctx, types.NamespacedName{Name: backupSchedule.Name + verifyRestoreSuffix, Namespace: namespace.Name}, pulpRestore); errors.IsNotFound(err) {
+		pulpRestore = restoreForVerification(backupSchedule, result)
+		log.Info("Restoring PulpBackup "+result.Backup+" into namespace "+namespace.Name, "PulpRestore", pulpRestore.Name)
+		return "restoring PulpBackup " + result.Backup, false, r.Create(ctx, pulpRestore)
+	} else if err != nil {
+		return "", false, err
+	}
+	switch pulpRestore.Status.Phase {
+	case controllers.PhaseFailed:
+		message := "restore failed"
+		if condition := v1.FindStatusCondition(pulpRestore.Status.Conditions, "RestoreComplete"); condition != nil {
+			message += ": " + condition.Message
+		}
+		return "", false, &verificationFailedError{message}
+	case restorePhaseCompleted:
+	case "":
+		return "restoring PulpBackup " + result.Backup, false, nil
+	default:
+		return "restoring PulpBackup " + result.Backup + " (phase " + pulpRestore.Status.Phase + ")", false, nil
+	}
+
+	pulp := &pulpv1.Pulp{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName, Namespace: namespace.Name}, pulp); err != nil {
+		return "", false, err
+	}
+	if notReady := pulpNotReady(pulp); len(notReady) > 0 {
+		return "waiting for " + strings.Join(notReady, ", "), false, nil
+	}
+
+	job := smokeCheckJob(backupSchedule, pulp, controllers.GetAPIRoot(ctx, r.Client, pulp))
+	if finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpRestore, job); !finished || err != nil {
+		return "running the smoke check", false, err
+	}
+	logs, err := controllers.JobLogs(ctx, r.Client, r.RESTClient, job.Name, job.Namespace)
+	if err != nil {
+		log.Error(err, "Failed to get the logs from the smoke check job")
+		return "", false, err
+	}
+	return strings.TrimSpace(logs), true, nil
+}
+
+// pulpNotReady returns the conditions of pulp that are not ready yet
+func pulpNotReady(pulp *pulpv1.Pulp) []string {
+	notReady := []string{}
+	for _, condition := range pulp.Status.Conditions {
+		if strings.HasSuffix(condition.Type, "-Ready") && condition.Status != metav1.ConditionTrue {
+			notReady = append(notReady, condition.Type)
+		}
+	}
+	if !v1.IsStatusConditionTrue(pulp.Status.Conditions, "Pulp-Operator-Finished-Execution") {
+		notReady = append(notReady, "Pulp-Operator-Finished-Execution")
+	}
+	return notReady
+}
+
+// finishVerification records the result of the drill and removes its namespace, unless the drill failed
+// and keep_on_failure is true
+func (r *RepoManagerBackupScheduleReconciler) finishVerification(ctx context.Context, backupSchedule *pulpv1.PulpBackupSchedule, result *pulpv1.BackupVerificationResult, state, message string) error {
+	log := r.RawLogger
+	result.State = state
+	result.Message = message
+	result.CompletionTime = &metav1.Time{Time: time.Now()}
+	backup := result.Backup
+
+	if state == controllers.PhaseFailed {
+		log.Info("Restore verification drill failed", "PulpBackup", backup, "Message", message)
+		v1.SetStatusCondition(&backupSchedule.Status.Conditions, metav1.Condition{Type: "RestoreVerified", Status: metav1.ConditionFalse, Reason: "VerificationFailed", Message: "Restore of PulpBackup " + backup + " failed: " + message})
+	} else {
+		log.Info("Restore verification drill succeeded", "PulpBackup", backup, "Versions", message)
+		v1.SetStatusCondition(&backupSchedule.Status.Conditions, metav1.Condition{Type: "RestoreVerified", Status: metav1.ConditionTrue, Reason: "VerificationSucceeded", Message: "PulpBackup " + backup + " restored and Pulp API is available"})
+	}
+	if results := backupSchedule.Status.VerificationResults; len(results) > maxVerificationResults {
+		backupSchedule.Status.VerificationResults = results[len(results)-maxVerificationResults:]
+	}
+	if err := r.Status().Update(ctx, backupSchedule); err != nil {
+		log.Error(err, "Failed to update PulpBackupSchedule status")
+		return err
+	}
+
+	if state == controllers.PhaseFailed && backupSchedule.Spec.Verify.KeepOnFailure {
+		return nil
+	}
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: result.Namespace}, namespace); err != nil || !ownsNamespace(backupSchedule, namespace) {
+		return client.IgnoreNotFound(err)
+	}
+	log.Info("Removing namespace " + namespace.Name + " of the restore verification drill")
+	return client.IgnoreNotFound(r.Delete(ctx, namespace))
+}
+
+// verifySecrets returns the secrets, from the namespace of the PulpBackupSchedule, needed to restore
+// the backups in the drill namespace
+func verifySecrets(backupSchedule *pulpv1.PulpBackupSchedule) []string {
+	secrets := []string{backupSchedule.Spec.BackupTemplate.ObjectStorage.S3Secret}
+	if len(backupSchedule.Spec.BackupTemplate.EncryptionSecret) > 0 {
+		secrets = append(secrets, backupSchedule.Spec.BackupTemplate.EncryptionSecret)
+	}
+	return append(secrets, backupSchedule.Spec.BackupTemplate.ImagePullSecrets...)
+}
+
+// copySecret copies the secret from namespace to the target namespace if it is not found there
+func (r *RepoManagerBackupScheduleReconciler) copySecret(ctx context.Context, name, namespace, target string) error {
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: target}, &corev1.Secret{}); err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, secret); errors.IsNotFound(err) {
+		return &verificationFailedError{"Secret " + name + " not found"}
+	} else if err != nil {
+		return err
+	}
+	return r.Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: target, Labels: secret.Labels},
+		Type:       secret.Type,
+		Data:       secret.Data,
+	})
+}
+
+// restoreForVerification returns the PulpRestore that restores the backup of the drill, from
+// object_storage, into the drill namespace
+func restoreForVerification(backupSchedule *pulpv1.PulpBackupSchedule, result *pulpv1.BackupVerificationResult) *pulpv1.PulpRestore {
+	return &pulpv1.PulpRestore{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      backupSchedule.Name + verifyRestoreSuffix,
+			Namespace: result.Namespace,
+			Labels:    verifyLabels(backupSchedule),
+		},
+		Spec: pulpv1.PulpRestoreSpec{
+			DeploymentName:       backupSchedule.Spec.BackupTemplate.DeploymentName,
+			BackupName:           result.Backup,
+			BackupDir:            result.BackupDirectory,
+			ObjectStorage:        backupSchedule.Spec.BackupTemplate.ObjectStorage,
+			BackupStorageReq:     backupSchedule.Spec.BackupTemplate.BackupStorageReq,
+			BackupSC:             backupSchedule.Spec.BackupTemplate.BackupSC,
+			EncryptionSecret:     backupSchedule.Spec.BackupTemplate.EncryptionSecret,
+			IngressHost:          backupSchedule.Spec.Verify.IngressHost,
+			RouteHost:            backupSchedule.Spec.Verify.RouteHost,
+			BackupManagerImage:   backupSchedule.Spec.BackupTemplate.BackupManagerImage,
+			ImagePullPolicy:      backupSchedule.Spec.BackupTemplate.ImagePullPolicy,
+			ImagePullSecrets:     backupSchedule.Spec.BackupTemplate.ImagePullSecrets,
+			ResourceRequirements: backupSchedule.Spec.BackupTemplate.ResourceRequirements,
+		},
+	}
+}
+
+// smokeCheckJob returns the job that runs smokeCheckScript, in the Pulp image, against the API of the
+// restored pulp
+func smokeCheckJob(backupSchedule *pulpv1.PulpBackupSchedule, pulp *pulpv1.Pulp, apiRoot string) *batchv1.Job {
+	labels := map[string]string{
+		"app.kubernetes.io/name":       "pulp-smoke-check",
+		"app.kubernetes.io/instance":   "pulp-smoke-check-" + pulp.Name,
+		"app.kubernetes.io/component":  "backup-storage",
+		"app.kubernetes.io/part-of":    "pulp",
+		"app.kubernetes.io/managed-by": "pulp-operator",
+	}
+	statusURL := fmt.Sprintf("http://%s.%s.svc:24817%sapi/v3/status/", settings.ApiService(pulp.Name), pulp.Namespace, apiRoot)
+	backoffLimit := int32(0)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      backupSchedule.Name + smokeCheckJobSuffix,
+			Namespace: pulp.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:                     "smoke-check",
+						Image:                    controllers.PulpImage(*pulp),
+						Command:                  []string{"python3", "-c", smokeCheckScript},
+						Env:                      []corev1.EnvVar{{Name: "STATUS_URL", Value: statusURL}},
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						SecurityContext:          controllers.SetDefaultSecurityContext(),
+					}},
+					RestartPolicy: corev1.RestartPolicyNever,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_backup_schedule
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// 2026-01-05 is a Monday, the drills run on Sundays
+var verifyNow = time.Date(2026, 1, 5, 7, 0, 0, 0, time.UTC)
+
+func verifyScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = pulpv1.AddToScheme(scheme)
+	return scheme
+}
+
+func verifySchedule(verify *pulpv1.BackupVerification) *pulpv1.PulpBackupSchedule {
+	return &pulpv1.PulpBackupSchedule{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "nightly",
+			Namespace:         "pulp",
+			CreationTimestamp: metav1.Time{Time: verifyNow.AddDate(0, 0, -7)},
+		},
+		Spec: pulpv1.PulpBackupScheduleSpec{
+			BackupTemplate: pulpv1.PulpBackupSpec{
+				DeploymentName: "pulp",
+				ObjectStorage:  &pulpv1.BackupObjectStorage{S3Secret: "backup-s3"},
+			},
+			Verify: verify,
+		},
+	}
+}
+
+func newVerifyReconciler(objects ...client.Object) *RepoManagerBackupScheduleReconciler {
+	scheme := verifyScheme()
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).
+		WithStatusSubresource(&pulpv1.PulpBackupSchedule{}, &pulpv1.PulpRestore{}).Build()
+	return &RepoManagerBackupScheduleReconciler{Client: c, RawLogger: logr.Discard(), Scheme: scheme}
+}
+
+// TestVerifyBackups verifies that a drill restores the latest backup into its namespace and that the
+// namespace of a failed drill is removed unless keep_on_failure is true
+func TestVerifyBackups(t *testing.T) {
+	tests := []struct {
+		name          string
+		keepOnFailure bool
+	}{
+		{name: "namespace removed after a failed drill"},
+		{name: "namespace kept after a failed drill", keepOnFailure: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backupSchedule := verifySchedule(&pulpv1.BackupVerification{Schedule: "0 6 * * 0", KeepOnFailure: tt.keepOnFailure})
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "backup-s3", Namespace: "pulp"}, Data: map[string][]byte{"s3-bucket-name": []byte("backups")}}
+			r := newVerifyReconciler(backupSchedule, secret)
+			ctx := context.TODO()
+			backups := []pulpv1.PulpBackup{
+				completedBackup("older", verifyNow.Add(-48*time.Hour)),
+				completedBackup("latest", verifyNow.Add(-5*time.Hour)),
+				{ObjectMeta: metav1.ObjectMeta{Name: "running", CreationTimestamp: metav1.Time{Time: verifyNow}}},
+			}
+
+			// the drill is started and its namespace created
+			if requeue, err := r.verifyBackups(ctx, backupSchedule, backups, verifyNow); err != nil || requeue != verifyRequeueInterval {
+				t.Fatalf("expected the drill to be requeued, got %v, %v", requeue, err)
+			}
+			result := runningVerification(backupSchedule)
+			if result == nil || result.Backup != "latest" || result.Namespace != "nightly-verify" {
+				t.Fatalf("expected a running drill of the latest backup, got %+v", backupSchedule.Status.VerificationResults)
+			}
+			namespace := &corev1.Namespace{}
+			if err := r.Get(ctx, types.NamespacedName{Name: "nightly-verify"}, namespace); err != nil || !ownsNamespace(backupSchedule, namespace) {
+				t.Fatalf("expected namespace nightly-verify to be created for the drill: %v", err)
+			}
+
+			// the secrets are copied and the backup restored
+			if _, err := r.verifyBackups(ctx, backupSchedule, backups, verifyNow); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Get(ctx, types.NamespacedName{Name: "backup-s3", Namespace: "nightly-verify"}, &corev1.Secret{}); err != nil {
+				t.Errorf("expected the object_storage secret to be copied: %v", err)
+			}
+			pulpRestore := &pulpv1.PulpRestore{}
+			if err := r.Get(ctx, types.NamespacedName{Name: "nightly-verify", Namespace: "nightly-verify"}, pulpRestore); err != nil {
+				t.Fatal(err)
+			}
+			if pulpRestore.Spec.BackupName != "latest" || pulpRestore.Spec.ObjectStorage == nil {
+				t.Errorf("expected latest to be restored from object_storage, got %+v", pulpRestore.Spec)
+			}
+
+			pulpRestore.Status.Phase = controllers.PhaseFailed
+			pulpRestore.Status.Conditions = []metav1.Condition{{Type: "RestoreComplete", Status: metav1.ConditionFalse, Reason: "Failed", Message: "broken backup"}}
+			if err := r.Status().Update(ctx, pulpRestore); err != nil {
+				t.Fatal(err)
+			}
+			if requeue, err := r.verifyBackups(ctx, backupSchedule, backups, verifyNow); err != nil || requeue != 0 {
+				t.Fatalf("expected the drill to finish, got %v, %v", requeue, err)
+			}
+			results := backupSchedule.Status.VerificationResults
+			if len(results) != 1 || results[0].State != controllers.PhaseFailed || results[0].Message != "restore failed: broken backup" {
+				t.Errorf("expected the failed drill to be recorded, got %+v", results)
+			}
+			if condition := v1.FindStatusCondition(backupSchedule.Status.Conditions, "RestoreVerified"); condition == nil || condition.Reason != "VerificationFailed" {
+				t.Errorf("expected RestoreVerified condition with reason VerificationFailed, got %+v", condition)
+			}
+			err := r.Get(ctx, types.NamespacedName{Name: "nightly-verify"}, &corev1.Namespace{})
+			if tt.keepOnFailure && err != nil {
+				t.Errorf("expected the namespace to be kept: %v", err)
+			} else if !tt.keepOnFailure && !errors.IsNotFound(err) {
+				t.Errorf("expected the namespace to be removed, got %v", err)
+			}
+
+			// no drill is started before the next schedule
+			if _, err := r.verifyBackups(ctx, backupSchedule, backups, verifyNow.Add(time.Hour)); err != nil || runningVerification(backupSchedule) != nil {
+				t.Errorf("expected no drill before the next schedule: %v", err)
+			}
+		})
+	}
+}
+
+// TestVerifyBackupsFailures verifies the drills that cannot run or do not finish
+func TestVerifyBackupsFailures(t *testing.T) {
+	ctx := context.TODO()
+
+	t.Run("object_storage is required", func(t *testing.T) {
+		backupSchedule := verifySchedule(&pulpv1.BackupVerification{Schedule: "0 6 * * 0"})
+		backupSchedule.Spec.BackupTemplate.ObjectStorage = nil
+		r := newVerifyReconciler(backupSchedule)
+		if _, err := r.verifyBackups(ctx, backupSchedule, []pulpv1.PulpBackup{completedBackup("latest", verifyNow)}, verifyNow); err != nil {
+			t.Fatal(err)
+		}
+		if condition := v1.FindStatusCondition(backupSchedule.Status.Conditions, "RestoreVerified"); condition == nil || condition.Reason != "ObjectStorageRequired" {
+			t.Errorf("expected RestoreVerified condition with reason ObjectStorageRequired, got %+v", condition)
+		}
+		if len(backupSchedule.Status.VerificationResults) > 0 {
+			t.Errorf("expected no drill to be started")
+		}
+	})
+
+	t.Run("operator watching a single namespace", func(t *testing.T) {
+		backupSchedule := verifySchedule(&pulpv1.BackupVerification{Schedule: "0 6 * * 0"})
+		r := newVerifyReconciler(backupSchedule)
+		r.WatchNamespace = "pulp-operator-system"
+		if _, err := r.verifyBackups(ctx, backupSchedule, []pulpv1.PulpBackup{completedBackup("latest", verifyNow)}, verifyNow); err != nil {
+			t.Fatal(err)
+		}
+		if condition := v1.FindStatusCondition(backupSchedule.Status.Conditions, "RestoreVerified"); condition == nil || condition.Reason != "ClusterWideInstallationRequired" {
+			t.Errorf("expected RestoreVerified condition with reason ClusterWideInstallationRequired, got %+v", condition)
+		}
+		if len(backupSchedule.Status.VerificationResults) > 0 {
+			t.Errorf("expected no drill to be started")
+		}
+	})
+
+	t.Run("existing namespace is not used", func(t *testing.T) {
+		backupSchedule := verifySchedule(&pulpv1.BackupVerification{Schedule: "0 6 * * 0", Namespace: "production"})
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "production"}}
+		r := newVerifyReconciler(backupSchedule, namespace)
+		if _, err := r.verifyBackups(ctx, backupSchedule, []pulpv1.PulpBackup{completedBackup("latest", verifyNow)}, verifyNow); err != nil {
+			t.Fatal(err)
+		}
+		results := backupSchedule.Status.VerificationResults
+		if len(results) != 1 || results[0].State != controllers.PhaseFailed || !strings.Contains(results[0].Message, "already exists") {
+			t.Errorf("expected the drill to fail, got %+v", results)
+		}
+		if err := r.Get(ctx, types.NamespacedName{Name: "production"}, &corev1.Namespace{}); err != nil {
+			t.Errorf("expected namespace production to be kept: %v", err)
+		}
+	})
+
+	t.Run("drill times out", func(t *testing.T) {
+		backupSchedule := verifySchedule(&pulpv1.BackupVerification{Schedule: "0 6 * * 0", TimeoutMinutes: 30})
+		backupSchedule.Status.VerificationResults = []pulpv1.BackupVerificationResult{{
+			Backup:    "latest",
+			Namespace: "nightly-verify",
+			State:     controllers.PhaseRunning,
+			StartTime: metav1.Time{Time: verifyNow},
+		}}
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nightly-verify", Labels: verifyLabels(backupSchedule)}}
+		r := newVerifyReconciler(backupSchedule, namespace, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "backup-s3", Namespace: "pulp"}})
+
+		if requeue, err := r.verifyBackups(ctx, backupSchedule, nil, verifyNow.Add(20*time.Minute)); err != nil || requeue != verifyRequeueInterval {
+			t.Fatalf("expected the drill to be requeued, got %v, %v", requeue, err)
+		}
+		if _, err := r.verifyBackups(ctx, backupSchedule, nil, verifyNow.Add(40*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		results := backupSchedule.Status.VerificationResults
+		if len(results) != 1 || results[0].State != controllers.PhaseFailed || !strings.HasPrefix(results[0].Message, "timed out after 30 minutes while restoring PulpBackup latest") {
+			t.Errorf("expected the drill to time out, got %+v", results)
+		}
+		if err := r.Get(ctx, types.NamespacedName{Name: "nightly-verify"}, &corev1.Namespace{}); !errors.IsNotFound(err) {
+			t.Errorf("expected the namespace to be removed, got %v", err)
+		}
+	})
+
+	t.Run("namespace of the drill is removed after verify.namespace changes", func(t *testing.T) {
+		backupSchedule := verifySchedule(&pulpv1.BackupVerification{Schedule: "0 6 * * 0", TimeoutMinutes: 30, Namespace: "drills"})
+		backupSchedule.Status.VerificationResults = []pulpv1.BackupVerificationResult{{
+			Backup:    "latest",
+			Namespace: "nightly-verify",
+			State:     controllers.PhaseRunning,
+			StartTime: metav1.Time{Time: verifyNow},
+		}}
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nightly-verify", Labels: verifyLabels(backupSchedule)}}
+		r := newVerifyReconciler(backupSchedule, namespace)
+
+		if _, err := r.verifyBackups(ctx, backupSchedule, nil, verifyNow.Add(40*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Get(ctx, types.NamespacedName{Name: "nightly-verify"}, &corev1.Namespace{}); !errors.IsNotFound(err) {
+			t.Errorf("expected the namespace of the drill to be removed, got %v", err)
+		}
+	})
+}
+
+// TestPulpNotReady verifies that the drill waits for all the Pulp conditions
+func TestPulpNotReady(t *testing.T) {
+	pulp := &pulpv1.Pulp{Status: pulpv1.PulpStatus{Conditions: []metav1.Condition{
+		{Type: "Pulp-API-Ready", Status: metav1.ConditionTrue},
+		{Type: "Pulp-Content-Ready", Status: metav1.ConditionFalse},
+		{Type: "Pulp-Worker-Ready", Status: metav1.ConditionTrue},
+	}}}
+	if notReady := strings.Join(pulpNotReady(pulp), ","); notReady != "Pulp-Content-Ready,Pulp-Operator-Finished-Execution" {
+		t.Errorf("unexpected conditions not ready: %s", notReady)
+	}
+
+	pulp.Status.Conditions[1].Status = metav1.ConditionTrue
+	pulp.Status.Conditions = append(pulp.Status.Conditions, metav1.Condition{Type: "Pulp-Operator-Finished-Execution", Status: metav1.ConditionTrue})
+	if notReady := pulpNotReady(pulp); len(notReady) > 0 {
+		t.Errorf("expected pulp to be ready, got %v", notReady)
+	}
+}
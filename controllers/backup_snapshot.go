@@ -0,0 +1,179 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// BackupModeSnapshot is the PulpBackup backup_mode that takes VolumeSnapshots instead of copying the Pulp dir
+	BackupModeSnapshot = "Snapshot"
+
+	// VolumeSnapshotAPIGroup is the API group of the VolumeSnapshots (used in the PVC dataSource)
+	VolumeSnapshotAPIGroup = "snapshot.storage.k8s.io"
+)
+
+// VolumeSnapshotGVK is the kind of the CSI snapshots taken by backup_mode Snapshot.
+// The snapshot client is not a dependency of the operator, so the VolumeSnapshots are handled as
+// unstructured objects.
+var VolumeSnapshotGVK = schema.GroupVersionKind{Group: VolumeSnapshotAPIGroup, Version: "v1", Kind: "VolumeSnapshot"}
+
+// VolumeSnapshotStatus is the part of the VolumeSnapshot status used by the backup and the restore
+type VolumeSnapshotStatus struct {
+	// Created is true once the snapshot is taken (the data written afterwards is not part of it)
+	Created bool
+
+	// ReadyToUse is true when a PVC can be provisioned from the snapshot
+	ReadyToUse bool
+
+	// RestoreSize is the minimum size of a PVC provisioned from the snapshot
+	RestoreSize string
+
+	// Error is the message of the error found while taking the snapshot
+	Error string
+}
+
+// VolumeSnapshot returns an (empty) VolumeSnapshot object, which can be used to get or delete it
+func VolumeSnapshot(name, namespace string) *unstructured.Unstructured {
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(VolumeSnapshotGVK)
+	snapshot.SetName(name)
+	snapshot.SetNamespace(namespace)
+	return snapshot
+}
+
+// NewVolumeSnapshot returns a VolumeSnapshot of the pvc. If snapshotClass is empty the default
+// VolumeSnapshotClass is used.
+func NewVolumeSnapshot(name, namespace, pvc, snapshotClass string, labels map[string]string) *unstructured.Unstructured {
+	snapshot := VolumeSnapshot(name, namespace)
+	snapshot.SetLabels(labels)
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{"persistentVolumeClaimName": pvc},
+	}
+	if len(snapshotClass) > 0 {
+		spec["volumeSnapshotClassName"] = snapshotClass
+	}
+	snapshot.Object["spec"] = spec
+	return snapshot
+}
+
+// GetVolumeSnapshotStatus returns the status of the VolumeSnapshot
+func GetVolumeSnapshotStatus(snapshot *unstructured.Unstructured) VolumeSnapshotStatus {
+	status := VolumeSnapshotStatus{}
+	creationTime, _, _ := unstructured.NestedString(snapshot.Object, "status", "creationTime")
+	status.Created = len(creationTime) > 0
+	status.ReadyToUse, _, _ = unstructured.NestedBool(snapshot.Object, "status", "readyToUse")
+	status.RestoreSize, _, _ = unstructured.NestedString(snapshot.Object, "status", "restoreSize")
+	status.Error, _, _ = unstructured.NestedString(snapshot.Object, "status", "error", "message")
+	return status
+}
+
+// FileStoragePVC returns the name of the PVC mounted in /var/lib/pulp, or an empty string if Pulp
+// does not store its content in a PVC (object storage or emptyDir)
+func FileStoragePVC(pulp *pulpv1.Pulp) string {
+	if len(pulp.Spec.ObjectStorageAzureSecret) > 0 || len(pulp.Spec.ObjectStorageS3Secret) > 0 || len(pulp.Spec.ObjectStorageGCSSecret) > 0 {
+		return ""
+	}
+	_, storageType := MultiStorageConfigured(pulp, PulpResource)
+	if len(storageType) == 0 {
+		return ""
+	}
+	switch storageType[0] {
+	// if SC defined, the PVC is provisioned by the operator
+	case SCNameType:
+		return settings.DefaultPulpFileStorage(pulp.Name)
+	// if .spec.PVC defined, the PVC is provisioned by the user
+	case PVCType:
+		return pulp.Spec.PVC
+	}
+	return ""
+}
+
+// DatabasePVC returns the name of the PVC with the data of the database managed by the operator, or
+// an empty string if Pulp uses an external database
+func DatabasePVC(pulp *pulpv1.Pulp) string {
+	if len(pulp.Spec.Database.ExternalDBSecret) > 0 {
+		return ""
+	}
+	// the database is moved to a new PVC by the major version upgrades
+	if len(pulp.Status.DatabasePVC) > 0 {
+		return pulp.Status.DatabasePVC
+	}
+	_, storageType := MultiStorageConfigured(pulp, DatabaseResource)
+	if len(storageType) == 0 {
+		return ""
+	}
+	switch storageType[0] {
+	// if SC defined, the PVC is claimed by the database StatefulSet (volumeClaimTemplate)
+	case SCNameType:
+		// the PVC of the primary, if the database is replicated
+		if pulp.Status.DatabaseHA != nil && len(pulp.Status.DatabaseHA.Primary) > 0 {
+			return settings.DefaultDBPVC(pulp.Name) + "-" + pulp.Status.DatabaseHA.Primary
+		}
+		return settings.DefaultDBPVC(pulp.Name) + "-" + settings.DefaultDBStatefulSet(pulp.Name) + "-0"
+	// if .spec.Database.PVC defined, the PVC is provisioned by the user
+	case PVCType:
+		return pulp.Spec.Database.PVC
+	}
+	return ""
+}
+
+// PVCFromSnapshot returns a PVC provisioned from the VolumeSnapshot taken during the backup, with the
+// storage class and access modes of the PVC it was taken from
+func PVCFromSnapshot(name, namespace string, snapshot pulpv1.BackupVolumeSnapshot, labels map[string]string) (*corev1.PersistentVolumeClaim, error) {
+	size, err := resource.ParseQuantity(snapshot.Size)
+	if err != nil {
+		return nil, err
+	}
+	apiGroup := VolumeSnapshotAPIGroup
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: snapshot.AccessModes,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+			DataSource: &corev1.TypedLocalObjectReference{
+				APIGroup: &apiGroup,
+				Kind:     VolumeSnapshotGVK.Kind,
+				Name:     snapshot.Name,
+			},
+		},
+	}
+	if len(snapshot.StorageClass) > 0 {
+		pvc.Spec.StorageClassName = &snapshot.StorageClass
+	}
+	return pvc, nil
+}
+
+// ProvisionedFromSnapshot returns true if the PVC was provisioned from the VolumeSnapshot
+func ProvisionedFromSnapshot(pvc *corev1.PersistentVolumeClaim, snapshot string) bool {
+	dataSource := pvc.Spec.DataSource
+	return dataSource != nil && dataSource.Kind == VolumeSnapshotGVK.Kind && dataSource.Name == snapshot &&
+		dataSource.APIGroup != nil && *dataSource.APIGroup == VolumeSnapshotAPIGroup
+}
@@ -289,6 +289,9 @@ func (d *CommonDeployment) setEnvVars(resources any, pulpcoreType settings.Pulpc
 
 	// add postgres env vars
 	envVars = append(envVars, GetPostgresEnvVars(*pulp)...)
+	if pulpcoreType == settings.WORKER {
+		envVars = append(envVars, GetDatabaseDirectEnvVars(*pulp)...)
+	}
 
 	// add cache configuration if enabled
 	if pulp.Spec.Cache.Enabled {
@@ -428,6 +431,37 @@ func GetPostgresEnvVars(pulp pulpv1.Pulp) (envVars []corev1.EnvVar) {
 	return envVars
 }
 
+// GetDatabaseDirectEnvVars returns the environment variables that make pulpcore connect straight to the
+// database instead of the pooler in transaction pooling mode, for the processes relying on session features
+// (advisory locks, LISTEN/NOTIFY). They must be added after the GetPostgresEnvVars variables.
+func GetDatabaseDirectEnvVars(pulp pulpv1.Pulp) []corev1.EnvVar {
+	pooler := pulp.Spec.Database.Pooler
+	if pooler == nil || pooler.PoolMode != "transaction" {
+		return nil
+	}
+
+	sslModeSecret, sslModeKey := settings.DefaultDBSecret(pulp.Name), "sslmode"
+	if len(pulp.Spec.Database.ExternalDBSecret) > 0 {
+		sslModeSecret, sslModeKey = pulp.Spec.Database.ExternalDBSecret, "POSTGRES_SSLMODE"
+	}
+	return []corev1.EnvVar{
+		{Name: "PULP_DATABASES__default__HOST", Value: "$(POSTGRES_SERVICE_HOST)"},
+		{Name: "PULP_DATABASES__default__PORT", Value: "$(POSTGRES_SERVICE_PORT)"},
+		{Name: "PULP_DATABASES__default__DISABLE_SERVER_SIDE_CURSORS", Value: "false"},
+		{
+			Name: "PULP_DATABASES__default__OPTIONS__sslmode",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: sslModeSecret,
+					},
+					Key: sslModeKey,
+				},
+			},
+		},
+	}
+}
+
 // GetAdminSecretName retrieves pulp admin user password
 func GetAdminSecretName(pulp pulpv1.Pulp) string {
 	return pulp.Spec.AdminPasswordSecret
@@ -569,6 +603,7 @@ func (d *CommonDeployment) setVolumes(resources any, pulpcoreType settings.Pulpc
 
 	// append the CA configmap to the volumes
 	volumes = SetCAVolumes(&pulp, volumes)
+	volumes = SetDatabaseCAVolumes(&pulp, volumes)
 
 	d.volumes = append([]corev1.Volume(nil), volumes...)
 }
@@ -743,6 +778,7 @@ func (d *CommonDeployment) setVolumeMounts(pulp pulpv1.Pulp, pulpcoreType settin
 
 	// append the CA configmap to the volumeMounts
 	volumeMounts = SetCAVolumeMounts(&pulp, volumeMounts)
+	volumeMounts = SetDatabaseCAVolumeMounts(&pulp, volumeMounts)
 
 	d.volumeMounts = append([]corev1.VolumeMount(nil), volumeMounts...)
 }
@@ -774,6 +810,7 @@ func (d *CommonDeployment) setInitContainerVolumeMounts(pulp pulpv1.Pulp) {
 		}
 		volumeMounts = append(volumeMounts, fileStorageMount)
 	}
+	volumeMounts = SetDatabaseCAVolumeMounts(&pulp, volumeMounts)
 	d.initContainerVolumeMounts = append([]corev1.VolumeMount(nil), volumeMounts...)
 }
 
@@ -880,13 +917,18 @@ func (d *CommonDeployment) setLivenessProbe(resources any, pulp pulpv1.Pulp, pul
 
 // setImage defines pulpcore container image
 func (d *CommonDeployment) setImage(pulp pulpv1.Pulp) {
+	d.image = PulpImage(pulp)
+}
+
+// PulpImage returns the pulpcore container image deployed for Pulp CR
+func PulpImage(pulp pulpv1.Pulp) string {
 	image := os.Getenv("RELATED_IMAGE_PULP")
 	if len(pulp.Spec.Image) > 0 && len(pulp.Spec.ImageVersion) > 0 {
 		image = pulp.Spec.Image + ":" + pulp.Spec.ImageVersion
 	} else if image == "" {
 		image = "quay.io/pulp/pulp-minimal:stable"
 	}
-	d.image = image
+	return image
 }
 
 // setInitContainerImage defines pulpcore init-container image
@@ -0,0 +1,238 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// RcloneImage is the image used to transfer the backups from/to the object storage
+	RcloneImage = "docker.io/rclone/rclone:1.68"
+
+	// objectStorageRemote is the name of the rclone remote configured through the env vars
+	objectStorageRemote = "backup"
+
+	// pulpStorageRemote is the name of the rclone remote with the object storage used by Pulp
+	pulpStorageRemote = "pulp"
+
+	// ObjectStorageContentDir is the directory, inside the backup directory, with the copy of the
+	// content stored in the object storage used by Pulp
+	ObjectStorageContentDir = "object_storage"
+)
+
+// ObjectStorageRemote contains the information needed to transfer a backup from/to an
+// S3-compatible object storage
+type ObjectStorageRemote struct {
+	Bucket string
+	Prefix string
+
+	// rclone remote configuration, the credentials are passed as references to the secret keys
+	Env []corev1.EnvVar
+
+	// name of the rclone remote
+	name string
+}
+
+// secretKeyRef returns an env var source with the key from secret
+func secretKeyRef(secret, key string) *corev1.EnvVarSource {
+	return &corev1.EnvVarSource{
+		SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: secret},
+			Key:                  key,
+		},
+	}
+}
+
+// s3RemoteEnv returns the env vars to configure an s3 rclone remote called name.
+// If the secret does not have the access keys, the credentials are gathered from the
+// environment (for example, from the role associated with the service account).
+func s3RemoteEnv(name, secret, endpoint string, optionalKey map[string]string) []corev1.EnvVar {
+	envPrefix := "RCLONE_CONFIG_" + strings.ToUpper(name) + "_"
+	env := []corev1.EnvVar{
+		{Name: envPrefix + "TYPE", Value: "s3"},
+		{Name: envPrefix + "PROVIDER", Value: "AWS"},
+	}
+	if len(endpoint) > 0 {
+		env[1].Value = "Other"
+		env = append(env, corev1.EnvVar{Name: envPrefix + "ENDPOINT", Value: endpoint})
+	}
+	if len(optionalKey["s3-access-key-id"]) > 0 {
+		env = append(env,
+			corev1.EnvVar{Name: envPrefix + "ENV_AUTH", Value: "false"},
+			corev1.EnvVar{Name: envPrefix + "ACCESS_KEY_ID", ValueFrom: secretKeyRef(secret, "s3-access-key-id")},
+			corev1.EnvVar{Name: envPrefix + "SECRET_ACCESS_KEY", ValueFrom: secretKeyRef(secret, "s3-secret-access-key")},
+		)
+	} else {
+		env = append(env, corev1.EnvVar{Name: envPrefix + "ENV_AUTH", Value: "true"})
+	}
+	if len(optionalKey["s3-region"]) > 0 {
+		env = append(env, corev1.EnvVar{Name: envPrefix + "REGION", Value: optionalKey["s3-region"]})
+	}
+	if optionalKey["s3-addressing-style"] == "virtual" {
+		env = append(env, corev1.EnvVar{Name: envPrefix + "FORCE_PATH_STYLE", Value: "false"})
+	}
+	return env
+}
+
+// NewObjectStorageRemote returns the ObjectStorageRemote based on the object_storage definition
+// and the keys from its s3_secret. If the secret does not have the access keys, rclone is configured
+// with env_auth to gather the credentials from the environment.
+func NewObjectStorageRemote(ctx context.Context, r client.Client, namespace string, objectStorage *pulpv1.BackupObjectStorage) (*ObjectStorageRemote, error) {
+	if objectStorage == nil || len(objectStorage.S3Secret) == 0 {
+		return nil, errors.New("error! object_storage s3_secret not provided")
+	}
+
+	// the access keys are optional, without them rclone gathers the credentials from the environment
+	optionalKey, err := RetrieveSecretData(ctx, objectStorage.S3Secret, namespace, false, r, "s3-access-key-id", "s3-secret-access-key", "s3-bucket-name", "s3-endpoint", "s3-region", "s3-addressing-style")
+	if err != nil {
+		return nil, err
+	}
+	if (len(optionalKey["s3-access-key-id"]) > 0) != (len(optionalKey["s3-secret-access-key"]) > 0) {
+		return nil, fmt.Errorf("could not find both \"s3-access-key-id\" and \"s3-secret-access-key\" keys in %v secret, set both of them or none to use the credentials from the environment", objectStorage.S3Secret)
+	}
+
+	bucket := objectStorage.Bucket
+	if len(bucket) == 0 {
+		bucket = optionalKey["s3-bucket-name"]
+	}
+	if len(bucket) == 0 {
+		return nil, fmt.Errorf("could not find the bucket name, set object_storage bucket or the \"s3-bucket-name\" key in %v secret", objectStorage.S3Secret)
+	}
+
+	endpoint := objectStorage.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = optionalKey["s3-endpoint"]
+	}
+
+	return &ObjectStorageRemote{
+		Bucket: bucket,
+		Prefix: strings.Trim(objectStorage.Prefix, "/"),
+		Env:    s3RemoteEnv(objectStorageRemote, objectStorage.S3Secret, endpoint, optionalKey),
+		name:   objectStorageRemote,
+	}, nil
+}
+
+// NewPulpStorageRemote returns the ObjectStorageRemote of the object storage (Azure, S3 or GCS)
+// where Pulp stores its content, based on the same secret keys used in Pulp settings
+func NewPulpStorageRemote(ctx context.Context, r client.Client, pulp *pulpv1.Pulp) (*ObjectStorageRemote, error) {
+	_, storageType := MultiStorageConfigured(pulp, "Pulp")
+	envPrefix := "RCLONE_CONFIG_" + strings.ToUpper(pulpStorageRemote) + "_"
+
+	switch storageType[0] {
+	case S3ObjType:
+		storageData, err := RetrieveSecretData(ctx, pulp.Spec.ObjectStorageS3Secret, pulp.Namespace, true, r, "s3-bucket-name")
+		if err != nil {
+			return nil, err
+		}
+		optionalKey, _ := RetrieveSecretData(ctx, pulp.Spec.ObjectStorageS3Secret, pulp.Namespace, false, r, "s3-endpoint", "s3-region", "s3-access-key-id", "s3-addressing-style")
+		return &ObjectStorageRemote{
+			Bucket: storageData["s3-bucket-name"],
+			Env:    s3RemoteEnv(pulpStorageRemote, pulp.Spec.ObjectStorageS3Secret, optionalKey["s3-endpoint"], optionalKey),
+			name:   pulpStorageRemote,
+		}, nil
+
+	case AzureObjType:
+		storageData, err := RetrieveSecretData(ctx, pulp.Spec.ObjectStorageAzureSecret, pulp.Namespace, true, r, "azure-account-name", "azure-account-key", "azure-container", "azure-container-path", "azure-connection-string")
+		if err != nil {
+			return nil, err
+		}
+		env := []corev1.EnvVar{
+			{Name: envPrefix + "TYPE", Value: "azureblob"},
+			{Name: envPrefix + "ACCOUNT", ValueFrom: secretKeyRef(pulp.Spec.ObjectStorageAzureSecret, "azure-account-name")},
+			{Name: envPrefix + "KEY", ValueFrom: secretKeyRef(pulp.Spec.ObjectStorageAzureSecret, "azure-account-key")},
+		}
+		// the connection string is used to define a custom endpoint (like Azurite)
+		for _, field := range strings.Split(storageData["azure-connection-string"], ";") {
+			if endpoint, found := strings.CutPrefix(field, "BlobEndpoint="); found {
+				env = append(env, corev1.EnvVar{Name: envPrefix + "ENDPOINT", Value: endpoint})
+			}
+		}
+		return &ObjectStorageRemote{
+			Bucket: storageData["azure-container"],
+			Prefix: strings.Trim(storageData["azure-container-path"], "/"),
+			Env:    env,
+			name:   pulpStorageRemote,
+		}, nil
+
+	case GCSObjType:
+		storageData, err := RetrieveSecretData(ctx, pulp.Spec.ObjectStorageGCSSecret, pulp.Namespace, true, r, "gcs-bucket-name")
+		if err != nil {
+			return nil, err
+		}
+		// as in Pulp pods, the credentials are gathered from the environment (workload identity)
+		return &ObjectStorageRemote{
+			Bucket: storageData["gcs-bucket-name"],
+			Env: []corev1.EnvVar{
+				{Name: envPrefix + "TYPE", Value: "google cloud storage"},
+				{Name: envPrefix + "ENV_AUTH", Value: "true"},
+				{Name: envPrefix + "BUCKET_POLICY_ONLY", Value: "true"},
+			},
+			name: pulpStorageRemote,
+		}, nil
+	}
+
+	return nil, errors.New("pulp is not deployed with object storage")
+}
+
+// objectKey returns the path, inside the bucket, of the backupDir
+func (o *ObjectStorageRemote) objectKey(backupDir string) string {
+	return path.Join(o.Bucket, o.Prefix, path.Base(backupDir))
+}
+
+// Path returns the rclone path of the backupDir in the object storage
+func (o *ObjectStorageRemote) Path(backupDir string) string {
+	return o.name + ":" + o.objectKey(backupDir)
+}
+
+// Root returns the rclone path of the bucket (and prefix) in the object storage
+func (o *ObjectStorageRemote) Root() string {
+	return o.name + ":" + path.Join(o.Bucket, o.Prefix)
+}
+
+// Location returns the s3 URL of the backupDir in the object storage
+func (o *ObjectStorageRemote) Location(backupDir string) string {
+	return "s3://" + o.objectKey(backupDir)
+}
+
+// ObjectStorageJob returns a Job that runs rclone with args and has the backup PVC mounted in mountPath
+func ObjectStorageJob(name, namespace, backupPVC, mountPath string, remote *ObjectStorageRemote, affinity *corev1.Affinity, args ...string) *batchv1.Job {
+	return backupJob(name, namespace, backupPVC, mountPath, "pulp-backup-transfer", affinity, corev1.Container{
+		Name:  "rclone",
+		Image: RcloneImage,
+		Args:  args,
+		// rclone does not need a config file because the remote is configured through env vars
+		Env: append([]corev1.EnvVar{{Name: "RCLONE_CONFIG", Value: "/tmp/rclone.conf"}}, remote.Env...),
+	})
+}
+
+// ObjectStorageScriptJob returns a Job that runs script, with sh, in the rclone image and has the
+// backup PVC mounted in mountPath
+func ObjectStorageScriptJob(name, namespace, backupPVC, mountPath string, remote *ObjectStorageRemote, affinity *corev1.Affinity, script string) *batchv1.Job {
+	job := ObjectStorageJob(name, namespace, backupPVC, mountPath, remote, affinity)
+	job.Spec.Template.Spec.Containers[0].Command = []string{"sh", "-c", script}
+	return job
+}
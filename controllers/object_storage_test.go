@@ -0,0 +1,314 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestNewObjectStorageRemote verifies the rclone remote configuration built from the object_storage definition
+func TestNewObjectStorageRemote(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+
+	minioSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "minio", Namespace: "test-namespace"},
+		Data: map[string][]byte{
+			"s3-access-key-id":     []byte("minioadmin"),
+			"s3-secret-access-key": []byte("minioadmin"),
+			"s3-bucket-name":       []byte("pulp-backups"),
+			"s3-endpoint":          []byte("http://minio:9000"),
+		},
+	}
+	awsSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "aws", Namespace: "test-namespace"},
+		Data: map[string][]byte{
+			"s3-access-key-id":     []byte("key"),
+			"s3-secret-access-key": []byte("secret"),
+			"s3-region":            []byte("us-east-1"),
+		},
+	}
+	noCredentialsSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "no-credentials", Namespace: "test-namespace"},
+		Data: map[string][]byte{
+			"s3-bucket-name": []byte("pulp-backups"),
+			"s3-region":      []byte("us-east-1"),
+		},
+	}
+	partialCredentialsSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "partial-credentials", Namespace: "test-namespace"},
+		Data: map[string][]byte{
+			"s3-access-key-id": []byte("key"),
+			"s3-bucket-name":   []byte("pulp-backups"),
+		},
+	}
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(minioSecret, awsSecret, noCredentialsSecret, partialCredentialsSecret).Build()
+
+	tests := []struct {
+		name          string
+		objectStorage *pulpv1.BackupObjectStorage
+		expectError   bool
+		envAuth       bool
+		path          string
+		location      string
+		env           map[string]string
+	}{
+		{
+			name:          "bucket and endpoint from secret",
+			objectStorage: &pulpv1.BackupObjectStorage{S3Secret: "minio", Prefix: "/prod/"},
+			path:          "backup:pulp-backups/prod/openshift-backup-2026-01-05-020000",
+			location:      "s3://pulp-backups/prod/openshift-backup-2026-01-05-020000",
+			env: map[string]string{
+				"RCLONE_CONFIG_BACKUP_PROVIDER": "Other",
+				"RCLONE_CONFIG_BACKUP_ENDPOINT": "http://minio:9000",
+			},
+		},
+		{
+			name:          "bucket and endpoint from object_storage override the secret",
+			objectStorage: &pulpv1.BackupObjectStorage{S3Secret: "minio", Bucket: "dr", Endpoint: "https://s3.example.com"},
+			path:          "backup:dr/openshift-backup-2026-01-05-020000",
+			location:      "s3://dr/openshift-backup-2026-01-05-020000",
+			env: map[string]string{
+				"RCLONE_CONFIG_BACKUP_ENDPOINT": "https://s3.example.com",
+			},
+		},
+		{
+			name:          "aws without endpoint",
+			objectStorage: &pulpv1.BackupObjectStorage{S3Secret: "aws", Bucket: "dr"},
+			path:          "backup:dr/openshift-backup-2026-01-05-020000",
+			location:      "s3://dr/openshift-backup-2026-01-05-020000",
+			env: map[string]string{
+				"RCLONE_CONFIG_BACKUP_PROVIDER": "AWS",
+				"RCLONE_CONFIG_BACKUP_REGION":   "us-east-1",
+			},
+		},
+		{
+			name:          "missing bucket",
+			objectStorage: &pulpv1.BackupObjectStorage{S3Secret: "aws"},
+			expectError:   true,
+		},
+		{
+			name:          "credentials from the environment",
+			objectStorage: &pulpv1.BackupObjectStorage{S3Secret: "no-credentials"},
+			envAuth:       true,
+			path:          "backup:pulp-backups/openshift-backup-2026-01-05-020000",
+			location:      "s3://pulp-backups/openshift-backup-2026-01-05-020000",
+			env: map[string]string{
+				"RCLONE_CONFIG_BACKUP_ENV_AUTH": "true",
+				"RCLONE_CONFIG_BACKUP_REGION":   "us-east-1",
+			},
+		},
+		{
+			name:          "missing secret access key",
+			objectStorage: &pulpv1.BackupObjectStorage{S3Secret: "partial-credentials"},
+			expectError:   true,
+		},
+		{
+			name:          "missing secret",
+			objectStorage: &pulpv1.BackupObjectStorage{S3Secret: "not-found", Bucket: "dr"},
+			expectError:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote, err := NewObjectStorageRemote(context.TODO(), client, "test-namespace", tt.objectStorage)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			backupDir := "/backups/openshift-backup-2026-01-05-020000"
+			if path := remote.Path(backupDir); path != tt.path {
+				t.Errorf("expected path %s, got %s", tt.path, path)
+			}
+			if location := remote.Location(backupDir); location != tt.location {
+				t.Errorf("expected location %s, got %s", tt.location, location)
+			}
+
+			env := map[string]corev1.EnvVar{}
+			for _, e := range remote.Env {
+				env[e.Name] = e
+			}
+			for name, value := range tt.env {
+				if env[name].Value != value {
+					t.Errorf("expected %s=%s, got %s", name, value, env[name].Value)
+				}
+			}
+			if tt.envAuth {
+				for _, name := range []string{"RCLONE_CONFIG_BACKUP_ACCESS_KEY_ID", "RCLONE_CONFIG_BACKUP_SECRET_ACCESS_KEY"} {
+					if _, found := env[name]; found {
+						t.Errorf("expected %s not to be set with env_auth", name)
+					}
+				}
+				return
+			}
+			// credentials should never be passed as plain values
+			for _, name := range []string{"RCLONE_CONFIG_BACKUP_ACCESS_KEY_ID", "RCLONE_CONFIG_BACKUP_SECRET_ACCESS_KEY"} {
+				if env[name].Value != "" || env[name].ValueFrom == nil || env[name].ValueFrom.SecretKeyRef.Name != tt.objectStorage.S3Secret {
+					t.Errorf("expected %s to reference %s secret", name, tt.objectStorage.S3Secret)
+				}
+			}
+		})
+	}
+}
+
+// TestNewPulpStorageRemote verifies the rclone remote configuration of the object storage used by Pulp
+func TestNewPulpStorageRemote(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+
+	s3Secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "s3", Namespace: "test-namespace"},
+		Data: map[string][]byte{
+			"s3-access-key-id":     []byte("key"),
+			"s3-secret-access-key": []byte("secret"),
+			"s3-bucket-name":       []byte("pulp-content"),
+			"s3-region":            []byte("us-east-1"),
+		},
+	}
+	s3RoleSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "s3-role", Namespace: "test-namespace"},
+		Data: map[string][]byte{
+			"s3-bucket-name": []byte("pulp-content"),
+			"s3-region":      []byte("us-east-1"),
+		},
+	}
+	azureSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "azure", Namespace: "test-namespace"},
+		Data: map[string][]byte{
+			"azure-account-name":      []byte("devstoreaccount1"),
+			"azure-account-key":       []byte("key"),
+			"azure-container":         []byte("pulp-content"),
+			"azure-container-path":    []byte("/pulp/"),
+			"azure-connection-string": []byte("DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;AccountKey=key;BlobEndpoint=http://azurite:10000/devstoreaccount1;"),
+		},
+	}
+	gcsSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gcs", Namespace: "test-namespace"},
+		Data: map[string][]byte{
+			"gcs-bucket-name": []byte("pulp-content"),
+		},
+	}
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(s3Secret, s3RoleSecret, azureSecret, gcsSecret).Build()
+
+	tests := []struct {
+		name        string
+		spec        pulpv1.PulpSpec
+		expectError bool
+		root        string
+		env         map[string]string
+		secretRefs  []string
+	}{
+		{
+			name: "s3 with access keys",
+			spec: pulpv1.PulpSpec{ObjectStorageS3Secret: "s3"},
+			root: "pulp:pulp-content",
+			env: map[string]string{
+				"RCLONE_CONFIG_PULP_TYPE":     "s3",
+				"RCLONE_CONFIG_PULP_ENV_AUTH": "false",
+				"RCLONE_CONFIG_PULP_REGION":   "us-east-1",
+			},
+			secretRefs: []string{"RCLONE_CONFIG_PULP_ACCESS_KEY_ID", "RCLONE_CONFIG_PULP_SECRET_ACCESS_KEY"},
+		},
+		{
+			name: "s3 without access keys",
+			spec: pulpv1.PulpSpec{ObjectStorageS3Secret: "s3-role"},
+			root: "pulp:pulp-content",
+			env: map[string]string{
+				"RCLONE_CONFIG_PULP_ENV_AUTH":      "true",
+				"RCLONE_CONFIG_PULP_ACCESS_KEY_ID": "",
+			},
+		},
+		{
+			name: "azure with custom endpoint",
+			spec: pulpv1.PulpSpec{ObjectStorageAzureSecret: "azure"},
+			root: "pulp:pulp-content/pulp",
+			env: map[string]string{
+				"RCLONE_CONFIG_PULP_TYPE":     "azureblob",
+				"RCLONE_CONFIG_PULP_ENDPOINT": "http://azurite:10000/devstoreaccount1",
+			},
+			secretRefs: []string{"RCLONE_CONFIG_PULP_ACCOUNT", "RCLONE_CONFIG_PULP_KEY"},
+		},
+		{
+			name: "gcs",
+			spec: pulpv1.PulpSpec{ObjectStorageGCSSecret: "gcs"},
+			root: "pulp:pulp-content",
+			env: map[string]string{
+				"RCLONE_CONFIG_PULP_TYPE":     "google cloud storage",
+				"RCLONE_CONFIG_PULP_ENV_AUTH": "true",
+			},
+		},
+		{
+			name:        "missing secret",
+			spec:        pulpv1.PulpSpec{ObjectStorageGCSSecret: "not-found"},
+			expectError: true,
+		},
+		{
+			name:        "file storage",
+			spec:        pulpv1.PulpSpec{FileStorageClass: "standard"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pulp := &pulpv1.Pulp{ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"}, Spec: tt.spec}
+			remote, err := NewPulpStorageRemote(context.TODO(), client, pulp)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if root := remote.Root(); root != tt.root {
+				t.Errorf("expected root %s, got %s", tt.root, root)
+			}
+			env := map[string]corev1.EnvVar{}
+			for _, e := range remote.Env {
+				env[e.Name] = e
+			}
+			for name, value := range tt.env {
+				if env[name].Value != value {
+					t.Errorf("expected %s=%s, got %s", name, value, env[name].Value)
+				}
+			}
+			// credentials should never be passed as plain values
+			for _, name := range tt.secretRefs {
+				if env[name].Value != "" || env[name].ValueFrom == nil || env[name].ValueFrom.SecretKeyRef == nil {
+					t.Errorf("expected %s to reference a secret key", name)
+				}
+			}
+		})
+	}
+}
@@ -60,6 +60,7 @@ type RepoManagerReconciler struct {
 //+kubebuilder:rbac:groups=route.openshift.io,namespace=pulp-operator-system,resources=routes;routes/custom-host,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=rbac.authorization.k8s.io,namespace=pulp-operator-system,resources=roles;rolebindings,verbs=create;update;patch;delete;watch;get;list
 //+kubebuilder:rbac:groups=core,namespace=pulp-operator-system,resources=pods;pods/log;serviceaccounts;configmaps;secrets;services;persistentvolumeclaims,verbs=create;update;patch;delete;watch;get;list
+//+kubebuilder:rbac:groups=core,namespace=pulp-operator-system,resources=pods/exec,verbs=create
 //+kubebuilder:rbac:groups=core,namespace=pulp-operator-system,resources=events,verbs=create;patch
 //+kubebuilder:rbac:groups=apps,namespace=pulp-operator-system,resources=deployments;statefulsets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=policy,namespace=pulp-operator-system,resources=poddisruptionbudgets,verbs=get;list;create;delete;patch;update;watch
@@ -108,6 +109,10 @@ func (r *RepoManagerReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return *reconcile, err
 	}
 
+	if reconcile, err := databasePoolerTasks(ctx, pulp, *r); err != nil || reconcile != nil {
+		return *reconcile, err
+	}
+
 	if reconcile, err := cacheTasks(ctx, pulp, *r); err != nil || reconcile != nil {
 		return *reconcile, err
 	}
@@ -116,6 +121,13 @@ func (r *RepoManagerReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return *reconcile, err
 	}
 
+	// the pooler is removed only after pulpcore settings stopped pointing to it
+	if pulp.Spec.Database.Pooler == nil {
+		if reconcile, err := r.deprovisionDatabasePooler(ctx, pulp, log); needsRequeue(err, reconcile) {
+			return reconcile, err
+		}
+	}
+
 	log.V(1).Info("Running status tasks")
 	if reconcile := r.pulpStatus(ctx, pulp, log); reconcile != nil {
 		return *reconcile, nil
@@ -181,6 +193,20 @@ func databaseTasks(ctx context.Context, pulp *pulpv1.Pulp, r RepoManagerReconcil
 	return nil, nil
 }
 
+func databasePoolerTasks(ctx context.Context, pulp *pulpv1.Pulp, r RepoManagerReconciler) (*ctrl.Result, error) {
+	if pulp.Spec.Database.Pooler == nil {
+		return nil, nil
+	}
+
+	log := r.RawLogger
+	log.V(1).Info("Running database pooler tasks")
+	pulpController, err := r.databasePoolerController(ctx, pulp, log)
+	if needsRequeue(err, pulpController) {
+		return &pulpController, err
+	}
+	return nil, nil
+}
+
 func pulpCoreTasks(ctx context.Context, pulp *pulpv1.Pulp, r RepoManagerReconciler) (*ctrl.Result, error) {
 	log := r.RawLogger
 
@@ -354,7 +380,8 @@ func (r *RepoManagerReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	}
 
 	controller := ctrl.NewControllerManagedBy(mgr).
-		For(&pulpv1.Pulp{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// the annotations are watched to stop the database while it is recovered by a PulpRestore
+		For(&pulpv1.Pulp{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
 		Owns(&appsv1.StatefulSet{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
@@ -18,7 +18,6 @@ package repo_manager
 
 import (
 	"context"
-	"os"
 	"path/filepath"
 	"time"
 
@@ -36,6 +35,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func (r *RepoManagerReconciler) databaseController(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger) (ctrl.Result, error) {
@@ -70,11 +70,59 @@ func (r *RepoManagerReconciler) databaseController(ctx context.Context, pulp *pu
 		return ctrl.Result{}, err
 	}
 
+	// the sslmode used by pulpcore follows postgres_ssl_mode and tls
+	if sslMode := databaseSSLMode(pulp); string(pgConfigSecret.Data["sslmode"]) != sslMode {
+		log.Info("Updating the sslmode of the " + secretName + " Secret")
+		if pgConfigSecret.Data == nil {
+			pgConfigSecret.Data = map[string][]byte{}
+		}
+		pgConfigSecret.Data["sslmode"] = []byte(sslMode)
+		if err := r.Update(ctx, pgConfigSecret); err != nil {
+			log.Error(err, "Failed to update "+secretName+" Secret")
+			return ctrl.Result{}, err
+		}
+		r.recorder.Event(pulp, corev1.EventTypeNormal, "Updated", secretName+" Secret sslmode updated")
+	}
+
+	// the primary of the replicated database is recorded before the StatefulSet is reconciled
+	if pulpController, err := r.databaseHAConfig(ctx, pulp, log); needsRequeue(err, pulpController) {
+		return pulpController, err
+	}
+
+	// the tuned postgresql.conf is updated before the StatefulSet restarts the database pods
+	if err := r.reconcileDatabaseTuning(ctx, pulp, log); err != nil {
+		controllers.UpdateStatus(ctx, r.Client, pulp, metav1.ConditionFalse, conditionType, "ErrorDatabaseTuning", "Failed to reconcile the database tuning: "+err.Error())
+		return ctrl.Result{}, err
+	}
+
+	// the certificate is issued before the StatefulSet mounts it
+	tlsSecret, err := r.reconcileDatabaseTLS(ctx, pulp, log)
+	if err != nil {
+		controllers.UpdateStatus(ctx, r.Client, pulp, metav1.ConditionFalse, conditionType, "ErrorDatabaseTLS", "Failed to reconcile the database certificate: "+err.Error())
+		return ctrl.Result{}, err
+	}
+
+	// the StatefulSet is not reconciled while its database is upgraded to a new major version
+	if pulpController, err := r.upgradeDatabase(ctx, pulp, log); needsRequeue(err, pulpController) {
+		return pulpController, err
+	}
+
 	// StatefulSet
 	statefulSetName := settings.DefaultDBStatefulSet(pulp.Name)
 	pgSts := &appsv1.StatefulSet{}
 	err = r.Get(ctx, types.NamespacedName{Name: statefulSetName, Namespace: pulp.Namespace}, pgSts)
-	expected_sts := statefulSetForDatabase(pulp)
+	var walRemote *controllers.ObjectStorageRemote
+	if archive := pulp.Spec.Database.WALArchive; archive != nil && archive.ObjectStorage != nil {
+		if walRemote, err = controllers.NewObjectStorageRemote(ctx, r.Client, pulp.Namespace, archive.ObjectStorage); err != nil {
+			log.Error(err, "Invalid database wal_archive object_storage configuration")
+			controllers.UpdateStatus(ctx, r.Client, pulp, metav1.ConditionFalse, conditionType, "ErrorWALArchiveObjectStorage", "Invalid wal_archive object_storage configuration: "+err.Error())
+			return ctrl.Result{}, err
+		}
+	}
+	expected_sts := statefulSetForDatabase(pulp, walRemote)
+	if tlsSecret != nil {
+		setDatabaseTLS(expected_sts, tlsSecret)
+	}
 
 	if err != nil && errors.IsNotFound(err) {
 		log.Info("Creating a new Database StatefulSet", "StatefulSet.Namespace", pgSts.Namespace, "StatefulSet.Name", statefulSetName)
@@ -96,6 +144,16 @@ func (r *RepoManagerReconciler) databaseController(ctx context.Context, pulp *pu
 		return ctrl.Result{}, err
 	}
 
+	// the pod management policy cannot be modified, the StatefulSet is recreated without its pods
+	if pulp.Spec.Database.HighAvailability != nil && pgSts.Spec.PodManagementPolicy != appsv1.ParallelPodManagement {
+		log.Info("Recreating the " + statefulSetName + " StatefulSet to replicate the database")
+		if err := r.Delete(ctx, pgSts, client.PropagationPolicy(metav1.DeletePropagationOrphan)); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete the "+statefulSetName+" StatefulSet")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
 	// Reconcile StatefulSet
 	if !equality.Semantic.DeepDerivative(expected_sts.Spec, pgSts.Spec) {
 		log.Info("The " + statefulSetName + " StatefulSet has been modified! Reconciling ...")
@@ -116,6 +174,11 @@ func (r *RepoManagerReconciler) databaseController(ctx context.Context, pulp *pu
 		return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, nil
 	}
 
+	// promote a standby if the primary of the replicated database is unavailable
+	if pulpController, err := r.databaseFailover(ctx, pulp, log); needsRequeue(err, pulpController) {
+		return pulpController, err
+	}
+
 	// SERVICE
 	svcName := settings.DBService(pulp.Name)
 	dbSvc := &corev1.Service{}
@@ -143,7 +206,8 @@ func (r *RepoManagerReconciler) databaseController(ctx context.Context, pulp *pu
 	}
 
 	// Reconcile Service
-	if !equality.Semantic.DeepDerivative(expected_svc.Spec, dbSvc.Spec) {
+	// the selector of the primary is removed from the Service when the database replication is removed
+	if !equality.Semantic.DeepDerivative(expected_svc.Spec, dbSvc.Spec) || !equality.Semantic.DeepEqual(expected_svc.Spec.Selector, dbSvc.Spec.Selector) {
 		log.Info("The Database service has been modified! Reconciling ...")
 		controllers.UpdateStatus(ctx, r.Client, pulp, metav1.ConditionFalse, conditionType, "UpdatingDatabaseService", "Reconciling "+svcName+" Service resource")
 		r.recorder.Event(pulp, corev1.EventTypeNormal, "Updating", "Reconciling database service")
@@ -159,6 +223,11 @@ func (r *RepoManagerReconciler) databaseController(ctx context.Context, pulp *pu
 		return ctrl.Result{Requeue: true, RequeueAfter: time.Second}, nil
 	}
 
+	// reload the dump of the database into the upgraded StatefulSet
+	if pulpController, err := r.finishDatabaseUpgrade(ctx, pulp, log); needsRequeue(err, pulpController) {
+		return pulpController, err
+	}
+
 	// we should only update the status when Database-Ready==false
 	if v1.IsStatusConditionFalse(pulp.Status.Conditions, conditionType) {
 		controllers.UpdateStatus(ctx, r.Client, pulp, metav1.ConditionTrue, conditionType, "DatabaseTasksFinished", "All Database tasks ran successfully")
@@ -168,12 +237,17 @@ func (r *RepoManagerReconciler) databaseController(ctx context.Context, pulp *pu
 	return ctrl.Result{}, nil
 }
 
-// statefulSetForDatabase returns a postgresql Deployment object
-func statefulSetForDatabase(m *pulpv1.Pulp) *appsv1.StatefulSet {
+// statefulSetForDatabase returns a postgresql Deployment object.
+// walRemote is the object storage where the WAL is archived, if wal_archive object_storage is defined.
+func statefulSetForDatabase(m *pulpv1.Pulp, walRemote *controllers.ObjectStorageRemote) *appsv1.StatefulSet {
 
 	ls := labelsForDatabase(m)
 	//replicas := m.Spec.Database.Replicas
 	replicas := int32(1)
+	// the database is stopped while a PulpRestore recovers it from the WAL archive
+	if _, recovering := m.Annotations[controllers.DatabaseRecoveryAnnotation]; recovering {
+		replicas = 0
+	}
 
 	affinity := &corev1.Affinity{}
 	if m.Spec.Database.Affinity != nil {
@@ -195,12 +269,7 @@ func statefulSetForDatabase(m *pulpv1.Pulp) *appsv1.StatefulSet {
 		args = m.Spec.Database.PostgresExtraArgs
 	}
 
-	postgresDataPath := ""
-	if m.Spec.Database.PostgresDataPath != "" {
-		postgresDataPath = m.Spec.Database.PostgresDataPath
-	} else {
-		postgresDataPath = "/var/lib/postgresql/data/pgdata"
-	}
+	postgresDataPath := controllers.PostgresDataPath(m)
 
 	postgresInitdbArgs := ""
 	if m.Spec.Database.PostgresInitdbArgs != "" {
@@ -296,8 +365,19 @@ func statefulSetForDatabase(m *pulpv1.Pulp) *appsv1.StatefulSet {
 	storageClass := m.Spec.Database.PostgresStorageClass
 
 	volumeName := settings.DefaultDBPVC(m.Name)
-	// if SC defined, we should use the PVC claimed by STS
-	if storageType[0] == controllers.SCNameType {
+	// if the database was upgraded to a new major version, we should use the PVC it was moved to
+	if len(m.Status.DatabasePVC) > 0 {
+		volumes = append(volumes, corev1.Volume{
+			Name: volumeName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: m.Status.DatabasePVC,
+				},
+			},
+		})
+
+		// if SC defined, we should use the PVC claimed by STS
+	} else if storageType[0] == controllers.SCNameType {
 
 		// Temporarily while we don't find a fix for backup and json.Unmarshal issue
 		postgresStorageSize := resource.MustParse("8Gi")
@@ -389,11 +469,10 @@ func statefulSetForDatabase(m *pulpv1.Pulp) *appsv1.StatefulSet {
 		}
 	}
 
-	postgresImage := os.Getenv("RELATED_IMAGE_PULP_POSTGRES")
-	if len(m.Spec.Database.PostgresImage) > 0 {
-		postgresImage = m.Spec.Database.PostgresImage
-	} else if postgresImage == "" {
-		postgresImage = "docker.io/library/postgres:15"
+	postgresImage := controllers.PostgresImage(m)
+	// the previous major version is kept until the database is moved to a new PVC by the upgrade
+	if upgrade := m.Status.DatabaseUpgrade; upgrade != nil && upgrade.Phase != databaseUpgradeRestoring {
+		postgresImage = upgrade.FromImage
 	}
 
 	containerPort := int32(0)
@@ -403,20 +482,9 @@ func statefulSetForDatabase(m *pulpv1.Pulp) *appsv1.StatefulSet {
 		containerPort = int32(m.Spec.Database.PostgresPort)
 	}
 
-	podSecurityContext := &corev1.PodSecurityContext{}
-	if isOpenshift, _ := controllers.IsOpenShift(); !isOpenshift {
-		runAsUser := int64(999)
-		fsGroup := int64(999)
-		fsGroupChangeOnRootMismatch := corev1.FSGroupChangeOnRootMismatch
-		podSecurityContext = &corev1.PodSecurityContext{
-			RunAsUser:           &runAsUser,
-			RunAsGroup:          &fsGroup,
-			FSGroup:             &fsGroup,
-			FSGroupChangePolicy: &fsGroupChangeOnRootMismatch,
-		}
-	}
+	podSecurityContext := controllers.PostgresSecurityContext()
 
-	return &appsv1.StatefulSet{
+	sts := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      settings.DefaultDBStatefulSet(m.Name),
 			Namespace: m.Namespace,
@@ -458,6 +526,16 @@ func statefulSetForDatabase(m *pulpv1.Pulp) *appsv1.StatefulSet {
 			VolumeClaimTemplates: volumeClaimTemplate,
 		},
 	}
+	if m.Spec.Database.WALArchive != nil {
+		controllers.SetWALArchive(sts, m, walRemote)
+	}
+	if m.Spec.Database.HighAvailability != nil {
+		setDatabaseHA(sts, m)
+	}
+	if m.Spec.Database.Tuning != nil {
+		setDatabaseTuning(sts, m)
+	}
+	return sts
 }
 
 // labelsForDatabase returns the labels for selecting the resources
@@ -481,6 +559,12 @@ func serviceForDatabase(m *pulpv1.Pulp) *corev1.Service {
 	targetPort := intstr.IntOrString{IntVal: 5432}
 	serviceType := corev1.ServiceType("ClusterIP")
 
+	// the Service of the replicated database points to the primary
+	selector := labelsForDatabase(m)
+	if m.Spec.Database.HighAvailability != nil {
+		selector[databaseRoleLabel] = databaseRolePrimary
+	}
+
 	return &corev1.Service{
 
 		ObjectMeta: metav1.ObjectMeta{
@@ -499,7 +583,7 @@ func serviceForDatabase(m *pulpv1.Pulp) *corev1.Service {
 				Protocol:   servicePortProto,
 				TargetPort: targetPort,
 			}},
-			Selector:        labelsForDatabase(m),
+			Selector:        selector,
 			SessionAffinity: serviceAffinity,
 			Type:            serviceType,
 		},
@@ -509,13 +593,6 @@ func serviceForDatabase(m *pulpv1.Pulp) *corev1.Service {
 // pulp-postgres-configuration secret
 func databaseConfigSecret(m *pulpv1.Pulp) *corev1.Secret {
 
-	sslMode := ""
-	if m.Spec.Database.PostgresSSLMode == "" {
-		sslMode = "prefer"
-	} else {
-		sslMode = m.Spec.Database.PostgresSSLMode
-	}
-
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      settings.DefaultDBSecret(m.Name),
@@ -528,7 +605,7 @@ func databaseConfigSecret(m *pulpv1.Pulp) *corev1.Secret {
 			"database": "pulp",
 			"port":     "5432",
 			"host":     m.Name + "-database-svc",
-			"sslmode":  sslMode,
+			"sslmode":  databaseSSLMode(m),
 			"type":     "managed",
 		},
 	}
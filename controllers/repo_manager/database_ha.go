@@ -0,0 +1,600 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// databaseRoleLabel is the label the operator maintains on the database pods with their replication
+	// role. The database Service selects the primary.
+	databaseRoleLabel   = "repo-manager.pulpproject.org/database-role"
+	databaseRolePrimary = "primary"
+	databaseRoleStandby = "standby"
+
+	// databaseHAVolume is the ConfigMap, mounted in the database pods, with the name of the primary pod
+	// and the pg_hba.conf allowing the replication connections
+	databaseHAVolume    = "database-ha"
+	databaseHAMountPath = "/etc/pulp-database-ha"
+
+	defaultDatabaseReplicas        = int32(2)
+	defaultDatabaseFailoverTimeout = int32(30)
+	databaseFailoverRequeue        = 5 * time.Second
+)
+
+// databaseExec runs a command in the postgres container of a database pod
+var databaseExec = func(ctx context.Context, r *RepoManagerReconciler, pod *corev1.Pod, command []string) (string, error) {
+	return controllers.ContainerExec(ctx, r, pod, command, "postgres", pod.Namespace)
+}
+
+// databaseHAConfigMapName returns the name of the ConfigMap with the name of the primary database pod
+func databaseHAConfigMapName(pulpName string) string {
+	return settings.DefaultDBStatefulSet(pulpName) + "-ha"
+}
+
+// firstDatabasePod returns the name of the first pod of the database StatefulSet, the primary of a new
+// replicated database and the pod kept when high_availability is removed
+func firstDatabasePod(pulpName string) string {
+	return settings.DefaultDBStatefulSet(pulpName) + "-0"
+}
+
+// databaseHAConfig records the primary of the replicated database and reconciles the ConfigMap read by the
+// database pods when they start, and the PDB of the database pods.
+// When high_availability is removed, the first pod (the one kept when the StatefulSet is scaled down) is
+// promoted before the replication resources are removed.
+func (r *RepoManagerReconciler) databaseHAConfig(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger) (ctrl.Result, error) {
+	firstPod := firstDatabasePod(pulp.Name)
+	if pulp.Spec.Database.HighAvailability == nil {
+		if pulp.Status.DatabaseHA == nil {
+			return ctrl.Result{}, nil
+		}
+		if pulp.Status.DatabaseHA.Primary != firstPod || pulp.Status.DatabaseHA.Promoting {
+			return r.switchoverDatabase(ctx, pulp, firstPod, log)
+		}
+		log.Info("Removing the replication of the database")
+		for _, obj := range []client.Object{
+			&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: databaseHAConfigMapName(pulp.Name), Namespace: pulp.Namespace}},
+			&policy.PodDisruptionBudget{ObjectMeta: metav1.ObjectMeta{Name: settings.DATABASE.PDBName(pulp.Name), Namespace: pulp.Namespace}},
+		} {
+			if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+				log.Error(err, "Failed to remove "+obj.GetName())
+				return ctrl.Result{}, err
+			}
+		}
+		pulp.Status.DatabaseHA = nil
+		if err := r.Status().Update(ctx, pulp); err != nil {
+			log.Error(err, "Failed to remove the database replication from Pulp status")
+			return ctrl.Result{}, err
+		}
+		r.recorder.Event(pulp, corev1.EventTypeNormal, "Deleted", "Database replication removed")
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	if pulp.Status.DatabaseHA == nil {
+		pulp.Status.DatabaseHA = &pulpv1.DatabaseHAStatus{Primary: firstPod}
+		if err := r.Status().Update(ctx, pulp); err != nil {
+			log.Error(err, "Failed to record the database primary in Pulp status")
+			return ctrl.Result{}, err
+		}
+	}
+	if err := r.reconcileDatabaseHAConfigMap(ctx, pulp, log); err != nil {
+		return ctrl.Result{}, err
+	}
+	return r.reconcileDatabasePDB(ctx, pulp, log)
+}
+
+// databaseFailover labels the database pods with their role, promotes a standby if the primary pod is
+// unavailable for longer than the failover timeout, and finishes the promotion of the new primary
+func (r *RepoManagerReconciler) databaseFailover(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger) (ctrl.Result, error) {
+	if pulp.Spec.Database.HighAvailability == nil {
+		return ctrl.Result{}, nil
+	}
+	// the database is stopped while a PulpRestore recovers it
+	if _, recovering := pulp.Annotations[controllers.DatabaseRecoveryAnnotation]; recovering {
+		return ctrl.Result{}, nil
+	}
+
+	pods, err := r.databasePods(ctx, pulp, log)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// the pods recreated by the StatefulSet do not have the role label
+	if err := r.labelDatabasePods(ctx, pulp, pods, log); err != nil {
+		return ctrl.Result{}, err
+	}
+	status := pulp.Status.DatabaseHA
+	if primary, found := pods[status.Primary]; found && podReady(primary) {
+		if status.PrimaryUnavailableSince != nil {
+			status.PrimaryUnavailableSince = nil
+			if err := r.Status().Update(ctx, pulp); err != nil {
+				log.Error(err, "Failed to update the database replication in Pulp status")
+				return ctrl.Result{}, err
+			}
+		}
+		if status.Promoting {
+			return r.promoteDatabase(ctx, pulp, pods, log)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// the primary restarted by a rolling update of the StatefulSet is not replaced by a standby
+	if restarting, err := r.databasePrimaryRestarting(ctx, pulp, pods[status.Primary], log); err != nil {
+		return ctrl.Result{}, err
+	} else if restarting {
+		log.Info("Waiting for the database StatefulSet update to restart the primary", "Pod", status.Primary)
+		if status.PrimaryUnavailableSince != nil {
+			status.PrimaryUnavailableSince = nil
+			if err := r.Status().Update(ctx, pulp); err != nil {
+				log.Error(err, "Failed to update the database replication in Pulp status")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: databaseFailoverRequeue}, nil
+	}
+
+	if status.PrimaryUnavailableSince == nil {
+		now := metav1.Now()
+		status.PrimaryUnavailableSince = &now
+		log.Info("The database primary is unavailable", "Pod", status.Primary)
+		r.setDatabaseHACondition(ctx, pulp, "DatabasePrimaryUnavailable", "The database primary "+status.Primary+" is unavailable")
+	}
+	if wait := time.Until(status.PrimaryUnavailableSince.Add(databaseFailoverTimeout(pulp))); wait > 0 {
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	standby := r.failoverCandidate(ctx, pulp, pods, log)
+	if len(standby) == 0 {
+		log.Info("No database standby available to replace the primary", "Pod", status.Primary)
+		r.setDatabaseHACondition(ctx, pulp, "NoDatabaseStandbyAvailable", "The database primary "+status.Primary+" is unavailable and no standby is ready to replace it")
+		return ctrl.Result{RequeueAfter: databaseFailoverRequeue}, nil
+	}
+	r.recorder.Event(pulp, corev1.EventTypeWarning, "DatabaseFailover", "The database primary "+status.Primary+" is unavailable since "+status.PrimaryUnavailableSince.Format(time.RFC3339)+", promoting the standby "+standby)
+	return r.setDatabasePrimary(ctx, pulp, pods, standby, log)
+}
+
+// databaseFailoverTimeout returns the time the primary can be unavailable before a standby is promoted
+func databaseFailoverTimeout(pulp *pulpv1.Pulp) time.Duration {
+	if ha := pulp.Spec.Database.HighAvailability; ha != nil && ha.FailoverTimeout > 0 {
+		return time.Duration(ha.FailoverTimeout) * time.Second
+	}
+	return time.Duration(defaultDatabaseFailoverTimeout) * time.Second
+}
+
+// databasePrimaryRestarting returns true if the primary pod is being replaced by a rolling update of the
+// database StatefulSet, which is the case until a pod of the updated revision is running for the primary.
+// A primary which is not ready with the updated revision (a wrong image, for example) is replaced by a
+// standby after the failover timeout.
+func (r *RepoManagerReconciler) databasePrimaryRestarting(ctx context.Context, pulp *pulpv1.Pulp, primary *corev1.Pod, log logr.Logger) (bool, error) {
+	sts := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: settings.DefaultDBStatefulSet(pulp.Name), Namespace: pulp.Namespace}, sts); errors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get the database StatefulSet")
+		return false, err
+	}
+	if len(sts.Status.UpdateRevision) == 0 || sts.Status.UpdateRevision == sts.Status.CurrentRevision {
+		return false, nil
+	}
+	return primary == nil || primary.DeletionTimestamp != nil || primary.Labels[appsv1.StatefulSetRevisionLabel] != sts.Status.UpdateRevision, nil
+}
+
+// switchoverDatabase promotes the standby running in pod, the previous primary is restarted as a standby
+func (r *RepoManagerReconciler) switchoverDatabase(ctx context.Context, pulp *pulpv1.Pulp, pod string, log logr.Logger) (ctrl.Result, error) {
+	pods, err := r.databasePods(ctx, pulp, log)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if standby, found := pods[pod]; !found || !podReady(standby) {
+		log.Info("Waiting for the database standby to be ready to switch over", "Pod", pod)
+		r.setDatabaseHACondition(ctx, pulp, "DatabaseSwitchover", "Waiting for the database standby "+pod+" to be ready to replace the primary "+pulp.Status.DatabaseHA.Primary)
+		return ctrl.Result{RequeueAfter: databaseFailoverRequeue}, nil
+	}
+	if pulp.Status.DatabaseHA.Primary != pod {
+		r.recorder.Event(pulp, corev1.EventTypeNormal, "DatabaseSwitchover", "Switching the database primary over from "+pulp.Status.DatabaseHA.Primary+" to "+pod)
+		return r.setDatabasePrimary(ctx, pulp, pods, pod, log)
+	}
+	return r.promoteDatabase(ctx, pulp, pods, log)
+}
+
+// setDatabasePrimary records pod as the new primary, points the database Service to it, and deletes the
+// previous primary pod so that it restarts as a standby of the new primary. The standby is promoted once
+// the previous primary has stopped.
+func (r *RepoManagerReconciler) setDatabasePrimary(ctx context.Context, pulp *pulpv1.Pulp, pods map[string]*corev1.Pod, pod string, log logr.Logger) (ctrl.Result, error) {
+	status := pulp.Status.DatabaseHA
+	previous := status.Primary
+	now := metav1.Now()
+	status.Primary = pod
+	status.Promoting = true
+	status.PrimaryUnavailableSince = nil
+	status.LastFailoverTime = &now
+	log.Info("Promoting the database standby", "Pod", pod, "PreviousPrimary", previous)
+	r.setDatabaseHACondition(ctx, pulp, "PromotingDatabaseStandby", "Promoting the database standby "+pod+" to replace the primary "+previous)
+	if err := r.Status().Update(ctx, pulp); err != nil {
+		log.Error(err, "Failed to record the database primary in Pulp status")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileDatabaseHAConfigMap(ctx, pulp, log); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.labelDatabasePods(ctx, pulp, pods, log); err != nil {
+		return ctrl.Result{}, err
+	}
+	if previousPod, found := pods[previous]; found {
+		if err := r.Delete(ctx, previousPod); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete the previous database primary", "Pod", previous)
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: databaseFailoverRequeue}, nil
+}
+
+// promoteDatabase promotes the standby recorded as the primary once the terminating database pods are
+// gone, so that the previous primary, which sends its last WAL to the standbys while it stops, does not
+// accept writes anymore.
+// The pod of a primary on an unreachable node stays terminating until the node is back or removed. It is
+// not waited for longer than the failover timeout after its grace period: if the node is only partitioned,
+// the previous primary could still be running, but a terminating pod is not an endpoint of the database
+// Service anymore, only the clients connected to it before could still write to it.
+func (r *RepoManagerReconciler) promoteDatabase(ctx context.Context, pulp *pulpv1.Pulp, pods map[string]*corev1.Pod, log logr.Logger) (ctrl.Result, error) {
+	status := pulp.Status.DatabaseHA
+	for name, pod := range pods {
+		if pod.DeletionTimestamp == nil {
+			continue
+		}
+		if time.Since(pod.DeletionTimestamp.Time) < databaseFailoverTimeout(pulp) {
+			log.Info("Waiting for the previous database primary to stop", "Pod", name)
+			return ctrl.Result{RequeueAfter: databaseFailoverRequeue}, nil
+		}
+		log.Info("The previous database primary did not stop, its node may be unreachable", "Pod", name)
+		r.recorder.Event(pulp, corev1.EventTypeWarning, "DatabasePrimaryNotStopped", "The database pod "+name+" is still terminating, its node may be unreachable. Promoting the standby "+status.Primary+" anyway")
+	}
+	if err := r.labelDatabasePods(ctx, pulp, pods, log); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	output, err := databaseExec(ctx, r, pods[status.Primary], []string{"bash", "-c", databasePSQL + `"SELECT CASE WHEN pg_is_in_recovery() THEN pg_promote() ELSE true END"`})
+	if err != nil || output != "t" {
+		log.Error(err, "Failed to promote the database standby", "Pod", status.Primary, "Output", output)
+		r.setDatabaseHACondition(ctx, pulp, "ErrorPromotingDatabaseStandby", fmt.Sprintf("Failed to promote the database standby %s: %v %s", status.Primary, err, output))
+		return ctrl.Result{RequeueAfter: databaseFailoverRequeue}, nil
+	}
+	status.Promoting = false
+	if err := r.Status().Update(ctx, pulp); err != nil {
+		log.Error(err, "Failed to update the database replication in Pulp status")
+		return ctrl.Result{}, err
+	}
+	log.Info("Database standby promoted", "Pod", status.Primary)
+	r.recorder.Event(pulp, corev1.EventTypeNormal, "DatabasePromoted", "The database standby "+status.Primary+" is the new primary")
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// databasePSQL runs a query in the database of the pod
+const databasePSQL = `psql -U "$POSTGRES_USER" -d "$POSTGRES_DB" -tAc `
+
+// failoverCandidate returns the ready standby which received the most WAL from the primary
+func (r *RepoManagerReconciler) failoverCandidate(ctx context.Context, pulp *pulpv1.Pulp, pods map[string]*corev1.Pod, log logr.Logger) string {
+	names := []string{}
+	for name := range pods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	candidate, candidateLSN := "", uint64(0)
+	for _, name := range names {
+		pod := pods[name]
+		if name == pulp.Status.DatabaseHA.Primary || pod.DeletionTimestamp != nil || !podReady(pod) {
+			continue
+		}
+		// a standby which was not streaming from the primary has only replayed the WAL
+		output, err := databaseExec(ctx, r, pod, []string{"bash", "-c", databasePSQL + `"SELECT COALESCE(pg_last_wal_receive_lsn(), pg_last_wal_replay_lsn())"`})
+		if err != nil {
+			log.Error(err, "Failed to get the WAL position of the database standby", "Pod", name)
+			continue
+		}
+		lsn, err := parseLSN(output)
+		if err != nil {
+			log.Info("The database pod is not a standby", "Pod", name, "Output", output)
+			continue
+		}
+		if len(candidate) == 0 || lsn > candidateLSN {
+			candidate, candidateLSN = name, lsn
+		}
+	}
+	return candidate
+}
+
+// parseLSN returns the position in the WAL of a PostgreSQL LSN, like 16/B374D848
+func parseLSN(lsn string) (uint64, error) {
+	high, low, found := strings.Cut(strings.TrimSpace(lsn), "/")
+	if !found {
+		return 0, fmt.Errorf("invalid LSN %q", lsn)
+	}
+	h, err := strconv.ParseUint(high, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q: %v", lsn, err)
+	}
+	l, err := strconv.ParseUint(low, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q: %v", lsn, err)
+	}
+	return h<<32 | l, nil
+}
+
+// databasePods returns the database pods indexed by name
+func (r *RepoManagerReconciler) databasePods(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger) (map[string]*corev1.Pod, error) {
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(pulp.Namespace), client.MatchingLabels(labelsForDatabase(pulp))); err != nil {
+		log.Error(err, "Failed to list the database pods")
+		return nil, err
+	}
+	pods := map[string]*corev1.Pod{}
+	for i := range podList.Items {
+		pods[podList.Items[i].Name] = &podList.Items[i]
+	}
+	return pods, nil
+}
+
+// labelDatabasePods sets the role label of the database pods, the database Service selects the primary
+func (r *RepoManagerReconciler) labelDatabasePods(ctx context.Context, pulp *pulpv1.Pulp, pods map[string]*corev1.Pod, log logr.Logger) error {
+	for name, pod := range pods {
+		role := databaseRoleStandby
+		if name == pulp.Status.DatabaseHA.Primary {
+			role = databaseRolePrimary
+		}
+		if pod.DeletionTimestamp != nil || pod.Labels[databaseRoleLabel] == role {
+			continue
+		}
+		patch := client.MergeFrom(pod.DeepCopy())
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		pod.Labels[databaseRoleLabel] = role
+		if err := r.Patch(ctx, pod, patch); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to label the database pod", "Pod", name, "Role", role)
+			return err
+		}
+	}
+	return nil
+}
+
+// podReady returns true if the pod is ready
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// setDatabaseHACondition sets the Pulp-Database-Ready condition to false with the state of the replication
+func (r *RepoManagerReconciler) setDatabaseHACondition(ctx context.Context, pulp *pulpv1.Pulp, reason, message string) {
+	v1.SetStatusCondition(&pulp.Status.Conditions, metav1.Condition{
+		Type:    "Pulp-Database-Ready",
+		Status:  metav1.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	})
+	if err := r.Status().Update(ctx, pulp); err != nil {
+		r.RawLogger.Error(err, "Failed to update the database replication in Pulp status")
+	}
+}
+
+// reconcileDatabaseHAConfigMap creates or updates the ConfigMap with the name of the primary pod
+func (r *RepoManagerReconciler) reconcileDatabaseHAConfigMap(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger) error {
+	expected := databaseHAConfigMap(pulp)
+	ctrl.SetControllerReference(pulp, expected, r.Scheme)
+	found := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: expected.Name, Namespace: pulp.Namespace}, found)
+	if errors.IsNotFound(err) {
+		log.Info("Creating a new " + expected.Name + " ConfigMap")
+		if err := r.Create(ctx, expected); err != nil {
+			log.Error(err, "Failed to create "+expected.Name+" ConfigMap")
+			return err
+		}
+		r.recorder.Event(pulp, corev1.EventTypeNormal, "Created", expected.Name+" ConfigMap created")
+		return nil
+	} else if err != nil {
+		log.Error(err, "Failed to get "+expected.Name+" ConfigMap")
+		return err
+	}
+	if !reflect.DeepEqual(expected.Data, found.Data) {
+		log.Info("The " + expected.Name + " ConfigMap has been modified! Reconciling ...")
+		found.Data = expected.Data
+		if err := r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update "+expected.Name+" ConfigMap")
+			return err
+		}
+	}
+	return nil
+}
+
+// databaseHAConfigMap returns the ConfigMap with the name of the primary pod and the pg_hba.conf of the
+// database pods, which allows the replication connections of the standbys
+func databaseHAConfigMap(pulp *pulpv1.Pulp) *corev1.ConfigMap {
+	authMethod := "scram-sha-256"
+	if pulp.Spec.Database.PostgresHostAuthMethod != "" {
+		authMethod = pulp.Spec.Database.PostgresHostAuthMethod
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      databaseHAConfigMapName(pulp.Name),
+			Namespace: pulp.Namespace,
+			Labels:    labelsForDatabase(pulp),
+		},
+		Data: map[string]string{
+			"primary": pulp.Status.DatabaseHA.Primary,
+			"pg_hba.conf": `local all all trust
+host all all 127.0.0.1/32 trust
+host all all ::1/128 trust
+local replication all trust
+host replication all 127.0.0.1/32 trust
+host replication all ::1/128 trust
+host all all all ` + authMethod + `
+host replication all all ` + authMethod + `
+`,
+		},
+	}
+}
+
+// reconcileDatabasePDB creates or updates the PDB of the database pods
+func (r *RepoManagerReconciler) reconcileDatabasePDB(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger) (ctrl.Result, error) {
+	pdbName := settings.DATABASE.PDBName(pulp.Name)
+	maxUnavailable := intstr.FromInt32(1)
+	pdbSpec := policy.PodDisruptionBudgetSpec{MaxUnavailable: &maxUnavailable}
+	if pdb := pulp.Spec.Database.HighAvailability.PDB; pdb != nil && !reflect.DeepEqual(pdb, &policy.PodDisruptionBudgetSpec{}) {
+		pdbSpec = *pdb.DeepCopy()
+	}
+	// the selector is always the one of the database pods
+	pdbSpec.Selector = &metav1.LabelSelector{MatchLabels: labelsForDatabase(pulp)}
+	expectedPDB := &policy.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pdbName,
+			Namespace: pulp.Namespace,
+			Labels:    labelsForDatabase(pulp),
+		},
+		Spec: pdbSpec,
+	}
+	ctrl.SetControllerReference(pulp, expectedPDB, r.Scheme)
+
+	pdbFound := &policy.PodDisruptionBudget{}
+	err := r.Get(ctx, types.NamespacedName{Name: pdbName, Namespace: pulp.Namespace}, pdbFound)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new " + pdbName + " PDB ...")
+		if err := r.Create(ctx, expectedPDB); err != nil {
+			log.Error(err, "Failed to create new "+pdbName+" PDB")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get "+pdbName+" PDB")
+		return ctrl.Result{}, err
+	}
+	if !equality.Semantic.DeepDerivative(expectedPDB.Spec, pdbFound.Spec) {
+		log.Info("The " + pdbName + " PDB has been modified! Reconciling ...")
+		expectedPDB.SetResourceVersion(pdbFound.GetResourceVersion())
+		if err := r.Update(ctx, expectedPDB); err != nil {
+			log.Error(err, "Error trying to update the "+pdbName+" PDB object ... ")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true, RequeueAfter: time.Second}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// setDatabaseHA configures the database StatefulSet with the replicas of high_availability.
+// The pods start through a script which, unless the pod is the primary recorded in the ConfigMap or it
+// already holds a standby, clones the primary with pg_basebackup. The standbys stream the WAL from the
+// database Service, so they follow the primary promoted by a failover.
+func setDatabaseHA(sts *appsv1.StatefulSet, pulp *pulpv1.Pulp) {
+	ha := pulp.Spec.Database.HighAvailability
+	// the database is stopped while a PulpRestore recovers it
+	if *sts.Spec.Replicas > 0 {
+		replicas := defaultDatabaseReplicas
+		if ha.Replicas > 0 {
+			replicas = ha.Replicas
+		}
+		sts.Spec.Replicas = &replicas
+	}
+	// a standby should not wait for the previous pods, which could be waiting for the primary
+	sts.Spec.PodManagementPolicy = appsv1.ParallelPodManagement
+
+	podSpec := &sts.Spec.Template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: databaseHAVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: databaseHAConfigMapName(pulp.Name)},
+			},
+		},
+	})
+
+	// the WAL needed by a standby which is catching up is kept by the primary
+	walKeep := "wal_keep_size=1GB"
+	if version, _ := strconv.Atoi(controllers.PostgresImageMajorVersion(pulp)); version > 0 && version < 13 {
+		walKeep = "wal_keep_segments=64"
+	}
+	postgres := &podSpec.Containers[0]
+	postgres.Command = []string{"bash", "-c", databaseHAScript(pulp), "postgres-ha"}
+	postgres.Args = append(postgres.Args, "-c", "hba_file="+databaseHAMountPath+"/pg_hba.conf", "-c", walKeep)
+	postgres.VolumeMounts = append(postgres.VolumeMounts, corev1.VolumeMount{
+		Name:      databaseHAVolume,
+		MountPath: databaseHAMountPath,
+		ReadOnly:  true,
+	})
+	// cloning the primary can take longer than the liveness probe allows
+	postgres.StartupProbe = &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"/bin/sh", "-i", "-c", "pg_isready -U pulp -h 127.0.0.1 -p 5432"},
+			},
+		},
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 360,
+		SuccessThreshold: 1,
+	}
+}
+
+// databaseHAScript returns the script starting postgres in the database pods with high_availability
+func databaseHAScript(pulp *pulpv1.Pulp) string {
+	// the standbys verify the certificate of the primary, pg_basebackup records the sslmode in primary_conninfo
+	sslEnv := ""
+	if pulp.Spec.Database.TLS != nil {
+		sslEnv = "PGSSLMODE=verify-full PGSSLROOTCERT=" + databaseTLSMountPath + "/ca.crt "
+	}
+	return `set -e
+primary=$(cat ` + databaseHAMountPath + `/primary)
+if [ -z "$primary" ]; then
+  echo "The primary of the database is not defined"
+  exit 1
+fi
+if [ "$HOSTNAME" != "$primary" ] && [ ! -f "$PGDATA/standby.signal" ]; then
+  echo "Cloning the database from the primary $primary"
+  until pg_isready -q -h ` + settings.DBService(pulp.Name) + ` -p 5432; do
+    sleep 2
+  done
+  rm -rf "$PGDATA"
+  ` + sslEnv + `PGPASSWORD="$POSTGRES_PASSWORD" pg_basebackup -h ` + settings.DBService(pulp.Name) + ` -p 5432 -U "$POSTGRES_USER" -D "$PGDATA" -X stream -R -c fast
+fi
+exec docker-entrypoint.sh postgres "$@"
+`
+}
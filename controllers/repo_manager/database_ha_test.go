@@ -0,0 +1,394 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newHAReconciler returns a reconciler with a replicated database whose primary is pulp-database-<primary>
+// and the database pods, ready or not
+func newHAReconciler(t *testing.T, spec *pulpv1.DatabaseHighAvailability, primary string, ready map[string]bool) (*RepoManagerReconciler, *pulpv1.Pulp) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = policy.AddToScheme(scheme)
+	_ = pulpv1.AddToScheme(scheme)
+
+	pulp := &pulpv1.Pulp{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+		Spec:       pulpv1.PulpSpec{Database: pulpv1.Database{HighAvailability: spec}},
+	}
+	if len(primary) > 0 {
+		pulp.Status.DatabaseHA = &pulpv1.DatabaseHAStatus{Primary: primary}
+	}
+	objects := []client.Object{pulp}
+	for name, isReady := range ready {
+		status := corev1.ConditionFalse
+		if isReady {
+			status = corev1.ConditionTrue
+		}
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test-namespace", Labels: labelsForDatabasePods(pulp)},
+			Status:     corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}},
+		})
+	}
+	r := &RepoManagerReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).WithStatusSubresource(pulp).Build(),
+		RawLogger: logr.Discard(),
+		Scheme:    scheme,
+		recorder:  record.NewFakeRecorder(20),
+	}
+	return r, pulp
+}
+
+// fakeDatabaseExec replaces the commands run in the database pods with the WAL position of the standbys
+// and the promotion of the primary, it returns the pods promoted
+func fakeDatabaseExec(t *testing.T, lsn map[string]string) *[]string {
+	promoted := &[]string{}
+	previous := databaseExec
+	databaseExec = func(ctx context.Context, r *RepoManagerReconciler, pod *corev1.Pod, command []string) (string, error) {
+		if strings.Contains(command[2], "pg_promote()") {
+			*promoted = append(*promoted, pod.Name)
+			return "t", nil
+		}
+		return lsn[pod.Name], nil
+	}
+	t.Cleanup(func() { databaseExec = previous })
+	return promoted
+}
+
+// podRole returns the role label of the database pod, or "deleted"
+func podRole(t *testing.T, r *RepoManagerReconciler, name string) string {
+	t.Helper()
+	pod := &corev1.Pod{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: "test-namespace"}, pod); errors.IsNotFound(err) {
+		return "deleted"
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return pod.Labels[databaseRoleLabel]
+}
+
+// TestDatabaseHAStatefulSet verifies the StatefulSet and Service of a replicated database
+func TestDatabaseHAStatefulSet(t *testing.T) {
+	storageClass := "standard"
+	pulp := &pulpv1.Pulp{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+		Spec: pulpv1.PulpSpec{Database: pulpv1.Database{
+			PostgresImage:        "docker.io/library/postgres:15",
+			PostgresExtraArgs:    []string{"-c", "max_connections=500"},
+			PostgresStorageClass: &storageClass,
+			HighAvailability:     &pulpv1.DatabaseHighAvailability{Replicas: 3},
+		}},
+	}
+	sts := statefulSetForDatabase(pulp, nil)
+	if *sts.Spec.Replicas != 3 || sts.Spec.PodManagementPolicy != appsv1.ParallelPodManagement || len(sts.Spec.VolumeClaimTemplates) != 1 {
+		t.Errorf("expected 3 replicas started in parallel, got %v %v", *sts.Spec.Replicas, sts.Spec.PodManagementPolicy)
+	}
+	postgres := sts.Spec.Template.Spec.Containers[0]
+	if !strings.Contains(postgres.Command[2], "pg_basebackup -h pulp-database-svc") || postgres.StartupProbe == nil {
+		t.Errorf("expected the standbys to clone the primary, got %v", postgres.Command)
+	}
+	expectedArgs := []string{"-c", "max_connections=500", "-c", "hba_file=/etc/pulp-database-ha/pg_hba.conf", "-c", "wal_keep_size=1GB"}
+	if strings.Join(postgres.Args, " ") != strings.Join(expectedArgs, " ") {
+		t.Errorf("expected args %v, got %v", expectedArgs, postgres.Args)
+	}
+	volumes := sts.Spec.Template.Spec.Volumes
+	if volume := volumes[len(volumes)-1]; volume.ConfigMap == nil || volume.ConfigMap.Name != "pulp-database-ha" {
+		t.Errorf("expected the ConfigMap with the primary to be mounted, got %+v", volume)
+	}
+	if selector := serviceForDatabase(pulp).Spec.Selector; selector[databaseRoleLabel] != databaseRolePrimary {
+		t.Errorf("expected the Service to select the primary, got %v", selector)
+	}
+
+	// the database is stopped while it is recovered
+	pulp.Annotations = map[string]string{"repo-manager.pulpproject.org/database-recovery": "true"}
+	if replicas := *statefulSetForDatabase(pulp, nil).Spec.Replicas; replicas != 0 {
+		t.Errorf("expected the database to be stopped, got %v replicas", replicas)
+	}
+
+	pulp.Spec.Database.HighAvailability = nil
+	if _, found := serviceForDatabase(pulp).Spec.Selector[databaseRoleLabel]; found {
+		t.Error("expected the Service of a database without replicas to select all its pods")
+	}
+}
+
+// TestDatabaseFailover verifies that the most up to date standby is promoted once the primary is unavailable
+// for longer than the failover timeout
+func TestDatabaseFailover(t *testing.T) {
+	ctx := context.TODO()
+	r, pulp := newHAReconciler(t, &pulpv1.DatabaseHighAvailability{FailoverTimeout: 60}, "", map[string]bool{
+		"pulp-database-0": false,
+		"pulp-database-1": true,
+		"pulp-database-2": true,
+	})
+	promoted := fakeDatabaseExec(t, map[string]string{"pulp-database-1": "0/3000000", "pulp-database-2": "0/3000060"})
+
+	if _, err := r.databaseHAConfig(ctx, pulp, r.RawLogger); err != nil {
+		t.Fatal(err)
+	}
+	cm := &corev1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pulp-database-ha", Namespace: "test-namespace"}, cm); err != nil || cm.Data["primary"] != "pulp-database-0" {
+		t.Fatalf("expected pulp-database-0 to be the primary: %v", err)
+	}
+	if !strings.Contains(cm.Data["pg_hba.conf"], "host replication all all scram-sha-256") {
+		t.Errorf("expected the replication connections to be allowed, got %v", cm.Data["pg_hba.conf"])
+	}
+	pdb := &policy.PodDisruptionBudget{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pulp-database", Namespace: "test-namespace"}, pdb); err != nil || pdb.Spec.MaxUnavailable.IntValue() != 1 {
+		t.Errorf("expected a PDB with maxUnavailable 1: %v", err)
+	}
+
+	// the primary is unavailable for less than the failover timeout
+	result, err := r.databaseFailover(ctx, pulp, r.RawLogger)
+	if err != nil || result.RequeueAfter <= 50*time.Second || pulp.Status.DatabaseHA.PrimaryUnavailableSince == nil {
+		t.Fatalf("expected the failover to wait for the timeout, got %+v %v", result, err)
+	}
+	if podRole(t, r, "pulp-database-0") != databaseRolePrimary || len(*promoted) > 0 {
+		t.Error("expected no failover before the timeout")
+	}
+
+	// the failover timeout is over
+	since := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	pulp.Status.DatabaseHA.PrimaryUnavailableSince = &since
+	if _, err := r.databaseFailover(ctx, pulp, r.RawLogger); err != nil {
+		t.Fatal(err)
+	}
+	if pulp.Status.DatabaseHA.Primary != "pulp-database-2" || !pulp.Status.DatabaseHA.Promoting || pulp.Status.DatabaseHA.LastFailoverTime == nil {
+		t.Fatalf("expected the most up to date standby to be promoted, got %+v", pulp.Status.DatabaseHA)
+	}
+	for name, role := range map[string]string{"pulp-database-0": "deleted", "pulp-database-1": databaseRoleStandby, "pulp-database-2": databaseRolePrimary} {
+		if got := podRole(t, r, name); got != role {
+			t.Errorf("expected %v to be %v, got %v", name, role, got)
+		}
+	}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pulp-database-ha", Namespace: "test-namespace"}, cm); err != nil || cm.Data["primary"] != "pulp-database-2" {
+		t.Errorf("expected the ConfigMap to record the new primary: %v", err)
+	}
+
+	// the standby is promoted
+	if _, err := r.databaseFailover(ctx, pulp, r.RawLogger); err != nil {
+		t.Fatal(err)
+	}
+	if len(*promoted) != 1 || (*promoted)[0] != "pulp-database-2" || pulp.Status.DatabaseHA.Promoting {
+		t.Errorf("expected pulp-database-2 to be promoted, got %v", *promoted)
+	}
+	if result, err := r.databaseFailover(ctx, pulp, r.RawLogger); err != nil || needsRequeue(err, result) || len(*promoted) != 1 {
+		t.Errorf("expected nothing to do once the standby is promoted, got %+v %v", result, err)
+	}
+}
+
+// TestDatabaseFailoverWithoutStandby verifies that the primary is kept if no standby is ready
+func TestDatabaseFailoverWithoutStandby(t *testing.T) {
+	ctx := context.TODO()
+	r, pulp := newHAReconciler(t, &pulpv1.DatabaseHighAvailability{}, "pulp-database-0", map[string]bool{
+		"pulp-database-0": false,
+		"pulp-database-1": false,
+	})
+	// a pod which is not in recovery has no WAL position
+	promoted := fakeDatabaseExec(t, map[string]string{"pulp-database-1": ""})
+	since := metav1.NewTime(time.Now().Add(-time.Minute))
+	pulp.Status.DatabaseHA.PrimaryUnavailableSince = &since
+
+	result, err := r.databaseFailover(ctx, pulp, r.RawLogger)
+	if err != nil || result.RequeueAfter != databaseFailoverRequeue {
+		t.Fatalf("expected the failover to be retried, got %+v %v", result, err)
+	}
+	if pulp.Status.DatabaseHA.Primary != "pulp-database-0" || len(*promoted) > 0 {
+		t.Errorf("expected the primary to be kept, got %+v", pulp.Status.DatabaseHA)
+	}
+	if podRole(t, r, "pulp-database-0") != databaseRolePrimary || podRole(t, r, "pulp-database-1") != databaseRoleStandby {
+		t.Error("expected the pods to be labeled with their role")
+	}
+}
+
+// TestDatabaseFailoverDuringUpdate verifies that the primary restarted by a rolling update of the
+// StatefulSet is not replaced, unless it is not ready once updated
+func TestDatabaseFailoverDuringUpdate(t *testing.T) {
+	ctx := context.TODO()
+	r, pulp := newHAReconciler(t, &pulpv1.DatabaseHighAvailability{}, "pulp-database-0", map[string]bool{
+		"pulp-database-0": false,
+		"pulp-database-1": true,
+	})
+	promoted := fakeDatabaseExec(t, map[string]string{"pulp-database-1": "0/3000000"})
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp-database", Namespace: "test-namespace"},
+		Status:     appsv1.StatefulSetStatus{CurrentRevision: "pulp-database-1", UpdateRevision: "pulp-database-2"},
+	}
+	if err := r.Create(ctx, sts); err != nil {
+		t.Fatal(err)
+	}
+	since := metav1.NewTime(time.Now().Add(-time.Minute))
+	pulp.Status.DatabaseHA.PrimaryUnavailableSince = &since
+
+	result, err := r.databaseFailover(ctx, pulp, r.RawLogger)
+	if err != nil || result.RequeueAfter != databaseFailoverRequeue {
+		t.Fatalf("expected the failover to wait for the update, got %+v %v", result, err)
+	}
+	if pulp.Status.DatabaseHA.Primary != "pulp-database-0" || pulp.Status.DatabaseHA.PrimaryUnavailableSince != nil || len(*promoted) > 0 {
+		t.Fatalf("expected no failover while the primary is restarted, got %+v", pulp.Status.DatabaseHA)
+	}
+
+	// the updated primary is not ready
+	primary := &corev1.Pod{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pulp-database-0", Namespace: "test-namespace"}, primary); err != nil {
+		t.Fatal(err)
+	}
+	primary.Labels[appsv1.StatefulSetRevisionLabel] = "pulp-database-2"
+	if err := r.Update(ctx, primary); err != nil {
+		t.Fatal(err)
+	}
+	result, err = r.databaseFailover(ctx, pulp, r.RawLogger)
+	if err != nil || result.RequeueAfter <= databaseFailoverRequeue || pulp.Status.DatabaseHA.PrimaryUnavailableSince == nil {
+		t.Errorf("expected the failover timeout to start once the primary is updated, got %+v %v", result, err)
+	}
+}
+
+// TestPromoteDatabase verifies that the standby is only promoted once the previous primary is gone, or
+// after the failover timeout if its pod does not terminate
+func TestPromoteDatabase(t *testing.T) {
+	ctx := context.TODO()
+	r, pulp := newHAReconciler(t, &pulpv1.DatabaseHighAvailability{FailoverTimeout: 60}, "pulp-database-1", map[string]bool{
+		"pulp-database-1": true,
+	})
+	pulp.Status.DatabaseHA.Promoting = true
+	promoted := fakeDatabaseExec(t, nil)
+	pods, err := r.databasePods(ctx, pulp, r.RawLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the previous primary is not ready anymore, but it is still stopping
+	deleted := metav1.Now()
+	pods["pulp-database-0"] = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pulp-database-0", Namespace: "test-namespace", DeletionTimestamp: &deleted}}
+
+	if result, err := r.promoteDatabase(ctx, pulp, pods, r.RawLogger); err != nil || result.RequeueAfter != databaseFailoverRequeue || len(*promoted) > 0 {
+		t.Fatalf("expected the promotion to wait for the previous primary, got %+v %v %v", result, err, *promoted)
+	}
+
+	deleted = metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	if _, err := r.promoteDatabase(ctx, pulp, pods, r.RawLogger); err != nil || len(*promoted) != 1 || pulp.Status.DatabaseHA.Promoting {
+		t.Errorf("expected the standby to be promoted after the failover timeout, got %v %v", err, *promoted)
+	}
+}
+
+// TestDatabaseHARemoved verifies that the first pod is promoted before the replication is removed
+func TestDatabaseHARemoved(t *testing.T) {
+	ctx := context.TODO()
+	r, pulp := newHAReconciler(t, &pulpv1.DatabaseHighAvailability{}, "", map[string]bool{
+		"pulp-database-0": true,
+		"pulp-database-1": true,
+	})
+	promoted := fakeDatabaseExec(t, nil)
+	if _, err := r.databaseHAConfig(ctx, pulp, r.RawLogger); err != nil {
+		t.Fatal(err)
+	}
+	pulp.Spec.Database.HighAvailability = nil
+	if err := r.Update(ctx, pulp); err != nil {
+		t.Fatal(err)
+	}
+	pulp.Status.DatabaseHA.Primary = "pulp-database-1"
+	if err := r.Status().Update(ctx, pulp); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.databaseHAConfig(ctx, pulp, r.RawLogger); err != nil {
+		t.Fatal(err)
+	}
+	if pulp.Status.DatabaseHA.Primary != "pulp-database-0" || podRole(t, r, "pulp-database-1") != "deleted" {
+		t.Fatalf("expected the primary to be switched over to pulp-database-0, got %+v", pulp.Status.DatabaseHA)
+	}
+	if _, err := r.databaseHAConfig(ctx, pulp, r.RawLogger); err != nil {
+		t.Fatal(err)
+	}
+	if len(*promoted) != 1 || pulp.Status.DatabaseHA.Promoting {
+		t.Fatalf("expected pulp-database-0 to be promoted, got %v", *promoted)
+	}
+	if _, err := r.databaseHAConfig(ctx, pulp, r.RawLogger); err != nil {
+		t.Fatal(err)
+	}
+	if pulp.Status.DatabaseHA != nil {
+		t.Errorf("expected the replication to be removed from status, got %+v", pulp.Status.DatabaseHA)
+	}
+	for name, obj := range map[string]client.Object{"pulp-database-ha": &corev1.ConfigMap{}, "pulp-database": &policy.PodDisruptionBudget{}} {
+		if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: "test-namespace"}, obj); !errors.IsNotFound(err) {
+			t.Errorf("expected %v to be removed, got %v", name, err)
+		}
+	}
+}
+
+// TestParseLSN verifies the parsing of the WAL positions
+func TestParseLSN(t *testing.T) {
+	tests := []struct {
+		lsn      string
+		expected uint64
+		valid    bool
+	}{
+		{"0/3000060", 0x3000060, true},
+		{"16/B374D848\n", 0x16B374D848, true},
+		{"", 0, false},
+		{"16B374D848", 0, false},
+	}
+	for _, tt := range tests {
+		lsn, err := parseLSN(tt.lsn)
+		if (err == nil) != tt.valid || lsn != tt.expected {
+			t.Errorf("parseLSN(%q) = %x, %v", tt.lsn, lsn, err)
+		}
+	}
+}
+
+// TestCheckDatabaseHA verifies the configurations where the database cannot be replicated
+func TestCheckDatabaseHA(t *testing.T) {
+	tests := []struct {
+		name     string
+		database pulpv1.Database
+		status   pulpv1.PulpStatus
+		valid    bool
+	}{
+		{"storage class", pulpv1.Database{PostgresStorageClass: new(string)}, pulpv1.PulpStatus{}, true},
+		{"external database", pulpv1.Database{ExternalDBSecret: "external-db"}, pulpv1.PulpStatus{}, false},
+		{"pvc", pulpv1.Database{PVC: "database-pvc"}, pulpv1.PulpStatus{}, false},
+		{"wal archive", pulpv1.Database{WALArchive: &pulpv1.WALArchive{PVC: "wal"}}, pulpv1.PulpStatus{}, false},
+		{"upgraded database", pulpv1.Database{}, pulpv1.PulpStatus{DatabasePVC: "pulp-postgres-16"}, false},
+		{"failed upgrade", pulpv1.Database{}, pulpv1.PulpStatus{DatabaseUpgrade: &pulpv1.DatabaseUpgrade{Phase: databaseUpgradeFailed}}, false},
+		{"postgres 11", pulpv1.Database{PostgresImage: "docker.io/library/postgres:11"}, pulpv1.PulpStatus{}, false},
+	}
+	for _, tt := range tests {
+		tt.database.HighAvailability = &pulpv1.DatabaseHighAvailability{}
+		pulp := &pulpv1.Pulp{Spec: pulpv1.PulpSpec{Database: tt.database}, Status: tt.status}
+		if valid := checkDatabaseHA(logr.Discard(), pulp) == nil; valid != tt.valid {
+			t.Errorf("%v: expected valid=%v", tt.name, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager
+
+import (
+	"context"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	databasePoolerConditionType   = "Pulp-Database-Pooler-Ready"
+	databasePoolerPort            = 6432
+	databasePoolerConfigPath      = "/etc/pgbouncer"
+	databasePoolerConfigHash      = "repo-manager.pulpproject.org/pooler-config-hash"
+	databasePoolerSessionMode     = "session"
+	databasePoolerTransactionMode = "transaction"
+
+	defaultDatabasePoolerImage    = "docker.io/edoburu/pgbouncer:v1.23.1-p2"
+	defaultDatabasePoolerPoolSize = int32(20)
+	defaultDatabasePoolerMaxConn  = int32(1000)
+)
+
+// databasePoolerController provisions the PgBouncer instance used by pulpcore to connect to the database
+func (r *RepoManagerReconciler) databasePoolerController(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger) (ctrl.Result, error) {
+	funcResources := controllers.FunctionResources{Context: ctx, Client: r.Client, Pulp: pulp, Scheme: r.Scheme, Logger: log}
+
+	// pgbouncer.ini and userlist.txt Secret
+	expectedSecret, err := databasePoolerSecret(funcResources)
+	if err != nil {
+		controllers.UpdateStatus(ctx, r.Client, pulp, metav1.ConditionFalse, databasePoolerConditionType, "ErrorDatabaseCredentials", "Failed to retrieve the database credentials: "+err.Error())
+		return ctrl.Result{}, err
+	}
+	secretFound := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: expectedSecret.Name, Namespace: pulp.Namespace}, secretFound)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new Database Pooler Secret", "Secret.Namespace", expectedSecret.Namespace, "Secret.Name", expectedSecret.Name)
+		if err := r.Create(ctx, expectedSecret); err != nil {
+			log.Error(err, "Failed to create new Database Pooler Secret", "Secret.Namespace", expectedSecret.Namespace, "Secret.Name", expectedSecret.Name)
+			r.recorder.Event(pulp, corev1.EventTypeWarning, "Failed", "Failed to create new Database Pooler Secret")
+			return ctrl.Result{}, err
+		}
+		r.recorder.Event(pulp, corev1.EventTypeNormal, "Created", "Database Pooler Secret created")
+		return ctrl.Result{Requeue: true}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Database Pooler Secret")
+		return ctrl.Result{}, err
+	}
+	if requeue, err := controllers.ReconcileObject(funcResources, expectedSecret, secretFound, databasePoolerConditionType, controllers.PulpSecret{}); err != nil || requeue {
+		return ctrl.Result{Requeue: requeue}, err
+	}
+
+	// database-pooler-svc Service
+	svc := databasePoolerService(pulp)
+	ctrl.SetControllerReference(pulp, svc, r.Scheme)
+	svcFound := &corev1.Service{}
+	err = r.Get(ctx, types.NamespacedName{Name: svc.Name, Namespace: pulp.Namespace}, svcFound)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new Database Pooler Service", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
+		if err := r.Create(ctx, svc); err != nil {
+			log.Error(err, "Failed to create new Database Pooler Service", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
+			r.recorder.Event(pulp, corev1.EventTypeWarning, "Failed", "Failed to create new Database Pooler Service")
+			return ctrl.Result{}, err
+		}
+		r.recorder.Event(pulp, corev1.EventTypeNormal, "Created", "Database Pooler Service created")
+		return ctrl.Result{Requeue: true}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Database Pooler Service")
+		return ctrl.Result{}, err
+	}
+	if requeue, err := controllers.ReconcileObject(funcResources, svc, svcFound, databasePoolerConditionType, controllers.PulpService{}); err != nil || requeue {
+		return ctrl.Result{Requeue: requeue}, err
+	}
+
+	// the certificate of the database is also used by pgbouncer for the connections of pulpcore
+	var tlsSecret *corev1.Secret
+	if controllers.DatabaseTLSEnabled(*pulp) {
+		tlsSecret = &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: controllers.DatabaseTLSSecret(*pulp), Namespace: pulp.Namespace}, tlsSecret); err != nil {
+			log.Error(err, "Failed to get the database certificate Secret")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// database-pooler Deployment
+	dep := databasePoolerDeployment(pulp, expectedSecret, tlsSecret, funcResources)
+	deploymentFound := &appsv1.Deployment{}
+	err = r.Get(ctx, types.NamespacedName{Name: dep.Name, Namespace: pulp.Namespace}, deploymentFound)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new Database Pooler Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+		if err := r.Create(ctx, dep); err != nil {
+			log.Error(err, "Failed to create new Database Pooler Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+			r.recorder.Event(pulp, corev1.EventTypeWarning, "Failed", "Failed to create new Database Pooler Deployment")
+			return ctrl.Result{}, err
+		}
+		controllers.UpdateStatus(ctx, r.Client, pulp, metav1.ConditionFalse, databasePoolerConditionType, "CreatingPoolerDeployment", "Database pooler deployment created")
+		r.recorder.Event(pulp, corev1.EventTypeNormal, "Created", "Database Pooler Deployment created")
+		return ctrl.Result{Requeue: true}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Database Pooler Deployment")
+		return ctrl.Result{}, err
+	}
+	if requeue, err := controllers.ReconcileObject(funcResources, dep, deploymentFound, databasePoolerConditionType, controllers.PulpDeployment{}); err != nil || requeue {
+		return ctrl.Result{Requeue: requeue}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// deprovisionDatabasePooler removes the PgBouncer resources in case the pooler is not defined anymore
+func (r *RepoManagerReconciler) deprovisionDatabasePooler(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger) (ctrl.Result, error) {
+	deploymentFound := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: settings.DBPoolerDeployment(pulp.Name), Namespace: pulp.Namespace}, deploymentFound)
+	if errors.IsNotFound(err) {
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Database Pooler Deployment")
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Removing the Database Pooler resources")
+	for _, obj := range []client.Object{
+		deploymentFound,
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: settings.DBPoolerService(pulp.Name), Namespace: pulp.Namespace}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: settings.DBPoolerSecret(pulp.Name), Namespace: pulp.Namespace}},
+	} {
+		if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to remove "+obj.GetName())
+			return ctrl.Result{}, err
+		}
+	}
+
+	v1.RemoveStatusCondition(&pulp.Status.Conditions, databasePoolerConditionType)
+	if err := r.Status().Update(ctx, pulp); err != nil {
+		log.Error(err, "Failed to remove the Database Pooler condition from Pulp status")
+		return ctrl.Result{}, err
+	}
+	r.recorder.Event(pulp, corev1.EventTypeNormal, "Deleted", "Database Pooler removed")
+	return ctrl.Result{}, nil
+}
+
+// databasePoolerSecret returns the Secret with the PgBouncer configuration and the credentials of the pulp
+// database user
+func databasePoolerSecret(resources controllers.FunctionResources) (*corev1.Secret, error) {
+	pulp := resources.Pulp
+	pooler := pulp.Spec.Database.Pooler
+
+	db, err := databaseConnectionData(resources)
+	if err != nil {
+		return nil, err
+	}
+
+	poolMode := pooler.PoolMode
+	if poolMode == "" {
+		poolMode = databasePoolerSessionMode
+	}
+	poolSize := pooler.DefaultPoolSize
+	if poolSize == 0 {
+		poolSize = defaultDatabasePoolerPoolSize
+	}
+	maxClientConn := pooler.MaxClientConn
+	if maxClientConn == 0 {
+		maxClientConn = defaultDatabasePoolerMaxConn
+	}
+	sslMode := db.sslMode
+	if sslMode == "" {
+		sslMode = "prefer"
+	}
+
+	// the clients (pulpcore) authenticate with the database credentials, which are also used by
+	// pgbouncer to open the server connections
+	config := `[databases]
+` + db.name + ` = host=` + db.host + ` port=` + db.port + ` dbname=` + db.name + `
+
+[pgbouncer]
+listen_addr = *
+listen_port = ` + strconv.Itoa(databasePoolerPort) + `
+unix_socket_dir =
+auth_type = scram-sha-256
+auth_file = ` + databasePoolerConfigPath + `/userlist.txt
+pool_mode = ` + poolMode + `
+default_pool_size = ` + strconv.Itoa(int(poolSize)) + `
+max_client_conn = ` + strconv.Itoa(int(maxClientConn)) + `
+ignore_startup_parameters = extra_float_digits,options
+server_tls_sslmode = ` + sslMode + `
+`
+	// pgbouncer verifies the certificate of the database, and pulpcore the same certificate on the pooler
+	if controllers.DatabaseTLSEnabled(*pulp) {
+		config += `server_tls_ca_file = ` + databaseTLSMountPath + `/ca.crt
+client_tls_sslmode = require
+client_tls_cert_file = ` + databaseTLSMountPath + `/tls.crt
+client_tls_key_file = ` + databaseTLSMountPath + `/tls.key
+`
+	}
+	userList := pgbouncerQuote(db.user) + " " + pgbouncerQuote(db.password) + "\n"
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      settings.DBPoolerSecret(pulp.Name),
+			Namespace: pulp.Namespace,
+			Labels:    labelsForDatabasePooler(pulp),
+		},
+		StringData: map[string]string{
+			"pgbouncer.ini": config,
+			"userlist.txt":  userList,
+		},
+	}
+	ctrl.SetControllerReference(pulp, secret, resources.Scheme)
+	return secret, nil
+}
+
+// pgbouncerQuote returns s as a double-quoted field of the pgbouncer auth_file
+func pgbouncerQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// databasePoolerService returns the Service used by pulpcore to connect to the pooler
+func databasePoolerService(m *pulpv1.Pulp) *corev1.Service {
+	labels := labelsForDatabasePooler(m)
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      settings.DBPoolerService(m.Name),
+			Namespace: m.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: labels,
+			Ports: []corev1.ServicePort{{
+				Port:       int32(databasePoolerPort),
+				Protocol:   corev1.ProtocolTCP,
+				TargetPort: intstr.FromInt(databasePoolerPort),
+				Name:       "pgbouncer-" + strconv.Itoa(databasePoolerPort),
+			}},
+		},
+	}
+}
+
+// databasePoolerDeployment returns the PgBouncer Deployment, with the certificate of the database from tlsSecret
+// if it is not nil.
+// The hash of the configuration is added to the pods so that they are recreated when it changes.
+func databasePoolerDeployment(m *pulpv1.Pulp, secret, tlsSecret *corev1.Secret, funcResources controllers.FunctionResources) *appsv1.Deployment {
+	pooler := m.Spec.Database.Pooler
+	ls := labelsForDatabasePooler(m)
+
+	replicas := pooler.Replicas
+	if replicas == 0 {
+		replicas = 1
+	}
+
+	image := os.Getenv("RELATED_IMAGE_PULP_PGBOUNCER")
+	if len(pooler.Image) > 0 {
+		image = pooler.Image
+	} else if image == "" {
+		image = defaultDatabasePoolerImage
+	}
+
+	probe := &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(databasePoolerPort)},
+		},
+		InitialDelaySeconds: 5,
+		PeriodSeconds:       10,
+		TimeoutSeconds:      5,
+		FailureThreshold:    3,
+		SuccessThreshold:    1,
+	}
+
+	podSecurityContext := &corev1.PodSecurityContext{}
+	if isOpenshift, _ := controllers.IsOpenShift(); !isOpenshift {
+		// postgres user of the pgbouncer image
+		runAsUser := int64(70)
+		podSecurityContext = &corev1.PodSecurityContext{
+			RunAsUser:  &runAsUser,
+			RunAsGroup: &runAsUser,
+			FSGroup:    &runAsUser,
+		}
+	}
+
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      settings.DBPoolerDeployment(m.Name),
+			Namespace: m.Namespace,
+			Labels:    ls,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Strategy: appsv1.DeploymentStrategy{Type: appsv1.RollingUpdateDeploymentStrategyType},
+			Selector: &metav1.LabelSelector{
+				MatchLabels: ls,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+					Annotations: map[string]string{
+						databasePoolerConfigHash: controllers.CalculateHash(secret.StringData),
+					},
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: settings.PulpServiceAccount(m.Name),
+					SecurityContext:    podSecurityContext,
+					Containers: []corev1.Container{{
+						Name:            "pgbouncer",
+						Image:           image,
+						ImagePullPolicy: corev1.PullIfNotPresent,
+						Command:         []string{"pgbouncer", databasePoolerConfigPath + "/pgbouncer.ini"},
+						Ports: []corev1.ContainerPort{{
+							ContainerPort: int32(databasePoolerPort),
+							Protocol:      corev1.ProtocolTCP,
+						}},
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "pgbouncer-config",
+							MountPath: databasePoolerConfigPath,
+							ReadOnly:  true,
+						}},
+						ReadinessProbe:  probe,
+						LivenessProbe:   probe,
+						Resources:       pooler.ResourceRequirements,
+						SecurityContext: controllers.SetDefaultSecurityContext(),
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "pgbouncer-config",
+						VolumeSource: corev1.VolumeSource{
+							Secret: &corev1.SecretVolumeSource{SecretName: secret.Name},
+						},
+					}},
+				},
+			},
+		},
+	}
+
+	if tlsSecret != nil {
+		podTemplate := &dep.Spec.Template
+		podTemplate.Annotations[databaseTLSHash] = databaseTLSSecretHash(tlsSecret)
+		podTemplate.Spec.Volumes = append(podTemplate.Spec.Volumes, databaseTLSSecretVolume(tlsSecret))
+		podTemplate.Spec.Containers[0].VolumeMounts = append(podTemplate.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      databaseTLSVolume,
+			MountPath: databaseTLSMountPath,
+			ReadOnly:  true,
+		})
+	}
+
+	controllers.AddHashLabel(funcResources, dep)
+	ctrl.SetControllerReference(m, dep, funcResources.Scheme)
+	return dep
+}
+
+// labelsForDatabasePooler returns the labels for selecting the pooler resources
+// belonging to the given pulp CR name.
+func labelsForDatabasePooler(m *pulpv1.Pulp) map[string]string {
+	labels := map[string]string{
+		"app.kubernetes.io/name":      "pulp-database-pooler",
+		"app.kubernetes.io/instance":  "pulp-database-pooler-" + m.Name,
+		"app.kubernetes.io/component": "database-pooler",
+		"app":                         "pulp-database-pooler",
+	}
+	maps.Copy(labels, settings.CommonLabels(*m))
+	return labels
+}
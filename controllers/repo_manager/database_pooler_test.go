@@ -0,0 +1,296 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// newPoolerReconciler returns a reconciler with the pooler defined in front of the database deployed by the
+// operator, or of the external database if external is true
+func newPoolerReconciler(t *testing.T, pooler *pulpv1.DatabasePooler, external bool) (*RepoManagerReconciler, *pulpv1.Pulp) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = pulpv1.AddToScheme(scheme)
+
+	pulp := &pulpv1.Pulp{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+		Spec:       pulpv1.PulpSpec{Database: pulpv1.Database{Pooler: pooler}},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp-postgres-configuration", Namespace: "test-namespace"},
+		StringData: map[string]string{
+			"username": "pulp",
+			"password": `pa"ss`,
+			"database": "pulp",
+			"port":     "5432",
+			"sslmode":  "prefer",
+		},
+	}
+	if external {
+		pulp.Spec.Database.ExternalDBSecret = "external-db"
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "external-db", Namespace: "test-namespace"},
+			StringData: map[string]string{
+				"POSTGRES_HOST":     "db.example.com",
+				"POSTGRES_PORT":     "5433",
+				"POSTGRES_USERNAME": "pulpuser",
+				"POSTGRES_PASSWORD": "secret",
+				"POSTGRES_DB_NAME":  "pulpdb",
+				"POSTGRES_SSLMODE":  "require",
+			},
+		}
+	}
+	stringDataToData(secret)
+
+	// the fake client does not convert the StringData of the secrets into Data like the API server
+	funcs := interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if secret, ok := obj.(*corev1.Secret); ok {
+				stringDataToData(secret)
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			if secret, ok := obj.(*corev1.Secret); ok {
+				stringDataToData(secret)
+			}
+			return c.Update(ctx, obj, opts...)
+		},
+	}
+	r := &RepoManagerReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulp, secret).WithStatusSubresource(pulp).WithInterceptorFuncs(funcs).Build(),
+		RawLogger: logr.Discard(),
+		Scheme:    scheme,
+		recorder:  record.NewFakeRecorder(20),
+	}
+	return r, pulp
+}
+
+func stringDataToData(secret *corev1.Secret) {
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	for k, v := range secret.StringData {
+		secret.Data[k] = []byte(v)
+	}
+	secret.StringData = nil
+}
+
+func poolerResources(r *RepoManagerReconciler, pulp *pulpv1.Pulp) controllers.FunctionResources {
+	return controllers.FunctionResources{Context: context.TODO(), Client: r.Client, Pulp: pulp, Scheme: r.Scheme, Logger: logr.Discard()}
+}
+
+// TestDatabasePoolerSecret verifies the PgBouncer configuration for the managed and the external databases
+func TestDatabasePoolerSecret(t *testing.T) {
+	tests := []struct {
+		name     string
+		pooler   *pulpv1.DatabasePooler
+		external bool
+		config   []string
+		userList string
+	}{
+		{
+			name:   "managed database with the defaults",
+			pooler: &pulpv1.DatabasePooler{},
+			config: []string{
+				"pulp = host=pulp-database-svc port=5432 dbname=pulp\n",
+				"listen_port = 6432\n",
+				"pool_mode = session\n",
+				"default_pool_size = 20\n",
+				"max_client_conn = 1000\n",
+				"server_tls_sslmode = prefer\n",
+			},
+			userList: `"pulp" "pa""ss"` + "\n",
+		},
+		{
+			name:     "external database in transaction mode",
+			pooler:   &pulpv1.DatabasePooler{PoolMode: "transaction", DefaultPoolSize: 50, MaxClientConn: 300},
+			external: true,
+			config: []string{
+				"pulpdb = host=db.example.com port=5433 dbname=pulpdb\n",
+				"pool_mode = transaction\n",
+				"default_pool_size = 50\n",
+				"max_client_conn = 300\n",
+				"server_tls_sslmode = require\n",
+			},
+			userList: `"pulpuser" "secret"` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, pulp := newPoolerReconciler(t, tt.pooler, tt.external)
+			secret, err := databasePoolerSecret(poolerResources(r, pulp))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range tt.config {
+				if !strings.Contains(secret.StringData["pgbouncer.ini"], line) {
+					t.Errorf("expected %q in pgbouncer.ini, got:\n%s", line, secret.StringData["pgbouncer.ini"])
+				}
+			}
+			if secret.StringData["userlist.txt"] != tt.userList {
+				t.Errorf("expected userlist.txt %q, got %q", tt.userList, secret.StringData["userlist.txt"])
+			}
+		})
+	}
+}
+
+// TestDatabasePoolerSettings verifies that settings.py points to the pooler
+func TestDatabasePoolerSettings(t *testing.T) {
+	tests := []struct {
+		name           string
+		pooler         *pulpv1.DatabasePooler
+		expected       []string
+		serverCursors  bool
+		expectedDirect bool
+	}{
+		{
+			name:     "without pooler",
+			expected: []string{"'HOST': 'pulp-database-svc'", "'PORT': '5432'"},
+		},
+		{
+			name:     "session mode",
+			pooler:   &pulpv1.DatabasePooler{},
+			expected: []string{"'HOST': 'pulp-database-pooler-svc'", "'PORT': '6432'", "'sslmode': 'prefer'"},
+		},
+		{
+			name:           "transaction mode",
+			pooler:         &pulpv1.DatabasePooler{PoolMode: "transaction"},
+			expected:       []string{"'HOST': 'pulp-database-pooler-svc'", "'DISABLE_SERVER_SIDE_CURSORS': True"},
+			serverCursors:  true,
+			expectedDirect: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, pulp := newPoolerReconciler(t, tt.pooler, false)
+			pulpSettings := ""
+			databaseSettings(poolerResources(r, pulp), &pulpSettings, map[string]struct{}{})
+			for _, expected := range tt.expected {
+				if !strings.Contains(pulpSettings, expected) {
+					t.Errorf("expected %q in settings, got:\n%s", expected, pulpSettings)
+				}
+			}
+			if strings.Contains(pulpSettings, "DISABLE_SERVER_SIDE_CURSORS") != tt.serverCursors {
+				t.Errorf("unexpected server-side cursors setting:\n%s", pulpSettings)
+			}
+
+			// the migrations bypass the pooler in transaction mode
+			direct := false
+			for _, env := range migrationContainer(pulp).Env {
+				if env.Name == "PULP_DATABASES__default__HOST" {
+					direct = env.Value == "$(POSTGRES_SERVICE_HOST)"
+				}
+			}
+			if direct != tt.expectedDirect {
+				t.Errorf("expected the migrations to connect directly to the database: %v", tt.expectedDirect)
+			}
+		})
+	}
+}
+
+// TestDatabasePoolerController verifies the provisioning and the removal of the pooler
+func TestDatabasePoolerController(t *testing.T) {
+	ctx := context.TODO()
+	r, pulp := newPoolerReconciler(t, &pulpv1.DatabasePooler{Replicas: 2, PoolMode: "transaction"}, true)
+
+	// the fake client does not mutate the deployments like the API server, so the deployment is
+	// updated on every loop and the controller keeps asking for a requeue
+	dep := &appsv1.Deployment{}
+	for i := 0; i < 3; i++ {
+		if _, err := r.databasePoolerController(ctx, pulp, logr.Discard()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pulp-database-pooler", Namespace: "test-namespace"}, dep); err != nil {
+		t.Fatal(err)
+	}
+	if *dep.Spec.Replicas != 2 || dep.Spec.Template.Spec.Containers[0].Image != defaultDatabasePoolerImage {
+		t.Errorf("unexpected pooler deployment %v %v", *dep.Spec.Replicas, dep.Spec.Template.Spec.Containers[0].Image)
+	}
+	configHash := dep.Spec.Template.Annotations[databasePoolerConfigHash]
+	svc := &corev1.Service{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pulp-database-pooler-svc", Namespace: "test-namespace"}, svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.Ports[0].Port != 6432 || svc.Spec.Selector["app.kubernetes.io/component"] != "database-pooler" {
+		t.Errorf("unexpected pooler service %v", svc.Spec)
+	}
+
+	// a new pool size is written to the configuration and rolls the pooler pods
+	pulp.Spec.Database.Pooler.DefaultPoolSize = 40
+	for i := 0; i < 5; i++ {
+		if _, err := r.databasePoolerController(ctx, pulp, logr.Discard()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pulp-database-pooler", Namespace: "test-namespace"}, secret); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(secret.Data["pgbouncer.ini"]), "default_pool_size = 40") {
+		t.Error("expected the new pool size in the pooler configuration")
+	}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pulp-database-pooler", Namespace: "test-namespace"}, dep); err != nil {
+		t.Fatal(err)
+	}
+	if dep.Spec.Template.Annotations[databasePoolerConfigHash] == configHash {
+		t.Error("expected the pooler pods to be recreated with the new configuration")
+	}
+
+	// the pooler is removed with its condition
+	v1.SetStatusCondition(&pulp.Status.Conditions, metav1.Condition{Type: databasePoolerConditionType, Status: metav1.ConditionTrue, Reason: "PoolerTasksFinished"})
+	if err := r.Status().Update(ctx, pulp); err != nil {
+		t.Fatal(err)
+	}
+	pulp.Spec.Database.Pooler = nil
+	if _, err := r.deprovisionDatabasePooler(ctx, pulp, logr.Discard()); err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range []client.Object{&appsv1.Deployment{}, &corev1.Service{}, &corev1.Secret{}} {
+		name := "pulp-database-pooler"
+		if _, isService := obj.(*corev1.Service); isService {
+			name = "pulp-database-pooler-svc"
+		}
+		if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: "test-namespace"}, obj); !errors.IsNotFound(err) {
+			t.Errorf("expected %T %v to be removed, got %v", obj, name, err)
+		}
+	}
+	if v1.FindStatusCondition(pulp.Status.Conditions, databasePoolerConditionType) != nil {
+		t.Error("expected the pooler condition to be removed")
+	}
+}
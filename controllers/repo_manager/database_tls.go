@@ -0,0 +1,302 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crypt_rand "crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"reflect"
+	"time"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// databaseTLSVolume is the Secret with the certificate of the database, mounted in the database and
+	// pooler pods
+	databaseTLSVolume    = "database-tls"
+	databaseTLSMountPath = "/etc/pulp-database-tls"
+	databaseTLSHash      = "repo-manager.pulpproject.org/database-tls-hash"
+
+	// the certificates issued by the operator are renewed databaseTLSRenewBefore their expiration
+	databaseCAValidity     = 10 * 365 * 24 * time.Hour
+	databaseCertValidity   = 365 * 24 * time.Hour
+	databaseTLSRenewBefore = 30 * 24 * time.Hour
+)
+
+// databaseSSLMode returns the sslmode used to connect to the database deployed by the operator
+func databaseSSLMode(pulp *pulpv1.Pulp) string {
+	if len(pulp.Spec.Database.PostgresSSLMode) > 0 {
+		return pulp.Spec.Database.PostgresSSLMode
+	}
+	if pulp.Spec.Database.TLS != nil {
+		return "verify-full"
+	}
+	return "prefer"
+}
+
+// reconcileDatabaseTLS returns the Secret with the certificate of the database, or nil if database.tls is not
+// defined. If database.tls.secret is not defined, the operator issues the certificate with a self-signed CA,
+// and renews it before its expiration or when the names of the database Services are not all covered.
+// The database pods are restarted by the StatefulSet when the certificate changes, through its hash in the
+// pod template.
+func (r *RepoManagerReconciler) reconcileDatabaseTLS(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger) (*corev1.Secret, error) {
+	tls := pulp.Spec.Database.TLS
+	secretName := settings.DBTLSSecret(pulp.Name)
+
+	if tls == nil || len(tls.Secret) > 0 {
+		// the certificate issued by the operator is not used anymore
+		found := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: pulp.Namespace}, found); err == nil && metav1.IsControlledBy(found, pulp) {
+			log.Info("Removing the " + secretName + " Secret")
+			if err := r.Delete(ctx, found); err != nil && !errors.IsNotFound(err) {
+				log.Error(err, "Failed to remove "+secretName+" Secret")
+				return nil, err
+			}
+		}
+		if tls == nil {
+			return nil, nil
+		}
+
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: tls.Secret, Namespace: pulp.Namespace}, secret); err != nil {
+			log.Error(err, "Failed to get "+tls.Secret+" Secret")
+			return nil, err
+		}
+		for _, key := range []string{"tls.crt", "tls.key", "ca.crt"} {
+			if len(secret.Data[key]) == 0 {
+				return nil, fmt.Errorf("the %s key is missing from the %s Secret", key, tls.Secret)
+			}
+		}
+		return secret, nil
+	}
+
+	found := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: pulp.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get "+secretName+" Secret")
+		return nil, err
+	}
+	data, tlsErr := databaseTLSData(pulp, found.Data, time.Now())
+	if tlsErr != nil {
+		log.Error(tlsErr, "Failed to issue the database certificate")
+		return nil, tlsErr
+	}
+
+	if errors.IsNotFound(err) {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      secretName,
+				Namespace: pulp.Namespace,
+				Labels:    labelsForDatabase(pulp),
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: data,
+		}
+		ctrl.SetControllerReference(pulp, secret, r.Scheme)
+		log.Info("Creating a new " + secretName + " Secret")
+		if err := r.Create(ctx, secret); err != nil {
+			log.Error(err, "Failed to create "+secretName+" Secret")
+			return nil, err
+		}
+		r.recorder.Event(pulp, corev1.EventTypeNormal, "Created", secretName+" Secret created")
+		return secret, nil
+	}
+	if !reflect.DeepEqual(data, found.Data) {
+		log.Info("Renewing the database certificate of the " + secretName + " Secret")
+		found.Data = data
+		if err := r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update "+secretName+" Secret")
+			return nil, err
+		}
+		r.recorder.Event(pulp, corev1.EventTypeNormal, "Updated", "Database certificate renewed, the database will be restarted")
+	}
+	return found, nil
+}
+
+// databaseTLSDNSNames returns the names of the database and pooler Services, which are covered by the
+// certificate of the database because pulpcore verifies the host it connects to
+func databaseTLSDNSNames(pulp *pulpv1.Pulp) []string {
+	dnsNames := []string{}
+	for _, svc := range []string{settings.DBService(pulp.Name), settings.DBPoolerService(pulp.Name)} {
+		dnsNames = append(dnsNames,
+			svc,
+			svc+"."+pulp.Namespace,
+			svc+"."+pulp.Namespace+".svc",
+			svc+"."+pulp.Namespace+".svc.cluster.local",
+		)
+	}
+	return dnsNames
+}
+
+// databaseTLSData returns the data of the Secret with the certificate issued by the operator. The CA and the
+// certificate from current are kept while they are valid, so that the database is only restarted when the
+// certificate is renewed.
+func databaseTLSData(pulp *pulpv1.Pulp, current map[string][]byte, now time.Time) (map[string][]byte, error) {
+	data := map[string][]byte{}
+	ca, caKey := parseCertificateAndKey(current["ca.crt"], current["ca.key"])
+	if ca == nil || !ca.IsCA || now.Add(databaseTLSRenewBefore).After(ca.NotAfter) {
+		var err error
+		template := &x509.Certificate{
+			Subject:               pkix.Name{CommonName: pulp.Name + "-database-ca"},
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		}
+		if data["ca.crt"], data["ca.key"], err = issueCertificate(template, nil, nil, now, databaseCAValidity); err != nil {
+			return nil, err
+		}
+		ca, caKey = parseCertificateAndKey(data["ca.crt"], data["ca.key"])
+	} else {
+		data["ca.crt"], data["ca.key"] = current["ca.crt"], current["ca.key"]
+	}
+
+	if cert, _ := parseCertificateAndKey(current["tls.crt"], current["tls.key"]); certificateValid(cert, ca, databaseTLSDNSNames(pulp), now) {
+		data["tls.crt"], data["tls.key"] = current["tls.crt"], current["tls.key"]
+		return data, nil
+	}
+	var err error
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: settings.DBService(pulp.Name)},
+		DNSNames:    databaseTLSDNSNames(pulp),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	data["tls.crt"], data["tls.key"], err = issueCertificate(template, ca, caKey, now, databaseCertValidity)
+	return data, err
+}
+
+// issueCertificate returns the PEM encoded certificate from template, signed by parent (self-signed if parent
+// is nil), and its private key
+func issueCertificate(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, now time.Time, validity time.Duration) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crypt_rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if template.SerialNumber, err = crypt_rand.Int(crypt_rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128)); err != nil {
+		return nil, nil, err
+	}
+	// tolerate clock skews between the nodes
+	template.NotBefore = now.Add(-time.Hour)
+	template.NotAfter = now.Add(validity)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(crypt_rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// parseCertificateAndKey returns the certificate and its private key, or nil if they are not valid or do not match
+func parseCertificateAndKey(certPEM, keyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey) {
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil || !key.PublicKey.Equal(cert.PublicKey) {
+		return nil, nil
+	}
+	return cert, key
+}
+
+// certificateValid returns true if cert is signed by ca, is valid for all the dnsNames and does not need to be renewed
+func certificateValid(cert, ca *x509.Certificate, dnsNames []string, now time.Time) bool {
+	if cert == nil || cert.CheckSignatureFrom(ca) != nil || now.Add(databaseTLSRenewBefore).After(cert.NotAfter) {
+		return false
+	}
+	for _, dnsName := range dnsNames {
+		if cert.VerifyHostname(dnsName) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// databaseTLSSecretVolume returns the volume with the certificate, the private key and the CA of the database.
+// The private key is only readable by the fsGroup of the pod, as required by postgres.
+func databaseTLSSecretVolume(secret *corev1.Secret) corev1.Volume {
+	mode := int32(0640)
+	return corev1.Volume{
+		Name: databaseTLSVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: secret.Name,
+				Items: []corev1.KeyToPath{
+					{Key: "tls.crt", Path: "tls.crt"},
+					{Key: "tls.key", Path: "tls.key"},
+					{Key: "ca.crt", Path: "ca.crt"},
+				},
+				DefaultMode: &mode,
+			},
+		},
+	}
+}
+
+// databaseTLSSecretHash returns the hash of the certificate, used to restart the pods when it is renewed
+func databaseTLSSecretHash(secret *corev1.Secret) string {
+	return controllers.CalculateHash([][]byte{secret.Data["tls.crt"], secret.Data["tls.key"], secret.Data["ca.crt"]})
+}
+
+// setDatabaseTLS configures the database pods with ssl and the certificate from secret
+func setDatabaseTLS(sts *appsv1.StatefulSet, secret *corev1.Secret) {
+	podTemplate := &sts.Spec.Template
+	if podTemplate.Annotations == nil {
+		podTemplate.Annotations = map[string]string{}
+	}
+	podTemplate.Annotations[databaseTLSHash] = databaseTLSSecretHash(secret)
+	podTemplate.Spec.Volumes = append(podTemplate.Spec.Volumes, databaseTLSSecretVolume(secret))
+
+	postgres := &podTemplate.Spec.Containers[0]
+	postgres.Args = append(postgres.Args,
+		"-c", "ssl=on",
+		"-c", "ssl_cert_file="+databaseTLSMountPath+"/tls.crt",
+		"-c", "ssl_key_file="+databaseTLSMountPath+"/tls.key",
+	)
+	postgres.VolumeMounts = append(postgres.VolumeMounts, corev1.VolumeMount{
+		Name:      databaseTLSVolume,
+		MountPath: databaseTLSMountPath,
+		ReadOnly:  true,
+	})
+}
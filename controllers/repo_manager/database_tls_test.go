@@ -0,0 +1,216 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// verifyDatabaseCertificate verifies that the certificate from data is issued by its CA for the database Services
+func verifyDatabaseCertificate(t *testing.T, pulp *pulpv1.Pulp, data map[string][]byte, now time.Time) {
+	t.Helper()
+	ca, _ := parseCertificateAndKey(data["ca.crt"], data["ca.key"])
+	cert, _ := parseCertificateAndKey(data["tls.crt"], data["tls.key"])
+	if ca == nil || cert == nil {
+		t.Fatal("expected a CA and a certificate with their private keys")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	for _, dnsName := range []string{"pulp-database-svc", "pulp-database-svc.test-namespace.svc", "pulp-database-pooler-svc"} {
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: dnsName, Roots: roots, CurrentTime: now}); err != nil {
+			t.Errorf("the certificate is not valid for %s: %v", dnsName, err)
+		}
+	}
+}
+
+// TestDatabaseTLSData verifies that the certificate issued by the operator is kept until it is renewed
+func TestDatabaseTLSData(t *testing.T) {
+	pulp := &pulpv1.Pulp{ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"}}
+	now := time.Now()
+
+	data, err := databaseTLSData(pulp, nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyDatabaseCertificate(t, pulp, data, now)
+
+	kept, _ := databaseTLSData(pulp, data, now.Add(24*time.Hour))
+	if !bytes.Equal(kept["tls.crt"], data["tls.crt"]) || !bytes.Equal(kept["ca.crt"], data["ca.crt"]) {
+		t.Error("expected the valid certificate to be kept")
+	}
+
+	// the certificate is renewed with the same CA, so that the pulpcore pods keep trusting the database
+	later := now.Add(databaseCertValidity - databaseTLSRenewBefore + time.Hour)
+	renewed, _ := databaseTLSData(pulp, data, later)
+	if bytes.Equal(renewed["tls.crt"], data["tls.crt"]) || !bytes.Equal(renewed["ca.crt"], data["ca.crt"]) {
+		t.Error("expected the certificate to be renewed with the same CA")
+	}
+	verifyDatabaseCertificate(t, pulp, renewed, later)
+
+	// a certificate which does not match its CA is issued again
+	other, _ := databaseTLSData(pulp, nil, now)
+	mixed := map[string][]byte{"ca.crt": data["ca.crt"], "ca.key": data["ca.key"], "tls.crt": other["tls.crt"], "tls.key": other["tls.key"]}
+	if reissued, _ := databaseTLSData(pulp, mixed, now); bytes.Equal(reissued["tls.crt"], other["tls.crt"]) {
+		t.Error("expected the certificate from another CA to be replaced")
+	}
+
+	// the CA is renewed before its expiration
+	muchLater := now.Add(databaseCAValidity - databaseTLSRenewBefore + time.Hour)
+	newCA, _ := databaseTLSData(pulp, data, muchLater)
+	if bytes.Equal(newCA["ca.crt"], data["ca.crt"]) {
+		t.Error("expected the CA to be renewed")
+	}
+	verifyDatabaseCertificate(t, pulp, newCA, muchLater)
+}
+
+// TestReconcileDatabaseTLS verifies the Secret issued by the operator and the one provided in database.tls.secret
+func TestReconcileDatabaseTLS(t *testing.T) {
+	ctx := context.TODO()
+	r, pulp := newPoolerReconciler(t, nil, false)
+	pulp.Spec.Database.TLS = &pulpv1.DatabaseTLS{}
+	generatedSecret := func() (*corev1.Secret, error) {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Name: "pulp-database-tls", Namespace: "test-namespace"}, secret)
+		return secret, err
+	}
+
+	secret, err := r.reconcileDatabaseTLS(ctx, pulp, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := generatedSecret()
+	if err != nil || found.Type != corev1.SecretTypeTLS || !metav1.IsControlledBy(found, pulp) {
+		t.Fatalf("expected the pulp-database-tls Secret issued by the operator, got %v", err)
+	}
+	verifyDatabaseCertificate(t, pulp, secret.Data, time.Now())
+	if again, _ := r.reconcileDatabaseTLS(ctx, pulp, logr.Discard()); !bytes.Equal(again.Data["tls.crt"], secret.Data["tls.crt"]) {
+		t.Error("expected the certificate to be kept between reconciliations")
+	}
+
+	userSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "database-certs", Namespace: "test-namespace"},
+		Data:       map[string][]byte{"tls.crt": secret.Data["tls.crt"], "tls.key": secret.Data["tls.key"]},
+	}
+	if err := r.Create(ctx, userSecret); err != nil {
+		t.Fatal(err)
+	}
+	pulp.Spec.Database.TLS.Secret = "database-certs"
+	if _, err := r.reconcileDatabaseTLS(ctx, pulp, logr.Discard()); err == nil || !strings.Contains(err.Error(), "ca.crt") {
+		t.Errorf("expected an error for the missing ca.crt, got %v", err)
+	}
+
+	userSecret.Data["ca.crt"] = secret.Data["ca.crt"]
+	if err := r.Update(ctx, userSecret); err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := r.reconcileDatabaseTLS(ctx, pulp, logr.Discard()); err != nil || secret.Name != "database-certs" {
+		t.Errorf("expected the database-certs Secret, got %v", err)
+	}
+	if _, err := generatedSecret(); !errors.IsNotFound(err) {
+		t.Errorf("expected the pulp-database-tls Secret to be removed, got %v", err)
+	}
+
+	pulp.Spec.Database.TLS = nil
+	if secret, err := r.reconcileDatabaseTLS(ctx, pulp, logr.Discard()); secret != nil || err != nil {
+		t.Errorf("expected no certificate without database.tls, got %v %v", secret, err)
+	}
+}
+
+// TestDatabaseTLSConfiguration verifies that the database serves the certificate and that pulpcore and the
+// pooler verify it
+func TestDatabaseTLSConfiguration(t *testing.T) {
+	ctx := context.TODO()
+	r, pulp := newPoolerReconciler(t, &pulpv1.DatabasePooler{}, false)
+	pulp.Spec.Database.TLS = &pulpv1.DatabaseTLS{}
+	if sslMode := databaseSSLMode(pulp); sslMode != "verify-full" {
+		t.Fatalf("expected the verify-full sslmode with tls, got %s", sslMode)
+	}
+
+	// the sslmode of the pulp-postgres-configuration Secret is updated by the database controller
+	dbSecret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pulp-postgres-configuration", Namespace: "test-namespace"}, dbSecret); err != nil {
+		t.Fatal(err)
+	}
+	dbSecret.Data["sslmode"] = []byte(databaseSSLMode(pulp))
+	if err := r.Update(ctx, dbSecret); err != nil {
+		t.Fatal(err)
+	}
+	tlsSecret, err := r.reconcileDatabaseTLS(ctx, pulp, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storageClass := "standard"
+	pulp.Spec.Database.PostgresStorageClass = &storageClass
+	sts := statefulSetForDatabase(pulp, nil)
+	setDatabaseTLS(sts, tlsSecret)
+	postgres := sts.Spec.Template.Spec.Containers[0]
+	if !slices.Contains(postgres.Args, "ssl=on") || !slices.Contains(postgres.Args, "ssl_key_file=/etc/pulp-database-tls/tls.key") {
+		t.Errorf("expected the database to enable ssl, got %v", postgres.Args)
+	}
+	if len(sts.Spec.Template.Annotations[databaseTLSHash]) == 0 {
+		t.Error("expected the hash of the certificate in the database pods")
+	}
+
+	pulpSettings := ""
+	databaseSettings(poolerResources(r, pulp), &pulpSettings, map[string]struct{}{})
+	if !strings.Contains(pulpSettings, "'OPTIONS': { 'sslmode': 'verify-full', 'sslrootcert': '/etc/pulp/database-tls/ca.crt' },") {
+		t.Errorf("expected pulpcore to verify the certificate of the pooler, got:\n%s", pulpSettings)
+	}
+
+	poolerSecret, err := databasePoolerSecret(poolerResources(r, pulp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"server_tls_sslmode = verify-full\n",
+		"server_tls_ca_file = /etc/pulp-database-tls/ca.crt\n",
+		"client_tls_sslmode = require\n",
+		"client_tls_cert_file = /etc/pulp-database-tls/tls.crt\n",
+	} {
+		if !strings.Contains(poolerSecret.StringData["pgbouncer.ini"], line) {
+			t.Errorf("expected %q in pgbouncer.ini", line)
+		}
+	}
+
+	// the CA is mounted in the pulpcore containers, without subPath to follow its renewal
+	if !slices.ContainsFunc(pulpcoreVolumes(pulp, ""), func(v corev1.Volume) bool {
+		return v.Secret != nil && v.Secret.SecretName == "pulp-database-tls"
+	}) || !slices.ContainsFunc(pulpcoreVolumeMounts(pulp), func(m corev1.VolumeMount) bool {
+		return m.MountPath == "/etc/pulp/database-tls" && m.SubPath == ""
+	}) {
+		t.Error("expected the CA of the database to be mounted in the pulpcore containers")
+	}
+
+	script := databaseHAScript(pulp)
+	if !strings.Contains(script, "PGSSLMODE=verify-full PGSSLROOTCERT=/etc/pulp-database-tls/ca.crt ") {
+		t.Errorf("expected the standbys to verify the certificate of the primary, got:\n%s", script)
+	}
+}
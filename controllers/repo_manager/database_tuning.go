@@ -0,0 +1,238 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager
+
+import (
+	"context"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// databaseTuningVolume is the ConfigMap, mounted in the database pods, with the postgresql.conf used as
+	// config_file. It includes the postgresql.conf of the data directory before the tuned parameters.
+	databaseTuningVolume    = "database-tuning"
+	databaseTuningMountPath = "/etc/pulp-database-tuning"
+	databaseTuningHash      = "repo-manager.pulpproject.org/database-tuning-hash"
+
+	// connections kept for the jobs, the backups and the administration of the database
+	databaseReservedConnections = 20
+	// max_connections is rounded up so that scaling Pulp by a few pods does not restart the database
+	databaseConnectionsStep  = 50
+	databaseMinConnections   = 100
+	databaseMinWorkMem       = 4 << 20
+	databaseMaxMaintenanceWM = 2 << 30
+)
+
+// databaseTuningParameter is the format of the names of the PostgreSQL parameters
+var databaseTuningParameter = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.]*$`)
+
+// databaseTuningReserved are the parameters which cannot be overridden because the operator or the
+// postgres image define them
+var databaseTuningReserved = []string{"config_file", "data_directory", "hba_file", "ident_file", "include", "include_dir", "include_if_exists", "port"}
+
+// databaseTuningConfigMapName returns the name of the ConfigMap with the tuned postgresql.conf
+func databaseTuningConfigMapName(pulpName string) string {
+	return settings.DefaultDBStatefulSet(pulpName) + "-tuning"
+}
+
+// reconcileDatabaseTuning reconciles the ConfigMap with the tuned postgresql.conf of the database pods, or
+// removes it when database.tuning is not defined anymore.
+// The database pods are restarted by the StatefulSet when the parameters change, through the hash of the
+// configuration in the pod template.
+func (r *RepoManagerReconciler) reconcileDatabaseTuning(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger) error {
+	found := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: databaseTuningConfigMapName(pulp.Name), Namespace: pulp.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get "+databaseTuningConfigMapName(pulp.Name)+" ConfigMap")
+		return err
+	}
+
+	if pulp.Spec.Database.Tuning == nil {
+		if err == nil {
+			log.Info("Removing the " + found.Name + " ConfigMap")
+			if err := r.Delete(ctx, found); err != nil && !errors.IsNotFound(err) {
+				log.Error(err, "Failed to remove "+found.Name+" ConfigMap")
+				return err
+			}
+		}
+		return nil
+	}
+
+	expected := databaseTuningConfigMap(pulp)
+	ctrl.SetControllerReference(pulp, expected, r.Scheme)
+	if errors.IsNotFound(err) {
+		log.Info("Creating a new " + expected.Name + " ConfigMap")
+		if err := r.Create(ctx, expected); err != nil {
+			log.Error(err, "Failed to create "+expected.Name+" ConfigMap")
+			return err
+		}
+		r.recorder.Event(pulp, corev1.EventTypeNormal, "Created", expected.Name+" ConfigMap created")
+		return nil
+	}
+	if !reflect.DeepEqual(expected.Data, found.Data) {
+		log.Info("The " + expected.Name + " ConfigMap has been modified! Reconciling ...")
+		found.Data = expected.Data
+		if err := r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update "+expected.Name+" ConfigMap")
+			return err
+		}
+		r.recorder.Event(pulp, corev1.EventTypeNormal, "Updated", expected.Name+" ConfigMap reconciled, the database will be restarted")
+	}
+	return nil
+}
+
+// databaseTuningConfigMap returns the ConfigMap with the tuned postgresql.conf
+func databaseTuningConfigMap(pulp *pulpv1.Pulp) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      databaseTuningConfigMapName(pulp.Name),
+			Namespace: pulp.Namespace,
+			Labels:    labelsForDatabase(pulp),
+		},
+		Data: map[string]string{"postgresql.conf": databaseTuningConfig(pulp)},
+	}
+}
+
+// databaseTuningConfig returns the postgresql.conf with the tuned parameters, which override the ones
+// from the postgresql.conf of the data directory
+func databaseTuningConfig(pulp *pulpv1.Pulp) string {
+	parameters := databaseTuningParameters(pulp)
+	config := "# generated by pulp-operator from database.tuning\n"
+	config += "include_if_exists = '" + controllers.PostgresDataPath(pulp) + "/postgresql.conf'\n"
+	for _, name := range slices.Sorted(maps.Keys(parameters)) {
+		config += name + " = '" + strings.ReplaceAll(parameters[name], "'", "''") + "'\n"
+	}
+	return config
+}
+
+// databaseTuningParameters returns the PostgreSQL parameters computed from the memory of the database container
+// and the connections opened by Pulp, with the parameters defined in database.tuning.parameters
+func databaseTuningParameters(pulp *pulpv1.Pulp) map[string]string {
+	maxConnections := databaseConnectionDemand(pulp) + databaseReservedConnections
+	maxConnections = (maxConnections + databaseConnectionsStep - 1) / databaseConnectionsStep * databaseConnectionsStep
+	maxConnections = max(maxConnections, databaseMinConnections)
+	parameters := map[string]string{
+		"max_connections": strconv.Itoa(maxConnections),
+	}
+
+	// the memory parameters are only computed if the memory available to the database is known
+	memory := pulp.Spec.Database.ResourceRequirements.Limits.Memory().Value()
+	if memory == 0 {
+		memory = pulp.Spec.Database.ResourceRequirements.Requests.Memory().Value()
+	}
+	if memory > 0 {
+		sharedBuffers := memory / 4
+		parameters["shared_buffers"] = megabytes(sharedBuffers)
+		parameters["effective_cache_size"] = megabytes(memory * 3 / 4)
+		parameters["maintenance_work_mem"] = megabytes(min(memory/16, databaseMaxMaintenanceWM))
+		// a query can use work_mem several times, for each sort or hash operation
+		parameters["work_mem"] = megabytes(max((memory-sharedBuffers)/int64(maxConnections*3), databaseMinWorkMem))
+	}
+
+	if tuning := pulp.Spec.Database.Tuning; tuning != nil {
+		maps.Copy(parameters, tuning.Parameters)
+	}
+	return parameters
+}
+
+// megabytes returns the number of bytes in the PostgreSQL MB unit, rounded down
+func megabytes(bytes int64) string {
+	return strconv.FormatInt(max(bytes>>20, 1), 10) + "MB"
+}
+
+// databaseConnectionDemand returns the number of connections opened to the database by the Pulp processes,
+// with the maximum number of replicas of the components autoscaled by HPA
+func databaseConnectionDemand(pulp *pulpv1.Pulp) int {
+	// a task worker uses a connection, and another one in the process running the task
+	workers := componentMaxReplicas(pulp, settings.WORKER, pulp.Spec.Worker.Replicas) * 2
+
+	// the pooler opens at most default_pool_size connections per pod
+	if pooler := pulp.Spec.Database.Pooler; pooler != nil {
+		replicas := max(int(pooler.Replicas), 1)
+		poolSize := int(pooler.DefaultPoolSize)
+		if poolSize == 0 {
+			poolSize = int(defaultDatabasePoolerPoolSize)
+		}
+		demand := replicas * poolSize
+		// the workers connect directly to the database in transaction mode
+		if pooler.PoolMode == databasePoolerTransactionMode {
+			demand += workers
+		}
+		return demand
+	}
+
+	api := componentMaxReplicas(pulp, settings.API, pulp.Spec.Api.Replicas) * gunicornWorkers(pulp.Spec.Api.GunicornWorkers)
+	content := componentMaxReplicas(pulp, settings.CONTENT, pulp.Spec.Content.Replicas) * gunicornWorkers(pulp.Spec.Content.GunicornWorkers)
+	return api + content + workers
+}
+
+// componentMaxReplicas returns the max_replicas of the component if it is autoscaled, or its replicas
+func componentMaxReplicas(pulp *pulpv1.Pulp, pulpcoreType settings.PulpcoreType, replicas int32) int {
+	if hpa := getHPAConfig(pulp, pulpcoreType); hpa != nil && hpa.Enabled {
+		return int(hpa.MaxReplicas)
+	}
+	return int(replicas)
+}
+
+// gunicornWorkers returns the number of gunicorn workers of a pod, each one with its own connection
+func gunicornWorkers(workers int) int {
+	if workers == 0 {
+		return 2
+	}
+	return workers
+}
+
+// setDatabaseTuning configures the database pods to use the tuned postgresql.conf
+func setDatabaseTuning(sts *appsv1.StatefulSet, pulp *pulpv1.Pulp) {
+	podTemplate := &sts.Spec.Template
+	if podTemplate.Annotations == nil {
+		podTemplate.Annotations = map[string]string{}
+	}
+	podTemplate.Annotations[databaseTuningHash] = controllers.CalculateHash(databaseTuningConfig(pulp))
+	podTemplate.Spec.Volumes = append(podTemplate.Spec.Volumes, corev1.Volume{
+		Name: databaseTuningVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: databaseTuningConfigMapName(pulp.Name)},
+			},
+		},
+	})
+
+	postgres := &podTemplate.Spec.Containers[0]
+	postgres.Args = append(postgres.Args, "-c", "config_file="+databaseTuningMountPath+"/postgresql.conf")
+	postgres.VolumeMounts = append(postgres.VolumeMounts, corev1.VolumeMount{
+		Name:      databaseTuningVolume,
+		MountPath: databaseTuningMountPath,
+		ReadOnly:  true,
+	})
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestDatabaseTuningParameters verifies the parameters computed from the database memory and the Pulp processes
+func TestDatabaseTuningParameters(t *testing.T) {
+	memory := corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("4Gi")}}
+	tests := []struct {
+		name     string
+		spec     pulpv1.PulpSpec
+		expected map[string]string
+	}{
+		{
+			name:     "defaults without memory requirements",
+			spec:     pulpv1.PulpSpec{Api: pulpv1.Api{Replicas: 1}, Content: pulpv1.Content{Replicas: 1}, Worker: pulpv1.Worker{Replicas: 1}},
+			expected: map[string]string{"max_connections": "100"},
+		},
+		{
+			name: "autoscaled components with memory limits",
+			spec: pulpv1.PulpSpec{
+				Api:      pulpv1.Api{Replicas: 2, GunicornWorkers: 4, HPA: &pulpv1.HPA{Enabled: true, MaxReplicas: 20}},
+				Content:  pulpv1.Content{Replicas: 10},
+				Worker:   pulpv1.Worker{Replicas: 10},
+				Database: pulpv1.Database{ResourceRequirements: memory},
+			},
+			expected: map[string]string{
+				"max_connections":      "150",
+				"shared_buffers":       "1024MB",
+				"effective_cache_size": "3072MB",
+				"maintenance_work_mem": "256MB",
+				"work_mem":             "6MB",
+			},
+		},
+		{
+			name: "pooler in transaction mode and overridden parameters",
+			spec: pulpv1.PulpSpec{
+				Api:    pulpv1.Api{Replicas: 30},
+				Worker: pulpv1.Worker{Replicas: 10},
+				Database: pulpv1.Database{
+					ResourceRequirements: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Gi")}},
+					Pooler:               &pulpv1.DatabasePooler{Replicas: 2, PoolMode: "transaction"},
+					Tuning:               &pulpv1.DatabaseTuning{Parameters: map[string]string{"work_mem": "64MB", "random_page_cost": "1.1"}},
+				},
+			},
+			expected: map[string]string{
+				"max_connections":      "100",
+				"shared_buffers":       "16384MB",
+				"effective_cache_size": "49152MB",
+				"maintenance_work_mem": "2048MB",
+				"work_mem":             "64MB",
+				"random_page_cost":     "1.1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pulp := &pulpv1.Pulp{ObjectMeta: metav1.ObjectMeta{Name: "pulp"}, Spec: tt.spec}
+			if parameters := databaseTuningParameters(pulp); !reflect.DeepEqual(parameters, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, parameters)
+			}
+		})
+	}
+}
+
+// TestDatabaseTuningStatefulSet verifies that the database pods use the tuned postgresql.conf and are
+// restarted when it changes
+func TestDatabaseTuningStatefulSet(t *testing.T) {
+	storageClass := "standard"
+	pulp := &pulpv1.Pulp{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+		Spec: pulpv1.PulpSpec{Database: pulpv1.Database{
+			PostgresStorageClass: &storageClass,
+			PostgresExtraArgs:    []string{"-c", "log_min_duration_statement=1000"},
+			Tuning:               &pulpv1.DatabaseTuning{Parameters: map[string]string{"work_mem": "8MB"}},
+		}},
+	}
+
+	config := databaseTuningConfig(pulp)
+	if !strings.HasPrefix(config, "# generated by pulp-operator from database.tuning\ninclude_if_exists = '/var/lib/postgresql/data/pgdata/postgresql.conf'\n") ||
+		!strings.Contains(config, "\nwork_mem = '8MB'\n") {
+		t.Errorf("unexpected postgresql.conf:\n%s", config)
+	}
+
+	sts := statefulSetForDatabase(pulp, nil)
+	postgres := sts.Spec.Template.Spec.Containers[0]
+	if !slices.Equal(postgres.Args, []string{"-c", "log_min_duration_statement=1000", "-c", "config_file=/etc/pulp-database-tuning/postgresql.conf"}) {
+		t.Errorf("unexpected postgres args %v", postgres.Args)
+	}
+	if !slices.ContainsFunc(postgres.VolumeMounts, func(m corev1.VolumeMount) bool { return m.MountPath == databaseTuningMountPath }) {
+		t.Error("expected the tuning ConfigMap to be mounted")
+	}
+
+	pulp.Spec.Database.Tuning.Parameters["work_mem"] = "16MB"
+	if statefulSetForDatabase(pulp, nil).Spec.Template.Annotations[databaseTuningHash] == sts.Spec.Template.Annotations[databaseTuningHash] {
+		t.Error("expected the database pods to be restarted with the new parameters")
+	}
+
+	pulp.Spec.Database.Tuning = nil
+	if sts := statefulSetForDatabase(pulp, nil); len(sts.Spec.Template.Annotations) > 0 || len(sts.Spec.Template.Spec.Containers[0].Args) != 2 {
+		t.Error("expected the stock postgresql.conf without database.tuning")
+	}
+}
+
+// TestReconcileDatabaseTuning verifies the creation, the update and the removal of the tuning ConfigMap
+func TestReconcileDatabaseTuning(t *testing.T) {
+	ctx := context.TODO()
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = pulpv1.AddToScheme(scheme)
+	pulp := &pulpv1.Pulp{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+		Spec:       pulpv1.PulpSpec{Database: pulpv1.Database{Tuning: &pulpv1.DatabaseTuning{}}},
+	}
+	r := &RepoManagerReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulp).Build(),
+		RawLogger: logr.Discard(),
+		Scheme:    scheme,
+		recorder:  record.NewFakeRecorder(20),
+	}
+	configMap := func() (*corev1.ConfigMap, error) {
+		cm := &corev1.ConfigMap{}
+		err := r.Get(ctx, types.NamespacedName{Name: "pulp-database-tuning", Namespace: "test-namespace"}, cm)
+		return cm, err
+	}
+
+	if err := r.reconcileDatabaseTuning(ctx, pulp, logr.Discard()); err != nil {
+		t.Fatal(err)
+	}
+	if cm, err := configMap(); err != nil || !strings.Contains(cm.Data["postgresql.conf"], "max_connections = '100'") {
+		t.Fatalf("expected the tuning ConfigMap, got %v %v", cm.Data, err)
+	}
+
+	pulp.Spec.Database.Tuning.Parameters = map[string]string{"max_connections": "300"}
+	if err := r.reconcileDatabaseTuning(ctx, pulp, logr.Discard()); err != nil {
+		t.Fatal(err)
+	}
+	if cm, _ := configMap(); !strings.Contains(cm.Data["postgresql.conf"], "max_connections = '300'") {
+		t.Errorf("expected the overridden max_connections, got %v", cm.Data)
+	}
+
+	pulp.Spec.Database.Tuning = nil
+	if err := r.reconcileDatabaseTuning(ctx, pulp, logr.Discard()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := configMap(); !errors.IsNotFound(err) {
+		t.Errorf("expected the tuning ConfigMap to be removed, got %v", err)
+	}
+}
+
+func TestCheckDatabaseTuning(t *testing.T) {
+	tests := []struct {
+		name     string
+		database pulpv1.Database
+		valid    bool
+	}{
+		{"without tuning", pulpv1.Database{ExternalDBSecret: "external"}, true},
+		{"valid parameters", pulpv1.Database{Tuning: &pulpv1.DatabaseTuning{Parameters: map[string]string{"work_mem": "8MB", "auto_explain.log_min_duration": "1s"}}}, true},
+		{"external database", pulpv1.Database{ExternalDBSecret: "external", Tuning: &pulpv1.DatabaseTuning{}}, false},
+		{"invalid parameter", pulpv1.Database{Tuning: &pulpv1.DatabaseTuning{Parameters: map[string]string{"work_mem = 1MB\nfsync": "off"}}}, false},
+		{"reserved parameter", pulpv1.Database{Tuning: &pulpv1.DatabaseTuning{Parameters: map[string]string{"config_file": "/tmp/postgresql.conf"}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pulp := &pulpv1.Pulp{Spec: pulpv1.PulpSpec{Database: tt.database}}
+			if valid := checkDatabaseTuning(logr.Discard(), pulp) == nil; valid != tt.valid {
+				t.Errorf("expected valid to be %v", tt.valid)
+			}
+		})
+	}
+}
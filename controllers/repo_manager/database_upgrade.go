@@ -0,0 +1,452 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// phases of the database upgrade
+	databaseUpgradeScalingDown = "ScalingDown"
+	databaseUpgradeDumping     = "Dumping"
+	databaseUpgradeSwitching   = "Switching"
+	databaseUpgradeRestoring   = "Restoring"
+	databaseUpgradeFailed      = "Failed"
+
+	databaseUpgradeDumpJobSuffix    = "-database-upgrade-dump"
+	databaseUpgradeRestoreJobSuffix = "-database-upgrade-restore"
+
+	// databaseUpgradeDir is the directory of the new database PVC where the dump of the database is stored
+	databaseUpgradeDir = "upgrade"
+
+	// databaseUpgradeRequeue is how long to wait before checking the upgrade again (its jobs are not watched)
+	databaseUpgradeRequeue = 10 * time.Second
+)
+
+// databaseUpgradeComponents are the Pulp components connected to the database, which are scaled down
+// during the upgrade
+var databaseUpgradeComponents = []settings.PulpcoreType{settings.API, settings.CONTENT, settings.WORKER}
+
+// upgradeDatabase upgrades the database deployed by the operator when the major version of its image
+// differs from the one recorded in status: the Pulp components are scaled down, the database is dumped,
+// with the previous version, into a new PVC and the StatefulSet is recreated on it with the new version
+// (the dump is then reloaded by finishDatabaseUpgrade). The PVC with the previous data is kept for rollback.
+// It returns a non-empty result while the StatefulSet should not be reconciled.
+func (r *RepoManagerReconciler) upgradeDatabase(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger) (ctrl.Result, error) {
+	sts := &appsv1.StatefulSet{}
+	err := r.Get(ctx, types.NamespacedName{Name: settings.DefaultDBStatefulSet(pulp.Name), Namespace: pulp.Namespace}, sts)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Database StatefulSet")
+		return ctrl.Result{}, err
+	}
+	stsFound := err == nil
+
+	upgrade := pulp.Status.DatabaseUpgrade
+	if upgrade == nil {
+		return r.startDatabaseUpgrade(ctx, pulp, sts, stsFound, log)
+	}
+
+	switch upgrade.Phase {
+	case databaseUpgradeFailed:
+		// a failed upgrade is cleared once postgres_image is modified, so that it can be retried
+		if controllers.PostgresImage(pulp) != upgrade.ToImage {
+			r.deleteDatabaseUpgradeJobs(ctx, pulp)
+			pulp.Status.DatabaseUpgrade = nil
+			return ctrl.Result{Requeue: true}, r.Status().Update(ctx, pulp)
+		}
+		// Pulp is started again with the previous version of the database
+		return ctrl.Result{}, r.resumeDatabaseClients(ctx, pulp)
+
+	case databaseUpgradeScalingDown:
+		if stopped, err := r.scaleDownDatabaseClients(ctx, pulp, log); !stopped || err != nil {
+			return ctrl.Result{RequeueAfter: databaseUpgradeRequeue}, err
+		}
+		return r.setDatabaseUpgradePhase(ctx, pulp, databaseUpgradeDumping, "")
+
+	case databaseUpgradeDumping:
+		if created, err := r.ensureDatabaseUpgradePVC(ctx, pulp, log); errors.IsNotFound(err) {
+			return r.failDatabaseUpgrade(ctx, pulp, log, "PVC "+upgrade.PreviousPVC+" of the database not found")
+		} else if err != nil {
+			return ctrl.Result{}, err
+		} else if created {
+			return ctrl.Result{RequeueAfter: databaseUpgradeRequeue}, nil
+		}
+		log.Info("Dumping the database before the upgrade ...")
+		finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulp, databaseUpgradeDumpJob(pulp))
+		jobErr := &controllers.JobFailedError{}
+		if goerrors.As(err, &jobErr) {
+			return r.failDatabaseUpgrade(ctx, pulp, log, "failed to dump the database: "+jobErr.Message)
+		} else if !finished || err != nil {
+			return ctrl.Result{RequeueAfter: databaseUpgradeRequeue}, err
+		}
+		return r.setDatabaseUpgradePhase(ctx, pulp, databaseUpgradeSwitching, "")
+
+	case databaseUpgradeSwitching:
+		// the volumeClaimTemplates of a StatefulSet cannot be modified, so it is recreated on the new PVC
+		if stsFound {
+			if sts.DeletionTimestamp.IsZero() {
+				log.Info("Removing the database StatefulSet to recreate it with PostgreSQL " + upgrade.ToVersion)
+				if err := r.Delete(ctx, sts, client.PropagationPolicy(metav1.DeletePropagationForeground)); err != nil {
+					return ctrl.Result{}, client.IgnoreNotFound(err)
+				}
+			}
+			return ctrl.Result{RequeueAfter: databaseUpgradeRequeue}, nil
+		}
+		pulp.Status.DatabasePVC = upgrade.PVC
+		return r.setDatabaseUpgradePhase(ctx, pulp, databaseUpgradeRestoring, "")
+	}
+
+	// the StatefulSet is reconciled with the new version once it is moved to the new PVC
+	return ctrl.Result{}, nil
+}
+
+// startDatabaseUpgrade records the major version of the database and starts an upgrade if the major
+// version of the postgres image is more recent. Downgrades are refused.
+func (r *RepoManagerReconciler) startDatabaseUpgrade(ctx context.Context, pulp *pulpv1.Pulp, sts *appsv1.StatefulSet, stsFound bool, log logr.Logger) (ctrl.Result, error) {
+	desired := controllers.PostgresImageMajorVersion(pulp)
+	current := pulp.Status.DatabaseVersion
+	switch {
+	// a new database, or a database without PVC, is created with the version of the image
+	case !stsFound || len(controllers.DatabasePVC(pulp)) == 0:
+		current = desired
+	// the version of a database deployed before the upgrades were supported is the one of its image
+	case len(current) == 0:
+		current = controllers.ImageMajorVersion(sts.Spec.Template.Spec.Containers[0].Image)
+	}
+	if current != pulp.Status.DatabaseVersion {
+		pulp.Status.DatabaseVersion = current
+		if err := r.Status().Update(ctx, pulp); err != nil {
+			log.Error(err, "Failed to update the database version in Pulp status")
+			return ctrl.Result{}, err
+		}
+	}
+	if len(current) == 0 || len(desired) == 0 || current == desired {
+		return ctrl.Result{}, nil
+	}
+
+	// the standbys of a replicated database cannot be upgraded with the dump of the primary
+	if pulp.Spec.Database.HighAvailability != nil || pulp.Status.DatabaseHA != nil {
+		log.Info("Upgrading a database with high_availability is not supported", "Version", current, "PostgresImage", controllers.PostgresImage(pulp))
+		controllers.UpdateStatus(ctx, r.Client, pulp, metav1.ConditionFalse, "Pulp-Database-Ready", "DatabaseUpgradeNotSupported", "The database with high_availability cannot be upgraded from PostgreSQL "+current+" to "+desired+", remove high_availability before the upgrade")
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+
+	if !newerMajorVersion(desired, current) {
+		log.Info("Downgrading the database is not supported", "Version", current, "PostgresImage", controllers.PostgresImage(pulp))
+		controllers.UpdateStatus(ctx, r.Client, pulp, metav1.ConditionFalse, "Pulp-Database-Ready", "DatabaseDowngradeNotSupported", "The database cannot be downgraded from PostgreSQL "+current+" to "+desired)
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+
+	pulp.Status.DatabaseUpgrade = &pulpv1.DatabaseUpgrade{
+		FromVersion: current,
+		FromImage:   sts.Spec.Template.Spec.Containers[0].Image,
+		ToVersion:   desired,
+		ToImage:     controllers.PostgresImage(pulp),
+		PreviousPVC: controllers.DatabasePVC(pulp),
+		PVC:         settings.DefaultDBPVC(pulp.Name) + "-" + desired,
+		StartTime:   metav1.Now(),
+	}
+	log.Info("Upgrading the database from PostgreSQL " + current + " to " + desired)
+	r.recorder.Event(pulp, corev1.EventTypeNormal, "DatabaseUpgradeStarted", "Upgrading the database from PostgreSQL "+current+" to "+desired)
+	if pulp.Status.DatabaseUpgrade.PVC == pulp.Status.DatabaseUpgrade.PreviousPVC {
+		return r.failDatabaseUpgrade(ctx, pulp, log, "the database is already stored in PVC "+pulp.Status.DatabaseUpgrade.PVC)
+	}
+	return r.setDatabaseUpgradePhase(ctx, pulp, databaseUpgradeScalingDown, "")
+}
+
+// finishDatabaseUpgrade reloads the dump into the database recreated with the new version, starts Pulp
+// again and records the new version. If the dump cannot be reloaded, the database is moved back to the
+// previous PVC and version.
+func (r *RepoManagerReconciler) finishDatabaseUpgrade(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger) (ctrl.Result, error) {
+	upgrade := pulp.Status.DatabaseUpgrade
+	if upgrade == nil || upgrade.Phase != databaseUpgradeRestoring {
+		return ctrl.Result{}, nil
+	}
+
+	sts := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: settings.DefaultDBStatefulSet(pulp.Name), Namespace: pulp.Namespace}, sts); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if sts.Status.ReadyReplicas == 0 || sts.Status.ReadyReplicas != sts.Status.Replicas {
+		log.Info("Waiting db pod get into a READY state ...")
+		return ctrl.Result{RequeueAfter: databaseUpgradeRequeue}, nil
+	}
+
+	log.Info("Reloading the database dump into PostgreSQL " + upgrade.ToVersion + " ...")
+	finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulp, databaseUpgradeRestoreJob(pulp))
+	jobErr := &controllers.JobFailedError{}
+	if goerrors.As(err, &jobErr) {
+		pulp.Status.DatabasePVC = upgrade.PreviousPVC
+		return r.failDatabaseUpgrade(ctx, pulp, log, "failed to reload the database: "+jobErr.Message)
+	} else if !finished || err != nil {
+		return ctrl.Result{RequeueAfter: databaseUpgradeRequeue}, err
+	}
+
+	if err := r.resumeDatabaseClients(ctx, pulp); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.deleteDatabaseUpgradeJobs(ctx, pulp)
+	message := fmt.Sprintf("Database upgraded from PostgreSQL %s to %s, the previous data is kept in PVC %s", upgrade.FromVersion, upgrade.ToVersion, upgrade.PreviousPVC)
+	log.Info(message)
+	r.recorder.Event(pulp, corev1.EventTypeNormal, "DatabaseUpgraded", message)
+	pulp.Status.DatabaseVersion = upgrade.ToVersion
+	pulp.Status.DatabaseUpgrade = nil
+	if err := r.Status().Update(ctx, pulp); err != nil {
+		log.Error(err, "Failed to update the database version in Pulp status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// newerMajorVersion returns true if the major version a is more recent than b
+func newerMajorVersion(a, b string) bool {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	return errA == nil && errB == nil && x > y
+}
+
+// setDatabaseUpgradePhase stores the phase of the upgrade, and the Pulp-Database-Ready condition, in status
+func (r *RepoManagerReconciler) setDatabaseUpgradePhase(ctx context.Context, pulp *pulpv1.Pulp, phase, message string) (ctrl.Result, error) {
+	upgrade := pulp.Status.DatabaseUpgrade
+	upgrade.Phase = phase
+	upgrade.Message = message
+	condition := metav1.Condition{
+		Type:    "Pulp-Database-Ready",
+		Status:  metav1.ConditionFalse,
+		Reason:  "UpgradingDatabase",
+		Message: fmt.Sprintf("Upgrading the database from PostgreSQL %s to %s (%s)", upgrade.FromVersion, upgrade.ToVersion, phase),
+	}
+	if phase == databaseUpgradeFailed {
+		condition.Reason = "DatabaseUpgradeFailed"
+		condition.Message = fmt.Sprintf("Failed to upgrade the database from PostgreSQL %s to %s: %s", upgrade.FromVersion, upgrade.ToVersion, message)
+	}
+	v1.SetStatusCondition(&pulp.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, pulp); err != nil {
+		r.RawLogger.Error(err, "Failed to update the database upgrade phase in Pulp status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: databaseUpgradeRequeue}, nil
+}
+
+// failDatabaseUpgrade records the failure of the upgrade. The previous version of the database is
+// deployed again and the Pulp components are scaled up in the next reconciliation.
+func (r *RepoManagerReconciler) failDatabaseUpgrade(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger, message string) (ctrl.Result, error) {
+	log.Info("Database upgrade failed: " + message)
+	r.recorder.Event(pulp, corev1.EventTypeWarning, "DatabaseUpgradeFailed", message)
+	return r.setDatabaseUpgradePhase(ctx, pulp, databaseUpgradeFailed, message)
+}
+
+// scaleDownDatabaseClients scales down the Pulp components connected to the database and returns true
+// when all their pods are terminated. The deployments are scaled directly since they are not reconciled
+// during the upgrade.
+func (r *RepoManagerReconciler) scaleDownDatabaseClients(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger) (bool, error) {
+	upgrade := pulp.Status.DatabaseUpgrade
+
+	// the replicas are stored before scaling down so that they can be restored even if the operator is restarted
+	if len(upgrade.ScaledDownComponents) == 0 {
+		for _, component := range databaseUpgradeComponents {
+			deployment := &appsv1.Deployment{}
+			if err := r.Get(ctx, types.NamespacedName{Name: component.DeploymentName(pulp.Name), Namespace: pulp.Namespace}, deployment); errors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return false, err
+			}
+			if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas > 0 {
+				upgrade.ScaledDownComponents = append(upgrade.ScaledDownComponents, pulpv1.QuiescedComponent{Name: string(component), Replicas: *deployment.Spec.Replicas})
+			}
+		}
+		if len(upgrade.ScaledDownComponents) > 0 {
+			if err := r.Status().Update(ctx, pulp); err != nil {
+				log.Error(err, "Failed to store the replicas of Pulp components")
+				return false, err
+			}
+		}
+	}
+
+	stopped := true
+	for _, component := range databaseUpgradeComponents {
+		deployment := &appsv1.Deployment{}
+		if err := r.Get(ctx, types.NamespacedName{Name: component.DeploymentName(pulp.Name), Namespace: pulp.Namespace}, deployment); errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas > 0 {
+			log.Info("Scaling down " + deployment.Name + " for the database upgrade")
+			replicas := int32(0)
+			deployment.Spec.Replicas = &replicas
+			if err := r.Update(ctx, deployment); err != nil {
+				log.Error(err, "Failed to scale down "+deployment.Name)
+				return false, err
+			}
+		}
+		if deployment.Status.Replicas > 0 {
+			log.Info("Waiting for " + deployment.Name + " pods to be terminated ...")
+			stopped = false
+		}
+	}
+	return stopped, nil
+}
+
+// resumeDatabaseClients restores the replicas of the components scaled down by scaleDownDatabaseClients
+func (r *RepoManagerReconciler) resumeDatabaseClients(ctx context.Context, pulp *pulpv1.Pulp) error {
+	upgrade := pulp.Status.DatabaseUpgrade
+	if len(upgrade.ScaledDownComponents) == 0 {
+		return nil
+	}
+	for _, component := range upgrade.ScaledDownComponents {
+		deployment := &appsv1.Deployment{}
+		if err := r.Get(ctx, types.NamespacedName{Name: settings.PulpcoreType(component.Name).DeploymentName(pulp.Name), Namespace: pulp.Namespace}, deployment); errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		replicas := component.Replicas
+		deployment.Spec.Replicas = &replicas
+		if err := r.Update(ctx, deployment); err != nil {
+			r.RawLogger.Error(err, "Failed to restore the replicas of "+deployment.Name)
+			return err
+		}
+	}
+	upgrade.ScaledDownComponents = nil
+	return r.Status().Update(ctx, pulp)
+}
+
+// ensureDatabaseUpgradePVC creates the PVC the database is upgraded to, with the storage class and access
+// modes of the previous one, and returns true if it was created. A NotFound error is returned if the
+// previous PVC does not exist.
+func (r *RepoManagerReconciler) ensureDatabaseUpgradePVC(ctx context.Context, pulp *pulpv1.Pulp, log logr.Logger) (bool, error) {
+	upgrade := pulp.Status.DatabaseUpgrade
+	if err := r.Get(ctx, types.NamespacedName{Name: upgrade.PVC, Namespace: pulp.Namespace}, &corev1.PersistentVolumeClaim{}); err == nil || !errors.IsNotFound(err) {
+		return false, err
+	}
+	previous := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Name: upgrade.PreviousPVC, Namespace: pulp.Namespace}, previous); err != nil {
+		return false, err
+	}
+
+	// the new PVC should store the data of the database and its dump
+	size := previous.Spec.Resources.Requests[corev1.ResourceStorage]
+	if requested, err := resource.ParseQuantity(pulp.Spec.Database.PostgresStorageRequirements); err == nil && requested.Cmp(size) > 0 {
+		size = requested
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      upgrade.PVC,
+			Namespace: pulp.Namespace,
+			Labels:    labelsForDatabase(pulp),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      previous.Spec.AccessModes,
+			StorageClassName: previous.Spec.StorageClassName,
+			VolumeMode:       previous.Spec.VolumeMode,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+		},
+	}
+	log.Info("Creating PVC "+pvc.Name+" for the database upgrade", "Size", size.String())
+	return true, r.Create(ctx, pvc)
+}
+
+// databaseUpgradeDump returns the path of the dump of the database in the upgrade jobs
+func databaseUpgradeDump(upgrade *pulpv1.DatabaseUpgrade) string {
+	return controllers.BackupMountPath + "/" + databaseUpgradeDir + "/pulp-" + upgrade.FromVersion + ".dump"
+}
+
+// databaseUpgradeJob returns a job, with the new database PVC mounted in controllers.BackupMountPath,
+// that runs script in image
+func databaseUpgradeJob(pulp *pulpv1.Pulp, suffix, image string, affinity *corev1.Affinity, script string) *batchv1.Job {
+	job := controllers.BackupManagerJob(pulp.Name+suffix, pulp.Namespace, pulp.Status.DatabaseUpgrade.PVC, affinity, script)
+	container := &job.Spec.Template.Spec.Containers[0]
+	container.Image = image
+	container.Env = controllers.PostgresEnv(settings.DefaultDBSecret(pulp.Name))
+	// the files in the database PVC are owned by the postgres user
+	job.Spec.Template.Spec.SecurityContext = controllers.PostgresSecurityContext()
+	return job
+}
+
+// databaseUpgradeDumpJob returns the job that dumps the database, with the previous version, into the
+// upgrade directory of the new PVC. The data left in the PVC by a previous upgrade is removed.
+func databaseUpgradeDumpJob(pulp *pulpv1.Pulp) *batchv1.Job {
+	upgrade := pulp.Status.DatabaseUpgrade
+	dataDir := filepath.Base(filepath.Dir(controllers.PostgresDataPath(pulp)))
+	script := fmt.Sprintf(`set -e
+rm -rf %[1]s/%[2]s %[1]s/%[3]s
+mkdir -p %[1]s/%[3]s
+pg_dump -Fc -f %[4]s`, controllers.BackupMountPath, dataDir, databaseUpgradeDir, databaseUpgradeDump(upgrade))
+	return databaseUpgradeJob(pulp, databaseUpgradeDumpJobSuffix, upgrade.FromImage, nil, script)
+}
+
+// databaseUpgradeRestoreJob returns the job that reloads the dump into the database with the new version.
+// It runs on the node of the database pod, since both mount the new PVC.
+// The WAL archived before the upgrade cannot be replayed on the new version, so a new base backup is
+// requested from the base-backup sidecar.
+func databaseUpgradeRestoreJob(pulp *pulpv1.Pulp) *batchv1.Job {
+	upgrade := pulp.Status.DatabaseUpgrade
+	affinity := &corev1.Affinity{PodAffinity: &corev1.PodAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+			LabelSelector: &metav1.LabelSelector{MatchLabels: labelsForDatabase(pulp)},
+			TopologyKey:   "kubernetes.io/hostname",
+		}},
+	}}
+	script := fmt.Sprintf(`set -e
+pg_restore --clean --if-exists -d "$PGDATABASE" %s`, databaseUpgradeDump(upgrade))
+	archive := pulp.Spec.Database.WALArchive
+	if archive != nil && archive.ObjectStorage == nil {
+		script += "\nrm -f " + controllers.WALArchiveMountPath + "/.last-base-backup"
+	}
+	job := databaseUpgradeJob(pulp, databaseUpgradeRestoreJobSuffix, upgrade.ToImage, affinity, script)
+	if archive != nil && archive.ObjectStorage == nil {
+		podSpec := &job.Spec.Template.Spec
+		mount, volume := controllers.WALArchiveMount(archive, "")
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, mount)
+		podSpec.Volumes = append(podSpec.Volumes, *volume)
+	}
+	return job
+}
+
+// deleteDatabaseUpgradeJobs removes the jobs of the upgrade, so that they can run again in the next one
+func (r *RepoManagerReconciler) deleteDatabaseUpgradeJobs(ctx context.Context, pulp *pulpv1.Pulp) {
+	for _, suffix := range []string{databaseUpgradeDumpJobSuffix, databaseUpgradeRestoreJobSuffix} {
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: pulp.Name + suffix, Namespace: pulp.Namespace}}
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+			r.RawLogger.Error(err, "Failed to remove job "+job.Name)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newUpgradeReconciler returns a reconciler with a Pulp whose database, deployed with postgres 15,
+// should be upgraded to postgres 16
+func newUpgradeReconciler(t *testing.T) (*RepoManagerReconciler, *pulpv1.Pulp) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = pulpv1.AddToScheme(scheme)
+
+	storageClass := "standard"
+	pulp := &pulpv1.Pulp{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+		Spec: pulpv1.PulpSpec{Database: pulpv1.Database{
+			PostgresImage:        "docker.io/library/postgres:16",
+			PostgresStorageClass: &storageClass,
+		}},
+	}
+	objects := []client.Object{pulp,
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "pulp-database", Namespace: "test-namespace"},
+			Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "postgres", Image: "docker.io/library/postgres:15"}},
+			}}},
+		},
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "pulp-postgres-pulp-database-0", Namespace: "test-namespace"},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				StorageClassName: &storageClass,
+				Resources:        corev1.VolumeResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("20Gi")}},
+			},
+		},
+	}
+	for _, component := range databaseUpgradeComponents {
+		replicas := int32(2)
+		objects = append(objects, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: component.DeploymentName("pulp"), Namespace: "test-namespace"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{Replicas: replicas},
+		})
+	}
+	r := &RepoManagerReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).WithStatusSubresource(pulp).Build(),
+		RawLogger: logr.Discard(),
+		Scheme:    scheme,
+		recorder:  record.NewFakeRecorder(20),
+	}
+	return r, pulp
+}
+
+// upgradeStep runs upgradeDatabase and finishDatabaseUpgrade, as databaseController does
+func upgradeStep(t *testing.T, r *RepoManagerReconciler, pulp *pulpv1.Pulp) {
+	t.Helper()
+	ctx := context.TODO()
+	if result, err := r.upgradeDatabase(ctx, pulp, r.RawLogger); err != nil || needsRequeue(err, result) {
+		return
+	}
+	if _, err := r.finishDatabaseUpgrade(ctx, pulp, r.RawLogger); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setJobCondition finishes the job with the condition
+func setJobCondition(t *testing.T, r *RepoManagerReconciler, name string, condition batchv1.JobConditionType) *batchv1.Job {
+	t.Helper()
+	job := &batchv1.Job{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: "test-namespace"}, job); err != nil {
+		t.Fatal(err)
+	}
+	job.Status.Conditions = []batchv1.JobCondition{{Type: condition, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"}}
+	if err := r.Status().Update(context.TODO(), job); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// recreateStatefulSet creates the database StatefulSet expected by the databaseController, and ready
+func recreateStatefulSet(t *testing.T, r *RepoManagerReconciler, pulp *pulpv1.Pulp) *appsv1.StatefulSet {
+	t.Helper()
+	sts := statefulSetForDatabase(pulp, nil)
+	if err := r.Create(context.TODO(), sts); err != nil {
+		t.Fatal(err)
+	}
+	sts.Status = appsv1.StatefulSetStatus{Replicas: 1, ReadyReplicas: 1}
+	if err := r.Status().Update(context.TODO(), sts); err != nil {
+		t.Fatal(err)
+	}
+	return sts
+}
+
+// TestUpgradeDatabase verifies the steps of a database major version upgrade
+func TestUpgradeDatabase(t *testing.T) {
+	r, pulp := newUpgradeReconciler(t)
+	ctx := context.TODO()
+
+	upgradeStep(t, r, pulp)
+	upgrade := pulp.Status.DatabaseUpgrade
+	if pulp.Status.DatabaseVersion != "15" || upgrade == nil || upgrade.Phase != databaseUpgradeScalingDown {
+		t.Fatalf("expected the upgrade from 15 to start, got version %q and upgrade %+v", pulp.Status.DatabaseVersion, upgrade)
+	}
+	if upgrade.PreviousPVC != "pulp-postgres-pulp-database-0" || upgrade.PVC != "pulp-postgres-16" || upgrade.FromImage != "docker.io/library/postgres:15" {
+		t.Errorf("unexpected upgrade %+v", upgrade)
+	}
+	if image := statefulSetForDatabase(pulp, nil).Spec.Template.Spec.Containers[0].Image; image != "docker.io/library/postgres:15" {
+		t.Errorf("expected the database to keep postgres 15 during the upgrade, got %v", image)
+	}
+
+	// the Pulp components are scaled down
+	upgradeStep(t, r, pulp)
+	if len(pulp.Status.DatabaseUpgrade.ScaledDownComponents) != 3 || pulp.Status.DatabaseUpgrade.Phase != databaseUpgradeScalingDown {
+		t.Fatalf("expected the replicas of 3 components to be stored, got %+v", pulp.Status.DatabaseUpgrade)
+	}
+	for _, component := range databaseUpgradeComponents {
+		deployment := &appsv1.Deployment{}
+		if err := r.Get(ctx, types.NamespacedName{Name: component.DeploymentName("pulp"), Namespace: "test-namespace"}, deployment); err != nil {
+			t.Fatal(err)
+		}
+		if *deployment.Spec.Replicas != 0 {
+			t.Errorf("expected %v to be scaled down", deployment.Name)
+		}
+		deployment.Status.Replicas = 0
+		if err := r.Status().Update(ctx, deployment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upgradeStep(t, r, pulp)
+	if pulp.Status.DatabaseUpgrade.Phase != databaseUpgradeDumping {
+		t.Fatalf("expected the database to be dumped, got %+v", pulp.Status.DatabaseUpgrade)
+	}
+
+	// the new PVC is created and the database dumped into it with postgres 15
+	upgradeStep(t, r, pulp)
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pulp-postgres-16", Namespace: "test-namespace"}, pvc); err != nil {
+		t.Fatal(err)
+	}
+	if size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "20Gi" || *pvc.Spec.StorageClassName != "standard" {
+		t.Errorf("expected a 20Gi PVC with the standard storage class, got %v %v", size.String(), *pvc.Spec.StorageClassName)
+	}
+	upgradeStep(t, r, pulp)
+	job := setJobCondition(t, r, "pulp"+databaseUpgradeDumpJobSuffix, batchv1.JobComplete)
+	container := job.Spec.Template.Spec.Containers[0]
+	if container.Image != "docker.io/library/postgres:15" || !strings.Contains(container.Command[2], "pg_dump -Fc -f /backups/upgrade/pulp-15.dump") {
+		t.Errorf("unexpected dump job %v: %v", container.Image, container.Command)
+	}
+	if claim := job.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName; claim != "pulp-postgres-16" {
+		t.Errorf("expected the dump to be stored in the new PVC, got %v", claim)
+	}
+	upgradeStep(t, r, pulp)
+	if pulp.Status.DatabaseUpgrade.Phase != databaseUpgradeSwitching {
+		t.Fatalf("expected the StatefulSet to be switched, got %+v", pulp.Status.DatabaseUpgrade)
+	}
+
+	// the StatefulSet is recreated on the new PVC with postgres 16
+	upgradeStep(t, r, pulp)
+	if err := r.Get(ctx, types.NamespacedName{Name: "pulp-database", Namespace: "test-namespace"}, &appsv1.StatefulSet{}); !errors.IsNotFound(err) {
+		t.Fatalf("expected the StatefulSet to be removed, got %v", err)
+	}
+	upgradeStep(t, r, pulp)
+	if pulp.Status.DatabaseUpgrade.Phase != databaseUpgradeRestoring || pulp.Status.DatabasePVC != "pulp-postgres-16" {
+		t.Fatalf("expected the dump to be reloaded in the new PVC, got %+v", pulp.Status.DatabaseUpgrade)
+	}
+	sts := recreateStatefulSet(t, r, pulp)
+	podSpec := sts.Spec.Template.Spec
+	if podSpec.Containers[0].Image != "docker.io/library/postgres:16" || len(sts.Spec.VolumeClaimTemplates) > 0 || podSpec.Volumes[0].PersistentVolumeClaim.ClaimName != "pulp-postgres-16" {
+		t.Errorf("expected the StatefulSet to run postgres 16 on the new PVC, got %v %+v", podSpec.Containers[0].Image, podSpec.Volumes)
+	}
+
+	// the dump is reloaded and Pulp is scaled up
+	upgradeStep(t, r, pulp)
+	job = setJobCondition(t, r, "pulp"+databaseUpgradeRestoreJobSuffix, batchv1.JobComplete)
+	if job.Spec.Template.Spec.Containers[0].Image != "docker.io/library/postgres:16" || job.Spec.Template.Spec.Affinity.PodAffinity == nil {
+		t.Errorf("expected the dump to be reloaded with postgres 16 on the node of the database, got %+v", job.Spec.Template.Spec)
+	}
+	upgradeStep(t, r, pulp)
+	if pulp.Status.DatabaseUpgrade != nil || pulp.Status.DatabaseVersion != "16" {
+		t.Fatalf("expected the upgrade to finish, got version %q and upgrade %+v", pulp.Status.DatabaseVersion, pulp.Status.DatabaseUpgrade)
+	}
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: settings.WORKER.DeploymentName("pulp"), Namespace: "test-namespace"}, deployment); err != nil || *deployment.Spec.Replicas != 2 {
+		t.Errorf("expected the worker replicas to be restored: %v", err)
+	}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pulp-postgres-pulp-database-0", Namespace: "test-namespace"}, &corev1.PersistentVolumeClaim{}); err != nil {
+		t.Errorf("expected the previous PVC to be kept: %v", err)
+	}
+}
+
+// TestUpgradeDatabaseFailure verifies that the previous database is deployed again if the dump cannot be reloaded
+func TestUpgradeDatabaseFailure(t *testing.T) {
+	r, pulp := newUpgradeReconciler(t)
+	ctx := context.TODO()
+	upgradeStep(t, r, pulp)
+	pulp.Status.DatabaseUpgrade.Phase = databaseUpgradeRestoring
+	pulp.Status.DatabaseUpgrade.ScaledDownComponents = []pulpv1.QuiescedComponent{{Name: string(settings.API), Replicas: 3}}
+	pulp.Status.DatabasePVC = "pulp-postgres-16"
+	if err := r.Status().Update(ctx, pulp); err != nil {
+		t.Fatal(err)
+	}
+	sts := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "pulp-database", Namespace: "test-namespace"}, sts); err != nil {
+		t.Fatal(err)
+	}
+	sts.Status = appsv1.StatefulSetStatus{Replicas: 1, ReadyReplicas: 1}
+	if err := r.Status().Update(ctx, sts); err != nil {
+		t.Fatal(err)
+	}
+
+	upgradeStep(t, r, pulp)
+	setJobCondition(t, r, "pulp"+databaseUpgradeRestoreJobSuffix, batchv1.JobFailed)
+	upgradeStep(t, r, pulp)
+	upgrade := pulp.Status.DatabaseUpgrade
+	if upgrade.Phase != databaseUpgradeFailed || !strings.HasPrefix(upgrade.Message, "failed to reload the database") {
+		t.Fatalf("expected the upgrade to fail, got %+v", upgrade)
+	}
+	expected := statefulSetForDatabase(pulp, nil).Spec.Template.Spec
+	if expected.Containers[0].Image != "docker.io/library/postgres:15" || expected.Volumes[0].PersistentVolumeClaim.ClaimName != "pulp-postgres-pulp-database-0" {
+		t.Errorf("expected the database to be rolled back to postgres 15 on the previous PVC, got %v %+v", expected.Containers[0].Image, expected.Volumes)
+	}
+
+	// Pulp is scaled up with the previous database
+	upgradeStep(t, r, pulp)
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: settings.API.DeploymentName("pulp"), Namespace: "test-namespace"}, deployment); err != nil || *deployment.Spec.Replicas != 3 {
+		t.Errorf("expected the api replicas to be restored: %v", err)
+	}
+
+	// the failed upgrade is cleared once postgres_image is modified
+	pulp.Spec.Database.PostgresImage = "docker.io/library/postgres:15"
+	upgradeStep(t, r, pulp)
+	if pulp.Status.DatabaseUpgrade != nil || pulp.Status.DatabaseVersion != "15" {
+		t.Errorf("expected the failed upgrade to be cleared, got %+v", pulp.Status.DatabaseUpgrade)
+	}
+}
+
+// TestDatabaseDowngrade verifies that the database is not downgraded
+func TestDatabaseDowngrade(t *testing.T) {
+	r, pulp := newUpgradeReconciler(t)
+	pulp.Spec.Database.PostgresImage = "docker.io/library/postgres:14"
+	result, err := r.upgradeDatabase(context.TODO(), pulp, r.RawLogger)
+	if err != nil || !needsRequeue(err, result) || pulp.Status.DatabaseUpgrade != nil {
+		t.Errorf("expected the downgrade to be refused, got %+v", pulp.Status.DatabaseUpgrade)
+	}
+}
@@ -131,15 +131,15 @@ func pulpcoreVolumes(pulp *pulpv1.Pulp, adminSecretName string) []corev1.Volume
 				},
 			},
 		}
-		return append(volumes, adminSecret)
+		volumes = append(volumes, adminSecret)
 	}
 
-	return volumes
+	return controllers.SetDatabaseCAVolumes(pulp, volumes)
 }
 
 // pulpcoreVolumeMounts defines the list of volumeMounts from pulpcore containers
 func pulpcoreVolumeMounts(pulp *pulpv1.Pulp) []corev1.VolumeMount {
-	return []corev1.VolumeMount{
+	volumeMounts := []corev1.VolumeMount{
 		{
 			Name:      pulp.Name + "-server",
 			MountPath: "/etc/pulp/settings.py",
@@ -153,6 +153,7 @@ func pulpcoreVolumeMounts(pulp *pulpv1.Pulp) []corev1.VolumeMount {
 			ReadOnly:  true,
 		},
 	}
+	return controllers.SetDatabaseCAVolumeMounts(pulp, volumeMounts)
 }
 
 // resetAdminPasswordContainer defines the container spec for the reset admin password job
@@ -236,6 +237,8 @@ func (r *RepoManagerReconciler) migrationJob(ctx context.Context, pulp *pulpv1.P
 func migrationContainer(pulp *pulpv1.Pulp) corev1.Container {
 	// env vars
 	envVars := controllers.GetPostgresEnvVars(*pulp)
+	// the migrations rely on session state, which is not kept by the pooler in transaction mode
+	envVars = append(envVars, controllers.GetDatabaseDirectEnvVars(*pulp)...)
 	envVars = append(envVars, controllers.SetCustomEnvVars(*pulp, "MigrationJob")...)
 
 	// volume mounts
@@ -577,6 +580,7 @@ func initContainer(pulp *pulpv1.Pulp, resources corev1.ResourceRequirements, ima
 			ReadOnly:  true,
 		},
 	}
+	volumeMounts = controllers.SetDatabaseCAVolumeMounts(pulp, volumeMounts)
 
 	allowPrivilegeEscalation, runAsNonRoot := false, true
 	securityContext := &corev1.SecurityContext{
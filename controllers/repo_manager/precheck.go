@@ -19,6 +19,8 @@ package repo_manager
 import (
 	"context"
 	"encoding/json"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/go-logr/logr"
@@ -54,6 +56,24 @@ func prechecks(ctx context.Context, r *RepoManagerReconciler, pulp *pulpv1.Pulp)
 		return reconcile, nil
 	}
 
+	// verify if a single wal_archive target is defined for the database deployed by the operator
+	if reconcile := checkWALArchive(r.RawLogger, pulp); reconcile != nil {
+		return reconcile, nil
+	}
+
+	// verify if the database replication can be enabled
+	if reconcile := checkDatabaseHA(r.RawLogger, pulp); reconcile != nil {
+		return reconcile, nil
+	}
+
+	if reconcile := checkDatabaseTuning(r.RawLogger, pulp); reconcile != nil {
+		return reconcile, nil
+	}
+
+	if reconcile := checkDatabaseTLS(r.RawLogger, pulp); reconcile != nil {
+		return reconcile, nil
+	}
+
 	// verify if ingress_type==route in a non-ocp cluster
 	if reconcile := checkRouteNotOCP(r.RawLogger, pulp); reconcile != nil {
 		return reconcile, nil
@@ -186,6 +206,93 @@ func checkStorageDefinitions(log logr.Logger, pulp *pulpv1.Pulp) *ctrl.Result {
 	return nil
 }
 
+// checkWALArchive verifies if database.wal_archive defines either a pvc or an object_storage, and that it
+// is not used with an external database
+func checkWALArchive(log logr.Logger, pulp *pulpv1.Pulp) *ctrl.Result {
+	archive := pulp.Spec.Database.WALArchive
+	if archive == nil {
+		return nil
+	}
+	if len(pulp.Spec.Database.ExternalDBSecret) > 0 {
+		log.Error(nil, "database.wal_archive is only available for the database deployed by the operator. Please, remove it or the external_db_secret.")
+		return &ctrl.Result{}
+	}
+	if (len(archive.PVC) > 0) == (archive.ObjectStorage != nil) {
+		log.Error(nil, "database.wal_archive should define either a pvc or an object_storage.")
+		return &ctrl.Result{}
+	}
+	return nil
+}
+
+// checkDatabaseHA verifies if database.high_availability is used with a database deployed by the operator,
+// on a PVC per pod, and with a PostgreSQL version able to promote a standby through SQL
+func checkDatabaseHA(log logr.Logger, pulp *pulpv1.Pulp) *ctrl.Result {
+	if pulp.Spec.Database.HighAvailability == nil {
+		return nil
+	}
+	if len(pulp.Spec.Database.ExternalDBSecret) > 0 {
+		log.Error(nil, "database.high_availability is only available for the database deployed by the operator. Please, remove it or the external_db_secret.")
+		return &ctrl.Result{}
+	}
+	if len(pulp.Spec.Database.PVC) > 0 {
+		log.Error(nil, "database.high_availability needs a PVC per database pod. Please, define database.postgres_storage_class instead of database.pvc.")
+		return &ctrl.Result{}
+	}
+	// the standbys could not follow a primary recovered from the WAL archive, see the high availability
+	// limitations in the database configuration guide
+	if pulp.Spec.Database.WALArchive != nil {
+		log.Error(nil, "database.high_availability cannot be used with database.wal_archive. Please, remove one of them.")
+		return &ctrl.Result{}
+	}
+	if pulp.Status.DatabaseUpgrade != nil {
+		log.Error(nil, "database.high_availability cannot be enabled while the database is upgraded to a new PostgreSQL major version (a failed upgrade is cleared by setting database.postgres_image back). Please, remove database.high_availability.")
+		return &ctrl.Result{}
+	}
+	// the upgraded database is stored in a single PVC, not in the PVC per pod of the StatefulSet
+	if len(pulp.Status.DatabasePVC) > 0 {
+		log.Error(nil, "database.high_availability cannot be enabled on a database upgraded to a new PostgreSQL major version, which is stored in the single PVC "+pulp.Status.DatabasePVC+". Please, remove database.high_availability.")
+		return &ctrl.Result{}
+	}
+	if version, _ := strconv.Atoi(controllers.PostgresImageMajorVersion(pulp)); version > 0 && version < 12 {
+		log.Error(nil, "database.high_availability needs PostgreSQL 12 or later. Please, remove database.high_availability or update database.postgres_image.")
+		return &ctrl.Result{}
+	}
+	return nil
+}
+
+// checkDatabaseTuning verifies if database.tuning is used with a database deployed by the operator and
+// only overrides valid PostgreSQL parameters not managed by the operator
+func checkDatabaseTuning(log logr.Logger, pulp *pulpv1.Pulp) *ctrl.Result {
+	tuning := pulp.Spec.Database.Tuning
+	if tuning == nil {
+		return nil
+	}
+	if len(pulp.Spec.Database.ExternalDBSecret) > 0 {
+		log.Error(nil, "database.tuning is only available for the database deployed by the operator. Please, remove it or the external_db_secret.")
+		return &ctrl.Result{}
+	}
+	for name := range tuning.Parameters {
+		if !databaseTuningParameter.MatchString(name) {
+			log.Error(nil, "Invalid PostgreSQL parameter \""+name+"\" in database.tuning.parameters.")
+			return &ctrl.Result{}
+		}
+		if slices.Contains(databaseTuningReserved, strings.ToLower(name)) {
+			log.Error(nil, "The PostgreSQL parameter \""+name+"\" is managed by pulp-operator and cannot be defined in database.tuning.parameters.")
+			return &ctrl.Result{}
+		}
+	}
+	return nil
+}
+
+// checkDatabaseTLS verifies if database.tls is used with a database deployed by the operator
+func checkDatabaseTLS(log logr.Logger, pulp *pulpv1.Pulp) *ctrl.Result {
+	if pulp.Spec.Database.TLS != nil && len(pulp.Spec.Database.ExternalDBSecret) > 0 {
+		log.Error(nil, "database.tls is only available for the database deployed by the operator. Please, remove it or the external_db_secret and configure the sslmode in the external_db_secret.")
+		return &ctrl.Result{}
+	}
+	return nil
+}
+
 // checkRouteNotOCP verifies if this is an non-OCP cluster and "ingress_type: route".
 func checkRouteNotOCP(log logr.Logger, pulp *pulpv1.Pulp) *ctrl.Result {
 	isOpenShift, _ := controllers.IsOpenShift()
@@ -292,59 +292,98 @@ func databaseSettings(resources controllers.FunctionResources, pulpSettings *str
 	}
 
 	pulp := resources.Pulp
-	logger := resources.Logger
-	context := resources.Context
-	client := resources.Client
-
-	var dbHost, dbPort, dbUser, dbPass, dbName, dbSSLMode string
+	db, err := databaseConnectionData(resources)
+	if err != nil {
+		return
+	}
 
-	// if there is no external database configuration get the databaseconfig from pulp-postgres-configuration secret
-	if len(pulp.Spec.Database.ExternalDBSecret) == 0 {
-		postgresConfigurationSecret := pulp.Name + "-postgres-configuration"
+	// the certificate of the database deployed by the operator is verified with its CA
+	options := `'sslmode': '` + db.sslMode + `'`
+	if controllers.DatabaseTLSEnabled(*pulp) {
+		options += `, 'sslrootcert': '` + controllers.DatabaseCAMountPath + `/ca.crt'`
+	}
 
-		logger.V(1).Info("Retrieving Postgres credentials from "+postgresConfigurationSecret+" secret", "Secret.Namespace", resources.Pulp.Namespace, "Secret.Name", resources.Pulp.Name)
-		pgCredentials, err := controllers.RetrieveSecretData(context, postgresConfigurationSecret, pulp.Namespace, true, client, "username", "password", "database", "port", "sslmode")
-		if err != nil {
-			logger.Error(err, "Secret Not Found!", "Secret.Namespace", pulp.Namespace, "Secret.Name", pulp.Name)
-			return
+	// the pulpcore processes connect to the pooler, which has the same database and credentials
+	extraOptions := ""
+	if pooler := pulp.Spec.Database.Pooler; pooler != nil {
+		db.host = settings.DBPoolerService(pulp.Name)
+		db.port = strconv.Itoa(databasePoolerPort)
+		if !controllers.DatabaseTLSEnabled(*pulp) {
+			options = `'sslmode': 'prefer'`
 		}
-		dbHost = pulp.Name + "-database-svc"
-		dbPort = pgCredentials["port"]
-		dbUser = pgCredentials["username"]
-		dbPass = pgCredentials["password"]
-		dbName = pgCredentials["database"]
-		dbSSLMode = pgCredentials["sslmode"]
-	} else {
-		logger.V(1).Info("Retrieving Postgres credentials from "+resources.Pulp.Spec.Database.ExternalDBSecret+" secret", "Secret.Namespace", resources.Pulp.Namespace, "Secret.Name", resources.Pulp.Name)
-		externalPostgresData := []string{"POSTGRES_HOST", "POSTGRES_PORT", "POSTGRES_USERNAME", "POSTGRES_PASSWORD", "POSTGRES_DB_NAME", "POSTGRES_SSLMODE"}
-		pgCredentials, err := controllers.RetrieveSecretData(context, pulp.Spec.Database.ExternalDBSecret, pulp.Namespace, true, client, externalPostgresData...)
-		if err != nil {
-			logger.Error(err, "Secret Not Found!", "Secret.Namespace", pulp.Namespace, "Secret.Name", pulp.Name)
-			return
+		// server-side cursors need the same server connection during the whole transaction
+		// https://docs.djangoproject.com/en/stable/ref/databases/#transaction-pooling-server-side-cursors
+		if pooler.PoolMode == databasePoolerTransactionMode {
+			extraOptions = `
+    'DISABLE_SERVER_SIDE_CURSORS': True,`
 		}
-		dbHost = pgCredentials["POSTGRES_HOST"]
-		dbPort = pgCredentials["POSTGRES_PORT"]
-		dbUser = pgCredentials["POSTGRES_USERNAME"]
-		dbPass = pgCredentials["POSTGRES_PASSWORD"]
-		dbName = pgCredentials["POSTGRES_DB_NAME"]
-		dbSSLMode = pgCredentials["POSTGRES_SSLMODE"]
 	}
 
 	*pulpSettings = *pulpSettings + `DATABASES = {
   'default': {
-    'HOST': '` + dbHost + `',
+    'HOST': '` + db.host + `',
     'ENGINE': 'django.db.backends.postgresql_psycopg2',
-    'NAME': '` + dbName + `',
-    'USER': '` + dbUser + `',
-    'PASSWORD': '` + dbPass + `',
-    'PORT': '` + dbPort + `',
+    'NAME': '` + db.name + `',
+    'USER': '` + db.user + `',
+    'PASSWORD': '` + db.password + `',
+    'PORT': '` + db.port + `',
     'CONN_MAX_AGE': 0,
-    'OPTIONS': { 'sslmode': '` + dbSSLMode + `' },
+    'OPTIONS': { ` + options + ` },` + extraOptions + `
   }
 }
 `
 }
 
+// databaseConnection holds the parameters to connect to the database
+type databaseConnection struct {
+	host, port, user, password, name, sslMode string
+}
+
+// databaseConnectionData retrieves the parameters to connect to the database from the pulp-postgres-configuration
+// secret, if the database is deployed by the operator, or from the external_db_secret
+func databaseConnectionData(resources controllers.FunctionResources) (*databaseConnection, error) {
+	pulp := resources.Pulp
+	logger := resources.Logger
+	context := resources.Context
+	client := resources.Client
+
+	// if there is no external database configuration get the databaseconfig from pulp-postgres-configuration secret
+	if len(pulp.Spec.Database.ExternalDBSecret) == 0 {
+		postgresConfigurationSecret := pulp.Name + "-postgres-configuration"
+
+		logger.V(1).Info("Retrieving Postgres credentials from "+postgresConfigurationSecret+" secret", "Secret.Namespace", resources.Pulp.Namespace, "Secret.Name", resources.Pulp.Name)
+		pgCredentials, err := controllers.RetrieveSecretData(context, postgresConfigurationSecret, pulp.Namespace, true, client, "username", "password", "database", "port", "sslmode")
+		if err != nil {
+			logger.Error(err, "Secret Not Found!", "Secret.Namespace", pulp.Namespace, "Secret.Name", pulp.Name)
+			return nil, err
+		}
+		return &databaseConnection{
+			host:     pulp.Name + "-database-svc",
+			port:     pgCredentials["port"],
+			user:     pgCredentials["username"],
+			password: pgCredentials["password"],
+			name:     pgCredentials["database"],
+			sslMode:  pgCredentials["sslmode"],
+		}, nil
+	}
+
+	logger.V(1).Info("Retrieving Postgres credentials from "+resources.Pulp.Spec.Database.ExternalDBSecret+" secret", "Secret.Namespace", resources.Pulp.Namespace, "Secret.Name", resources.Pulp.Name)
+	externalPostgresData := []string{"POSTGRES_HOST", "POSTGRES_PORT", "POSTGRES_USERNAME", "POSTGRES_PASSWORD", "POSTGRES_DB_NAME", "POSTGRES_SSLMODE"}
+	pgCredentials, err := controllers.RetrieveSecretData(context, pulp.Spec.Database.ExternalDBSecret, pulp.Namespace, true, client, externalPostgresData...)
+	if err != nil {
+		logger.Error(err, "Secret Not Found!", "Secret.Namespace", pulp.Namespace, "Secret.Name", pulp.Name)
+		return nil, err
+	}
+	return &databaseConnection{
+		host:     pgCredentials["POSTGRES_HOST"],
+		port:     pgCredentials["POSTGRES_PORT"],
+		user:     pgCredentials["POSTGRES_USERNAME"],
+		password: pgCredentials["POSTGRES_PASSWORD"],
+		name:     pgCredentials["POSTGRES_DB_NAME"],
+		sslMode:  pgCredentials["POSTGRES_SSLMODE"],
+	}, nil
+}
+
 // azureSettings appends azure blob object storage settings into pulpSettings
 func azureSettings(resources controllers.FunctionResources, pulpSettings *string, customSettings map[string]struct{}) {
 	if _, exists := customSettings["STORAGES"]; exists {
@@ -89,6 +89,13 @@ func (r *RepoManagerReconciler) setStatusConditions(ctx context.Context, pulp *p
 			ConditionType: "Pulp-Web-Ready",
 		},
 	}
+	if pulp.Spec.Database.Pooler != nil {
+		pulpResources = append(pulpResources, pulpResource{
+			Type:          "Pooler",
+			Name:          settings.DBPoolerDeployment(pulp.Name),
+			ConditionType: databasePoolerConditionType,
+		})
+	}
 
 	var wg sync.WaitGroup
 
@@ -0,0 +1,68 @@
+package repo_manager_restore
+
+import (
+	"context"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// restoreArtifacts copies the content from the backup (if the backup was made with artifact_copy)
+// into the object storage used by the restored Pulp
+func (r *RepoManagerRestoreReconciler) restoreArtifacts(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupDir string) (bool, error) {
+	log := r.RawLogger
+	if !restoreComponent(pulpRestore, componentArtifacts) {
+		return true, nil
+	}
+
+	pulp := &pulpv1.Pulp{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName, Namespace: pulpRestore.Namespace}, pulp); err != nil {
+		log.Error(err, "Failed to get Pulp CR")
+		return false, err
+	}
+
+	// the content is restored with the pulp dir if Pulp is not deployed with object storage
+	if len(pulp.Spec.ObjectStorageAzureSecret) == 0 && len(pulp.Spec.ObjectStorageS3Secret) == 0 && len(pulp.Spec.ObjectStorageGCSSecret) == 0 {
+		return true, nil
+	}
+
+	remote, err := controllers.NewPulpStorageRemote(ctx, r.Client, pulp)
+	if err != nil {
+		log.Error(err, "Failed to get the object storage configuration from Pulp")
+		return false, err
+	}
+
+	// the service account is provisioned by the pulp controller after restoring pulp CR
+	serviceAccount := &corev1.ServiceAccount{}
+	if err := r.Get(ctx, types.NamespacedName{Name: settings.PulpServiceAccount(pulp.Name), Namespace: pulpRestore.Namespace}, serviceAccount); err != nil {
+		log.Info("Waiting for Pulp service account ...")
+		return false, client.IgnoreNotFound(err)
+	}
+
+	encrypted, err := r.isEncrypted(ctx, pulpRestore)
+	if err != nil {
+		return false, err
+	}
+
+	// objects found in the bucket are overwritten, but the ones that are not in the backup are kept
+	source := backupDir + "/" + controllers.ObjectStorageContentDir
+	script := "set -e\nif [ ! -d " + source + " ]; then\n  echo \"No object storage content found in backup\"\n  exit 0\nfi\n"
+	if encrypted {
+		script += controllers.EncryptedRemoteScript(source) + "rclone copy " + controllers.EncryptedRemote + ": " + remote.Root() + "\n"
+	} else {
+		script += "rclone copy " + source + " " + remote.Root() + "\n"
+	}
+	job := controllers.ObjectStorageScriptJob(pulpRestore.Name+restoreArtifactsJobSuffix, pulpRestore.Namespace, r.getBackupPVCName(ctx, pulpRestore), controllers.BackupMountPath, remote, nil, script)
+	job.Spec.Template.Spec.ServiceAccountName = serviceAccount.Name
+	decryptJob(job, pulpRestore)
+
+	finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpRestore, job)
+	if finished {
+		log.Info("Object storage content restore finished!", "Destination", remote.Root())
+	}
+	return finished, err
+}
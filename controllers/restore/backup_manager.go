@@ -0,0 +1,49 @@
+package repo_manager_restore
+
+import (
+	"context"
+	"encoding/json"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// backupManagerJob returns the job that runs script in the backup-manager image selected for the
+// restore, with backupPVC mounted in controllers.BackupMountPath
+func backupManagerJob(pulpRestore *pulpv1.PulpRestore, jobSuffix, backupPVC, script string) *batchv1.Job {
+	job := controllers.BackupManagerJob(pulpRestore.Name+jobSuffix, pulpRestore.Namespace, backupPVC, nil, script)
+	image := pulpRestore.Status.BackupManagerImage
+	if len(pulpRestore.Spec.BackupManagerImage) > 0 {
+		image = pulpRestore.Spec.BackupManagerImage
+	}
+	controllers.SetBackupManager(job, image, pulpRestore.Spec.ImagePullPolicy, pulpRestore.Spec.ImagePullSecrets, pulpRestore.Spec.ResourceRequirements)
+	return job
+}
+
+// backupManagerImage returns the image used by the restore jobs after the backup verification: the
+// image of the database deployed by the operator or, for an external database, the postgres image
+// with the major version of the server the backup was taken from, since pg_restore can not read the
+// dumps made by a newer pg_dump
+func (r *RepoManagerRestoreReconciler) backupManagerImage(ctx context.Context, pulpRestore *pulpv1.PulpRestore, files map[string][]byte, manifest *controllers.BackupManifest) (string, error) {
+	if len(pulpRestore.Spec.BackupManagerImage) > 0 {
+		return pulpRestore.Spec.BackupManagerImage, nil
+	}
+
+	// the database configuration of an existing Pulp is kept, unless Pulp CR is restored over it
+	pulp := &pulpv1.Pulp{}
+	err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName, Namespace: pulpRestore.Namespace}, pulp)
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	if errors.IsNotFound(err) || replaceExisting(pulpRestore, componentPulpCR) {
+		pulp = &pulpv1.Pulp{}
+		if err := json.Unmarshal(files["cr_object"], &pulp.Spec); err != nil {
+			r.RawLogger.Error(err, "Failed to get cr_object backup file!")
+			return "", err
+		}
+	}
+	return controllers.BackupManagerImage(pulp, manifest.DatabaseVersion), nil
+}
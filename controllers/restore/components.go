@@ -0,0 +1,258 @@
+package repo_manager_restore
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"slices"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// components of a backup that can be selected in PulpRestore .spec.components
+const (
+	componentSecrets    = "Secrets"
+	componentConfigMaps = "ConfigMaps"
+	componentPulpCR     = "PulpCR"
+	componentDatabase   = "Database"
+	componentPulpDir    = "PulpDir"
+	componentArtifacts  = "Artifacts"
+)
+
+// pulpComponents are the Pulp components scaled down while the data of an existing Pulp is restored
+var pulpComponents = []settings.PulpcoreType{settings.API, settings.CONTENT, settings.WORKER, settings.WEB}
+
+// restoreComponent returns true if the component should be restored
+func restoreComponent(pulpRestore *pulpv1.PulpRestore, component string) bool {
+	return len(pulpRestore.Spec.Components) == 0 || slices.Contains(pulpRestore.Spec.Components, component)
+}
+
+// replaceExisting returns true if the resources of the component found in the namespace should be
+// replaced with the ones from backup, which is the case only when the component is explicitly selected.
+// Otherwise, the existing resources are kept (they could have been created in a previous reconciliation).
+func replaceExisting(pulpRestore *pulpv1.PulpRestore, component string) bool {
+	return slices.Contains(pulpRestore.Spec.Components, component)
+}
+
+// restoresData returns true if the database, the pulp dir, or the object storage content is restored
+func restoresData(pulpRestore *pulpv1.PulpRestore) bool {
+	return restoreComponent(pulpRestore, componentDatabase) || restoreComponent(pulpRestore, componentPulpDir) || restoreComponent(pulpRestore, componentArtifacts)
+}
+
+// restoresOntoExistingPulp returns true if the restore runs against a Pulp that was running before the restore
+func restoresOntoExistingPulp(pulpRestore *pulpv1.PulpRestore) bool {
+	return len(pulpRestore.Status.QuiescedComponents) > 0
+}
+
+// quiesceLockHolder returns the value of the controllers.QuiescedByAnnotation set by pulpRestore
+func quiesceLockHolder(pulpRestore *pulpv1.PulpRestore) string {
+	return controllers.QuiesceLockHolder("PulpRestore", pulpRestore.Name)
+}
+
+// createdByRestore returns true if the Pulp CR was created by the restore, which deploys it (with all the
+// components scaled down until its data is restored) with the controllers.QuiescedByAnnotation and without
+// original settings of the components
+func createdByRestore(pulpRestore *pulpv1.PulpRestore, pulp *pulpv1.Pulp) bool {
+	_, quiesced := pulp.Annotations[controllers.QuiescedComponentsAnnotation]
+	return pulp.Annotations[controllers.QuiescedByAnnotation] == quiesceLockHolder(pulpRestore) && !quiesced
+}
+
+// lockPulp sets the controllers.QuiescedByAnnotation in Pulp CR and returns false while a PulpBackup or
+// another PulpRestore holds it, the replicas found in Pulp CR are not the original ones in this case
+func (r *RepoManagerRestoreReconciler) lockPulp(ctx context.Context, pulpRestore *pulpv1.PulpRestore, pulp *pulpv1.Pulp) (bool, error) {
+	locked, err := controllers.LockPulp(ctx, r.Client, r.RawLogger, quiesceLockHolder(pulpRestore), pulp, pulpComponents)
+	removedErr := &controllers.QuiescedByRemovedError{}
+	if goerrors.As(err, &removedErr) {
+		return false, &restoreFailedError{"QuiescedByRemovedBackup", removedErr.Error()}
+	}
+	return locked, err
+}
+
+// unlockPulp removes the controllers.QuiescedByAnnotation set by pulpRestore from Pulp CR (which is not updated)
+func unlockPulp(pulpRestore *pulpv1.PulpRestore, pulp *pulpv1.Pulp) {
+	if pulp.Annotations[controllers.QuiescedByAnnotation] == quiesceLockHolder(pulpRestore) {
+		controllers.UnlockPulp(pulp)
+	}
+}
+
+// pulpRequired returns the error that stops the restore if Pulp CR is not found and the data
+// components are selected without the PulpCR component
+func pulpRequired(pulpRestore *pulpv1.PulpRestore) error {
+	if restoresData(pulpRestore) && !restoreComponent(pulpRestore, componentPulpCR) {
+		return &restoreFailedError{"PulpNotFound", fmt.Sprintf("Pulp %v not found. Add %v to components to restore it from backup.", pulpRestore.Spec.DeploymentName, componentPulpCR)}
+	}
+	return nil
+}
+
+// checkComponents locks an existing Pulp and stores the replicas and HPA settings of its components, so
+// that they can be restored at the end of the restore. It returns false while Pulp is quiesced by a
+// PulpBackup or another PulpRestore.
+// The data components can only be restored into a Pulp CR, which should be restored (PulpCR component)
+// if it does not exist.
+func (r *RepoManagerRestoreReconciler) checkComponents(ctx context.Context, pulpRestore *pulpv1.PulpRestore) (bool, error) {
+	log := r.RawLogger
+	pulp := &pulpv1.Pulp{}
+	err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName, Namespace: pulpRestore.Namespace}, pulp)
+	if errors.IsNotFound(err) {
+		return true, pulpRequired(pulpRestore)
+	} else if err != nil {
+		log.Error(err, "Failed to get Pulp CR")
+		return false, err
+	}
+
+	if restoresOntoExistingPulp(pulpRestore) || createdByRestore(pulpRestore, pulp) {
+		return true, nil
+	}
+
+	if locked, err := r.lockPulp(ctx, pulpRestore, pulp); !locked {
+		return false, err
+	}
+
+	// the settings are stored before modifying Pulp CR so that they can be restored even if the operator is restarted
+	original, err := controllers.OriginalComponents(pulp, pulpComponents)
+	if err != nil {
+		log.Error(err, "Failed to read the "+controllers.QuiescedComponentsAnnotation+" annotation")
+		return false, err
+	}
+	pulpRestore.Status.QuiescedComponents = original
+	log.Info("Restoring into an existing Pulp", "Components", pulpRestore.Spec.Components)
+	if err := r.Status().Update(ctx, pulpRestore); err != nil {
+		log.Error(err, "Failed to store the replicas of Pulp components")
+		return false, err
+	}
+	return true, nil
+}
+
+// quiescePulp scales down the components of an existing Pulp before restoring its data and returns
+// true when all their pods are terminated
+func (r *RepoManagerRestoreReconciler) quiescePulp(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupDir string) (bool, error) {
+	log := r.RawLogger
+	if !restoresOntoExistingPulp(pulpRestore) || !restoresData(pulpRestore) {
+		return true, nil
+	}
+
+	pulp := &pulpv1.Pulp{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName, Namespace: pulpRestore.Namespace}, pulp); err != nil {
+		log.Error(err, "Failed to get Pulp CR")
+		return false, err
+	}
+
+	// the migrations are also disabled until the data is restored, as it is done for the Pulp CR created by the restore
+	scaled := !pulp.Spec.DisableMigrations
+	for _, component := range pulpComponents {
+		if replicas, hpa := controllers.ComponentScale(pulp, component); *replicas != 0 || *hpa != nil {
+			*replicas, *hpa = 0, nil
+			scaled = true
+		}
+	}
+	pulp.Spec.DisableMigrations = true
+	if scaled {
+		log.Info("Scaling down Pulp components ...")
+		if err := r.Update(ctx, pulp); err != nil {
+			log.Error(err, "Failed to scale down Pulp components")
+			return false, err
+		}
+		return false, nil
+	}
+
+	for _, component := range pulpComponents {
+		deployment := &appsv1.Deployment{}
+		err := r.Get(ctx, types.NamespacedName{Name: component.DeploymentName(pulp.Name), Namespace: pulp.Namespace}, deployment)
+		if err != nil && !errors.IsNotFound(err) {
+			return false, err
+		}
+		if err == nil && deployment.Status.Replicas > 0 {
+			log.Info("Waiting for " + deployment.Name + " pods to be terminated ...")
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// resumePulp restores the replicas and HPA settings of the components of an existing Pulp (stored by
+// checkComponents), removes the controllers.QuiescedByAnnotation, and returns true when the api deployment
+// is ready
+func (r *RepoManagerRestoreReconciler) resumePulp(ctx context.Context, pulpRestore *pulpv1.PulpRestore, pulp *pulpv1.Pulp) (bool, error) {
+	resumed := pulp.DeepCopy()
+	for _, component := range pulpRestore.Status.QuiescedComponents {
+		if replicas, hpa := controllers.ComponentScale(resumed, settings.PulpcoreType(component.Name)); replicas != nil {
+			*replicas, *hpa = component.Replicas, component.HPA
+		}
+	}
+	resumed.Spec.DisableMigrations = false
+	unlockPulp(pulpRestore, resumed)
+
+	if !equality.Semantic.DeepEqual(pulp.Spec, resumed.Spec) || !equality.Semantic.DeepEqual(pulp.Annotations, resumed.Annotations) {
+		pulp.Spec, pulp.Annotations = resumed.Spec, resumed.Annotations
+		r.RawLogger.Info("Restoring the replicas of Pulp components")
+		if err := r.Update(ctx, pulp); err != nil {
+			r.RawLogger.Error(err, "Failed to restore the replicas of Pulp components")
+			return false, err
+		}
+		return false, nil
+	}
+
+	// the number of replicas of the components managed by an HPA is not known in advance
+	if pulp.Spec.Api.HPA == nil && pulp.Spec.Api.Replicas > 0 {
+		if ready, err := r.deploymentReady(ctx, settings.API.DeploymentName(pulp.Name), pulp.Namespace, pulp.Spec.Api.Replicas); !ready || err != nil {
+			return false, err
+		}
+	}
+
+	// the status is stored with the next condition update
+	pulpRestore.Status.QuiescedComponents = nil
+	return true, nil
+}
+
+// replacePulpCR replaces the spec of an existing Pulp CR with the one from backup, keeping the
+// replicas and HPA settings of its components (they are managed by the restore)
+func (r *RepoManagerRestoreReconciler) replacePulpCR(ctx context.Context, pulp *pulpv1.Pulp, pulpRestore *pulpv1.PulpRestore, target *restoreTarget, files map[string][]byte) error {
+	log := r.RawLogger
+	spec := pulpv1.PulpSpec{}
+	if err := json.Unmarshal(files["cr_object"], &spec); err != nil {
+		log.Error(err, "Failed to get cr_object backup file!")
+		return err
+	}
+	target.pulpSpec(&spec, pulpRestore)
+
+	restored := &pulpv1.Pulp{Spec: spec}
+	for _, component := range pulpComponents {
+		replicas, hpa := controllers.ComponentScale(pulp, component)
+		restoredReplicas, restoredHPA := controllers.ComponentScale(restored, component)
+		*restoredReplicas, *restoredHPA = *replicas, *hpa
+	}
+	restored.Spec.DisableMigrations = pulp.Spec.DisableMigrations
+	if equality.Semantic.DeepEqual(pulp.Spec, restored.Spec) {
+		return nil
+	}
+
+	log.Info("Replacing " + pulp.Name + " CR with the one from backup ...")
+	pulp.Spec = restored.Spec
+	if err := r.Update(ctx, pulp); err != nil {
+		log.Error(err, "Failed to replace "+pulp.Name+" CR!")
+		return err
+	}
+	return nil
+}
+
+// replaceObject updates an existing resource with the content from backup when the component is
+// explicitly selected in PulpRestore
+func (r *RepoManagerRestoreReconciler) replaceObject(ctx context.Context, pulpRestore *pulpv1.PulpRestore, component, resourceType string, obj client.Object) error {
+	if !replaceExisting(pulpRestore, component) {
+		return nil
+	}
+	r.RawLogger.Info("Replacing " + resourceType + " " + obj.GetName() + " with the one from backup ...")
+	if err := r.Update(ctx, obj); err != nil {
+		r.RawLogger.Error(err, "Failed to replace "+resourceType+" "+obj.GetName())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_restore
+
+import (
+	"context"
+	goerrors "errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestRestoreComponent verifies which parts of the backup are restored based on the selected components
+func TestRestoreComponent(t *testing.T) {
+	all := []string{componentSecrets, componentConfigMaps, componentPulpCR, componentDatabase, componentPulpDir, componentArtifacts}
+	tests := []struct {
+		name          string
+		components    []string
+		expectRestore []string
+		expectReplace []string
+		expectData    bool
+	}{
+		{
+			name:          "all components",
+			expectRestore: all,
+			expectData:    true,
+		},
+		{
+			name:          "database only",
+			components:    []string{componentDatabase},
+			expectRestore: []string{componentDatabase},
+			expectReplace: []string{componentDatabase},
+			expectData:    true,
+		},
+		{
+			name:          "secrets and Pulp CR",
+			components:    []string{componentSecrets, componentPulpCR},
+			expectRestore: []string{componentSecrets, componentPulpCR},
+			expectReplace: []string{componentSecrets, componentPulpCR},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pulpRestore := &pulpv1.PulpRestore{Spec: pulpv1.PulpRestoreSpec{Components: tt.components}}
+			restored, replaced := []string{}, []string{}
+			for _, component := range all {
+				if restoreComponent(pulpRestore, component) {
+					restored = append(restored, component)
+				}
+				if replaceExisting(pulpRestore, component) {
+					replaced = append(replaced, component)
+				}
+			}
+			if !reflect.DeepEqual(restored, tt.expectRestore) {
+				t.Errorf("expected restored components %v, got %v", tt.expectRestore, restored)
+			}
+			if len(replaced) != len(tt.expectReplace) || (len(replaced) > 0 && !reflect.DeepEqual(replaced, tt.expectReplace)) {
+				t.Errorf("expected replaced components %v, got %v", tt.expectReplace, replaced)
+			}
+			if restoresData(pulpRestore) != tt.expectData {
+				t.Errorf("expected restoresData %v", tt.expectData)
+			}
+		})
+	}
+}
+
+// TestCheckComponents verifies that the data can not be restored without a Pulp CR
+func TestCheckComponents(t *testing.T) {
+	tests := []struct {
+		name         string
+		components   []string
+		expectReason string
+	}{
+		{name: "all components"},
+		{name: "secrets only", components: []string{componentSecrets}},
+		{name: "database and Pulp CR", components: []string{componentPulpCR, componentDatabase}},
+		{name: "database only", components: []string{componentDatabase}, expectReason: "PulpNotFound"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = pulpv1.AddToScheme(scheme)
+			r := &RepoManagerRestoreReconciler{
+				Client:    fake.NewClientBuilder().WithScheme(scheme).Build(),
+				RawLogger: logr.Discard(),
+				Scheme:    scheme,
+			}
+			pulpRestore := &pulpv1.PulpRestore{
+				ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "test-namespace"},
+				Spec:       pulpv1.PulpRestoreSpec{DeploymentName: "pulp", Components: tt.components},
+			}
+
+			_, err := r.checkComponents(context.TODO(), pulpRestore)
+			restoreErr := &restoreFailedError{}
+			if len(tt.expectReason) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if !goerrors.As(err, &restoreErr) || restoreErr.reason != tt.expectReason {
+				t.Errorf("expected %v error, got %v", tt.expectReason, err)
+			}
+		})
+	}
+}
+
+// TestRestoreIntoExistingPulp verifies that an existing Pulp is scaled down while its data is restored
+// and gets back its replicas and HPA settings afterwards
+func TestRestoreIntoExistingPulp(t *testing.T) {
+	minReplicas := int32(2)
+	originalSpec := pulpv1.PulpSpec{
+		Api:     pulpv1.Api{Replicas: 2},
+		Content: pulpv1.Content{Replicas: 2},
+		Worker:  pulpv1.Worker{Replicas: 3, HPA: &pulpv1.HPA{Enabled: true, MinReplicas: &minReplicas, MaxReplicas: 5}},
+		Web:     pulpv1.Web{Replicas: 1},
+	}
+
+	scheme := runtime.NewScheme()
+	_ = pulpv1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	pulp := &pulpv1.Pulp{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace", Annotations: map[string]string{controllers.QuiescedByAnnotation: "PulpBackup/backup"}},
+		Spec:       *originalSpec.DeepCopy(),
+	}
+	pulpBackup := &pulpv1.PulpBackup{ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "test-namespace"}}
+	pulpRestore := &pulpv1.PulpRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "test-namespace"},
+		Spec:       pulpv1.PulpRestoreSpec{DeploymentName: "pulp", Components: []string{componentDatabase}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulp, pulpBackup, pulpRestore).WithStatusSubresource(pulpRestore).Build()
+	r := &RepoManagerRestoreReconciler{Client: c, RawLogger: logr.Discard(), Scheme: scheme}
+	ctx := context.TODO()
+	key := types.NamespacedName{Name: "pulp", Namespace: "test-namespace"}
+
+	// the restore waits for the backup that quiesced Pulp to restore its replicas
+	if checked, err := r.checkComponents(ctx, pulpRestore); checked || err != nil {
+		t.Fatalf("expected the restore to wait for the backup, got %v, %v", checked, err)
+	}
+	if restoresOntoExistingPulp(pulpRestore) {
+		t.Fatalf("expected no replicas to be stored while Pulp is quiesced by a backup, got %+v", pulpRestore.Status.QuiescedComponents)
+	}
+	if err := c.Get(ctx, key, pulp); err != nil {
+		t.Fatal(err)
+	}
+	controllers.UnlockPulp(pulp)
+	if err := c.Update(ctx, pulp); err != nil {
+		t.Fatal(err)
+	}
+
+	if checked, err := r.checkComponents(ctx, pulpRestore); !checked || err != nil {
+		t.Fatalf("unexpected result: %v, %v", checked, err)
+	}
+	if !restoresOntoExistingPulp(pulpRestore) || len(pulpRestore.Status.QuiescedComponents) != len(pulpComponents) {
+		t.Fatalf("expected the replicas of the existing Pulp to be stored, got %+v", pulpRestore.Status.QuiescedComponents)
+	}
+
+	// the first call scales down the components, the next one waits for the pods (none in this case)
+	if finished, err := r.quiescePulp(ctx, pulpRestore, ""); finished || err != nil {
+		t.Fatalf("expected Pulp to be scaled down, got %v, %v", finished, err)
+	}
+	if finished, err := r.quiescePulp(ctx, pulpRestore, ""); !finished || err != nil {
+		t.Fatalf("expected quiesce to finish, got %v, %v", finished, err)
+	}
+	scaled := &pulpv1.Pulp{}
+	if err := c.Get(ctx, key, scaled); err != nil {
+		t.Fatal(err)
+	}
+	for _, component := range pulpComponents {
+		if replicas, hpa := controllers.ComponentScale(scaled, component); *replicas != 0 || *hpa != nil {
+			t.Errorf("expected %v to be scaled down, got %d replicas and HPA %v", component, *replicas, *hpa)
+		}
+	}
+	if !scaled.Spec.DisableMigrations {
+		t.Errorf("expected the migrations to be disabled")
+	}
+	if scaled.Annotations[controllers.QuiescedByAnnotation] != quiesceLockHolder(pulpRestore) || createdByRestore(pulpRestore, scaled) {
+		t.Errorf("expected Pulp to be quiesced by the restore, got %v", scaled.Annotations)
+	}
+
+	// checkComponents runs again if the operator is restarted during the restore
+	if _, err := r.checkComponents(ctx, pulpRestore); err != nil || pulpRestore.Status.QuiescedComponents[0].Replicas != 2 {
+		t.Fatalf("expected the stored replicas to be kept, got %+v, %v", pulpRestore.Status.QuiescedComponents, err)
+	}
+
+	if finished, err := r.scaleDeployments(ctx, pulpRestore, ""); finished || err != nil {
+		t.Fatalf("expected Pulp to be scaled up, got %v, %v", finished, err)
+	}
+	resumed := &pulpv1.Pulp{}
+	if err := c.Get(ctx, key, resumed); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resumed.Spec, originalSpec) {
+		t.Errorf("expected %+v, got %+v", originalSpec, resumed.Spec)
+	}
+	if len(resumed.Annotations) != 0 {
+		t.Errorf("expected the annotations to be removed, got %v", resumed.Annotations)
+	}
+
+	apiReplicas := int32(2)
+	api := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp-api", Namespace: "test-namespace"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &apiReplicas},
+		Status:     appsv1.DeploymentStatus{Replicas: 2, ReadyReplicas: 2, UpdatedReplicas: 2},
+	}
+	if err := c.Create(ctx, api); err != nil {
+		t.Fatal(err)
+	}
+	if finished, err := r.scaleDeployments(ctx, pulpRestore, ""); !finished || err != nil {
+		t.Fatalf("expected the restore to finish once the api is ready, got %v, %v", finished, err)
+	}
+	if len(pulpRestore.Status.QuiescedComponents) != 0 {
+		t.Errorf("expected the quiesced components to be cleared")
+	}
+}
+
+// TestCreatedByRestore verifies that the replicas of a Pulp CR created by the restore are not stored as the
+// ones of an existing Pulp, even if it was restarted before its data was restored
+func TestCreatedByRestore(t *testing.T) {
+	pulpRestore := &pulpv1.PulpRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "test-namespace"},
+		Spec:       pulpv1.PulpRestoreSpec{DeploymentName: "pulp"},
+	}
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		expectCreated bool
+		expectReason  string
+	}{
+		{name: "created by the restore", annotations: map[string]string{controllers.QuiescedByAnnotation: quiesceLockHolder(pulpRestore)}, expectCreated: true},
+		{name: "created by a removed restore", annotations: map[string]string{controllers.QuiescedByAnnotation: "PulpRestore/other"}, expectReason: "QuiescedByRemovedBackup"},
+		{name: "scaled down by the user"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = pulpv1.AddToScheme(scheme)
+			pulp := &pulpv1.Pulp{
+				ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace", Annotations: tt.annotations},
+				Spec:       pulpv1.PulpSpec{DisableMigrations: true},
+			}
+			restore := pulpRestore.DeepCopy()
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulp, restore).WithStatusSubresource(restore).Build()
+			r := &RepoManagerRestoreReconciler{Client: c, RawLogger: logr.Discard(), Scheme: scheme}
+
+			if created := createdByRestore(restore, pulp); created != tt.expectCreated {
+				t.Fatalf("expected createdByRestore %v, got %v", tt.expectCreated, created)
+			}
+			_, err := r.checkComponents(context.TODO(), restore)
+			restoreErr := &restoreFailedError{}
+			if len(tt.expectReason) > 0 {
+				if !goerrors.As(err, &restoreErr) || restoreErr.reason != tt.expectReason {
+					t.Errorf("expected %v error, got %v", tt.expectReason, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if restoresOntoExistingPulp(restore) == tt.expectCreated {
+				t.Errorf("expected the replicas to be stored only for an existing Pulp, got %+v", restore.Status.QuiescedComponents)
+			}
+		})
+	}
+}
+
+// TestReplaceExistingConfigMap verifies that the existing resources are only replaced when their
+// component is selected
+func TestReplaceExistingConfigMap(t *testing.T) {
+	tests := []struct {
+		name       string
+		components []string
+		expectData string
+	}{
+		{name: "all components", expectData: "current"},
+		{name: "configmaps selected", components: []string{componentConfigMaps}, expectData: "backup"},
+	}
+
+	backupCM := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  uid: abcd-1234\ndata:\n  value: backup\n"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = corev1.AddToScheme(scheme)
+			current := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "test-namespace"}, Data: map[string]string{"value": "current"}}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(current).Build()
+			r := &RepoManagerRestoreReconciler{Client: c, RawLogger: logr.Discard(), Scheme: scheme}
+			pulpRestore := &pulpv1.PulpRestore{
+				ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "test-namespace"},
+				Spec:       pulpv1.PulpRestoreSpec{DeploymentName: "pulp", Components: tt.components},
+			}
+			target := &restoreTarget{sourceName: "pulp", sourceNamespace: "test-namespace", name: "pulp", namespace: "test-namespace"}
+
+			if _, err := r.restoreConfigMapFromYaml(context.TODO(), "CustomPulpSettings", target, map[string][]byte{"custom_pulp_settings.yaml": []byte(backupCM)}, "custom_pulp_settings.yaml", pulpRestore); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			restored := &corev1.ConfigMap{}
+			if err := c.Get(context.TODO(), client.ObjectKeyFromObject(current), restored); err != nil {
+				t.Fatal(err)
+			}
+			if restored.Data["value"] != tt.expectData {
+				t.Errorf("expected %v data, got %v", tt.expectData, restored.Data["value"])
+			}
+		})
+	}
+}
@@ -18,13 +18,18 @@ package repo_manager_restore
 
 import (
 	"context"
+	goerrors "errors"
 
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/go-logr/logr"
@@ -40,15 +45,27 @@ type RepoManagerRestoreReconciler struct {
 	RESTClient rest.Interface
 	RESTConfig *rest.Config
 	Scheme     *runtime.Scheme
+	recorder   record.EventRecorder
+
+	// OperatorVersion is compared with the version of the operator that made the backup
+	OperatorVersion string
 }
 
 //+kubebuilder:rbac:groups=repo-manager.pulpproject.org,namespace=pulp-operator-system,resources=pulprestores,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=repo-manager.pulpproject.org,namespace=pulp-operator-system,resources=pulprestores/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=repo-manager.pulpproject.org,namespace=pulp-operator-system,resources=pulprestores/finalizers,verbs=update
 //+kubebuilder:rbac:groups=repo-manager.pulpproject.org,namespace=pulp-operator-system,resources=pulpbackups;pulps,verbs=get;list;
+//+kubebuilder:rbac:groups=batch,namespace=pulp-operator-system,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,namespace=pulp-operator-system,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,namespace=pulp-operator-system,resources=pods/log,verbs=get
+//+kubebuilder:rbac:groups=core,namespace=pulp-operator-system,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=snapshot.storage.k8s.io,namespace=pulp-operator-system,resources=volumesnapshots,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,namespace=pulp-operator-system,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
+// Each step of the restore is stored in .status.phase and the long running steps are executed in Jobs,
+// so the reconciliation does not wait for them and the restore can be resumed if the operator is restarted.
 func (r *RepoManagerRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.RawLogger
 	pulpRestore := &pulpv1.PulpRestore{}
@@ -67,6 +84,36 @@ func (r *RepoManagerRestoreReconciler) Reconcile(ctx context.Context, req ctrl.R
 		return ctrl.Result{}, err
 	}
 
+	// restores finished before the phases were stored in the status should not run again
+	if len(pulpRestore.Status.Phase) == 0 && v1.IsStatusConditionTrue(pulpRestore.Status.Conditions, "RestoreComplete") {
+		pulpRestore.Status.Phase = phaseCompleted
+		return ctrl.Result{}, r.Status().Update(ctx, pulpRestore)
+	}
+
+	if pulpRestore.Status.Phase == phaseCompleted || pulpRestore.Status.Phase == phaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	// the restore failed while the database was being recovered to the point_in_time
+	if pulpRestore.Status.Phase == phaseReleasingDatabase {
+		if released, err := r.releaseDatabase(ctx, pulpRestore); err != nil {
+			return ctrl.Result{}, err
+		} else if !released {
+			return ctrl.Result{RequeueAfter: requeueInterval}, nil
+		}
+		log.Info("Database of Pulp started again after the failed recovery")
+		pulpRestore.Status.Phase = phaseFailed
+		return ctrl.Result{}, r.Status().Update(ctx, pulpRestore)
+	}
+
+	// the restore starts from the beginning when dry_run is set to false after a dry run
+	if pulpRestore.Status.Phase == phaseDryRunCompleted {
+		if pulpRestore.Spec.DryRun {
+			return ctrl.Result{}, nil
+		}
+		pulpRestore.Status.Phase = ""
+	}
+
 	backupDir, err := r.getBackupDir(ctx, pulpRestore)
 	if err != nil {
 		log.Error(err, "Failed to get the directory used during backup. Please provide a backup_dir with the path of the backup")
@@ -74,16 +121,19 @@ func (r *RepoManagerRestoreReconciler) Reconcile(ctx context.Context, req ctrl.R
 	}
 	log.Info("Backup dir found!", "BackupDir", backupDir)
 
-	// if lock configmap is found it means that the restore already ran, so the controller should stop execution.
-	// To rerun a restore the user will have to manually delete the lock configmap first.
-	lockCM := &corev1.ConfigMap{}
-	if err := r.Get(ctx, types.NamespacedName{Name: CMLock, Namespace: pulpRestore.Namespace}, lockCM); err == nil {
-		controllers.CustomZapLogger().Warn("PulpRestore lock ConfigMap found. No restore procedure will be executed!")
-		controllers.CustomZapLogger().Warn("If you really want to run restore tasks again, just remove the " + CMLock + " ConfigMap")
-		return ctrl.Result{}, nil
-	}
+	if len(pulpRestore.Status.Phase) == 0 {
+		// if lock configmap is found it means that the restore already ran, so the controller should stop execution.
+		// To rerun a restore the user will have to manually delete the lock configmap first.
+		// A dry run does not modify Pulp, so it only reports the lock.
+		lockCM := &corev1.ConfigMap{}
+		if err := r.Get(ctx, types.NamespacedName{Name: CMLock, Namespace: pulpRestore.Namespace}, lockCM); err == nil && !pulpRestore.Spec.DryRun {
+			controllers.CustomZapLogger().Warn("PulpRestore lock ConfigMap found. No restore procedure will be executed!")
+			controllers.CustomZapLogger().Warn("If you really want to run restore tasks again, just remove the " + CMLock + " ConfigMap")
+			return ctrl.Result{}, nil
+		}
 
-	r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Restore process running ...", "StartingRestoreProcess")
+		r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Restore process running ...", "StartingRestoreProcess")
+	}
 
 	// [TODO] REVIEW THIS
 	// I'm not sure if we should keep this approach
@@ -93,112 +143,85 @@ func (r *RepoManagerRestoreReconciler) Reconcile(ctx context.Context, req ctrl.R
 	// we should be able to recover only with the data from pulpRestore CR + backup PVC
 	// the problem with this approach is that users will need to know (or manually retrieve from the backup) the name of the bkp secrets,
 	// the name of the files, and manually configure pulpRestore CR with them
-	/* 	// Look up details for the backup
-	   	pulpBackup := &repomanagerpulpprojectorgv1beta2.PulpBackup{}
-	   	err = r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.BackupName, Namespace: pulpRestore.Namespace}, pulpBackup)
-
-	   	// Surface error to user
-	   	if err != nil && errors.IsNotFound(err) {
-	   		log.Error(err, "PulpBackup CR not found!")
-	   		r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "PulpBackup CR not found!", "FailedToFindPulpBackupCR")
-	   		return ctrl.Result{}, err
-	   	}
-
-	   	// make sure that there is a bkpPVC and pulpBackup ran before continue
-		// checking only for bkpPVC can get into a state where pulpBackup controller is running and the content from bkpPVC is outdated
-		// checking only for pulpBackup status can get into a situation where it ran before but the pvc was removed
-	   	for timeout := 0; timeout < 300; timeout++ {
-	   		if ###CHECK BKPPVC ### && v1.IsStatusConditionTrue(pulpBackup.Status.Conditions, "BackupComplete") {
-	   			break
-	   		}
-	   		time.Sleep(time.Second * 5)
-	   		r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.BackupName, Namespace: pulpRestore.Namespace}, pulpBackup)
-	   	} */
-
-	// Fail early if pvc is defined but does not exist
-	backupPVCName, PVCfound := r.backupPVCFound(ctx, pulpRestore)
-	if !PVCfound {
-		log.Error(err, "Backup PVC not found!")
-		r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "PVC "+backupPVCName+" not found!", "BackupPVCNotFound")
-		return ctrl.Result{}, err
-	}
-	log.V(1).Info("Backup PVC found!", "PVC", backupPVCName)
-
-	// Delete any existing management pod
-	r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Removing old manager pod ...", "RemovingOldPod")
-	r.cleanup(ctx, pulpRestore)
 
-	// Create a new management pod
-	r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Creating manager pod ...", "CreatingPod")
-	pod, err := r.createRestorePod(ctx, pulpRestore, backupPVCName, "/backups")
-	if err != nil {
-		r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Failed to create manager pod!", "FailedCreatingPod")
-		return ctrl.Result{}, err
-	}
-
-	// Check to make sure backup directory exists on PVC
-	execCmd := []string{
-		"stat", backupDir,
-	}
-	_, err = controllers.ContainerExec(ctx, r, pod, execCmd, pulpRestore.Name+"-backup-manager", pod.Namespace)
-	if err != nil {
-		// requeue request when backupDir is not found
-		r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Failed to find "+backupDir+" dir!", "BackupDirNotFound")
-		return ctrl.Result{}, err
-	}
-
-	// Restoring the configmaps
-	if err := r.restoreConfigMap(ctx, pulpRestore, backupDir, pod); err != nil {
-		return ctrl.Result{}, err
-	}
-
-	// Restoring the secrets
-	if err := r.restoreSecret(ctx, pulpRestore, backupDir, pod); err != nil {
-		// requeue request when there is an error with a secret restore
-		return ctrl.Result{}, err
-	}
-
-	// Restoring pulp CR
-	podReplicas, err := r.restorePulpCR(ctx, pulpRestore, backupDir, pod)
-	if err != nil {
-		// requeue request when there is an error with a pulp CR restore
-		return ctrl.Result{}, err
-	}
-
-	// Restoring database
-	if err := r.restoreDatabaseData(ctx, pulpRestore, backupDir, pod); err != nil {
-		// requeue request when there is an error with a database restore
-		return ctrl.Result{}, err
-	}
-
-	// Restoring /var/lib/pulp data
-	if err := r.restorePulpDir(ctx, pulpRestore, backupPVCName, backupDir); err != nil {
-		// requeue request when there is an error with pulp dir restore
-		return ctrl.Result{}, err
-	}
+	phases := r.phases()
+	for _, phase := range phases[phaseIndex(phases, pulpRestore.Status.Phase):] {
+		// the condition is updated only when the phase starts, the phases can also set
+		// their own reasons (like the restored secrets) that should not be overwritten
+		if pulpRestore.Status.Phase != phase.name {
+			pulpRestore.Status.Phase = phase.name
+			r.startPhase(pulpRestore, phase)
+			r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", phase.message, phase.name)
+		}
 
-	// Scale pulpcore deployments
-	if err := r.scaleDeployments(ctx, pulpRestore, podReplicas); err != nil {
-		// requeue request when there is an error with pulpcore scale
-		return ctrl.Result{}, err
+		finished, err := phase.run(ctx, pulpRestore, backupDir)
+		jobErr := &controllers.JobFailedError{}
+		restoreErr := &restoreFailedError{}
+		if goerrors.As(err, &restoreErr) {
+			return r.restoreFailed(ctx, pulpRestore, err, restoreErr.message, restoreErr.reason)
+		} else if goerrors.As(err, &jobErr) {
+			r.phaseProgress(ctx, pulpRestore, phase)
+			return r.restoreFailed(ctx, pulpRestore, err, phase.failedMessage+" "+jobErr.Message, "Failed"+phase.name)
+		} else if err != nil {
+			// the phase is retried, the error is kept in its record
+			if record := controllers.LastPhaseRecord(pulpRestore.Status.PhaseRecords, phase.name); record != nil && record.LastError != err.Error() {
+				record.LastError = err.Error()
+				r.Status().Update(ctx, pulpRestore)
+			}
+			return ctrl.Result{}, err
+		}
+		if !finished {
+			if r.phaseProgress(ctx, pulpRestore, phase) {
+				if err := r.Status().Update(ctx, pulpRestore); err != nil {
+					log.Error(err, "Failed to update the progress of phase "+phase.name)
+				}
+			}
+			return ctrl.Result{RequeueAfter: requeueInterval}, nil
+		}
+		// the record is stored with the next condition update
+		r.finishPhase(ctx, pulpRestore, phase)
+		if phase.name == phaseDryRun && pulpRestore.Spec.DryRun {
+			return r.dryRunCompleted(ctx, pulpRestore, phases)
+		}
 	}
 
 	log.Info("Cleaning up restore resources ...")
-	r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Cleaning up restore resources ...", "DeletingMgmtPod")
-
-	r.cleanup(ctx, pulpRestore)
+	r.cleanup(ctx, pulpRestore, phases)
 	r.createLockConfigMap(ctx, pulpRestore)
 
+	pulpRestore.Status.Phase = phaseCompleted
 	r.updateStatus(ctx, pulpRestore, metav1.ConditionTrue, "RestoreComplete", "All restore tasks run!", "RestoreTasksFinished")
+	r.recorder.Event(pulpRestore, corev1.EventTypeNormal, "RestoreCompleted", "All restore tasks run!")
 	log.Info("Restore tasks finished!")
 	return ctrl.Result{}, nil
 }
 
+// restoreFailed stops the restore, setting the Failed phase and the RestoreComplete condition with
+// message and reason
+func (r *RepoManagerRestoreReconciler) restoreFailed(ctx context.Context, pulpRestore *pulpv1.PulpRestore, err error, message, reason string) (ctrl.Result, error) {
+	r.RawLogger.Error(err, "Restore failed", "Phase", pulpRestore.Status.Phase)
+	controllers.FinishPhaseRecord(pulpRestore.Status.PhaseRecords, pulpRestore.Status.Phase, controllers.PhaseFailed, err.Error())
+	r.recorder.Event(pulpRestore, corev1.EventTypeWarning, reason, message)
+	// the jobs are kept for troubleshooting, but not the copy of the backed up secrets
+	r.deleteBackupFiles(ctx, pulpRestore)
+	// the data of the database from before the point-in-time recovery is moved back before the restore is
+	// set as Failed
+	if pulpRestore.Spec.PointInTime != nil && pulpRestore.Status.Phase == phaseRestoringDB {
+		pulpRestore.Status.Phase = phaseReleasingDatabase
+		r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", message, reason)
+		return ctrl.Result{Requeue: true}, nil
+	}
+	pulpRestore.Status.Phase = phaseFailed
+	r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", message, reason)
+	return ctrl.Result{}, nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *RepoManagerRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.recorder = mgr.GetEventRecorderFor("PulpRestore")
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&pulpv1.PulpRestore{}).
-		Owns(&corev1.ConfigMap{}).
-		WithEventFilter(controllers.IgnoreUpdateCRStatusPredicate()).
+		For(&pulpv1.PulpRestore{}, builder.WithPredicates(controllers.IgnoreUpdateCRStatusPredicate())).
+		Owns(&corev1.ConfigMap{}, builder.WithPredicates(controllers.IgnoreUpdateCRStatusPredicate())).
+		Owns(&batchv1.Job{}).
 		Complete(r)
 }
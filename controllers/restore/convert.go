@@ -0,0 +1,159 @@
+package repo_manager_restore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/cli-runtime/pkg/printers"
+)
+
+// backupConverter converts the backup files read from the verification job from a backup format
+// version to the next one
+type backupConverter func(pulpRestore *pulpv1.PulpRestore, files map[string][]byte) error
+
+// backupConverters are the converters of the backup files, indexed by the backup format version they
+// convert from. A converter must be added when controllers.BackupManifestVersion is increased.
+var backupConverters = map[int]backupConverter{
+	controllers.LegacyBackupVersion: convertLegacyBackup,
+}
+
+// convertBackupFiles converts the backup files, made with an older backup format version, to the format
+// written by this operator. The manifest keeps the version of the backup, the backups with an unknown
+// version are not converted (they are rejected by verifyBackup).
+func convertBackupFiles(pulpRestore *pulpv1.PulpRestore, files map[string][]byte) error {
+	manifest, err := backupManifest(files)
+	if err != nil {
+		return err
+	}
+	for v := manifest.Version; v >= controllers.LegacyBackupVersion && v < controllers.BackupManifestVersion; v++ {
+		convert, found := backupConverters[v]
+		if !found {
+			return fmt.Errorf("no converter found for backup format version %d", v)
+		}
+		if err := convert(pulpRestore, files); err != nil {
+			return fmt.Errorf("failed to convert backup format version %d: %v", v, err)
+		}
+	}
+	return nil
+}
+
+// convertLegacyBackup converts a backup made before the manifest was introduced:
+//   - the manifest gets the name and namespace of the restored Pulp, which are not recorded in the backup
+//     (a legacy backup cannot be restored with a different name or into a different namespace)
+//   - the deprecated pulp_settings field of the Pulp CR is replaced by a custom_pulp_settings ConfigMap, or
+//     merged into the custom_pulp_settings ConfigMap from backup
+func convertLegacyBackup(pulpRestore *pulpv1.PulpRestore, files map[string][]byte) error {
+	manifest, err := backupManifest(files)
+	if err != nil {
+		return err
+	}
+	manifest.DeploymentName = pulpRestore.Spec.DeploymentName
+	manifest.Namespace = pulpRestore.Namespace
+	if files[controllers.BackupManifestFileName], err = json.Marshal(manifest); err != nil {
+		return err
+	}
+
+	spec := map[string]interface{}{}
+	if err := json.Unmarshal(files["cr_object"], &spec); err != nil {
+		return fmt.Errorf("failed to parse cr_object: %v", err)
+	}
+	pulpSettings, found := spec["pulp_settings"].(map[string]interface{})
+	delete(spec, "pulp_settings")
+	if found && len(pulpSettings) > 0 {
+		cm := &corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: pulpRestore.Spec.DeploymentName + "-custom-pulp-settings"},
+		}
+		if customSettings, found := files["custom_pulp_settings.yaml"]; found {
+			if err := yaml.Unmarshal(customSettings, cm); err != nil {
+				return fmt.Errorf("failed to parse custom_pulp_settings.yaml: %v", err)
+			}
+		} else {
+			spec["custom_pulp_settings"] = cm.Name
+		}
+		if files["custom_pulp_settings.yaml"], err = pulpSettingsConfigMap(cm, pulpSettings); err != nil {
+			return err
+		}
+	}
+	files["cr_object"], err = json.Marshal(spec)
+	return err
+}
+
+// pulpSettingsConfigMap returns the YAML of the custom_pulp_settings ConfigMap cm with the settings defined
+// in the pulp_settings field. The keys are the settings in uppercase and the values are python literals.
+// The settings already defined in cm override the ones from pulp_settings.
+func pulpSettingsConfigMap(cm *corev1.ConfigMap, pulpSettings map[string]interface{}) ([]byte, error) {
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	for key, value := range pulpSettings {
+		if _, defined := cm.Data[strings.ToUpper(key)]; !defined {
+			cm.Data[strings.ToUpper(key)] = pythonLiteral(value)
+		}
+	}
+	cmYaml := new(bytes.Buffer)
+	ymlPrinter := printers.YAMLPrinter{}
+	if err := ymlPrinter.PrintObj(cm, cmYaml); err != nil {
+		return nil, err
+	}
+	return cmYaml.Bytes(), nil
+}
+
+// pythonLiteral returns the python literal of a value parsed from JSON
+func pythonLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "None"
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return strconv.Quote(v)
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			items = append(items, pythonLiteral(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		keys := []string{}
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := []string{}
+		for _, key := range keys {
+			items = append(items, strconv.Quote(key)+": "+pythonLiteral(v[key]))
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+	return fmt.Sprint(value)
+}
+
+// newerOperatorVersion returns true if the backup was made by a newer version of the operator than the
+// running one. Versions that cannot be compared (like devel builds) are not considered newer.
+func newerOperatorVersion(backupVersion, operatorVersion string) bool {
+	backup, err := version.ParseGeneric(backupVersion)
+	if err != nil {
+		return false
+	}
+	running, err := version.ParseGeneric(operatorVersion)
+	if err != nil {
+		return false
+	}
+	return running.LessThan(backup)
+}
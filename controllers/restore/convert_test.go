@@ -0,0 +1,174 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_restore
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// TestConvertLegacyBackup verifies the conversion of a backup made before the manifest was introduced
+func TestConvertLegacyBackup(t *testing.T) {
+	pulpRestore := &pulpv1.PulpRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "prod"},
+		Spec:       pulpv1.PulpRestoreSpec{DeploymentName: "pulp"},
+	}
+	legacyCR := `{"image": "quay.io/pulp/pulp", "storage_type": "File", "pulp_settings": {"api_root": "/api/", "telemetry": false, "allowed_export_paths": ["/tmp"], "token_server": null}}`
+
+	tests := []struct {
+		name           string
+		files          map[string][]byte
+		expectSettings map[string]string
+		expectCustom   string
+	}{
+		{
+			name: "pulp_settings converted to custom_pulp_settings",
+			files: map[string][]byte{
+				controllers.BackupManifestFileName: []byte(`{"version": 0, "files": []}`),
+				"cr_object":                        []byte(legacyCR),
+			},
+			expectSettings: map[string]string{
+				"API_ROOT":             `"/api/"`,
+				"TELEMETRY":            "False",
+				"ALLOWED_EXPORT_PATHS": `["/tmp"]`,
+				"TOKEN_SERVER":         "None",
+			},
+			expectCustom: "pulp-custom-pulp-settings",
+		},
+		{
+			name: "custom_pulp_settings from backup are kept",
+			files: map[string][]byte{
+				controllers.BackupManifestFileName: []byte(`{"version": 0, "files": []}`),
+				"cr_object":                        []byte(`{"custom_pulp_settings": "settings", "pulp_settings": {"analytics": true}}`),
+				"custom_pulp_settings.yaml":        []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n  ANALYTICS: \"False\"\n"),
+			},
+			expectSettings: map[string]string{"ANALYTICS": "False"},
+			expectCustom:   "settings",
+		},
+		{
+			name: "pulp_settings merged into custom_pulp_settings from backup",
+			files: map[string][]byte{
+				controllers.BackupManifestFileName: []byte(`{"version": 0, "files": []}`),
+				"cr_object":                        []byte(`{"custom_pulp_settings": "settings", "pulp_settings": {"analytics": true, "telemetry": false, "api_root": "/api/"}}`),
+				"custom_pulp_settings.yaml":        []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n  ANALYTICS: \"False\"\n"),
+			},
+			expectSettings: map[string]string{"ANALYTICS": "False", "TELEMETRY": "False", "API_ROOT": `"/api/"`},
+			expectCustom:   "settings",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := convertBackupFiles(pulpRestore, tt.files); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			manifest, err := backupManifest(tt.files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest.Version != controllers.LegacyBackupVersion || manifest.DeploymentName != "pulp" || manifest.Namespace != "prod" {
+				t.Errorf("expected a legacy manifest of pulp in prod, got %+v", manifest)
+			}
+			target, err := newRestoreTarget(pulpRestore, tt.files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target.isCopy() {
+				t.Errorf("a legacy backup should not be restored as a copy")
+			}
+
+			crObject := map[string]interface{}{}
+			if err := json.Unmarshal(tt.files["cr_object"], &crObject); err != nil {
+				t.Fatal(err)
+			}
+			if _, found := crObject["pulp_settings"]; found {
+				t.Errorf("pulp_settings should be removed from cr_object")
+			}
+			spec := pulpv1.PulpSpec{}
+			if err := json.Unmarshal(tt.files["cr_object"], &spec); err != nil {
+				t.Fatal(err)
+			}
+			if spec.CustomPulpSettings != tt.expectCustom {
+				t.Errorf("expected custom_pulp_settings %v, got %v", tt.expectCustom, spec.CustomPulpSettings)
+			}
+
+			obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(tt.files["custom_pulp_settings.yaml"], nil, nil)
+			if err != nil {
+				t.Fatalf("invalid custom_pulp_settings.yaml: %v", err)
+			}
+			cm := obj.(*corev1.ConfigMap)
+			if cm.Name != tt.expectCustom || !reflect.DeepEqual(cm.Data, tt.expectSettings) {
+				t.Errorf("expected ConfigMap %v with %v, got %v with %v", tt.expectCustom, tt.expectSettings, cm.Name, cm.Data)
+			}
+		})
+	}
+}
+
+// TestConvertCurrentBackup verifies that the backups in the current format are not modified
+func TestConvertCurrentBackup(t *testing.T) {
+	pulpRestore := &pulpv1.PulpRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "dr"},
+		Spec:       pulpv1.PulpRestoreSpec{DeploymentName: "staging"},
+	}
+	for _, version := range []int{controllers.BackupManifestVersion, controllers.BackupManifestVersion + 1} {
+		files := backupFilesFor(t, "pulp", "prod", pulpv1.PulpSpec{CustomPulpSettings: "settings"})
+		manifest, _ := backupManifest(files)
+		manifest.Version = version
+		files[controllers.BackupManifestFileName], _ = json.Marshal(manifest)
+		expected := map[string][]byte{}
+		for name, content := range files {
+			expected[name] = content
+		}
+
+		if err := convertBackupFiles(pulpRestore, files); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(files, expected) {
+			t.Errorf("backup format version %d should not be converted, got %s", version, files)
+		}
+	}
+}
+
+// TestNewerOperatorVersion verifies the detection of backups made by a newer operator
+func TestNewerOperatorVersion(t *testing.T) {
+	tests := []struct {
+		backupVersion   string
+		operatorVersion string
+		expectNewer     bool
+	}{
+		{"1.2.0", "1.2.0", false},
+		{"1.1.3", "1.2.0", false},
+		{"1.3.0", "1.2.0", true},
+		{"v2.0.0", "1.2.0", true},
+		{"1.3.0", "devel", false},
+		{"devel", "1.2.0", false},
+		{"", "1.2.0", false},
+	}
+	for _, tt := range tests {
+		if newer := newerOperatorVersion(tt.backupVersion, tt.operatorVersion); newer != tt.expectNewer {
+			t.Errorf("backup made by %q restored by %q: expected newer %v, got %v", tt.backupVersion, tt.operatorVersion, tt.expectNewer, newer)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package repo_manager_restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// restoreTarget describes the Pulp CR backed up and the one being restored.
+// When they have different names or namespaces the restore makes a copy of Pulp, and the
+// names of the restored resources are rewritten so that the copy does not share them with
+// the original instance.
+type restoreTarget struct {
+	// name and namespace of the Pulp CR backed up
+	sourceName, sourceNamespace string
+
+	// name and namespace of the Pulp CR restored
+	name, namespace string
+}
+
+// newRestoreTarget returns the restoreTarget based on the backup manifest
+func newRestoreTarget(pulpRestore *pulpv1.PulpRestore, files map[string][]byte) (*restoreTarget, error) {
+	manifest, err := backupManifest(files)
+	if err != nil {
+		return nil, err
+	}
+	target := &restoreTarget{
+		sourceName:      manifest.DeploymentName,
+		sourceNamespace: manifest.Namespace,
+		name:            pulpRestore.Spec.DeploymentName,
+		namespace:       pulpRestore.Namespace,
+	}
+	// backups made by older versions of the operator do not record the namespace
+	if len(target.sourceNamespace) == 0 {
+		target.sourceNamespace = target.namespace
+	}
+	return target, nil
+}
+
+// isCopy returns true if Pulp is restored with a different name or into a different namespace
+func (t *restoreTarget) isCopy() bool {
+	return t.name != t.sourceName || t.namespace != t.sourceNamespace
+}
+
+// resourceName returns the name of a backed up resource in the restored Pulp.
+// Resources named after the Pulp CR (like <pulp>-admin-password) have the Pulp CR name replaced,
+// and the other ones are prefixed with it so that they are not shared with the original instance
+// when it runs in the same namespace.
+func (t *restoreTarget) resourceName(name string) string {
+	if len(name) == 0 || t.name == t.sourceName {
+		return name
+	}
+	if suffix, found := strings.CutPrefix(name, t.sourceName+"-"); found {
+		return t.name + "-" + suffix
+	}
+	return t.name + "-" + name
+}
+
+// databaseHost returns the host of the database of the restored Pulp.
+// Only the managed database service (<pulp>-database-svc) is rewritten, external databases are kept.
+func (t *restoreTarget) databaseHost(host string) string {
+	labels := strings.Split(host, ".")
+	if labels[0] != t.sourceName+"-database-svc" {
+		return host
+	}
+	labels[0] = t.name + "-database-svc"
+	if len(labels) > 1 && labels[1] == t.sourceNamespace {
+		labels[1] = t.namespace
+	}
+	return strings.Join(labels, ".")
+}
+
+// objectMeta returns the metadata of a resource restored from a YAML backup file.
+// The fields set by Kubernetes (uid, resourceVersion, ownerReferences, etc) refer to the
+// original resource, so only the name, labels and annotations are kept.
+func (t *restoreTarget) objectMeta(meta metav1.ObjectMeta) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        t.resourceName(meta.Name),
+		Namespace:   t.namespace,
+		Labels:      meta.Labels,
+		Annotations: meta.Annotations,
+	}
+}
+
+// pulpSpec rewrites the Pulp CR spec from backup with the names of the restored resources and the
+// hostnames from PulpRestore
+func (t *restoreTarget) pulpSpec(spec *pulpv1.PulpSpec, pulpRestore *pulpv1.PulpRestore) {
+	for _, name := range []*string{
+		&spec.AdminPasswordSecret,
+		&spec.PulpSecretKey,
+		&spec.DBFieldsEncryptionSecret,
+		&spec.ContainerTokenSecret,
+		&spec.SigningSecret,
+		&spec.SigningScripts,
+		&spec.SSOSecret,
+		&spec.LDAP.Config,
+		&spec.LDAP.CA,
+		&spec.ObjectStorageS3Secret,
+		&spec.ObjectStorageAzureSecret,
+		&spec.ObjectStorageGCSSecret,
+		&spec.Database.ExternalDBSecret,
+		&spec.CustomPulpSettings,
+		// the PVCs provisioned by the user are not part of the backup, but the copy should not mount
+		// the ones from the original instance
+		&spec.PVC,
+		&spec.Database.PVC,
+		&spec.Cache.PVC,
+	} {
+		*name = t.resourceName(*name)
+	}
+
+	if len(pulpRestore.Spec.IngressHost) > 0 {
+		spec.IngressHost = pulpRestore.Spec.IngressHost
+	}
+	if len(pulpRestore.Spec.RouteHost) > 0 {
+		spec.RouteHost = pulpRestore.Spec.RouteHost
+	}
+}
+
+// externalSecrets returns the (restored) names of the secrets with the configuration of the external
+// services (database and object storage) used by the Pulp CR from backup
+func (t *restoreTarget) externalSecrets(spec *pulpv1.PulpSpec) []string {
+	secrets := []string{}
+	for _, name := range []string{spec.Database.ExternalDBSecret, spec.ObjectStorageS3Secret, spec.ObjectStorageAzureSecret, spec.ObjectStorageGCSSecret} {
+		if len(name) > 0 {
+			secrets = append(secrets, t.resourceName(name))
+		}
+	}
+	return secrets
+}
+
+// checkCopy makes sure that a copy of Pulp will not share the hostname, the external database or
+// the object storage with the original instance.
+// The external secrets are not restored for a copy, they should be created before running the
+// restore with the configuration of a database or bucket that is not used by the original instance.
+func (r *RepoManagerRestoreReconciler) checkCopy(ctx context.Context, pulpRestore *pulpv1.PulpRestore, target *restoreTarget, files map[string][]byte) error {
+	if !target.isCopy() {
+		return nil
+	}
+
+	spec := &pulpv1.PulpSpec{}
+	if err := json.Unmarshal(files["cr_object"], spec); err != nil {
+		r.RawLogger.Error(err, "Failed to get cr_object backup file!")
+		return err
+	}
+
+	ingressType := strings.ToLower(spec.IngressType)
+	if ingressType == "ingress" && len(spec.IngressHost) > 0 && len(pulpRestore.Spec.IngressHost) == 0 {
+		return &restoreFailedError{"IngressHostRequired", fmt.Sprintf("ingress_host must be defined to restore a copy of %v, otherwise it would use the same hostname as the original instance (%v)", target.sourceName, spec.IngressHost)}
+	}
+	if ingressType == "route" && len(spec.RouteHost) > 0 && len(pulpRestore.Spec.RouteHost) == 0 {
+		return &restoreFailedError{"RouteHostRequired", fmt.Sprintf("route_host must be defined to restore a copy of %v, otherwise it would use the same hostname as the original instance (%v)", target.sourceName, spec.RouteHost)}
+	}
+
+	for _, name := range target.externalSecrets(spec) {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: target.namespace}, secret)
+		if errors.IsNotFound(err) {
+			return &restoreFailedError{"ExternalSecretNotFound", fmt.Sprintf("Secret %v not found. To restore a copy of %v, create it with the configuration of a database or object storage that is not used by the original instance.", name, target.sourceName)}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	r.RawLogger.Info("Restoring a copy of Pulp", "Source", target.sourceNamespace+"/"+target.sourceName, "Target", target.namespace+"/"+target.name)
+	return nil
+}
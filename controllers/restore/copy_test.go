@@ -0,0 +1,234 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_restore
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// backupFilesFor returns the backup files (manifest and Pulp CR) of a Pulp deployed in namespace
+func backupFilesFor(t *testing.T, name, namespace string, spec pulpv1.PulpSpec) map[string][]byte {
+	manifest, err := json.Marshal(controllers.BackupManifest{Version: 1, DeploymentName: name, Namespace: namespace})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crObject, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string][]byte{controllers.BackupManifestFileName: manifest, "cr_object": crObject}
+}
+
+// TestRestoreTarget verifies the names of the resources restored into a copy of Pulp
+func TestRestoreTarget(t *testing.T) {
+	backupSpec := pulpv1.PulpSpec{
+		AdminPasswordSecret: "pulp-admin-password",
+		SigningSecret:       "signing-galaxy",
+		CustomPulpSettings:  "settings",
+		PVC:                 "pulp-file-storage",
+		IngressType:         "ingress",
+		IngressHost:         "pulp.example.com",
+		Database:            pulpv1.Database{ExternalDBSecret: "pulp-db"},
+	}
+
+	tests := []struct {
+		name         string
+		backupNS     string
+		restore      pulpv1.PulpRestore
+		expectCopy   bool
+		expectHost   string
+		expectSpec   pulpv1.PulpSpec
+		expectDBHost string
+	}{
+		{
+			name:     "same name and namespace",
+			backupNS: "prod",
+			restore: pulpv1.PulpRestore{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "prod"},
+				Spec:       pulpv1.PulpRestoreSpec{DeploymentName: "pulp"},
+			},
+			expectSpec:   backupSpec,
+			expectDBHost: "pulp-database-svc",
+		},
+		{
+			name: "backup without namespace",
+			restore: pulpv1.PulpRestore{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "prod"},
+				Spec:       pulpv1.PulpRestoreSpec{DeploymentName: "pulp"},
+			},
+			expectSpec:   backupSpec,
+			expectDBHost: "pulp-database-svc",
+		},
+		{
+			name:     "new name in the same namespace",
+			backupNS: "prod",
+			restore: pulpv1.PulpRestore{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "prod"},
+				Spec:       pulpv1.PulpRestoreSpec{DeploymentName: "staging", IngressHost: "staging.example.com"},
+			},
+			expectCopy: true,
+			expectSpec: pulpv1.PulpSpec{
+				AdminPasswordSecret: "staging-admin-password",
+				SigningSecret:       "staging-signing-galaxy",
+				CustomPulpSettings:  "staging-settings",
+				PVC:                 "staging-file-storage",
+				IngressType:         "ingress",
+				IngressHost:         "staging.example.com",
+				Database:            pulpv1.Database{ExternalDBSecret: "staging-db"},
+			},
+			expectDBHost: "staging-database-svc",
+		},
+		{
+			name:     "same name in a new namespace",
+			backupNS: "prod",
+			restore: pulpv1.PulpRestore{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "dr"},
+				Spec:       pulpv1.PulpRestoreSpec{DeploymentName: "pulp", IngressHost: "dr.example.com"},
+			},
+			expectCopy: true,
+			expectSpec: func() pulpv1.PulpSpec {
+				spec := backupSpec
+				spec.IngressHost = "dr.example.com"
+				return spec
+			}(),
+			expectDBHost: "pulp-database-svc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := newRestoreTarget(&tt.restore, backupFilesFor(t, "pulp", tt.backupNS, backupSpec))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if target.isCopy() != tt.expectCopy {
+				t.Errorf("expected isCopy %v, got %v", tt.expectCopy, target.isCopy())
+			}
+
+			spec := backupSpec
+			target.pulpSpec(&spec, &tt.restore)
+			if !reflect.DeepEqual(spec, tt.expectSpec) {
+				t.Errorf("expected %+v, got %+v", tt.expectSpec, spec)
+			}
+			if host := target.databaseHost("pulp-database-svc"); host != tt.expectDBHost {
+				t.Errorf("expected database host %v, got %v", tt.expectDBHost, host)
+			}
+			if host := target.databaseHost("postgres.example.com"); host != "postgres.example.com" {
+				t.Errorf("external database host should not be modified, got %v", host)
+			}
+		})
+	}
+}
+
+// TestRestoreTargetDatabaseHost verifies that the fully qualified name of the managed database
+// service points to the copy of Pulp
+func TestRestoreTargetDatabaseHost(t *testing.T) {
+	target := &restoreTarget{sourceName: "pulp", sourceNamespace: "prod", name: "staging", namespace: "staging-ns"}
+	if host := target.databaseHost("pulp-database-svc.prod.svc.cluster.local"); host != "staging-database-svc.staging-ns.svc.cluster.local" {
+		t.Errorf("unexpected database host %v", host)
+	}
+}
+
+// TestCheckCopy verifies that a copy of Pulp does not share the hostname or the external services
+// with the original instance
+func TestCheckCopy(t *testing.T) {
+	externalDBSpec := pulpv1.PulpSpec{Database: pulpv1.Database{ExternalDBSecret: "pulp-db"}}
+	stagingDBSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "staging-db", Namespace: "prod"}}
+
+	tests := []struct {
+		name         string
+		backupSpec   pulpv1.PulpSpec
+		restoreSpec  pulpv1.PulpRestoreSpec
+		objects      []runtime.Object
+		expectReason string
+	}{
+		{
+			name:        "not a copy",
+			backupSpec:  pulpv1.PulpSpec{Database: pulpv1.Database{ExternalDBSecret: "pulp-db"}, IngressType: "ingress", IngressHost: "pulp.example.com"},
+			restoreSpec: pulpv1.PulpRestoreSpec{DeploymentName: "pulp"},
+		},
+		{
+			name:         "ingress host not defined",
+			backupSpec:   pulpv1.PulpSpec{IngressType: "ingress", IngressHost: "pulp.example.com"},
+			restoreSpec:  pulpv1.PulpRestoreSpec{DeploymentName: "staging"},
+			expectReason: "IngressHostRequired",
+		},
+		{
+			name:         "route host not defined",
+			backupSpec:   pulpv1.PulpSpec{IngressType: "route", RouteHost: "pulp.apps.example.com"},
+			restoreSpec:  pulpv1.PulpRestoreSpec{DeploymentName: "staging"},
+			expectReason: "RouteHostRequired",
+		},
+		{
+			name:        "route host defined",
+			backupSpec:  pulpv1.PulpSpec{IngressType: "route", RouteHost: "pulp.apps.example.com"},
+			restoreSpec: pulpv1.PulpRestoreSpec{DeploymentName: "staging", RouteHost: "staging.apps.example.com"},
+		},
+		{
+			name:         "external database secret not found",
+			backupSpec:   externalDBSpec,
+			restoreSpec:  pulpv1.PulpRestoreSpec{DeploymentName: "staging"},
+			expectReason: "ExternalSecretNotFound",
+		},
+		{
+			name:        "external database secret found",
+			backupSpec:  externalDBSpec,
+			restoreSpec: pulpv1.PulpRestoreSpec{DeploymentName: "staging"},
+			objects:     []runtime.Object{stagingDBSecret},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = corev1.AddToScheme(scheme)
+			r := &RepoManagerRestoreReconciler{
+				Client:    fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(tt.objects...).Build(),
+				RawLogger: logr.Discard(),
+				Scheme:    scheme,
+			}
+			pulpRestore := &pulpv1.PulpRestore{ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "prod"}, Spec: tt.restoreSpec}
+			files := backupFilesFor(t, "pulp", "prod", tt.backupSpec)
+
+			target, err := newRestoreTarget(pulpRestore, files)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err = r.checkCopy(context.TODO(), pulpRestore, target, files)
+			restoreErr := &restoreFailedError{}
+			if len(tt.expectReason) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if !goerrors.As(err, &restoreErr) || restoreErr.reason != tt.expectReason {
+				t.Errorf("expected %v error, got %v", tt.expectReason, err)
+			}
+		})
+	}
+}
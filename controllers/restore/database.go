@@ -2,67 +2,110 @@ package repo_manager_restore
 
 import (
 	"context"
-	"time"
+	"fmt"
 
 	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
 	"github.com/pulp/pulp-operator/controllers"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// restoreDatabaseData scales down the pods and runs a pg_restore
-func (r *RepoManagerRestoreReconciler) restoreDatabaseData(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupDir string, pod *corev1.Pod) error {
+// restoreDatabaseData runs a pg_restore in a job once the database is ready, or recovers the database
+// to the point_in_time
+func (r *RepoManagerRestoreReconciler) restoreDatabaseData(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupDir string) (bool, error) {
 	log := r.RawLogger
-	backupFile := "pulp.db"
+	if !restoreComponent(pulpRestore, componentDatabase) {
+		return true, nil
+	}
 
-	//[TODO] fix this
-	// WORKAROUND!!!! Giving some time to pulp CR be created
-	// sometimes the scale down process was failing with kube-api returning an error
-	// because the object has been modified and asking to try again
-	// I think one of the reasons that it is happening is because pulp CR
-	// was in the middle of its "creation process" and this kludge did a "relief"
-	time.Sleep(5 * time.Second)
+	// the restored pulp CR is needed to find out if the database is managed by the operator
+	pulp := &pulpv1.Pulp{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName, Namespace: pulpRestore.Namespace}, pulp); err != nil {
+		log.Error(err, "Failed to get Pulp CR")
+		return false, err
+	}
+
+	// the database is recovered from the WAL archive instead of the backup
+	if pulpRestore.Spec.PointInTime != nil {
+		return r.recoverDatabase(ctx, pulpRestore, pulp)
+	}
+
+	// the database restored from a VolumeSnapshot already has the data from backup
+	if restored, err := r.databaseRestoredFromSnapshot(ctx, pulpRestore, pulp); err != nil || restored {
+		if restored {
+			log.Info("Database restored from VolumeSnapshot!")
+		}
+		return restored, err
+	}
 
 	// retrieve pg credentials and address
 	pgConfig := &corev1.Secret{}
 	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Status.PostgresSecret, Namespace: pulpRestore.Namespace}, pgConfig); err != nil {
 		log.Error(err, "Failed to find postgres-configuration secret")
-		return err
+		return false, err
 	}
 
 	// wait until database pod is ready
-	log.Info("Waiting db pod get into a READY state ...")
-	r.waitDBReady(ctx, pulpRestore.Namespace, pulpRestore.Spec.DeploymentName+"-database")
-
-	// run pg_restore
-	execCmd := []string{
-		"pg_restore", "-d",
-		"postgresql://" + string(pgConfig.Data["username"]) + ":" + string(pgConfig.Data["password"]) + "@" + string(pgConfig.Data["host"]) + ":" + string(pgConfig.Data["port"]) + "/" + string(pgConfig.Data["database"]),
-		backupDir + "/" + backupFile,
+	if len(pulp.Spec.Database.ExternalDBSecret) == 0 {
+		sts := &appsv1.StatefulSet{}
+		if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName + "-database", Namespace: pulpRestore.Namespace}, sts); err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+		if sts.Status.ReadyReplicas == 0 || sts.Status.ReadyReplicas != sts.Status.Replicas {
+			log.Info("Waiting db pod get into a READY state ...")
+			return false, nil
+		}
 	}
 
-	log.Info("Running db restore ...")
-	if _, err := controllers.ContainerExec(ctx, r, pod, execCmd, pulpRestore.Name+"-backup-manager", pod.Namespace); err != nil {
-		log.Error(err, "Failed to restore postgres data")
-		return err
+	files, err := r.backupFiles(ctx, pulpRestore)
+	if err != nil {
+		return false, err
+	}
+	manifest, err := backupManifest(files)
+	if err != nil {
+		return false, err
 	}
+	// the dump is restored with as many jobs as it was made with, unless database_restore_jobs is defined
+	jobs := pulpRestore.Spec.DatabaseRestoreJobs
+	if jobs == 0 {
+		jobs = int32(manifest.DatabaseDumpJobs)
+	}
+	// the objects of the database of an existing Pulp are dropped before being recreated from backup
+	script := pgRestoreScript(backupDir+"/"+controllers.DatabaseDumpFile, jobs, restoresOntoExistingPulp(pulpRestore), len(manifest.Encryption) > 0)
 
-	log.Info("Database restore finished!")
+	job := backupManagerJob(pulpRestore, restoreDatabaseJobSuffix, r.getBackupPVCName(ctx, pulpRestore), script)
+	job.Spec.Template.Spec.Containers[0].Env = controllers.PostgresEnv(pulpRestore.Status.PostgresSecret)
+	decryptJob(job, pulpRestore)
 
-	return nil
+	finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpRestore, job)
+	if finished {
+		log.Info("Database restore finished!")
+	}
+	return finished, err
 }
 
-// waitDBReady waits until db container gets into a "READY" state
-func (r *RepoManagerRestoreReconciler) waitDBReady(ctx context.Context, namespace, stsName string) error {
-	var err error
-	for timeout := 0; timeout < 120; timeout++ {
-		sts := &appsv1.StatefulSet{}
-		err = r.Get(ctx, types.NamespacedName{Name: stsName, Namespace: namespace}, sts)
-		if sts.Status.ReadyReplicas == sts.Status.Replicas {
-			return nil
-		}
-		time.Sleep(time.Second)
+// pgRestoreScript returns the script that restores the database dump from backupFile, dropping the
+// existing objects first if clean is true.
+// pg_restore detects the format of the dump, but it can only run parallel jobs for a dump in the custom
+// or directory format read from a file, so encrypted and tar dumps are restored with a single job.
+func pgRestoreScript(backupFile string, jobs int32, clean, encrypted bool) string {
+	pgRestore := "pg_restore -d \"$PGDATABASE\""
+	if clean {
+		pgRestore += " --clean --if-exists"
+	}
+	if encrypted {
+		return "set -eo pipefail\n" + controllers.EncryptionScript + "decrypt < " + backupFile + " | " + pgRestore
+	}
+	if jobs <= 1 {
+		return pgRestore + " " + backupFile
 	}
-	return err
+	// the archives in the custom format start with PGDMP
+	return fmt.Sprintf(`set -e
+JOBS=
+if [ -d %[1]s ] || [ "$(head -c 5 %[1]s)" = PGDMP ]; then
+  JOBS="-j %[2]d"
+fi
+%[3]s $JOBS %[1]s`, backupFile, jobs, pgRestore)
 }
@@ -0,0 +1,92 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_restore
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPgRestoreScript verifies that parallel jobs are only used for the dumps in the custom and directory formats
+func TestPgRestoreScript(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not found")
+	}
+	backupDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(backupDir, "tar.db"), []byte("toc.dat\x00"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(backupDir, "custom.db"), []byte("PGDMP\x01"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(backupDir, "directory.db"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		file   string
+		jobs   int32
+		clean  bool
+		expect string
+	}{
+		{
+			name:   "tar format",
+			file:   "tar.db",
+			jobs:   4,
+			expect: "-d pulp tar.db",
+		},
+		{
+			name:   "custom format",
+			file:   "custom.db",
+			jobs:   4,
+			clean:  true,
+			expect: "-d pulp --clean --if-exists -j 4 custom.db",
+		},
+		{
+			name:   "directory format",
+			file:   "directory.db",
+			jobs:   8,
+			expect: "-d pulp -j 8 directory.db",
+		},
+		{
+			name:   "single job",
+			file:   "directory.db",
+			jobs:   1,
+			expect: "-d pulp directory.db",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// pg_restore prints its arguments instead of restoring the dump
+			script := "pg_restore() { echo \"$@\"; }\ncd " + backupDir + "\n" + pgRestoreScript(tt.file, tt.jobs, tt.clean, false)
+			cmd := exec.Command("bash", "-c", script)
+			cmd.Env = append(os.Environ(), "PGDATABASE=pulp")
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("script failed: %v\n%s", err, out)
+			}
+			if args := strings.TrimSpace(string(out)); args != tt.expect {
+				t.Errorf("expected pg_restore %v, got pg_restore %v", tt.expect, args)
+			}
+		})
+	}
+}
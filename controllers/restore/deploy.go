@@ -4,25 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"strings"
-	"time"
 
 	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
 	"github.com/pulp/pulp-operator/controllers"
 	appsv1 "k8s.io/api/apps/v1"
-	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-type PodReplicas struct {
-	Api, Content, Worker, Web int32
-}
-
 // restorePulpCR recreates the pulp CR with the content from backup
-func (r *RepoManagerRestoreReconciler) restorePulpCR(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupDir string, pod *corev1.Pod) (PodReplicas, error) {
+func (r *RepoManagerRestoreReconciler) restorePulpCR(ctx context.Context, pulpRestore *pulpv1.PulpRestore, target *restoreTarget, files map[string][]byte) error {
 	pulp := &pulpv1.Pulp{}
-	podReplicas := PodReplicas{}
 
 	// we'll recreate pulp instance only if it was not found
 	// in situations like during a pulpRestore reconcile loop (because of an error, for example) pulp instance could have been previously created
@@ -31,117 +26,126 @@ func (r *RepoManagerRestoreReconciler) restorePulpCR(ctx context.Context, pulpRe
 		log := r.RawLogger
 		log.Info("Restoring " + pulpRestore.Spec.DeploymentName + " CR ...")
 		r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Restoring "+pulpRestore.Spec.DeploymentName+" CR", "Restoring"+pulpRestore.Spec.DeploymentName+"CR")
-		execCmd := []string{
-			"cat", backupDir + "/cr_object",
-		}
-		cmdOutput, err := controllers.ContainerExec(ctx, r, pod, execCmd, pulpRestore.Name+"-backup-manager", pod.Namespace)
-		if err != nil {
-			log.Error(err, "Failed to get cr_object backup file!")
-			r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Failed to get cr_object backup file!", "FailedGet"+pulpRestore.Spec.DeploymentName+"CR")
-			return PodReplicas{}, err
-		}
 
+		// the annotation keeps the backups from quiescing Pulp until the restore finishes
 		pulp := pulpv1.Pulp{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      pulpRestore.Spec.DeploymentName,
-				Namespace: pulpRestore.Namespace,
+				Name:        pulpRestore.Spec.DeploymentName,
+				Namespace:   pulpRestore.Namespace,
+				Annotations: map[string]string{controllers.QuiescedByAnnotation: quiesceLockHolder(pulpRestore)},
 			},
 		}
-
-		json.Unmarshal([]byte(cmdOutput), &pulp.Spec)
-
-		// store the number of replicas so we can rescale with the same amount later
-		podReplicas = PodReplicas{
-			Api:     pulp.Spec.Api.Replicas,
-			Content: pulp.Spec.Content.Replicas,
-			Worker:  pulp.Spec.Worker.Replicas,
-			Web:     pulp.Spec.Web.Replicas,
+		if err := json.Unmarshal(files["cr_object"], &pulp.Spec); err != nil {
+			log.Error(err, "Failed to get cr_object backup file!")
+			r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Failed to get cr_object backup file!", "FailedGet"+pulpRestore.Spec.DeploymentName+"CR")
+			return err
 		}
+		target.pulpSpec(&pulp.Spec, pulpRestore)
 
+		// the deployments are scaled up (with the number of replicas from backup or 1) only
+		// after the database and the pulp dir are restored
 		pulp.Spec.Api.Replicas = 0
 		pulp.Spec.Content.Replicas = 0
 		pulp.Spec.Worker.Replicas = 0
 		pulp.Spec.Web.Replicas = 0
 		pulp.Spec.DisableMigrations = true
 
-		if err = r.Create(ctx, &pulp); err != nil {
+		if err := r.Create(ctx, &pulp); err != nil {
 			log.Error(err, "Error trying to restore "+pulpRestore.Spec.DeploymentName+" CR!")
 			r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Failed to restore cr_object!", "FailedRestore"+pulpRestore.Spec.DeploymentName+"CR")
-			return PodReplicas{}, err
+			return err
 		}
 
 		log.Info(pulpRestore.Spec.DeploymentName + " CR restored!")
+	} else if err != nil {
+		return err
+	} else if replaceExisting(pulpRestore, componentPulpCR) {
+		return r.replacePulpCR(ctx, pulp, pulpRestore, target, files)
 	}
 
-	return podReplicas, nil
+	return nil
 }
 
 // scaleDeployments will rescale the deployments with:
 // - if KeepBackupReplicasCount = true  - it will keep the same amount of replicas from backup
 // - if KeepBackupReplicasCount = false - it will deploy 1 replica for each component
-func (r *RepoManagerRestoreReconciler) scaleDeployments(ctx context.Context, pulpRestore *pulpv1.PulpRestore, podReplicas PodReplicas) error {
+// - if Pulp existed before the restore - it will restore the replicas it had
+// and returns true when the api and web deployments are ready.
+func (r *RepoManagerRestoreReconciler) scaleDeployments(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupDir string) (bool, error) {
 	log := r.RawLogger
 	pulp := &pulpv1.Pulp{}
 
-	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName, Namespace: pulpRestore.Namespace}, pulp); err != nil && errors.IsNotFound(err) {
+	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName, Namespace: pulpRestore.Namespace}, pulp); err != nil {
 		// Error reading the object - requeue the request.
 		log.Error(err, "Failed to get Pulp CR")
-		return err
+		return false, err
 	}
 
+	// an existing Pulp gets back the replicas it had before the restore
+	if restoresOntoExistingPulp(pulpRestore) {
+		return r.resumePulp(ctx, pulpRestore, pulp)
+	}
+
+	expected := pulp.Spec.DeepCopy()
 	if pulpRestore.Spec.KeepBackupReplicasCount {
-		pulp.Spec.Api.Replicas = podReplicas.Api
-		pulp.Spec.Content.Replicas = podReplicas.Content
-		pulp.Spec.Worker.Replicas = podReplicas.Worker
-		pulp.Spec.Web.Replicas = podReplicas.Web
+		files, err := r.backupFiles(ctx, pulpRestore)
+		if err != nil {
+			return false, err
+		}
+		backupSpec := pulpv1.PulpSpec{}
+		if err := json.Unmarshal(files["cr_object"], &backupSpec); err != nil {
+			log.Error(err, "Failed to get cr_object backup file!")
+			return false, err
+		}
+		expected.Api.Replicas = backupSpec.Api.Replicas
+		expected.Content.Replicas = backupSpec.Content.Replicas
+		expected.Worker.Replicas = backupSpec.Worker.Replicas
+		expected.Web.Replicas = backupSpec.Web.Replicas
 	} else {
-		pulp.Spec.Api.Replicas = 1
-		pulp.Spec.Content.Replicas = 1
-		pulp.Spec.Worker.Replicas = 1
+		expected.Api.Replicas = 1
+		expected.Content.Replicas = 1
+		expected.Worker.Replicas = 1
 		isNginxIngress := strings.ToLower(pulp.Spec.IngressType) == "ingress" && !controllers.IsNginxIngressSupported(pulp)
 		if strings.ToLower(pulp.Spec.IngressType) != "route" && !isNginxIngress {
-			pulp.Spec.Web.Replicas = 1
+			expected.Web.Replicas = 1
 		}
 	}
-	pulp.Spec.DisableMigrations = false
-
-	if err := r.Update(ctx, pulp); err != nil {
-		log.Error(err, "Failed to scale up deployment replicas!")
-		return err
-	}
-
-	log.Info("Waiting operator tasks ...")
-	// wait operator finish update before proceeding
-	for timeout := 0; timeout < 18; timeout++ {
-		time.Sleep(time.Second * 10)
-
-		// [TODO] we should use the operator status to make sure that it finished its execution, but the
-		// .status.condition is not reflecting the real state.
-		// pulp-api and pulp-web were not READY and Pulp-Operator-Finished-Execution was set to true
-		/* r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName, Namespace: pulpRestore.Namespace}, pulp)
-		if v1.IsStatusConditionPresentAndEqual(pulp.Status.Conditions, cases.Title(language.English, cases.Compact).String(pulp.Spec.DeploymentType)+"-Operator-Finished-Execution", metav1.ConditionTrue) {
-			break
-		} */
-
-		apiDeployment := &appsv1.Deployment{}
-		r.Get(ctx, types.NamespacedName{Name: pulp.Name + "-api", Namespace: pulp.Namespace}, apiDeployment)
-		if apiDeployment.Status.ReadyReplicas == apiDeployment.Status.Replicas {
-			break
+	expected.DisableMigrations = false
+	// the backups can quiesce Pulp again once its data is restored
+	locked := pulp.Annotations[controllers.QuiescedByAnnotation] == quiesceLockHolder(pulpRestore)
+	unlockPulp(pulpRestore, pulp)
+
+	if !equality.Semantic.DeepEqual(&pulp.Spec, expected) || locked {
+		pulp.Spec = *expected
+		if err := r.Update(ctx, pulp); err != nil {
+			log.Error(err, "Failed to scale up deployment replicas!")
+			return false, err
 		}
+		log.Info("Waiting operator tasks ...")
+		return false, nil
 	}
 
-	// There is a small interval in which pulp-web stays in a READY state and crash after a few seconds because pulp-api
-	// didn't finish it boot process. This sleep is a workaround to try to mitigate this.
-	// [TODO] add readiness probe to pulp-web pods
-	time.Sleep(time.Second * 60)
-	for timeout := 0; timeout < 18; timeout++ {
-		webDeployment := &appsv1.Deployment{}
-		r.Get(ctx, types.NamespacedName{Name: pulp.Name + "-web", Namespace: pulp.Namespace}, webDeployment)
-		if webDeployment.Status.ReadyReplicas == webDeployment.Status.Replicas {
-			break
-		}
-		time.Sleep(time.Second * 10)
+	// [TODO] we should use the operator status to make sure that it finished its execution, but the
+	// .status.condition is not reflecting the real state.
+	// pulp-api and pulp-web were not READY and Pulp-Operator-Finished-Execution was set to true
+	if ready, err := r.deploymentReady(ctx, pulp.Name+"-api", pulp.Namespace, pulp.Spec.Api.Replicas); !ready || err != nil {
+		return false, err
 	}
+	if pulp.Spec.Web.Replicas > 0 {
+		return r.deploymentReady(ctx, pulp.Name+"-web", pulp.Namespace, pulp.Spec.Web.Replicas)
+	}
+	return true, nil
+}
 
-	return nil
+// deploymentReady returns true when the deployment has been updated by the pulp controller with
+// the expected number of replicas and all of them are ready
+func (r *RepoManagerRestoreReconciler) deploymentReady(ctx context.Context, name, namespace string, replicas int32) (bool, error) {
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, deployment); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas != replicas || deployment.Status.ObservedGeneration < deployment.Generation {
+		return false, nil
+	}
+	return deployment.Status.ReadyReplicas == replicas && deployment.Status.UpdatedReplicas == replicas, nil
 }
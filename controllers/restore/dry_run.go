@@ -0,0 +1,238 @@
+package repo_manager_restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// actions reported by the dry run for each object restored
+const (
+	dryRunCreate    = "Create"
+	dryRunOverwrite = "Overwrite"
+	dryRunKeep      = "Keep"
+)
+
+// backupResourceFile is a backup file with a resource restored by restoreSecret or restoreConfigMap
+type backupResourceFile struct {
+	// name of the backup file
+	file string
+
+	// key with the name of the resource in the files that are not a Secret/ConfigMap YAML
+	nameKey string
+
+	// component of the resource
+	component string
+}
+
+// backupResourceFiles are the backup files with the Secrets and ConfigMaps restored
+var backupResourceFiles = []backupResourceFile{
+	{"pulp_secret_key.yaml", "", componentSecrets},
+	{"admin_secret.yaml", "admin_password_secret", componentSecrets},
+	{"postgres_configuration_secret.yaml", "postgres_secret", componentSecrets},
+	{"db_fields_encryption_secret.yaml", "db_fields_encryption_secret", componentSecrets},
+	{"container_token_secret.yaml", "container_token_secret", componentSecrets},
+	{"objectstorage_secret.yaml", "storage_secret", componentSecrets},
+	{"signing_secret.yaml", "signing_secret", componentSecrets},
+	{"signing_scripts.yaml", "", componentSecrets},
+	{"sso_secret.yaml", "sso_secret", componentSecrets},
+	{"ldap_secret.yaml", "", componentSecrets},
+	{"ldap_ca_secret.yaml", "", componentSecrets},
+	{"custom_pulp_settings.yaml", "", componentConfigMaps},
+}
+
+// resourceName returns the name of the resource backed up in the file
+func (f backupResourceFile) resourceName(content []byte) (string, error) {
+	if len(f.nameKey) > 0 {
+		fields := map[string]interface{}{}
+		if err := yaml.Unmarshal(content, &fields); err != nil {
+			return "", err
+		}
+		name, _ := fields[f.nameKey].(string)
+		return name, nil
+	}
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(content, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	meta, ok := obj.(metav1.Object)
+	if !ok {
+		return "", fmt.Errorf("%v is not a Kubernetes object", f.file)
+	}
+	return meta.GetName(), nil
+}
+
+// dryRun reports, when dry_run is true, what the restore would do without modifying Pulp.
+// The restore stops after this phase in case of a dry run.
+func (r *RepoManagerRestoreReconciler) dryRun(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupDir string) (bool, error) {
+	if !pulpRestore.Spec.DryRun {
+		return true, nil
+	}
+
+	files, err := r.backupFiles(ctx, pulpRestore)
+	if err != nil {
+		return false, err
+	}
+	target, err := newRestoreTarget(pulpRestore, files)
+	if err != nil {
+		r.RawLogger.Error(err, "Failed to parse "+controllers.BackupManifestFileName)
+		return false, err
+	}
+	// the restore would fail with the same errors
+	if err := r.checkCopy(ctx, pulpRestore, target, files); err != nil {
+		return false, err
+	}
+
+	report, err := r.dryRunReport(ctx, pulpRestore, target, files)
+	if err != nil {
+		return false, err
+	}
+	pulpRestore.Status.DryRunReport = report
+	return true, nil
+}
+
+// dryRunReport compares the resources from backup with the ones found in the namespace
+func (r *RepoManagerRestoreReconciler) dryRunReport(ctx context.Context, pulpRestore *pulpv1.PulpRestore, target *restoreTarget, files map[string][]byte) (*pulpv1.RestoreDryRunReport, error) {
+	manifest, err := backupManifest(files)
+	if err != nil {
+		return nil, err
+	}
+	report := &pulpv1.RestoreDryRunReport{
+		FileCount:        manifest.FileCount,
+		BackupSize:       manifest.TotalSize,
+		BackupImage:      manifest.PulpImage,
+		DatabaseDumpSize: manifest.DatabaseDumpSize,
+	}
+
+	for _, resourceFile := range backupResourceFiles {
+		content, found := files[resourceFile.file]
+		if !found || !restoreComponent(pulpRestore, resourceFile.component) {
+			continue
+		}
+		name, err := resourceFile.resourceName(content)
+		if err != nil {
+			r.RawLogger.Error(err, "Failed to decode "+resourceFile.file+"!")
+			return nil, err
+		}
+		var obj client.Object = &corev1.Secret{}
+		kind := "Secret"
+		if resourceFile.component == componentConfigMaps {
+			obj, kind = &corev1.ConfigMap{}, "ConfigMap"
+		}
+		object, err := r.dryRunObject(ctx, pulpRestore, resourceFile.component, kind, target.resourceName(name), obj)
+		if err != nil {
+			return nil, err
+		}
+		report.Objects = append(report.Objects, *object)
+	}
+
+	backupSpec := pulpv1.PulpSpec{}
+	if err := json.Unmarshal(files["cr_object"], &backupSpec); err != nil {
+		r.RawLogger.Error(err, "Failed to get cr_object backup file!")
+		return nil, err
+	}
+	pulp := &pulpv1.Pulp{}
+	err = r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName, Namespace: pulpRestore.Namespace}, pulp)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	pulpFound := err == nil
+	if !pulpFound {
+		if err := pulpRequired(pulpRestore); err != nil {
+			return nil, err
+		}
+	}
+
+	// the image of the Pulp CR from backup is deployed if it is restored, otherwise the existing Pulp keeps its image
+	report.PulpImage = controllers.PulpImage(pulpv1.Pulp{Spec: backupSpec})
+	if pulpFound && !replaceExisting(pulpRestore, componentPulpCR) {
+		report.PulpImage = controllers.PulpImage(*pulp)
+	}
+	report.ImageMismatch = len(report.BackupImage) > 0 && report.BackupImage != report.PulpImage
+
+	if restoreComponent(pulpRestore, componentPulpCR) {
+		object, err := r.dryRunObject(ctx, pulpRestore, componentPulpCR, "Pulp", pulpRestore.Spec.DeploymentName, &pulpv1.Pulp{})
+		if err != nil {
+			return nil, err
+		}
+		report.Objects = append(report.Objects, *object)
+	}
+
+	// the PVCs provisioned from the VolumeSnapshots taken by backup_mode Snapshot
+	targetPulp := pulp
+	if !pulpFound {
+		targetPulp = &pulpv1.Pulp{ObjectMeta: metav1.ObjectMeta{Name: target.name, Namespace: target.namespace}, Spec: *backupSpec.DeepCopy()}
+		target.pulpSpec(&targetPulp.Spec, pulpRestore)
+	}
+	for _, volume := range snapshotVolumes {
+		if !restoreComponent(pulpRestore, volume) {
+			continue
+		}
+		snapshot, err := r.backupSnapshot(ctx, pulpRestore, volume)
+		if err != nil {
+			return nil, err
+		}
+		pvcName := volumePVC(targetPulp, volume)
+		if snapshot == nil || len(pvcName) == 0 {
+			continue
+		}
+		object, err := r.dryRunObject(ctx, pulpRestore, volume, "PersistentVolumeClaim", pvcName, &corev1.PersistentVolumeClaim{})
+		if err != nil {
+			return nil, err
+		}
+		// the content of an existing file storage PVC is copied from the snapshot, while an existing
+		// database is restored from the dump
+		if object.Action != dryRunCreate {
+			if volume == componentDatabase {
+				continue
+			}
+			object.Action = dryRunOverwrite
+		}
+		report.Objects = append(report.Objects, *object)
+	}
+
+	lockCM := &corev1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Name: CMLock, Namespace: pulpRestore.Namespace}, lockCM); err == nil {
+		report.RestoreLocked = true
+	} else if !errors.IsNotFound(err) {
+		return nil, err
+	}
+	return report, nil
+}
+
+// dryRunObject returns what the restore would do with the object
+func (r *RepoManagerRestoreReconciler) dryRunObject(ctx context.Context, pulpRestore *pulpv1.PulpRestore, component, kind, name string, obj client.Object) (*pulpv1.RestoreDryRunObject, error) {
+	object := &pulpv1.RestoreDryRunObject{Kind: kind, Name: name, Action: dryRunCreate}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: pulpRestore.Namespace}, obj)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		object.Action = dryRunKeep
+		if replaceExisting(pulpRestore, component) {
+			object.Action = dryRunOverwrite
+		}
+	}
+	return object, nil
+}
+
+// dryRunCompleted stops the restore after the dry run. The restore runs when dry_run is set to false.
+func (r *RepoManagerRestoreReconciler) dryRunCompleted(ctx context.Context, pulpRestore *pulpv1.PulpRestore, phases []restorePhase) (ctrl.Result, error) {
+	r.RawLogger.Info("Restore dry run finished!", "Report", pulpRestore.Status.DryRunReport)
+	r.cleanup(ctx, pulpRestore, phases)
+	pulpRestore.Status.Phase = phaseDryRunCompleted
+	r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Dry run finished, check .status.dryRunReport and set dry_run to false to run the restore.", "DryRunCompleted")
+	r.recorder.Event(pulpRestore, corev1.EventTypeNormal, "DryRunCompleted", "Dry run finished, check .status.dryRunReport.")
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_restore
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestDryRunReport verifies the objects and the Pulp image reported by a dry run
+func TestDryRunReport(t *testing.T) {
+	manifest, err := json.Marshal(controllers.BackupManifest{
+		Version:          1,
+		DeploymentName:   "pulp",
+		Namespace:        "test-namespace",
+		PulpImage:        "quay.io/pulp/pulp-minimal:3.60",
+		FileCount:        12,
+		TotalSize:        4096,
+		DatabaseDumpSize: 2048,
+		Files:            []controllers.BackupManifestFile{{Path: "cr_object", Size: 96}, {Path: "pulp.db", Size: 2048}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crObject, err := json.Marshal(pulpv1.PulpSpec{Image: "quay.io/pulp/pulp-minimal", ImageVersion: "3.60"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		controllers.BackupManifestFileName:   manifest,
+		"cr_object":                          crObject,
+		"admin_secret.yaml":                  []byte("admin_password_secret: pulp-admin-password\npassword: password\n"),
+		"postgres_configuration_secret.yaml": []byte("postgres_secret: pulp-postgres-configuration\nhost: pulp-database-svc\nport: 5432\n"),
+		"custom_pulp_settings.yaml":          []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n  TOKEN_AUTH_DISABLED: \"True\"\n"),
+	}
+
+	tests := []struct {
+		name                string
+		components          []string
+		expectObjects       []pulpv1.RestoreDryRunObject
+		expectPulpImage     string
+		expectImageMismatch bool
+	}{
+		{
+			name: "all components",
+			expectObjects: []pulpv1.RestoreDryRunObject{
+				{Kind: "Secret", Name: "pulp-admin-password", Action: dryRunKeep},
+				{Kind: "Secret", Name: "pulp-postgres-configuration", Action: dryRunCreate},
+				{Kind: "ConfigMap", Name: "settings", Action: dryRunCreate},
+				{Kind: "Pulp", Name: "pulp", Action: dryRunKeep},
+			},
+			expectPulpImage:     "quay.io/pulp/pulp-minimal:3.65",
+			expectImageMismatch: true,
+		},
+		{
+			name:       "secrets and Pulp CR",
+			components: []string{componentSecrets, componentPulpCR},
+			expectObjects: []pulpv1.RestoreDryRunObject{
+				{Kind: "Secret", Name: "pulp-admin-password", Action: dryRunOverwrite},
+				{Kind: "Secret", Name: "pulp-postgres-configuration", Action: dryRunCreate},
+				{Kind: "Pulp", Name: "pulp", Action: dryRunOverwrite},
+			},
+			expectPulpImage: "quay.io/pulp/pulp-minimal:3.60",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = corev1.AddToScheme(scheme)
+			_ = pulpv1.AddToScheme(scheme)
+			pulp := &pulpv1.Pulp{
+				ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+				Spec:       pulpv1.PulpSpec{Image: "quay.io/pulp/pulp-minimal", ImageVersion: "3.65"},
+			}
+			adminSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "pulp-admin-password", Namespace: "test-namespace"}}
+			r := &RepoManagerRestoreReconciler{
+				Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulp, adminSecret).Build(),
+				RawLogger: logr.Discard(),
+				Scheme:    scheme,
+			}
+			pulpRestore := &pulpv1.PulpRestore{
+				ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "test-namespace"},
+				Spec:       pulpv1.PulpRestoreSpec{DeploymentName: "pulp", DryRun: true, Components: tt.components},
+			}
+			target, err := newRestoreTarget(pulpRestore, files)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			report, err := r.dryRunReport(context.TODO(), pulpRestore, target, files)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(report.Objects, tt.expectObjects) {
+				t.Errorf("expected objects %+v, got %+v", tt.expectObjects, report.Objects)
+			}
+			if report.DatabaseDumpSize != 2048 || report.FileCount != 12 || report.BackupSize != 4096 {
+				t.Errorf("unexpected sizes in report: %+v", report)
+			}
+			if report.PulpImage != tt.expectPulpImage || report.ImageMismatch != tt.expectImageMismatch {
+				t.Errorf("expected image %v (mismatch %v), got %v (mismatch %v)", tt.expectPulpImage, tt.expectImageMismatch, report.PulpImage, report.ImageMismatch)
+			}
+			if report.RestoreLocked {
+				t.Errorf("restore should not be locked")
+			}
+		})
+	}
+}
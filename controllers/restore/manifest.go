@@ -0,0 +1,277 @@
+package repo_manager_restore
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// verifyOutputDir is where the verification job writes the content of the backed up resources
+	verifyOutputDir  = "/verify"
+	verifyOutputFile = verifyOutputDir + "/backup-files"
+
+	// backupFilesSecretSuffix is the suffix of the Secret with the content of the backed up resources
+	backupFilesSecretSuffix = "-backup-files"
+
+	// backupFilesNotRead is the exit code of the verification job when the operator did not read the
+	// backup files in time
+	backupFilesNotRead = 7
+)
+
+// waitBackupFilesReadScript keeps the verification job running until the operator has read the backup files
+var waitBackupFilesReadScript = `for i in $(seq 600); do
+  [ -f ` + verifyOutputDir + `/read ] && exit 0
+  sleep 1
+done
+echo "the backup files were not read by the operator within 10 minutes"
+exit ` + fmt.Sprint(backupFilesNotRead) + `
+`
+
+// verifyBackup runs a job that makes sure that the backup directory has a valid manifest and that
+// the backup files match the sizes and checksums recorded in it.
+// The content of the backed up resources, used by the next phases, is read from the job pod and stored
+// in the backup files Secret.
+func (r *RepoManagerRestoreReconciler) verifyBackup(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupDir string) (bool, error) {
+	log := r.RawLogger
+
+	// Fail early if pvc is defined but does not exist
+	backupPVCName, PVCfound := r.backupPVCFound(ctx, pulpRestore)
+	if !PVCfound {
+		log.Info("Backup PVC not found!", "PVC", r.getBackupPVCName(ctx, pulpRestore))
+		r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "PVC "+r.getBackupPVCName(ctx, pulpRestore)+" not found!", "BackupPVCNotFound")
+		return false, nil
+	}
+	log.V(1).Info("Backup PVC found!", "PVC", backupPVCName)
+
+	job := backupManagerJob(pulpRestore, verifyJobSuffix, backupPVCName, controllers.VerifyBackupScript(backupDir, verifyOutputFile)+waitBackupFilesReadScript)
+	mountVerifyOutput(job)
+	decryptJob(job, pulpRestore)
+	finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpRestore, job)
+	jobErr := &controllers.JobFailedError{}
+	if goerrors.As(err, &jobErr) {
+		switch jobErr.ExitCode {
+		case controllers.VerifyBackupDirNotFound:
+			return false, &restoreFailedError{"BackupDirNotFound", "Failed to find " + backupDir + " dir!"}
+		case controllers.VerifyBackupManifestNotFound:
+			return false, r.backupVerificationFailed(ctx, pulpRestore, "BackupManifestNotFound", jobErr.Message)
+		case controllers.VerifyBackupInvalidManifest:
+			return false, r.backupVerificationFailed(ctx, pulpRestore, "InvalidBackupManifest", jobErr.Message)
+		case controllers.VerifyBackupEncrypted:
+			return false, r.backupVerificationFailed(ctx, pulpRestore, "EncryptionSecretRequired", jobErr.Message)
+		case controllers.VerifyBackupDecryptionFailed:
+			return false, r.backupVerificationFailed(ctx, pulpRestore, "BackupDecryptionFailed", jobErr.Message)
+		case backupFilesNotRead:
+			return false, r.backupVerificationFailed(ctx, pulpRestore, "BackupFilesNotRead", jobErr.Message)
+		default:
+			return false, r.backupVerificationFailed(ctx, pulpRestore, "BackupVerificationFailed", jobErr.Message)
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	if !finished {
+		return false, r.readBackupFiles(ctx, pulpRestore, job)
+	}
+
+	files, err := r.backupFiles(ctx, pulpRestore)
+	if err != nil {
+		return false, err
+	}
+	manifest, err := backupManifest(files)
+	if err != nil {
+		return false, r.backupVerificationFailed(ctx, pulpRestore, "InvalidBackupManifest", "failed to parse "+backupDir+"/"+controllers.BackupManifestFileName+": "+err.Error())
+	}
+	if manifest.Version > controllers.BackupManifestVersion {
+		return false, r.backupVerificationFailed(ctx, pulpRestore, "UnsupportedBackupVersion", fmt.Sprintf("backup format version %d is newer than the latest version supported by this operator (%d), upgrade the operator to restore it", manifest.Version, controllers.BackupManifestVersion))
+	}
+	if newerOperatorVersion(manifest.OperatorVersion, r.OperatorVersion) {
+		return false, r.backupVerificationFailed(ctx, pulpRestore, "UnsupportedBackupVersion", "backup made by operator version "+manifest.OperatorVersion+", newer than the running operator ("+r.OperatorVersion+"), upgrade the operator to restore it")
+	}
+
+	message := "Backup files match the manifest"
+	if manifest.IsLegacy() {
+		message = "Backup made without a manifest by an older version of the operator, its files were not verified"
+		log.Info(message)
+		r.recorder.Event(pulpRestore, corev1.EventTypeWarning, "UnverifiedBackup", message)
+	} else if err := manifest.Validate(); err != nil {
+		return false, r.backupVerificationFailed(ctx, pulpRestore, "IncompleteBackupManifest", err.Error())
+	} else {
+		log.Info("Backup files verified!", "BackupVersion", manifest.Version, "OperatorVersion", manifest.OperatorVersion, "PulpImage", manifest.PulpImage, "DatabaseVersion", manifest.DatabaseVersion, "Encryption", manifest.Encryption)
+	}
+
+	image, err := r.backupManagerImage(ctx, pulpRestore, files, manifest)
+	if err != nil {
+		return false, err
+	}
+	log.Info("Backup manager image selected", "Image", image)
+	pulpRestore.Status.BackupManagerImage = image
+	r.updateStatus(ctx, pulpRestore, metav1.ConditionTrue, "BackupVerified", message, "BackupVerified")
+	return true, nil
+}
+
+// backupVerificationFailed sets the BackupVerified condition and returns the error that stops the restore
+func (r *RepoManagerRestoreReconciler) backupVerificationFailed(ctx context.Context, pulpRestore *pulpv1.PulpRestore, reason, message string) error {
+	r.RawLogger.Error(goerrors.New(message), "Backup verification failed")
+	r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "BackupVerified", message, reason)
+	return &restoreFailedError{"FailedBackupVerification", "Backup verification failed, the restore will not run. Check the BackupVerified condition for more information."}
+}
+
+// mountVerifyOutput mounts the volume where the verification job writes the content of the backed up
+// resources until it is read by the operator. The volume is kept in memory, the decrypted secrets are not
+// written to the disk of the node.
+func mountVerifyOutput(job *batchv1.Job) {
+	podSpec := &job.Spec.Template.Spec
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "verify-output",
+		MountPath: verifyOutputDir,
+	})
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name:         "verify-output",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory}},
+	})
+}
+
+// verifyPodExec runs a command in the backup-manager container of the verification job pod
+var verifyPodExec = func(ctx context.Context, r *RepoManagerRestoreReconciler, pod *corev1.Pod, command []string) (string, error) {
+	return controllers.ContainerExec(ctx, r, pod, command, "backup-manager", pod.Namespace)
+}
+
+// readBackupFiles copies the content of the backed up resources, written by the verification job, from
+// its pod into the backup files Secret (owned by the PulpRestore) and lets the job finish.
+// The content is read through the exec API so that the credentials of Pulp are never written in the logs.
+func (r *RepoManagerRestoreReconciler) readBackupFiles(ctx context.Context, pulpRestore *pulpv1.PulpRestore, job *batchv1.Job) error {
+	log := r.RawLogger
+	pod, err := controllers.RunningJobPod(ctx, r.Client, job.Name, job.Namespace)
+	if err != nil || pod == nil {
+		return err
+	}
+
+	secretName := pulpRestore.Name + backupFilesSecretSuffix
+	secret := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: pulpRestore.Namespace}, secret)
+	if errors.IsNotFound(err) {
+		output, err := verifyPodExec(ctx, r, pod, []string{"bash", "-c", "[ -f " + verifyOutputFile + " ] && cat " + verifyOutputFile})
+		if err != nil {
+			// the files are not verified yet
+			log.V(1).Info("Backup files not available yet", "Pod", pod.Name)
+			return nil
+		}
+		files, err := controllers.ParseBackupFileContents(output)
+		if err != nil {
+			log.Error(err, "Failed to parse the backup files")
+			return err
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: pulpRestore.Namespace},
+			Data:       files,
+		}
+		ctrl.SetControllerReference(pulpRestore, secret, r.Scheme)
+		if err := r.Create(ctx, secret); err != nil {
+			log.Error(err, "Failed to store the backup files in Secret "+secretName)
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if _, err := verifyPodExec(ctx, r, pod, []string{"touch", verifyOutputDir + "/read"}); err != nil {
+		log.Error(err, "Failed to notify the verification job that the backup files were read")
+		return err
+	}
+	return nil
+}
+
+// backupFiles returns the content of the backed up resources (secrets, configmaps and Pulp CR) stored
+// in the backup files Secret, converted from the backup format version to the current one
+func (r *RepoManagerRestoreReconciler) backupFiles(ctx context.Context, pulpRestore *pulpv1.PulpRestore) (map[string][]byte, error) {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Name + backupFilesSecretSuffix, Namespace: pulpRestore.Namespace}, secret); err != nil {
+		r.RawLogger.Error(err, "Failed to get the backup files read by the verification job")
+		return nil, err
+	}
+	files := map[string][]byte{}
+	for name, content := range secret.Data {
+		files[name] = content
+	}
+	if err := convertBackupFiles(pulpRestore, files); err != nil {
+		return nil, &restoreFailedError{"BackupConversionFailed", err.Error()}
+	}
+	return files, nil
+}
+
+// backupManifest returns the manifest (with only the required files) written by the verification job
+func backupManifest(files map[string][]byte) (*controllers.BackupManifest, error) {
+	manifest := &controllers.BackupManifest{}
+	if err := json.Unmarshal(files[controllers.BackupManifestFileName], manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// isEncrypted returns true if the backup files are encrypted
+func (r *RepoManagerRestoreReconciler) isEncrypted(ctx context.Context, pulpRestore *pulpv1.PulpRestore) (bool, error) {
+	files, err := r.backupFiles(ctx, pulpRestore)
+	if err != nil {
+		return false, err
+	}
+	manifest, err := backupManifest(files)
+	if err != nil {
+		return false, err
+	}
+	return len(manifest.Encryption) > 0, nil
+}
+
+// decryptJob mounts the secret with the encryption passphrase in the job if it is defined
+func decryptJob(job *batchv1.Job, pulpRestore *pulpv1.PulpRestore) {
+	if len(pulpRestore.Spec.EncryptionSecret) > 0 {
+		controllers.MountEncryptionSecret(job, pulpRestore.Spec.EncryptionSecret)
+	}
+}
+
+// restoreResources restores the configmaps, secrets and Pulp CR (selected in components) from backup,
+// renaming them in case Pulp is restored with a different name or into a different namespace
+func (r *RepoManagerRestoreReconciler) restoreResources(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupDir string) (bool, error) {
+	files, err := r.backupFiles(ctx, pulpRestore)
+	if err != nil {
+		return false, err
+	}
+	target, err := newRestoreTarget(pulpRestore, files)
+	if err != nil {
+		r.RawLogger.Error(err, "Failed to parse "+controllers.BackupManifestFileName)
+		return false, err
+	}
+	if err := r.checkCopy(ctx, pulpRestore, target, files); err != nil {
+		return false, err
+	}
+	if checked, err := r.checkComponents(ctx, pulpRestore); !checked || err != nil {
+		return false, err
+	}
+	if restoreComponent(pulpRestore, componentConfigMaps) {
+		if err := r.restoreConfigMap(ctx, pulpRestore, target, files); err != nil {
+			return false, err
+		}
+	}
+	if restoreComponent(pulpRestore, componentSecrets) {
+		if err := r.restoreSecret(ctx, pulpRestore, target, files); err != nil {
+			return false, err
+		}
+	} else if err := postgresSecretName(target, files, pulpRestore); err != nil {
+		return false, err
+	}
+	if restoreComponent(pulpRestore, componentPulpCR) {
+		if err := r.restorePulpCR(ctx, pulpRestore, target, files); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_restore
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestReadBackupFiles verifies that the backup files are read from the verification job pod into a
+// Secret owned by the PulpRestore, and that the job is let finish once they are stored
+func TestReadBackupFiles(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = pulpv1.AddToScheme(scheme)
+	pulpRestore := &pulpv1.PulpRestore{ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "test-namespace", UID: "restore-uid"}}
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "restore" + verifyJobSuffix, Namespace: "test-namespace", UID: "job-uid"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "restore-backup-verify-abcde", Namespace: "test-namespace", Labels: map[string]string{"controller-uid": "job-uid"}},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	r := &RepoManagerRestoreReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulpRestore, job, pod).Build(),
+		RawLogger: logr.Discard(),
+		Scheme:    scheme,
+	}
+	ctx := context.TODO()
+
+	manifest := fmt.Sprintf(`{"version": %d}`, controllers.BackupManifestVersion)
+	output := ""
+	for name, content := range map[string]string{controllers.BackupManifestFileName: manifest, "cr_object": "{}", "admin_secret.yaml": "password: password\n"} {
+		output += "--- " + name + "\n" + base64.StdEncoding.EncodeToString([]byte(content)) + "\n"
+	}
+	verified, read := false, false
+	previous := verifyPodExec
+	verifyPodExec = func(ctx context.Context, r *RepoManagerRestoreReconciler, pod *corev1.Pod, command []string) (string, error) {
+		if command[0] == "touch" {
+			read = true
+			return "", nil
+		}
+		if !verified {
+			return "", errors.New("command terminated with exit code 1")
+		}
+		return output, nil
+	}
+	t.Cleanup(func() { verifyPodExec = previous })
+
+	// the job has not verified the backup yet
+	if err := r.readBackupFiles(ctx, pulpRestore, job); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if read {
+		t.Fatal("the job should not finish before the backup files are read")
+	}
+
+	verified = true
+	if err := r.readBackupFiles(ctx, pulpRestore, job); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !read {
+		t.Error("expected the job to be notified that the backup files were read")
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "restore" + backupFilesSecretSuffix, Namespace: "test-namespace"}, secret); err != nil {
+		t.Fatal(err)
+	}
+	if !metav1.IsControlledBy(secret, pulpRestore) {
+		t.Errorf("expected the Secret to be owned by the PulpRestore, got %+v", secret.OwnerReferences)
+	}
+
+	files, err := r.backupFiles(ctx, pulpRestore)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(files["cr_object"]) != "{}" || !strings.Contains(string(files["admin_secret.yaml"]), "password") {
+		t.Errorf("expected the content of the backed up resources, got %v", files)
+	}
+
+	r.deleteBackupFiles(ctx, pulpRestore)
+	if _, err := r.backupFiles(ctx, pulpRestore); err == nil {
+		t.Error("expected the backup files to be removed")
+	}
+}
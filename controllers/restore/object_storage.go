@@ -0,0 +1,96 @@
+package repo_manager_restore
+
+import (
+	"context"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// downloadBackup copies the backup directory from the object storage into the backup PVC
+func (r *RepoManagerRestoreReconciler) downloadBackup(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupDir string) (bool, error) {
+	log := r.RawLogger
+
+	if pulpRestore.Spec.ObjectStorage == nil {
+		return true, nil
+	}
+
+	remote, err := controllers.NewObjectStorageRemote(ctx, r.Client, pulpRestore.Namespace, pulpRestore.Spec.ObjectStorage)
+	if err != nil {
+		log.Error(err, "Invalid object_storage configuration")
+		return false, err
+	}
+
+	backupPVCName := r.getBackupPVCName(ctx, pulpRestore)
+	if err := r.createBackupPVC(ctx, pulpRestore, backupPVCName); err != nil {
+		return false, err
+	}
+
+	job := controllers.ObjectStorageScriptJob(pulpRestore.Name+downloadJobSuffix, pulpRestore.Namespace, backupPVCName, controllers.BackupMountPath, remote, nil,
+		controllers.ProgressScript(backupDir, "")+"rclone copy "+remote.Path(backupDir)+" "+backupDir,
+	)
+	finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpRestore, job)
+	if finished {
+		log.Info("Backup download finished!", "Location", remote.Location(backupDir))
+	}
+	return finished, err
+}
+
+// createBackupPVC provisions the PVC that will store the backup downloaded from object storage
+// in case it is not found (for example, when restoring into a new cluster)
+func (r *RepoManagerRestoreReconciler) createBackupPVC(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupPVCName string) error {
+	log := r.RawLogger
+
+	pvcFound := &corev1.PersistentVolumeClaim{}
+	err := r.Get(ctx, types.NamespacedName{Name: backupPVCName, Namespace: pulpRestore.Namespace}, pvcFound)
+	if err == nil {
+		return nil
+	} else if !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get backup PVC")
+		return err
+	}
+
+	storageRequirements := "5Gi"
+	if len(pulpRestore.Spec.BackupStorageReq) > 0 {
+		storageRequirements = pulpRestore.Spec.BackupStorageReq
+	}
+	storageClassName := pulpRestore.Spec.BackupSC
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      backupPVCName,
+			Namespace: pulpRestore.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "pulp-backup-storage",
+				"app.kubernetes.io/instance":   "pulp-backup-storage-" + pulpRestore.Name,
+				"app.kubernetes.io/component":  "backup-storage",
+				"app.kubernetes.io/part-of":    "pulp",
+				"app.kubernetes.io/managed-by": "pulp-operator",
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: resource.MustParse(storageRequirements),
+				},
+			},
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+		},
+	}
+	// keep the cluster default storage class if none is provided
+	if len(storageClassName) > 0 {
+		pvc.Spec.StorageClassName = &storageClassName
+	}
+
+	log.Info("Creating a new backup PVC", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+	if err := r.Create(ctx, pvc); err != nil {
+		log.Error(err, "Failed to create backup PVC", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package repo_manager_restore
+
+import (
+	"context"
+	"time"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+)
+
+const (
+	phaseDownloadBackup       = "DownloadBackup"
+	phaseVerifyingBackup      = "VerifyingBackup"
+	phaseDryRun               = "DryRun"
+	phaseRestoringVolumes     = "RestoringVolumes"
+	phaseRestoringResources   = "RestoringResources"
+	phaseQuiescingPulp        = "QuiescingPulp"
+	phaseRestoringDB          = "RestoringDB"
+	phaseRestoringPulpDir     = "RestoringPulpDir"
+	phaseRestoringArtifacts   = "RestoringArtifacts"
+	phaseScalingDeployments   = "ScalingDeployments"
+	phaseCompleted            = "Completed"
+	phaseFailed               = "Failed"
+	phaseReleasingDatabase    = "ReleasingDatabase"
+	phaseDryRunCompleted      = "DryRunCompleted"
+	downloadJobSuffix         = "-backup-download"
+	verifyJobSuffix           = "-backup-verify"
+	restoreDatabaseJobSuffix  = "-restore-db"
+	restorePulpDirJobSuffix   = "-restore-dir"
+	restoreArtifactsJobSuffix = "-restore-artifacts"
+
+	// requeueInterval is how long to wait before checking a running job (or the
+	// resources the restore depends on) again in case no event is received
+	requeueInterval = 30 * time.Second
+)
+
+// restorePhase is a step of the restore process
+type restorePhase struct {
+	// name of the phase, it is also used as the RestoreComplete condition reason
+	name string
+
+	// suffix of the name of the job that runs the phase (if any)
+	job string
+
+	// message of the RestoreComplete condition while the phase is running
+	message string
+
+	// message of the RestoreComplete condition if the phase fails
+	failedMessage string
+
+	// run executes the phase and returns true when it is finished.
+	// It should not wait for the jobs or the Pulp components, it will be called again until the phase is finished.
+	run func(context.Context, *pulpv1.PulpRestore, string) (bool, error)
+}
+
+// restoreFailedError is returned by a phase when the restore can not continue
+type restoreFailedError struct {
+	// reason and message of the RestoreComplete condition
+	reason, message string
+}
+
+func (e *restoreFailedError) Error() string {
+	return e.message
+}
+
+// phases returns the steps of the restore process in the order they run
+func (r *RepoManagerRestoreReconciler) phases() []restorePhase {
+	return []restorePhase{
+		{phaseDownloadBackup, downloadJobSuffix, "Downloading backup from object storage ...", "Failed to download backup from object storage!", r.downloadBackup},
+		{phaseVerifyingBackup, verifyJobSuffix, "Verifying backup ...", "Failed to verify backup!", r.verifyBackup},
+		{phaseDryRun, "", "Running restore dry run ...", "Failed to run restore dry run!", r.dryRun},
+		{phaseRestoringVolumes, "", "Restoring volumes from snapshots ...", "Failed to restore volumes from snapshots!", r.restoreVolumes},
+		{phaseRestoringResources, "", "Restoring secrets, configmaps and Pulp CR ...", "Failed to restore secrets, configmaps and Pulp CR!", r.restoreResources},
+		{phaseQuiescingPulp, "", "Scaling down Pulp components ...", "Failed to scale down Pulp components!", r.quiescePulp},
+		{phaseRestoringDB, restoreDatabaseJobSuffix, "Restoring database ...", "Failed to restore database!", r.restoreDatabaseData},
+		{phaseRestoringPulpDir, restorePulpDirJobSuffix, "Restoring Pulp dir ...", "Failed to restore Pulp dir!", r.restorePulpDir},
+		{phaseRestoringArtifacts, restoreArtifactsJobSuffix, "Restoring object storage content ...", "Failed to restore object storage content!", r.restoreArtifacts},
+		{phaseScalingDeployments, "", "Scaling Pulp deployments ...", "Failed to scale Pulp deployments!", r.scaleDeployments},
+	}
+}
+
+// phaseIndex returns the position of the phase named name, or 0 if it is not found
+func phaseIndex(phases []restorePhase, name string) int {
+	for i, phase := range phases {
+		if phase.name == name {
+			return i
+		}
+	}
+	return 0
+}
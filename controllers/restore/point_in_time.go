@@ -0,0 +1,362 @@
+package repo_manager_restore
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	recoverDatabaseJobSuffix  = "-restore-db-recovery"
+	promoteDatabaseJobSuffix  = "-restore-db-promote"
+	rollbackDatabaseJobSuffix = "-restore-db-rollback"
+
+	// databaseStartTimeout is how long to wait, after the recovery job, for the database to get ready
+	databaseStartTimeout = 30 * time.Minute
+
+	// databaseRecoveryRestarts is the number of restarts of the database container after which the
+	// recovery is considered failed, postgres exits when the recovery target can not be reached
+	databaseRecoveryRestarts = 3
+
+	// recoveryTargetLayout is the format of recovery_target_time
+	recoveryTargetLayout = "2006-01-02 15:04:05-07"
+)
+
+// recoverDatabase recovers the database deployed by the operator to the point_in_time from the WAL
+// archive of Pulp. The database is stopped (through controllers.DatabaseRecoveryAnnotation) while a job
+// moves its data aside, extracts the latest base backup taken before point_in_time and configures postgres
+// to replay the archived WAL up to it. Once the database is started again, the restore waits for the
+// recovery to finish. The recovery fails if the database is not ready within databaseStartTimeout or if
+// postgres keeps exiting, and releaseDatabase then moves the original data back.
+func (r *RepoManagerRestoreReconciler) recoverDatabase(ctx context.Context, pulpRestore *pulpv1.PulpRestore, pulp *pulpv1.Pulp) (bool, error) {
+	log := r.RawLogger
+	target := pulpRestore.Spec.PointInTime.UTC().Format(recoveryTargetLayout)
+	archive := pulp.Spec.Database.WALArchive
+	dbPVC := controllers.DatabasePVC(pulp)
+	if archive == nil || len(dbPVC) == 0 {
+		return false, &restoreFailedError{"PointInTimeNotSupported", "point_in_time requires the database deployed by the operator, with a PVC and wal_archive, in Pulp " + pulp.Name + "."}
+	}
+
+	recoveryJob, err := r.recoveryJob(ctx, pulpRestore, pulp, dbPVC)
+	if err != nil {
+		return false, err
+	}
+	recovered := false
+	foundJob := &batchv1.Job{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(recoveryJob), foundJob); err == nil {
+		if recovered, err = controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpRestore, recoveryJob); err != nil {
+			return false, err
+		}
+	} else if !errors.IsNotFound(err) {
+		return false, err
+	}
+
+	_, stopped := pulp.Annotations[controllers.DatabaseRecoveryAnnotation]
+	if !recovered {
+		if !stopped {
+			// the database is started at least once, so that its PVC is provisioned
+			if ready, err := r.databaseReady(ctx, pulp); !ready || err != nil {
+				return false, err
+			}
+			log.Info("Stopping the database to recover it to " + target)
+			metav1.SetMetaDataAnnotation(&pulp.ObjectMeta, controllers.DatabaseRecoveryAnnotation, pulpRestore.Name)
+			return false, r.Update(ctx, pulp)
+		}
+		sts := &appsv1.StatefulSet{}
+		if err := r.Get(ctx, types.NamespacedName{Name: settings.DefaultDBStatefulSet(pulp.Name), Namespace: pulp.Namespace}, sts); err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+		if sts.Status.Replicas > 0 {
+			log.Info("Waiting for the database to stop ...")
+			return false, nil
+		}
+		log.Info("Recovering the database to " + target)
+		if finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpRestore, recoveryJob); !finished || err != nil {
+			return false, err
+		}
+	}
+
+	if stopped {
+		log.Info("Starting the database to replay the WAL up to " + target)
+		delete(pulp.Annotations, controllers.DatabaseRecoveryAnnotation)
+		return false, r.Update(ctx, pulp)
+	}
+	if err := r.checkDatabaseRecovery(ctx, pulp, target); err != nil {
+		return false, err
+	}
+	if ready, err := r.databaseReady(ctx, pulp); err != nil {
+		return false, err
+	} else if !ready {
+		if completion := foundJob.Status.CompletionTime; completion != nil && time.Since(completion.Time) > databaseStartTimeout {
+			return false, &restoreFailedError{"DatabaseRecoveryTimeout", fmt.Sprintf("The database did not get ready within %v after the recovery to %v.", databaseStartTimeout, target)}
+		}
+		return false, nil
+	}
+
+	job := backupManagerJob(pulpRestore, promoteDatabaseJobSuffix, r.getBackupPVCName(ctx, pulpRestore), promoteScript)
+	job.Spec.Template.Spec.Containers[0].Env = controllers.PostgresEnv(pulpRestore.Status.PostgresSecret)
+	if finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpRestore, job); !finished || err != nil {
+		return false, err
+	}
+
+	// the data from before the recovery is not needed anymore once the database is promoted
+	dataPath := controllers.PostgresDataPath(pulp)
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, types.NamespacedName{Name: settings.DefaultDBStatefulSet(pulp.Name) + "-0", Namespace: pulp.Namespace}, pod); err != nil {
+		return false, err
+	}
+	if _, err := databasePodExec(ctx, r, pod, []string{"rm", "-rf", savedDataPath(pulpRestore, dataPath)}); err != nil {
+		log.Error(err, "Failed to remove the data of the database from before the recovery")
+		return false, err
+	}
+	log.Info("Database recovered to " + target)
+	return true, nil
+}
+
+// databasePodExec runs a command in the postgres container of the database pod
+var databasePodExec = func(ctx context.Context, r *RepoManagerRestoreReconciler, pod *corev1.Pod, command []string) (string, error) {
+	return controllers.ContainerExec(ctx, r, pod, command, "postgres", pod.Namespace)
+}
+
+// promoteScript waits until postgres replays the WAL up to the recovery target, where the replay is
+// paused (recovery_target_action pause), promotes the database and removes the recovery settings so that
+// they are not applied again. postgres exits, instead of pausing, if the recovery target is not reached.
+const promoteScript = `until [ "$(psql -Atc 'SELECT pg_is_in_recovery()')" = f ]; do
+  if [ "$(psql -Atc 'SELECT pg_is_wal_replay_paused()')" = t ]; then
+    echo "Recovery target reached, last replayed transaction: $(psql -Atc 'SELECT pg_last_xact_replay_timestamp()')"
+    psql -v ON_ERROR_STOP=1 -c 'SELECT pg_promote()'
+  else
+    echo "Waiting for the database recovery ..."
+    sleep 10
+  fi
+done
+psql -v ON_ERROR_STOP=1 -c 'ALTER SYSTEM RESET restore_command' -c 'ALTER SYSTEM RESET recovery_target_time' -c 'ALTER SYSTEM RESET recovery_target_action'`
+
+// checkDatabaseRecovery returns an error if postgres exited more than databaseRecoveryRestarts times
+// since the database was started to replay the WAL (for example, because the WAL archive ends before
+// the recovery target)
+func (r *RepoManagerRestoreReconciler) checkDatabaseRecovery(ctx context.Context, pulp *pulpv1.Pulp, target string) error {
+	sts := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: settings.DefaultDBStatefulSet(pulp.Name), Namespace: pulp.Namespace}, sts); err != nil || sts.Spec.Selector == nil {
+		return client.IgnoreNotFound(err)
+	}
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(pulp.Namespace), client.MatchingLabels(sts.Spec.Selector.MatchLabels)); err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		for _, container := range pod.Status.ContainerStatuses {
+			if container.RestartCount >= databaseRecoveryRestarts {
+				return &restoreFailedError{"DatabaseRecoveryFailed", fmt.Sprintf("The database was restarted %d times while recovering to %v, the WAL archive may end before it. Check the logs of the %v pod.", container.RestartCount, target, pod.Name)}
+			}
+		}
+	}
+	return nil
+}
+
+// recoveryJob returns the job that replaces the data of the database, in the dbPVC, with the base backup
+// from which the point_in_time is recovered. For a WAL archive in object storage, the job runs in the
+// rclone image to download the base backup and the WAL.
+func (r *RepoManagerRestoreReconciler) recoveryJob(ctx context.Context, pulpRestore *pulpv1.PulpRestore, pulp *pulpv1.Pulp, dbPVC string) (*batchv1.Job, error) {
+	archive := pulp.Spec.Database.WALArchive
+	dataPath := controllers.PostgresDataPath(pulp)
+	backupPVC := r.getBackupPVCName(ctx, pulpRestore)
+
+	var job *batchv1.Job
+	if archive.ObjectStorage != nil {
+		remote, err := controllers.NewObjectStorageRemote(ctx, r.Client, pulpRestore.Namespace, archive.ObjectStorage)
+		if err != nil {
+			r.RawLogger.Error(err, "Invalid wal_archive object_storage configuration")
+			return nil, err
+		}
+		job = controllers.ObjectStorageScriptJob(pulpRestore.Name+recoverDatabaseJobSuffix, pulpRestore.Namespace, backupPVC, controllers.BackupMountPath, remote, nil,
+			recoveryScript(pulpRestore.Spec.PointInTime.Time, dataPath, savedDataPath(pulpRestore, dataPath), remote),
+		)
+	} else {
+		job = backupManagerJob(pulpRestore, recoverDatabaseJobSuffix, backupPVC, recoveryScript(pulpRestore.Spec.PointInTime.Time, dataPath, savedDataPath(pulpRestore, dataPath), nil))
+	}
+
+	mountDatabaseVolume(job, pulp, dbPVC)
+	mount, volume := controllers.WALArchiveMount(archive, "database")
+	podSpec := &job.Spec.Template.Spec
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, mount)
+	if volume != nil {
+		podSpec.Volumes = append(podSpec.Volumes, *volume)
+	}
+	return job, nil
+}
+
+// mountDatabaseVolume mounts the dbPVC in the job as in the database StatefulSet and schedules the
+// job as the database pods
+func mountDatabaseVolume(job *batchv1.Job, pulp *pulpv1.Pulp, dbPVC string) {
+	dataPath := controllers.PostgresDataPath(pulp)
+	podSpec := &job.Spec.Template.Spec
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      "database",
+		MountPath: filepath.Dir(dataPath),
+		SubPath:   filepath.Base(filepath.Dir(dataPath)),
+	})
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "database",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: dbPVC},
+		},
+	})
+	// the files of the database are owned by the postgres user
+	podSpec.SecurityContext = controllers.PostgresSecurityContext()
+	podSpec.Affinity = pulp.Spec.Database.Affinity
+	podSpec.NodeSelector = pulp.Spec.Database.NodeSelector
+	podSpec.Tolerations = pulp.Spec.Database.Tolerations
+}
+
+// savedDataPath returns where the recovery job moves the data of the database found before the recovery
+func savedDataPath(pulpRestore *pulpv1.PulpRestore, dataPath string) string {
+	return dataPath + ".pre-pitr-" + pulpRestore.Name
+}
+
+// recoveryScript returns the script that moves the data of the database from dataPath to savedPath,
+// extracts, in dataPath, the latest base backup taken before target and configures postgres to replay
+// the archived WAL up to target. It fails, without touching the data of the database, if there is no
+// base backup before target. Whether the WAL archive reaches target is only known by postgres: the
+// replay is paused at target, or postgres exits if the archive ends before it (checkDatabaseRecovery).
+// For an archive in object storage (remote), the base backup and the WAL are downloaded to the recovery
+// directory of the spool, which is not uploaded, and removed by the base-backup sidecar once the
+// database is promoted.
+func recoveryScript(target time.Time, dataPath, savedPath string, remote *controllers.ObjectStorageRemote) string {
+	listBases := "ls $SOURCE/base"
+	download := ""
+	source := "$ARCHIVE"
+	if remote != nil {
+		listBases = "rclone lsf --dirs-only " + remote.Root() + "/base | tr -d /"
+		download = fmt.Sprintf(`rm -rf $SOURCE
+rclone copy %[1]s/base/$BASE $SOURCE/base/$BASE
+rclone copy %[1]s/wal $SOURCE/wal --max-age $(( $(date +%%s) - BASE + 3600 ))s
+`, remote.Root())
+		source = controllers.WALRecoveryDir
+	}
+	targetTime := target.UTC().Format(recoveryTargetLayout)
+	return fmt.Sprintf(`set -e
+ARCHIVE=%[1]s
+SOURCE=%[2]s
+TARGET=%[3]d
+BASE=$(%[4]s | grep -E '^[0-9]+$' | sort -n | awk -v t=$TARGET '$1 <= t' | tail -n 1)
+if [ -z "$BASE" ]; then
+  echo "No base backup taken before %[5]s found in the WAL archive"
+  exit 1
+fi
+%[6]s# the data found before the recovery is kept, a retried job only removes the data extracted by the previous one
+if [ -d %[8]s ]; then
+  rm -rf %[7]s
+elif [ -d %[7]s ]; then
+  echo "Moving the data of the database to %[8]s"
+  mv %[7]s %[8]s
+fi
+echo "Restoring base backup $BASE"
+mkdir -m 0700 %[7]s
+tar -xzf $SOURCE/base/$BASE/base.tar.gz -C %[7]s
+touch %[7]s/recovery.signal
+cat >> %[7]s/postgresql.auto.conf <<EOF
+restore_command = 'cp $SOURCE/wal/%%f %%p'
+recovery_target_time = '%[5]s'
+recovery_target_action = 'pause'
+EOF
+# a new base backup is taken once the database is promoted
+rm -f $ARCHIVE/.last-base-backup
+`, controllers.WALArchiveMountPath, source, target.Unix(), listBases, targetTime, download, dataPath, savedPath)
+}
+
+// databaseReady returns true if the database StatefulSet of pulp is ready
+func (r *RepoManagerRestoreReconciler) databaseReady(ctx context.Context, pulp *pulpv1.Pulp) (bool, error) {
+	sts := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: settings.DefaultDBStatefulSet(pulp.Name), Namespace: pulp.Namespace}, sts); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	if sts.Status.ReadyReplicas == 0 || sts.Status.ReadyReplicas != sts.Status.Replicas {
+		r.RawLogger.Info("Waiting db pod get into a READY state ...")
+		return false, nil
+	}
+	return true, nil
+}
+
+// rollbackScript moves the data of the database found before the recovery, from savedPath, back to dataPath
+func rollbackScript(dataPath, savedPath string) string {
+	return fmt.Sprintf(`set -e
+if [ -d %[2]s ]; then
+  echo "Moving the data of the database back from %[2]s"
+  rm -rf %[1]s
+  mv %[2]s %[1]s
+fi
+`, dataPath, savedPath)
+}
+
+// releaseDatabase runs when the restore fails while the database of Pulp is being recovered. It stops
+// the database, moves back the data found before the recovery (if the recovery job moved it) and starts
+// the database again. It returns true once the database is started.
+func (r *RepoManagerRestoreReconciler) releaseDatabase(ctx context.Context, pulpRestore *pulpv1.PulpRestore) (bool, error) {
+	log := r.RawLogger
+	pulp := &pulpv1.Pulp{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName, Namespace: pulpRestore.Namespace}, pulp); err != nil {
+		return errors.IsNotFound(err), client.IgnoreNotFound(err)
+	}
+	holder, stopped := pulp.Annotations[controllers.DatabaseRecoveryAnnotation]
+	if stopped && holder != pulpRestore.Name {
+		return true, nil
+	}
+
+	// the data is only modified by the recovery job
+	recovering := true
+	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Name + recoverDatabaseJobSuffix, Namespace: pulpRestore.Namespace}, &batchv1.Job{}); errors.IsNotFound(err) {
+		recovering = false
+	} else if err != nil {
+		return false, err
+	}
+	dbPVC := controllers.DatabasePVC(pulp)
+	if recovering && len(dbPVC) > 0 {
+		if !stopped {
+			log.Info("Stopping the database to move back its data from before the recovery")
+			metav1.SetMetaDataAnnotation(&pulp.ObjectMeta, controllers.DatabaseRecoveryAnnotation, pulpRestore.Name)
+			return false, r.Update(ctx, pulp)
+		}
+		// the promote job could still be waiting for the recovery
+		if err := controllers.DeleteJob(ctx, r.Client, pulpRestore.Name+promoteDatabaseJobSuffix, pulpRestore.Namespace); err != nil {
+			return false, err
+		}
+		sts := &appsv1.StatefulSet{}
+		if err := r.Get(ctx, types.NamespacedName{Name: settings.DefaultDBStatefulSet(pulp.Name), Namespace: pulp.Namespace}, sts); err != nil && !errors.IsNotFound(err) {
+			return false, err
+		} else if err == nil && sts.Status.Replicas > 0 {
+			log.Info("Waiting for the database to stop ...")
+			return false, nil
+		}
+
+		dataPath := controllers.PostgresDataPath(pulp)
+		job := backupManagerJob(pulpRestore, rollbackDatabaseJobSuffix, r.getBackupPVCName(ctx, pulpRestore), rollbackScript(dataPath, savedDataPath(pulpRestore, dataPath)))
+		mountDatabaseVolume(job, pulp, dbPVC)
+		if finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpRestore, job); !finished || err != nil {
+			return false, err
+		}
+	}
+
+	if stopped {
+		log.Info("Starting the database of Pulp " + pulp.Name)
+		delete(pulp.Annotations, controllers.DatabaseRecoveryAnnotation)
+		if err := r.Update(ctx, pulp); err != nil {
+			log.Error(err, "Failed to start the database of Pulp "+pulp.Name)
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,287 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_restore
+
+import (
+	"context"
+	goerrors "errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// TestRecoverDatabase verifies the steps of the point-in-time recovery: the database is stopped, its
+// data replaced by the recovery job, started again and the restore waits for its promotion
+func TestRecoverDatabase(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = pulpv1.AddToScheme(scheme)
+	storageClass := "standard"
+	pulp := &pulpv1.Pulp{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+		Spec: pulpv1.PulpSpec{Database: pulpv1.Database{
+			PostgresStorageClass: &storageClass,
+			WALArchive:           &pulpv1.WALArchive{PVC: "pulp-wal"},
+		}},
+	}
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp-database", Namespace: "test-namespace"},
+		Status:     appsv1.StatefulSetStatus{Replicas: 1, ReadyReplicas: 1},
+	}
+	pulpRestore := &pulpv1.PulpRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "test-namespace"},
+		Spec: pulpv1.PulpRestoreSpec{
+			DeploymentName: "pulp",
+			BackupPVC:      "backup-claim",
+			PointInTime:    &metav1.Time{Time: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)},
+		},
+		Status: pulpv1.PulpRestoreStatus{PostgresSecret: "pulp-postgres-configuration"},
+	}
+	databasePod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pulp-database-0", Namespace: "test-namespace"}}
+	r := &RepoManagerRestoreReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulp, sts, pulpRestore, databasePod).Build(),
+		RawLogger: logr.Discard(),
+		Scheme:    scheme,
+	}
+	ctx := context.TODO()
+	removed := ""
+	previous := databasePodExec
+	databasePodExec = func(ctx context.Context, r *RepoManagerRestoreReconciler, pod *corev1.Pod, command []string) (string, error) {
+		removed = pod.Name + ": " + strings.Join(command, " ")
+		return "", nil
+	}
+	t.Cleanup(func() { databasePodExec = previous })
+
+	recover := func(step string) bool {
+		t.Helper()
+		pulp := &pulpv1.Pulp{}
+		if err := r.Get(ctx, types.NamespacedName{Name: "pulp", Namespace: "test-namespace"}, pulp); err != nil {
+			t.Fatal(err)
+		}
+		finished, err := r.recoverDatabase(ctx, pulpRestore, pulp)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", step, err)
+		}
+		return finished
+	}
+	annotation := func() string {
+		pulp := &pulpv1.Pulp{}
+		if err := r.Get(ctx, types.NamespacedName{Name: "pulp", Namespace: "test-namespace"}, pulp); err != nil {
+			t.Fatal(err)
+		}
+		return pulp.Annotations[controllers.DatabaseRecoveryAnnotation]
+	}
+	setStatefulSet := func(replicas int32) {
+		sts.Status = appsv1.StatefulSetStatus{Replicas: replicas, ReadyReplicas: replicas}
+		if err := r.Status().Update(ctx, sts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	completeJob := func(suffix string) *batchv1.Job {
+		job := &batchv1.Job{}
+		if err := r.Get(ctx, types.NamespacedName{Name: "restore" + suffix, Namespace: "test-namespace"}, job); err != nil {
+			t.Fatalf("job %v not found: %v", suffix, err)
+		}
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		if err := r.Status().Update(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+
+	if recover("stop") || annotation() != "restore" {
+		t.Fatalf("expected the database to be stopped, got annotation %q", annotation())
+	}
+	if recover("wait for the database to stop") {
+		t.Fatal("expected the recovery to wait for the database to stop")
+	}
+	if err := r.Get(ctx, types.NamespacedName{Name: "restore" + recoverDatabaseJobSuffix, Namespace: "test-namespace"}, &batchv1.Job{}); err == nil {
+		t.Fatal("the recovery job should not run while the database is running")
+	}
+
+	setStatefulSet(0)
+	if recover("recovery job") {
+		t.Fatal("expected the recovery to wait for the recovery job")
+	}
+	job := completeJob(recoverDatabaseJobSuffix)
+	script := job.Spec.Template.Spec.Containers[0].Command[2]
+	if !strings.Contains(script, "recovery_target_time = '2026-10-01 12:00:00+00'") || !strings.Contains(script, "tar -xzf $SOURCE/base/$BASE/base.tar.gz -C "+controllers.DefaultPostgresDataPath) ||
+		!strings.Contains(script, "mv "+controllers.DefaultPostgresDataPath+" "+controllers.DefaultPostgresDataPath+".pre-pitr-restore") ||
+		!strings.Contains(script, "recovery_target_action = 'pause'") {
+		t.Errorf("unexpected recovery script: %v", script)
+	}
+	claims := []string{}
+	for _, volume := range job.Spec.Template.Spec.Volumes {
+		claims = append(claims, volume.PersistentVolumeClaim.ClaimName)
+	}
+	if strings.Join(claims, ",") != "backup-claim,pulp-postgres-pulp-database-0,pulp-wal" {
+		t.Errorf("unexpected volumes of the recovery job: %v", claims)
+	}
+
+	if recover("start") || annotation() != "" {
+		t.Fatalf("expected the database to be started, got annotation %q", annotation())
+	}
+	setStatefulSet(1)
+	if recover("promote job") {
+		t.Fatal("expected the recovery to wait for the promotion of the database")
+	}
+	promoteJob := completeJob(promoteDatabaseJobSuffix)
+	if script := promoteJob.Spec.Template.Spec.Containers[0].Command[2]; !strings.Contains(script, "pg_is_wal_replay_paused()") || !strings.Contains(script, "pg_promote()") {
+		t.Errorf("unexpected promote script: %v", script)
+	}
+	if removed != "" {
+		t.Fatal("the data from before the recovery should be kept until the database is promoted")
+	}
+	if !recover("recovered") {
+		t.Fatal("expected the recovery to be finished")
+	}
+	if expected := "pulp-database-0: rm -rf " + controllers.DefaultPostgresDataPath + ".pre-pitr-restore"; removed != expected {
+		t.Errorf("expected %q, got %q", expected, removed)
+	}
+}
+
+// TestRecoverDatabaseRollback verifies that the recovery fails if the database does not get ready and
+// that the data from before the recovery is moved back before the database is started again
+func TestRecoverDatabaseRollback(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = appsv1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = pulpv1.AddToScheme(scheme)
+	storageClass := "standard"
+	pulp := &pulpv1.Pulp{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+		Spec: pulpv1.PulpSpec{Database: pulpv1.Database{
+			PostgresStorageClass: &storageClass,
+			WALArchive:           &pulpv1.WALArchive{PVC: "pulp-wal"},
+		}},
+	}
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulp-database", Namespace: "test-namespace"},
+		Status:     appsv1.StatefulSetStatus{Replicas: 1},
+	}
+	pulpRestore := &pulpv1.PulpRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "test-namespace", UID: "restore-uid"},
+		Spec: pulpv1.PulpRestoreSpec{
+			DeploymentName: "pulp",
+			BackupPVC:      "backup-claim",
+			PointInTime:    &metav1.Time{Time: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)},
+		},
+	}
+	r := &RepoManagerRestoreReconciler{RawLogger: logr.Discard(), Scheme: scheme}
+	recoveryJob, err := r.recoveryJob(context.TODO(), pulpRestore, pulp, "pulp-postgres-pulp-database-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	controllerutil.SetControllerReference(pulpRestore, recoveryJob, scheme)
+	// the recovery job finished an hour ago and the database is not ready since then
+	completion := metav1.NewTime(time.Now().Add(-time.Hour))
+	recoveryJob.Status = batchv1.JobStatus{
+		CompletionTime: &completion,
+		Conditions:     []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+	}
+	r.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulp, sts, pulpRestore, recoveryJob).WithStatusSubresource(sts).Build()
+	ctx := context.TODO()
+
+	_, err = r.recoverDatabase(ctx, pulpRestore, pulp)
+	restoreErr := &restoreFailedError{}
+	if !goerrors.As(err, &restoreErr) || restoreErr.reason != "DatabaseRecoveryTimeout" {
+		t.Fatalf("expected DatabaseRecoveryTimeout error, got %v", err)
+	}
+
+	release := func(step string) bool {
+		t.Helper()
+		released, err := r.releaseDatabase(ctx, pulpRestore)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", step, err)
+		}
+		return released
+	}
+	annotation := func() string {
+		pulp := &pulpv1.Pulp{}
+		if err := r.Get(ctx, types.NamespacedName{Name: "pulp", Namespace: "test-namespace"}, pulp); err != nil {
+			t.Fatal(err)
+		}
+		return pulp.Annotations[controllers.DatabaseRecoveryAnnotation]
+	}
+	if release("stop") || annotation() != "restore" {
+		t.Fatalf("expected the database to be stopped, got annotation %q", annotation())
+	}
+	if release("wait for the database to stop") {
+		t.Fatal("expected the rollback to wait for the database to stop")
+	}
+	sts.Status = appsv1.StatefulSetStatus{}
+	if err := r.Status().Update(ctx, sts); err != nil {
+		t.Fatal(err)
+	}
+	if release("rollback job") {
+		t.Fatal("expected the rollback to wait for the rollback job")
+	}
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, types.NamespacedName{Name: "restore" + rollbackDatabaseJobSuffix, Namespace: "test-namespace"}, job); err != nil {
+		t.Fatalf("rollback job not found: %v", err)
+	}
+	if script := job.Spec.Template.Spec.Containers[0].Command[2]; !strings.Contains(script, "mv "+controllers.DefaultPostgresDataPath+".pre-pitr-restore "+controllers.DefaultPostgresDataPath) {
+		t.Errorf("unexpected rollback script: %v", script)
+	}
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	if err := r.Status().Update(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if !release("start") || annotation() != "" {
+		t.Fatalf("expected the database to be started, got annotation %q", annotation())
+	}
+}
+
+// TestRecoverDatabaseNotSupported verifies that the point-in-time recovery fails without the WAL archive
+func TestRecoverDatabaseNotSupported(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = pulpv1.AddToScheme(scheme)
+	r := &RepoManagerRestoreReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).Build(),
+		RawLogger: logr.Discard(),
+		Scheme:    scheme,
+	}
+	pulpRestore := &pulpv1.PulpRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "test-namespace"},
+		Spec:       pulpv1.PulpRestoreSpec{DeploymentName: "pulp", PointInTime: &metav1.Time{Time: time.Now()}},
+	}
+	for _, pulp := range []*pulpv1.Pulp{
+		{ObjectMeta: metav1.ObjectMeta{Name: "pulp"}, Spec: pulpv1.PulpSpec{Database: pulpv1.Database{ExternalDBSecret: "external-db", WALArchive: &pulpv1.WALArchive{PVC: "pulp-wal"}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pulp"}, Spec: pulpv1.PulpSpec{Database: pulpv1.Database{PVC: "pulp-db"}}},
+	} {
+		_, err := r.recoverDatabase(context.TODO(), pulpRestore, pulp)
+		restoreErr := &restoreFailedError{}
+		if !goerrors.As(err, &restoreErr) || restoreErr.reason != "PointInTimeNotSupported" {
+			t.Errorf("expected PointInTimeNotSupported error, got %v", err)
+		}
+	}
+}
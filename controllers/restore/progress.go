@@ -0,0 +1,54 @@
+package repo_manager_restore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// startPhase records the start of the phase and emits an event, unless the phase is resumed
+func (r *RepoManagerRestoreReconciler) startPhase(pulpRestore *pulpv1.PulpRestore, phase restorePhase) {
+	if controllers.StartPhaseRecord(&pulpRestore.Status.PhaseRecords, phase.name, "") {
+		r.recorder.Event(pulpRestore, corev1.EventTypeNormal, phase.name, phase.message)
+	}
+}
+
+// phaseProgress updates the record of the phase with its job and the progress printed by it.
+// It returns true if the record changed.
+func (r *RepoManagerRestoreReconciler) phaseProgress(ctx context.Context, pulpRestore *pulpv1.PulpRestore, phase restorePhase) bool {
+	record := controllers.LastPhaseRecord(pulpRestore.Status.PhaseRecords, phase.name)
+	if record == nil || len(phase.job) == 0 {
+		return false
+	}
+	// the phases do not always run their job
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Name + phase.job, Namespace: pulpRestore.Namespace}, job); err != nil {
+		return false
+	}
+	changed := record.Job != job.Name
+	record.Job = job.Name
+	if r.RESTClient == nil {
+		return changed
+	}
+	logs, err := controllers.JobLogs(ctx, r.Client, r.RESTClient, job.Name, job.Namespace)
+	if err != nil {
+		return changed
+	}
+	return controllers.SetPhaseProgress(record, controllers.ParseJobProgress(logs)) || changed
+}
+
+// finishPhase records the end of the phase and emits an event
+func (r *RepoManagerRestoreReconciler) finishPhase(ctx context.Context, pulpRestore *pulpv1.PulpRestore, phase restorePhase) {
+	r.phaseProgress(ctx, pulpRestore, phase)
+	controllers.FinishPhaseRecord(pulpRestore.Status.PhaseRecords, phase.name, controllers.PhaseSucceeded, "")
+	if record := controllers.LastPhaseRecord(pulpRestore.Status.PhaseRecords, phase.name); record != nil {
+		duration := record.CompletionTime.Sub(record.StartTime.Time).Round(time.Second)
+		r.recorder.Event(pulpRestore, corev1.EventTypeNormal, phase.name+"Finished", fmt.Sprintf("%v finished in %v", phase.name, duration))
+	}
+}
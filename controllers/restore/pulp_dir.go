@@ -5,36 +5,62 @@ import (
 
 	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
 	"github.com/pulp/pulp-operator/controllers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// backupPulpDir copies the content of /var/lib/pulp into the backup PVC
-func (r *RepoManagerRestoreReconciler) restorePulpDir(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupPVCName, backupDir string) error {
+// restorePulpDir copies the content of the pulp dir from backup into /var/lib/pulp
+func (r *RepoManagerRestoreReconciler) restorePulpDir(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupDir string) (bool, error) {
+	log := r.RawLogger
+	if !restoreComponent(pulpRestore, componentPulpDir) {
+		return true, nil
+	}
 
-	// if file-storage PVC is not provisioned it means that pulp is deployed with object storage
-	// in this case, we should just return without action
-	if !r.isFileStorage(ctx, pulpRestore) {
-		return nil
+	pulp := &pulpv1.Pulp{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName, Namespace: pulpRestore.Namespace}, pulp); err != nil {
+		log.Error(err, "Failed to get Pulp CR")
+		return false, err
 	}
 
-	log := r.RawLogger
+	// if pulp is deployed with object storage (or without a PVC) there is no pulp dir to restore
+	fileStoragePVC := controllers.FileStoragePVC(pulp)
+	if len(fileStoragePVC) == 0 {
+		return true, nil
+	}
 
-	// redeploy manager pod to remount the file-storage pvc which
-	// has been reprovisioned after restoring pulp CR
-	r.cleanup(ctx, pulpRestore)
-	pod, err := r.createRestorePod(ctx, pulpRestore, backupPVCName, "/backups")
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Name: fileStoragePVC, Namespace: pulpRestore.Namespace}, pvc); err != nil {
+		log.Info("Waiting for file storage PVC ...", "PVC", fileStoragePVC)
+		return false, client.IgnoreNotFound(err)
+	}
+
+	// backups made with backup_mode Snapshot do not have a copy of the pulp dir
+	snapshot, err := r.backupSnapshot(ctx, pulpRestore, componentPulpDir)
 	if err != nil {
-		return err
+		return false, err
+	} else if snapshot != nil {
+		return r.restorePulpDirFromSnapshot(ctx, pulpRestore, pulp, pvc, snapshot)
 	}
-	log.Info("Starting pulp dir restore ...")
-	execCmd := []string{
-		"bash", "-c", "cp -fa " + backupDir + "/pulp/ /var/lib/pulp",
+
+	encrypted, err := r.isEncrypted(ctx, pulpRestore)
+	if err != nil {
+		return false, err
 	}
-	if _, err := controllers.ContainerExec(ctx, r, pod, execCmd, pulpRestore.Name+"-backup-manager", pod.Namespace); err != nil {
-		log.Error(err, "Failed to restore pulp dir")
-		return err
+	// the size of the Pulp dir is compared with the size of the backup copy
+	script := controllers.ProgressScript(controllers.FileStorageMountPath, backupDir+"/pulp") + "cp -fa " + backupDir + "/pulp/. " + controllers.FileStorageMountPath
+	if encrypted {
+		script = controllers.ProgressScript(controllers.FileStorageMountPath, "") + "set -eo pipefail\n" + controllers.EncryptionScript +
+			"decrypt < " + backupDir + "/" + controllers.EncryptedPulpDir + " | tar -C " + controllers.FileStorageMountPath + " -xf -"
 	}
 
-	log.Info("Pulp's directory backup finished!")
+	job := backupManagerJob(pulpRestore, restorePulpDirJobSuffix, r.getBackupPVCName(ctx, pulpRestore), script)
+	controllers.MountFileStorage(job, fileStoragePVC)
+	decryptJob(job, pulpRestore)
 
-	return nil
+	finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpRestore, job)
+	if finished {
+		log.Info("Pulp's directory restore finished!")
+	}
+	return finished, err
 }
@@ -2,10 +2,10 @@ package repo_manager_restore
 
 import (
 	"context"
+	goerrors "errors"
 	"reflect"
 
 	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
-	"github.com/pulp/pulp-operator/controllers"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -96,7 +96,7 @@ const (
 )
 
 // restoreSecret restores the operator secrets created by pulpbackup CR
-func (r *RepoManagerRestoreReconciler) restoreSecret(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupDir string, pod *corev1.Pod) error {
+func (r *RepoManagerRestoreReconciler) restoreSecret(ctx context.Context, pulpRestore *pulpv1.PulpRestore, target *restoreTarget, files map[string][]byte) error {
 
 	// [TODO]
 	// type secretTypes struct {resourceType string, secretNameKey string, backupFile string}
@@ -106,57 +106,57 @@ func (r *RepoManagerRestoreReconciler) restoreSecret(ctx context.Context, pulpRe
 	r.RawLogger.V(1).Info("Restoring from golang backup version")
 
 	// restore pulp-secret-key secret
-	if _, err := r.restoreSecretFromYaml(ctx, resourceTypePulpSecretKey, backupDir, "pulp_secret_key.yaml", pod, pulpRestore); err != nil {
+	if _, err := r.restoreSecretFromYaml(ctx, resourceTypePulpSecretKey, target, files, "pulp_secret_key.yaml", pulpRestore); err != nil {
 		return err
 	}
 
 	// restore admin password secret
-	if _, err := r.secret(ctx, resourceTypeAdminPassword, "admin_password_secret", backupDir, "admin_secret.yaml", pod, pulpRestore); err != nil {
+	if _, err := r.secret(ctx, resourceTypeAdminPassword, "admin_password_secret", target, files, "admin_secret.yaml", pulpRestore); err != nil {
 		return err
 	}
 
 	// restore postgres secret
-	if _, err := r.secret(ctx, resourceTypePostgres, "postgres_secret", backupDir, "postgres_configuration_secret.yaml", pod, pulpRestore); err != nil {
+	if _, err := r.secret(ctx, resourceTypePostgres, "postgres_secret", target, files, "postgres_configuration_secret.yaml", pulpRestore); err != nil {
 		return err
 	}
 
 	// restore db fields encryption secret
-	if _, err := r.secret(ctx, resourceTypeDBFieldsEncryption, "db_fields_encryption_secret", backupDir, "db_fields_encryption_secret.yaml", pod, pulpRestore); err != nil {
+	if _, err := r.secret(ctx, resourceTypeDBFieldsEncryption, "db_fields_encryption_secret", target, files, "db_fields_encryption_secret.yaml", pulpRestore); err != nil {
 		return err
 	}
 
 	// restore container token secret
 	// this secret is not mandatory. If the backup file is not found is not an error
-	if found, err := r.secret(ctx, resourceTypeContainerToken, "container_token_secret", backupDir, "container_token_secret.yaml", pod, pulpRestore); found && err != nil {
+	if found, err := r.secret(ctx, resourceTypeContainerToken, "container_token_secret", target, files, "container_token_secret.yaml", pulpRestore); found && err != nil {
 		return err
 	}
 
 	// restore object storage secret
 	// this secret is not mandatory. If the backup file is not found is not an error
-	if found, err := r.secret(ctx, resourceTypeObjectStorage, "storage_secret", backupDir, "objectstorage_secret.yaml", pod, pulpRestore); found && err != nil {
+	if found, err := r.secret(ctx, resourceTypeObjectStorage, "storage_secret", target, files, "objectstorage_secret.yaml", pulpRestore); found && err != nil {
 		return err
 	}
 
 	// restore signing secret
 	// this secret is not mandatory. If the backup file is not found is not an error
-	if found, err := r.secret(ctx, resourceTypeSigningSecret, "signing_secret", backupDir, "signing_secret.yaml", pod, pulpRestore); found && err != nil {
+	if found, err := r.secret(ctx, resourceTypeSigningSecret, "signing_secret", target, files, "signing_secret.yaml", pulpRestore); found && err != nil {
 		return err
 	}
-	if found, err := r.restoreSecretFromYaml(ctx, resourceTypeSigningScripts, backupDir, "signing_scripts.yaml", pod, pulpRestore); found && err != nil {
+	if found, err := r.restoreSecretFromYaml(ctx, resourceTypeSigningScripts, target, files, "signing_scripts.yaml", pulpRestore); found && err != nil {
 		return err
 	}
 
 	// restore sso secret
 	// this secret is not mandatory. If the backup file is not found is not an error
-	if found, err := r.secret(ctx, resourceTypeSSOSecret, "sso_secret", backupDir, "sso_secret.yaml", pod, pulpRestore); found && err != nil {
+	if found, err := r.secret(ctx, resourceTypeSSOSecret, "sso_secret", target, files, "sso_secret.yaml", pulpRestore); found && err != nil {
 		return err
 	}
 
 	// restore ldap secret(s)
-	if found, err := r.restoreSecretFromYaml(ctx, resourceTypeLDAP, backupDir, "ldap_secret.yaml", pod, pulpRestore); found && err != nil {
+	if found, err := r.restoreSecretFromYaml(ctx, resourceTypeLDAP, target, files, "ldap_secret.yaml", pulpRestore); found && err != nil {
 		return err
 	}
-	if found, err := r.restoreSecretFromYaml(ctx, resourceTypeLDAP, backupDir, "ldap_ca_secret.yaml", pod, pulpRestore); found && err != nil {
+	if found, err := r.restoreSecretFromYaml(ctx, resourceTypeLDAP, target, files, "ldap_ca_secret.yaml", pulpRestore); found && err != nil {
 		return err
 	}
 
@@ -167,32 +167,20 @@ func (r *RepoManagerRestoreReconciler) restoreSecret(ctx context.Context, pulpRe
 // resourceType: the type of the secret (like AdminPassword, or ObjectStorage, or ContainerToken, etc)
 // secretNameKey: is the secret's key that contains the secret name to be restored
 // it returns false and the error if the file is not found
-func (r *RepoManagerRestoreReconciler) secret(ctx context.Context, resourceType, secretNameKey, backupDir, backupFile string, pod *corev1.Pod, pulpRestore *pulpv1.PulpRestore) (bool, error) {
+func (r *RepoManagerRestoreReconciler) secret(ctx context.Context, resourceType, secretNameKey string, target *restoreTarget, files map[string][]byte, backupFile string, pulpRestore *pulpv1.PulpRestore) (bool, error) {
 
 	log := r.RawLogger
 
 	secretNameData := ""
-	execCmd := []string{
-		"test", "-f", backupDir + "/" + backupFile,
-	}
-	_, err := controllers.ContainerExec(ctx, r, pod, execCmd, pulpRestore.Name+"-backup-manager", pod.Namespace)
+	cmdOutput, found := files[backupFile]
 
 	// if backupFile file is not found return the error
-	if err != nil {
-		return false, err
+	if !found {
+		return false, goerrors.New(backupFile + " not found in backup")
 	} else {
 		// retrieving backup file content
 		log.Info("Restoring " + resourceType + " secret ...")
 		r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Restoring "+resourceType+" secret", "Restoring"+resourceType+"Secret")
-		execCmd = []string{
-			"cat", backupDir + "/" + backupFile,
-		}
-		cmdOutput, err := controllers.ContainerExec(ctx, r, pod, execCmd, pulpRestore.Name+"-backup-manager", pod.Namespace)
-		if err != nil {
-			log.Error(err, "Failed to get "+backupFile+"!")
-			r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Failed to get "+backupFile, "FailedGet"+resourceType+"Secret")
-			return true, err
-		}
 
 		// "assert" struct type based on secretNameKey
 		secretData := map[string]string{}
@@ -200,35 +188,35 @@ func (r *RepoManagerRestoreReconciler) secret(ctx context.Context, resourceType,
 		switch secretNameKey {
 		case "signing_secret":
 			secretType := signingSecret{}
-			yaml.Unmarshal([]byte(cmdOutput), &secretType)
+			yaml.Unmarshal(cmdOutput, &secretType)
 			v = reflect.ValueOf(secretType)
 		case "db_fields_encryption_secret":
 			secretType := dbFieldsEncryptionSecret{}
-			yaml.Unmarshal([]byte(cmdOutput), &secretType)
+			yaml.Unmarshal(cmdOutput, &secretType)
 			v = reflect.ValueOf(secretType)
 		case "storage_secret":
 			secretType := storageObjectSecret{}
-			yaml.Unmarshal([]byte(cmdOutput), &secretType)
+			yaml.Unmarshal(cmdOutput, &secretType)
 			v = reflect.ValueOf(secretType)
 		case "postgres_secret":
 			secretType := postgresSecret{}
-			yaml.Unmarshal([]byte(cmdOutput), &secretType)
+			yaml.Unmarshal(cmdOutput, &secretType)
 			v = reflect.ValueOf(secretType)
 		case "admin_password_secret":
 			secretType := adminPassword{}
-			yaml.Unmarshal([]byte(cmdOutput), &secretType)
+			yaml.Unmarshal(cmdOutput, &secretType)
 			v = reflect.ValueOf(secretType)
 		case "sso_secret":
 			secretType := ssoSecret{}
-			yaml.Unmarshal([]byte(cmdOutput), &secretType)
+			yaml.Unmarshal(cmdOutput, &secretType)
 			v = reflect.ValueOf(secretType)
 		case "container_token_secret":
 			secretType := containerTokenSecret{}
-			yaml.Unmarshal([]byte(cmdOutput), &secretType)
+			yaml.Unmarshal(cmdOutput, &secretType)
 			v = reflect.ValueOf(secretType)
 		case "pulp_secret_key":
 			secretType := pulpSecretKey{}
-			yaml.Unmarshal([]byte(cmdOutput), &secretType)
+			yaml.Unmarshal(cmdOutput, &secretType)
 			v = reflect.ValueOf(secretType)
 		}
 
@@ -239,8 +227,8 @@ func (r *RepoManagerRestoreReconciler) secret(ctx context.Context, resourceType,
 			// we will keep the field content instead of storing it in
 			// secretData because it should not be part of the secret data itself
 			if v.Type().Field(i).Tag.Get("json") == secretNameKey {
-				secretNameData = v.Field(i).String()
-				setStatusField(secretNameKey, v.Field(i).String(), pulpRestore)
+				secretNameData = target.resourceName(v.Field(i).String())
+				setStatusField(secretNameKey, secretNameData, pulpRestore)
 				continue
 			}
 
@@ -251,6 +239,11 @@ func (r *RepoManagerRestoreReconciler) secret(ctx context.Context, resourceType,
 			}
 		}
 
+		// the managed database of a copy of Pulp is provisioned with the new name
+		if len(secretData["host"]) > 0 && secretNameKey == "postgres_secret" {
+			secretData["host"] = target.databaseHost(secretData["host"])
+		}
+
 		secret := &corev1.Secret{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      secretNameData,
@@ -262,7 +255,8 @@ func (r *RepoManagerRestoreReconciler) secret(ctx context.Context, resourceType,
 		// we'll recreate the secret only if it was not found
 		// in situations like during a pulpRestore reconcile loop (because of an error) the secret could have been previously created
 		// this will avoid an infinite reconciliation loop trying to recreate a resource that already exists
-		if err := r.Get(ctx, types.NamespacedName{Name: secretNameData, Namespace: pulpRestore.Namespace}, secret); err != nil && errors.IsNotFound(err) {
+		existing := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: secretNameData, Namespace: pulpRestore.Namespace}, existing); err != nil && errors.IsNotFound(err) {
 			if err := r.Create(ctx, secret); err != nil {
 				log.Error(err, "Failed to create "+resourceType+" secret!")
 				r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Error trying to restore "+resourceType+" secret!", "FailedCreate"+resourceType+"Secret")
@@ -270,6 +264,11 @@ func (r *RepoManagerRestoreReconciler) secret(ctx context.Context, resourceType,
 			}
 			log.Info(resourceType + " secret restored")
 			r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", resourceType+" secret restored", resourceType+"SecretRestored")
+		} else if err == nil {
+			existing.Data, existing.StringData = nil, secretData
+			if err := r.replaceObject(ctx, pulpRestore, componentSecrets, resourceType+" secret", existing); err != nil {
+				return true, err
+			}
 		}
 	}
 
@@ -300,41 +299,33 @@ func setStatusField(fieldName, fieldValue string, pulpRestore *pulpv1.PulpRestor
 // restoreSecretFromYaml restores the Secret from a YAML file.
 // Since we don't need to keep compatibility with ansible version anymore, this
 // method does not need to follow an specific struct and should work with any Secret.
-func (r *RepoManagerRestoreReconciler) restoreSecretFromYaml(ctx context.Context, resourceType, backupDir, backupFile string, pod *corev1.Pod, pulpRestore *pulpv1.PulpRestore) (bool, error) {
+func (r *RepoManagerRestoreReconciler) restoreSecretFromYaml(ctx context.Context, resourceType string, target *restoreTarget, files map[string][]byte, backupFile string, pulpRestore *pulpv1.PulpRestore) (bool, error) {
 
 	log := r.RawLogger
-	ldapSecretFile := backupDir + "/" + backupFile
-	execCmd := []string{
-		"test", "-f", ldapSecretFile,
-	}
-	_, err := controllers.ContainerExec(ctx, r, pod, execCmd, pulpRestore.Name+"-backup-manager", pod.Namespace)
+	cmdOutput, found := files[backupFile]
 
 	// if no ldap secret found there is nothing to be restored
-	if err != nil {
+	if !found {
 		return false, nil
 	}
-	execCmd = []string{
-		"cat", ldapSecretFile,
-	}
-	cmdOutput, err := controllers.ContainerExec(ctx, r, pod, execCmd, pulpRestore.Name+"-backup-manager", pod.Namespace)
-	if err != nil {
-		log.Error(err, "Failed to get "+backupFile+"!")
-		r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Failed to get "+backupFile, "FailedGet"+resourceType+"Secret")
-		return true, err
-	}
 
 	decode := scheme.Codecs.UniversalDeserializer().Decode
-	obj, _, _ := decode([]byte(cmdOutput), nil, nil)
-	secret := obj.(*corev1.Secret)
+	obj, _, err := decode(cmdOutput, nil, nil)
+	secret, ok := obj.(*corev1.Secret)
+	if err != nil || !ok {
+		log.Error(err, "Failed to decode "+backupFile+"!")
+		r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Failed to decode "+backupFile, "FailedGet"+resourceType+"Secret")
+		return true, goerrors.New("failed to decode " + backupFile)
+	}
 
 	// "removing" fields from backup to avoid errors
-	secret.ObjectMeta.ResourceVersion = ""
-	secret.ObjectMeta.ManagedFields = []metav1.ManagedFieldsEntry{}
+	secret.ObjectMeta = target.objectMeta(secret.ObjectMeta)
 
 	// we'll recreate the secret only if it was not found
 	// in situations like during a pulpRestore reconcile loop (because of an error) the secret could have been previously created
 	// this will avoid an infinite reconciliation loop trying to recreate a resource that already exists
-	if err := r.Get(ctx, types.NamespacedName{Name: secret.Name, Namespace: pulpRestore.Namespace}, secret); err != nil && errors.IsNotFound(err) {
+	existing := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: secret.Name, Namespace: pulpRestore.Namespace}, existing); err != nil && errors.IsNotFound(err) {
 		if err := r.Create(ctx, secret); err != nil {
 			log.Error(err, "Failed to create "+resourceType+" secret!")
 			r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", "Error trying to restore "+resourceType+" secret!", "FailedCreate"+resourceType+"Secret")
@@ -342,7 +333,28 @@ func (r *RepoManagerRestoreReconciler) restoreSecretFromYaml(ctx context.Context
 		}
 		log.Info(resourceType + " secret restored")
 		r.updateStatus(ctx, pulpRestore, metav1.ConditionFalse, "RestoreComplete", resourceType+" secret restored", resourceType+"SecretRestored")
+	} else if err == nil {
+		existing.Data, existing.StringData = secret.Data, secret.StringData
+		if err := r.replaceObject(ctx, pulpRestore, componentSecrets, resourceType+" secret", existing); err != nil {
+			return true, err
+		}
 	}
 
 	return true, nil
 }
+
+// postgresSecretName sets the name of the postgres secret from backup in pulpRestore status, which is
+// needed to restore the database when the secrets are not restored
+func postgresSecretName(target *restoreTarget, files map[string][]byte, pulpRestore *pulpv1.PulpRestore) error {
+	backupFile := "postgres_configuration_secret.yaml"
+	content, found := files[backupFile]
+	if !found {
+		return goerrors.New(backupFile + " not found in backup")
+	}
+	secret := postgresSecret{}
+	if err := yaml.Unmarshal(content, &secret); err != nil {
+		return err
+	}
+	pulpRestore.Status.PostgresSecret = target.resourceName(secret.PostgresSecret)
+	return nil
+}
@@ -0,0 +1,209 @@
+package repo_manager_restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// snapshotPVCSuffix is the suffix of the temporary PVC provisioned from the file storage VolumeSnapshot
+// when the content of an existing PVC is restored
+const snapshotPVCSuffix = "-file-storage-snapshot"
+
+// snapshotVolumes are the components that can be restored from the VolumeSnapshots taken by the backup
+var snapshotVolumes = []string{componentPulpDir, componentDatabase}
+
+// volumePVC returns the name of the PVC of pulp where the volume is restored, or an empty string if
+// pulp does not store the volume in a PVC
+func volumePVC(pulp *pulpv1.Pulp, volume string) string {
+	if volume == componentDatabase {
+		return controllers.DatabasePVC(pulp)
+	}
+	return controllers.FileStoragePVC(pulp)
+}
+
+// backupSnapshot returns the VolumeSnapshot of the volume taken by the PulpBackup (backup_name), or nil
+// if the volume was not snapshotted (the backup was made with backup_mode Copy or the PulpBackup is not found)
+func (r *RepoManagerRestoreReconciler) backupSnapshot(ctx context.Context, pulpRestore *pulpv1.PulpRestore, volume string) (*pulpv1.BackupVolumeSnapshot, error) {
+	if len(pulpRestore.Spec.BackupName) == 0 {
+		return nil, nil
+	}
+	pulpBackup := &pulpv1.PulpBackup{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.BackupName, Namespace: pulpRestore.Namespace}, pulpBackup); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+
+	for _, backupSnapshot := range pulpBackup.Status.VolumeSnapshots {
+		if backupSnapshot.Volume != volume {
+			continue
+		}
+		if !backupSnapshot.ReadyToUse {
+			return nil, &restoreFailedError{"VolumeSnapshotNotReady", fmt.Sprintf("VolumeSnapshot %v taken by PulpBackup %v is not ready to use.", backupSnapshot.Name, pulpBackup.Name)}
+		}
+		snapshot := controllers.VolumeSnapshot(backupSnapshot.Name, pulpRestore.Namespace)
+		if err := r.Get(ctx, client.ObjectKeyFromObject(snapshot), snapshot); errors.IsNotFound(err) {
+			return nil, &restoreFailedError{"VolumeSnapshotNotFound", fmt.Sprintf("VolumeSnapshot %v taken by PulpBackup %v not found!", backupSnapshot.Name, pulpBackup.Name)}
+		} else if err != nil {
+			r.RawLogger.Error(err, "Failed to get VolumeSnapshot", "VolumeSnapshot.Name", backupSnapshot.Name)
+			return nil, err
+		}
+		return &backupSnapshot, nil
+	}
+	return nil, nil
+}
+
+// targetPulp returns the Pulp CR found in the namespace or, if it is not found, the Pulp CR that will
+// be restored from backup
+func (r *RepoManagerRestoreReconciler) targetPulp(ctx context.Context, pulpRestore *pulpv1.PulpRestore) (*pulpv1.Pulp, error) {
+	pulp := &pulpv1.Pulp{}
+	err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName, Namespace: pulpRestore.Namespace}, pulp)
+	if err == nil || !errors.IsNotFound(err) {
+		return pulp, err
+	}
+	if err := pulpRequired(pulpRestore); err != nil {
+		return nil, err
+	}
+
+	files, err := r.backupFiles(ctx, pulpRestore)
+	if err != nil {
+		return nil, err
+	}
+	target, err := newRestoreTarget(pulpRestore, files)
+	if err != nil {
+		r.RawLogger.Error(err, "Failed to parse "+controllers.BackupManifestFileName)
+		return nil, err
+	}
+	pulp = &pulpv1.Pulp{ObjectMeta: metav1.ObjectMeta{Name: target.name, Namespace: target.namespace}}
+	if err := json.Unmarshal(files["cr_object"], &pulp.Spec); err != nil {
+		r.RawLogger.Error(err, "Failed to get cr_object backup file!")
+		return nil, err
+	}
+	target.pulpSpec(&pulp.Spec, pulpRestore)
+	return pulp, nil
+}
+
+// restoreVolumes provisions the file storage and database PVCs from the VolumeSnapshots taken by
+// backup_mode Snapshot. The PVCs are created before Pulp CR is restored, so that the operator (and the
+// database StatefulSet) use them instead of provisioning empty ones.
+// The content of the PVCs that already exist is restored by restorePulpDir and restoreDatabaseData.
+func (r *RepoManagerRestoreReconciler) restoreVolumes(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupDir string) (bool, error) {
+	log := r.RawLogger
+	var pulp *pulpv1.Pulp
+	for _, volume := range snapshotVolumes {
+		if !restoreComponent(pulpRestore, volume) {
+			continue
+		}
+		snapshot, err := r.backupSnapshot(ctx, pulpRestore, volume)
+		if err != nil {
+			return false, err
+		} else if snapshot == nil {
+			continue
+		}
+
+		if pulp == nil {
+			if pulp, err = r.targetPulp(ctx, pulpRestore); err != nil {
+				return false, err
+			}
+		}
+		pvcName := volumePVC(pulp, volume)
+		if len(pvcName) == 0 {
+			continue
+		}
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := r.Get(ctx, types.NamespacedName{Name: pvcName, Namespace: pulpRestore.Namespace}, pvc); err == nil {
+			continue
+		} else if !errors.IsNotFound(err) {
+			return false, err
+		}
+
+		labels := settings.CommonLabels(*pulp)
+		labels["app.kubernetes.io/component"] = "storage"
+		if volume == componentDatabase {
+			labels["app.kubernetes.io/component"] = "database"
+		}
+		pvc, err = controllers.PVCFromSnapshot(pvcName, pulpRestore.Namespace, *snapshot, labels)
+		if err != nil {
+			log.Error(err, "Invalid size of VolumeSnapshot", "VolumeSnapshot.Name", snapshot.Name, "Size", snapshot.Size)
+			return false, err
+		}
+		log.Info("Provisioning PVC "+pvcName+" from VolumeSnapshot "+snapshot.Name, "Volume", volume)
+		if err := r.Create(ctx, pvc); err != nil {
+			log.Error(err, "Failed to provision PVC "+pvcName+" from VolumeSnapshot "+snapshot.Name)
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// restorePulpDirFromSnapshot restores the content of the file storage PVC from the VolumeSnapshot.
+// Nothing is copied if the PVC was provisioned from the snapshot (by restoreVolumes), otherwise the
+// content is copied from a temporary PVC provisioned from the snapshot.
+func (r *RepoManagerRestoreReconciler) restorePulpDirFromSnapshot(ctx context.Context, pulpRestore *pulpv1.PulpRestore, pulp *pulpv1.Pulp, pvc *corev1.PersistentVolumeClaim, snapshot *pulpv1.BackupVolumeSnapshot) (bool, error) {
+	log := r.RawLogger
+	if controllers.ProvisionedFromSnapshot(pvc, snapshot.Name) {
+		// the PVC provisioned by the operator is owned by Pulp CR
+		if pvc.Name == settings.DefaultPulpFileStorage(pulp.Name) && metav1.GetControllerOf(pvc) == nil {
+			ctrl.SetControllerReference(pulp, pvc, r.Scheme)
+			if err := r.Update(ctx, pvc); err != nil {
+				log.Error(err, "Failed to set the owner of PVC "+pvc.Name)
+				return false, err
+			}
+		}
+		log.Info("Pulp's directory restored from VolumeSnapshot " + snapshot.Name)
+		return true, nil
+	}
+
+	snapshotPVC := &corev1.PersistentVolumeClaim{}
+	snapshotPVCName := pulpRestore.Name + snapshotPVCSuffix
+	if err := r.Get(ctx, types.NamespacedName{Name: snapshotPVCName, Namespace: pulpRestore.Namespace}, snapshotPVC); errors.IsNotFound(err) {
+		snapshotPVC, err = controllers.PVCFromSnapshot(snapshotPVCName, pulpRestore.Namespace, *snapshot, settings.CommonLabels(*pulp))
+		if err != nil {
+			log.Error(err, "Invalid size of VolumeSnapshot", "VolumeSnapshot.Name", snapshot.Name, "Size", snapshot.Size)
+			return false, err
+		}
+		ctrl.SetControllerReference(pulpRestore, snapshotPVC, r.Scheme)
+		log.Info("Provisioning PVC " + snapshotPVCName + " from VolumeSnapshot " + snapshot.Name)
+		return false, r.Create(ctx, snapshotPVC)
+	} else if err != nil {
+		return false, err
+	}
+
+	job := backupManagerJob(pulpRestore, restorePulpDirJobSuffix, snapshotPVCName,
+		controllers.ProgressScript(controllers.FileStorageMountPath, controllers.BackupMountPath)+"cp -fa "+controllers.BackupMountPath+"/. "+controllers.FileStorageMountPath,
+	)
+	controllers.MountFileStorage(job, pvc.Name)
+	finished, err := controllers.EnsureJob(ctx, r.Client, r.Scheme, pulpRestore, job)
+	if finished {
+		log.Info("Pulp's directory restored from VolumeSnapshot " + snapshot.Name)
+	}
+	return finished, err
+}
+
+// databaseRestoredFromSnapshot returns true if the PVC of the database managed by the operator was
+// provisioned (by restoreVolumes) from the VolumeSnapshot taken by the backup, in which case the
+// database dump does not need to be restored
+func (r *RepoManagerRestoreReconciler) databaseRestoredFromSnapshot(ctx context.Context, pulpRestore *pulpv1.PulpRestore, pulp *pulpv1.Pulp) (bool, error) {
+	snapshot, err := r.backupSnapshot(ctx, pulpRestore, componentDatabase)
+	if err != nil || snapshot == nil {
+		return false, err
+	}
+	pvcName := controllers.DatabasePVC(pulp)
+	if len(pvcName) == 0 {
+		return false, nil
+	}
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pvcName, Namespace: pulpRestore.Namespace}, pvc); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return controllers.ProvisionedFromSnapshot(pvc, snapshot.Name), nil
+}
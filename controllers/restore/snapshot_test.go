@@ -0,0 +1,170 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_manager_restore
+
+import (
+	"context"
+	goerrors "errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestRestoreFromSnapshots verifies that the PVCs of Pulp are provisioned from the VolumeSnapshots taken
+// by the backup, and that the content of an existing PVC is copied from the snapshot
+func TestRestoreFromSnapshots(t *testing.T) {
+	storageClass := "csi-standard"
+	tests := []struct {
+		name               string
+		existingPVC        bool
+		expectPVCFinished  bool
+		expectSnapshotPVC  bool
+		expectDataSourceOf string
+	}{
+		{name: "new PVCs", expectPVCFinished: true, expectDataSourceOf: "backup-file-storage"},
+		{name: "existing file storage PVC", existingPVC: true, expectSnapshotPVC: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = corev1.AddToScheme(scheme)
+			_ = appsv1.AddToScheme(scheme)
+			_ = batchv1.AddToScheme(scheme)
+			_ = pulpv1.AddToScheme(scheme)
+			scheme.AddKnownTypeWithName(controllers.VolumeSnapshotGVK, &unstructured.Unstructured{})
+			pulp := &pulpv1.Pulp{
+				ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+				Spec: pulpv1.PulpSpec{
+					FileStorageClass: storageClass,
+					Database:         pulpv1.Database{PostgresStorageClass: &storageClass},
+				},
+			}
+			pulpBackup := &pulpv1.PulpBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "test-namespace"},
+				Status: pulpv1.PulpBackupStatus{VolumeSnapshots: []pulpv1.BackupVolumeSnapshot{
+					{Name: "backup-file-storage", Volume: componentPulpDir, PVC: "pulp-file-storage", StorageClass: storageClass, AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}, Size: "100Gi", ReadyToUse: true},
+					{Name: "backup-database", Volume: componentDatabase, PVC: "pulp-postgres-pulp-database-0", StorageClass: storageClass, AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}, Size: "8Gi", ReadyToUse: true},
+				}},
+			}
+			objects := []client.Object{pulp, pulpBackup,
+				controllers.NewVolumeSnapshot("backup-file-storage", "test-namespace", "pulp-file-storage", "", nil),
+				controllers.NewVolumeSnapshot("backup-database", "test-namespace", "pulp-postgres-pulp-database-0", "", nil),
+			}
+			if tt.existingPVC {
+				objects = append(objects, &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pulp-file-storage", Namespace: "test-namespace"}})
+			}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+			r := &RepoManagerRestoreReconciler{Client: c, RawLogger: logr.Discard(), Scheme: scheme}
+			pulpRestore := &pulpv1.PulpRestore{
+				ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "test-namespace", UID: "restore-uid"},
+				Spec:       pulpv1.PulpRestoreSpec{DeploymentName: "pulp", BackupName: "backup"},
+			}
+			ctx := context.TODO()
+
+			if finished, err := r.restoreVolumes(ctx, pulpRestore, ""); !finished || err != nil {
+				t.Fatalf("unexpected result: %v, %v", finished, err)
+			}
+			database := &corev1.PersistentVolumeClaim{}
+			if err := c.Get(ctx, types.NamespacedName{Name: "pulp-postgres-pulp-database-0", Namespace: "test-namespace"}, database); err != nil {
+				t.Fatalf("expected the database PVC to be provisioned: %v", err)
+			}
+			if !controllers.ProvisionedFromSnapshot(database, "backup-database") || database.Spec.Resources.Requests.Storage().String() != "8Gi" || *database.Spec.StorageClassName != storageClass {
+				t.Errorf("expected the database PVC to be provisioned from backup-database, got %+v", database.Spec)
+			}
+			if restored, err := r.databaseRestoredFromSnapshot(ctx, pulpRestore, pulp); !restored || err != nil {
+				t.Errorf("expected the database to be restored from the snapshot, got %v, %v", restored, err)
+			}
+
+			finished, err := r.restorePulpDir(ctx, pulpRestore, "")
+			if finished != tt.expectPVCFinished || err != nil {
+				t.Fatalf("expected restorePulpDir to return %v, got %v, %v", tt.expectPVCFinished, finished, err)
+			}
+			fileStorage := &corev1.PersistentVolumeClaim{}
+			if err := c.Get(ctx, types.NamespacedName{Name: "pulp-file-storage", Namespace: "test-namespace"}, fileStorage); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.expectDataSourceOf) > 0 && (!controllers.ProvisionedFromSnapshot(fileStorage, tt.expectDataSourceOf) || !metav1.IsControlledBy(fileStorage, pulp)) {
+				t.Errorf("expected the file storage PVC to be provisioned from %v and owned by Pulp, got %+v", tt.expectDataSourceOf, fileStorage)
+			}
+
+			snapshotPVC := &corev1.PersistentVolumeClaim{}
+			err = c.Get(ctx, types.NamespacedName{Name: "restore" + snapshotPVCSuffix, Namespace: "test-namespace"}, snapshotPVC)
+			if (err == nil) != tt.expectSnapshotPVC {
+				t.Fatalf("expected the temporary snapshot PVC to exist: %v, got %v", tt.expectSnapshotPVC, err)
+			}
+			if !tt.expectSnapshotPVC {
+				return
+			}
+			if finished, err := r.restorePulpDir(ctx, pulpRestore, ""); finished || err != nil {
+				t.Fatalf("expected the copy job to be created, got %v, %v", finished, err)
+			}
+			job := &batchv1.Job{}
+			if err := c.Get(ctx, types.NamespacedName{Name: "restore" + restorePulpDirJobSuffix, Namespace: "test-namespace"}, job); err != nil {
+				t.Fatal(err)
+			}
+			claims := []string{}
+			for _, volume := range job.Spec.Template.Spec.Volumes {
+				claims = append(claims, volume.PersistentVolumeClaim.ClaimName)
+			}
+			if len(claims) != 2 || claims[0] != "restore"+snapshotPVCSuffix || claims[1] != "pulp-file-storage" {
+				t.Errorf("expected the job to copy from the snapshot PVC into the file storage, got %v", claims)
+			}
+		})
+	}
+}
+
+// TestVolumeSnapshotNotFound verifies that the restore fails if a VolumeSnapshot taken by the backup was removed
+func TestVolumeSnapshotNotFound(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = pulpv1.AddToScheme(scheme)
+	scheme.AddKnownTypeWithName(controllers.VolumeSnapshotGVK, &unstructured.Unstructured{})
+	pulpBackup := &pulpv1.PulpBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "test-namespace"},
+		Status: pulpv1.PulpBackupStatus{VolumeSnapshots: []pulpv1.BackupVolumeSnapshot{
+			{Name: "backup-file-storage", Volume: componentPulpDir, PVC: "pulp-file-storage", Size: "100Gi", ReadyToUse: true},
+		}},
+	}
+	r := &RepoManagerRestoreReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(pulpBackup).Build(), RawLogger: logr.Discard(), Scheme: scheme}
+	pulpRestore := &pulpv1.PulpRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: "test-namespace"},
+		Spec:       pulpv1.PulpRestoreSpec{DeploymentName: "pulp", BackupName: "backup"},
+	}
+
+	_, err := r.restoreVolumes(context.TODO(), pulpRestore, "")
+	restoreErr := &restoreFailedError{}
+	if !goerrors.As(err, &restoreErr) || restoreErr.reason != "VolumeSnapshotNotFound" {
+		t.Errorf("expected VolumeSnapshotNotFound error, got %v", err)
+	}
+
+	// the database was not snapshotted, so it is restored from the dump
+	pulpRestore.Spec.Components = []string{componentDatabase, componentPulpCR}
+	if finished, err := r.restoreVolumes(context.TODO(), pulpRestore, ""); !finished || err != nil {
+		t.Errorf("expected no volume to be restored, got %v, %v", finished, err)
+	}
+}
@@ -16,29 +16,25 @@ import (
 
 const CMLock = "restore-lock"
 
-// isFileStorage returns true if pulp is deployed with storage type = file
-// this is a workaround to identify if it will be necessary to mount /var/lib/pulp in the backup-manager pod
-// to restore its contents
-func (r *RepoManagerRestoreReconciler) isFileStorage(ctx context.Context, pulpRestore *pulpv1.PulpRestore) bool {
-	// if file-storage PVC is not provisioned it means that pulp is deployed with object storage
-	// in this case, we should just return restorePulpDir without action
-	fileStoragePVC := &corev1.PersistentVolumeClaim{}
-	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.DeploymentName + "-file-storage", Namespace: pulpRestore.Namespace}, fileStoragePVC); err != nil {
-		return false
+// getBackupPVCName returns the backup_pvc if provided, if not will return the PVC used by
+// the PulpBackup or the default one based on backup_name
+func (r *RepoManagerRestoreReconciler) getBackupPVCName(ctx context.Context, pulpRestore *pulpv1.PulpRestore) string {
+	if len(pulpRestore.Spec.BackupPVC) > 0 {
+		return pulpRestore.Spec.BackupPVC
 	}
 
-	return true
+	// scheduled backups share the same PVC, which is stored in pulpBackup status
+	pulpBackup := &pulpv1.PulpBackup{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Spec.BackupName, Namespace: pulpRestore.Namespace}, pulpBackup); err == nil && len(pulpBackup.Status.BackupClaim) > 0 {
+		return pulpBackup.Status.BackupClaim
+	}
+	return pulpRestore.Spec.BackupName + "-backup-claim"
 }
 
 // backupPVCFound returns the name of PVC and true if backup-claim PVC is found else return nil,false
 func (r *RepoManagerRestoreReconciler) backupPVCFound(ctx context.Context, pulpRestore *pulpv1.PulpRestore) (string, bool) {
 
-	backupPVCName := ""
-	if pulpRestore.Spec.BackupPVC == "" {
-		backupPVCName = pulpRestore.Spec.BackupName + "-backup-claim"
-	} else {
-		backupPVCName = pulpRestore.Spec.BackupPVC
-	}
+	backupPVCName := r.getBackupPVCName(ctx, pulpRestore)
 	backupPVC := &corev1.PersistentVolumeClaim{}
 	if err := r.Get(ctx, types.NamespacedName{Name: backupPVCName, Namespace: pulpRestore.Namespace}, backupPVC); err != nil {
 		return "", false
@@ -60,153 +56,35 @@ func (r *RepoManagerRestoreReconciler) updateStatus(ctx context.Context, pulpRes
 	r.Status().Update(ctx, pulpRestore)
 }
 
-// [TODO] refactor cleanup so that it can be used by pulpRestore and pulpBackup controllers
-// cleanup deletes the backup-manager pod
-func (r *RepoManagerRestoreReconciler) cleanup(ctx context.Context, pulpRestore *pulpv1.PulpRestore) error {
-	restorePod := &corev1.Pod{}
-	r.Get(ctx, types.NamespacedName{Name: pulpRestore.Name + "-backup-manager", Namespace: pulpRestore.Namespace}, restorePod)
-	r.Delete(ctx, restorePod)
-
-	// the Delete method is not synchronous, so this loop will wait until the pod is not present anymore or
-	// the 120 seconds timeout
-	for timeout := 0; timeout < 120; timeout++ {
-		err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Name + "-backup-manager", Namespace: pulpRestore.Namespace}, restorePod)
-		if err != nil && errors.IsNotFound(err) {
-			break
+// cleanup deletes the jobs, the backup files Secret and the temporary PVC created during the restore
+func (r *RepoManagerRestoreReconciler) cleanup(ctx context.Context, pulpRestore *pulpv1.PulpRestore, phases []restorePhase) {
+	for _, phase := range phases {
+		if len(phase.job) == 0 {
+			continue
+		}
+		if err := controllers.DeleteJob(ctx, r.Client, pulpRestore.Name+phase.job, pulpRestore.Namespace); err != nil {
+			r.RawLogger.Error(err, "Failed to remove restore job", "Job.Name", pulpRestore.Name+phase.job)
 		}
-		time.Sleep(time.Second * 5)
-	}
-
-	return nil
-}
-
-// [TODO] refactor createBackupPod so that it can be used by pulpRestore and pulpBackup controllers
-// createBackupPod provisions the backup-manager pod where the restore steps will run
-func (r *RepoManagerRestoreReconciler) createRestorePod(ctx context.Context, pulpRestore *pulpv1.PulpRestore, backupPVCName, backupDir string) (*corev1.Pod, error) {
-	log := r.RawLogger
-
-	labels := map[string]string{
-		"app.kubernetes.io/name":       "pulp-backup-storage",
-		"app.kubernetes.io/instance":   "pulp-backup-storage-" + pulpRestore.Name,
-		"app.kubernetes.io/component":  "backup-storage",
-		"app.kubernetes.io/part-of":    "pulp",
-		"app.kubernetes.io/managed-by": "pulp-operator",
-	}
-
-	// [TO-DO] define postgres image based on the database implementation type
-	// if external database: we should gather from an user input (pulpRestore CR) postgres version
-	// if provisioned by operator: we should gather, for example, from pulp CR spec or from database deployment spec
-	postgresImage := "docker.io/library/postgres:15"
-
-	volumeMounts := []corev1.VolumeMount{{
-		Name:      pulpRestore.Name + "-backup",
-		ReadOnly:  false,
-		MountPath: backupDir,
-	}}
-
-	volumes := []corev1.Volume{{
-		Name: pulpRestore.Name + "-backup",
-		VolumeSource: corev1.VolumeSource{
-			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-				ClaimName: backupPVCName,
-			},
-		},
-	}}
-
-	// we will only mount file-storage PVC if it is found
-	if r.isFileStorage(ctx, pulpRestore) {
-		volumeMounts = append(volumeMounts, corev1.VolumeMount{
-			Name:      "file-storage",
-			ReadOnly:  false,
-			MountPath: "/var/lib/pulp",
-		})
-
-		volumes = append(volumes, corev1.Volume{
-			Name: "file-storage",
-			VolumeSource: corev1.VolumeSource{
-				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-					ClaimName: pulpRestore.Spec.DeploymentName + "-file-storage",
-				},
-			},
-		})
-	}
-
-	// running a dumb command on bkp mount point just to make sure that
-	// the pod is ready to execute the backup commands (mkdir,cp,echo,etc)
-	readinessProbe := &corev1.Probe{
-		ProbeHandler: corev1.ProbeHandler{
-			Exec: &corev1.ExecAction{Command: []string{"ls", backupDir}},
-		},
-		FailureThreshold:    10,
-		InitialDelaySeconds: 3,
-		PeriodSeconds:       10,
-		SuccessThreshold:    1,
-		TimeoutSeconds:      10,
-	}
-
-	runAsUser := int64(700)
-	fsGroup := int64(700)
-
-	restorePod := &corev1.Pod{}
-	pod := &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      pulpRestore.Name + "-backup-manager",
-			Namespace: pulpRestore.Namespace,
-			Labels:    labels,
-		},
-		Spec: corev1.PodSpec{
-			Containers: []corev1.Container{{
-				Name:            pulpRestore.Name + "-backup-manager",
-				Image:           postgresImage,
-				ImagePullPolicy: corev1.PullAlways,
-				Command: []string{
-					"sleep",
-					"infinity",
-				},
-				VolumeMounts:    volumeMounts,
-				ReadinessProbe:  readinessProbe,
-				SecurityContext: controllers.SetDefaultSecurityContext(),
-			}},
-			Volumes:         volumes,
-			RestartPolicy:   corev1.RestartPolicyNever,
-			SecurityContext: &corev1.PodSecurityContext{RunAsUser: &runAsUser, FSGroup: &fsGroup},
-		},
 	}
-	err := r.Get(ctx, types.NamespacedName{Name: pulpRestore.Name + "-backup-manager", Namespace: pulpRestore.Namespace}, restorePod)
-	if err != nil && errors.IsNotFound(err) {
-		log.Info("Creating a new manager Pod", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
-		ctrl.SetControllerReference(pulpRestore, pod, r.Scheme)
-		err = r.Create(ctx, pod)
-		if err != nil {
-			log.Error(err, "Failed to create new manager Pod", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
-			return &corev1.Pod{}, err
+	for _, suffix := range []string{recoverDatabaseJobSuffix, promoteDatabaseJobSuffix, rollbackDatabaseJobSuffix} {
+		if err := controllers.DeleteJob(ctx, r.Client, pulpRestore.Name+suffix, pulpRestore.Namespace); err != nil {
+			r.RawLogger.Error(err, "Failed to remove restore job", "Job.Name", pulpRestore.Name+suffix)
 		}
-	} else if err != nil {
-		log.Error(err, "Failed to get manager Pod")
-		return &corev1.Pod{}, err
 	}
-
-	pod, err = r.waitPodReady(ctx, pulpRestore.Namespace, pulpRestore.Name+"-backup-manager")
-	if err != nil {
-		log.Error(err, "Manager pod not found")
-		return &corev1.Pod{}, err
+	r.deleteBackupFiles(ctx, pulpRestore)
+	snapshotPVC := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: pulpRestore.Name + snapshotPVCSuffix, Namespace: pulpRestore.Namespace}}
+	if err := r.Delete(ctx, snapshotPVC); err != nil && !errors.IsNotFound(err) {
+		r.RawLogger.Error(err, "Failed to remove PVC", "PVC.Name", snapshotPVC.Name)
 	}
-	return pod, nil
 }
 
-// waitPodReady waits until container gets into a "READY" state or 120 seconds timeout
-func (r *RepoManagerRestoreReconciler) waitPodReady(ctx context.Context, namespace, podName string) (*corev1.Pod, error) {
-	var err error
-	for timeout := 0; timeout < 120; timeout++ {
-		pod := &corev1.Pod{}
-		err = r.Get(ctx, types.NamespacedName{Name: podName, Namespace: namespace}, pod)
-
-		if len(pod.Status.ContainerStatuses) > 0 && pod.Status.ContainerStatuses[0].Ready {
-			return pod, nil
-		}
-		time.Sleep(time.Second)
+// deleteBackupFiles removes the Secret with the content of the backed up resources, which holds a copy
+// of the credentials of Pulp
+func (r *RepoManagerRestoreReconciler) deleteBackupFiles(ctx context.Context, pulpRestore *pulpv1.PulpRestore) {
+	backupFiles := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: pulpRestore.Name + backupFilesSecretSuffix, Namespace: pulpRestore.Namespace}}
+	if err := r.Delete(ctx, backupFiles); err != nil && !errors.IsNotFound(err) {
+		r.RawLogger.Error(err, "Failed to remove Secret", "Secret.Name", backupFiles.Name)
 	}
-	return &corev1.Pod{}, err
 }
 
 // createLockConfigMap creates a new configmap that is used to control the operator execution.
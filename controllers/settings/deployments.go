@@ -40,3 +40,7 @@ func (t PulpcoreType) ToLabel() string {
 
 	return strings.ToLower(string(t))
 }
+
+func DBPoolerDeployment(pulpName string) string {
+	return pulpName + "-database-pooler"
+}
@@ -39,6 +39,12 @@ func RedHatOperatorPullSecret(pulpName string) string {
 func DefaultDBSecret(pulpName string) string {
 	return pulpName + "-" + postgresConfiguration
 }
+func DBPoolerSecret(pulpName string) string {
+	return pulpName + "-database-pooler"
+}
+func DBTLSSecret(pulpName string) string {
+	return pulpName + "-database-tls"
+}
 
 // Default configurations for settings.py
 func DefaultPulpSettings(rootUrl string) map[string]string {
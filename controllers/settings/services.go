@@ -26,3 +26,6 @@ func DBService(pulpName string) string {
 func CacheService(pulpName string) string {
 	return pulpName + "-redis-svc"
 }
+func DBPoolerService(pulpName string) string {
+	return pulpName + "-database-pooler-svc"
+}
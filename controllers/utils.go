@@ -542,6 +542,22 @@ func GetHPAConfig(pulp pulpv1.Pulp, pulpcoreType settings.PulpcoreType) *pulpv1.
 	return hpaField.Interface().(*pulpv1.HPA)
 }
 
+// ComponentScale returns the replicas and HPA fields of the component from Pulp CR, so that they
+// can be modified when the component is scaled down (or up) by the backup and restore controllers
+func ComponentScale(pulp *pulpv1.Pulp, component settings.PulpcoreType) (*int32, **pulpv1.HPA) {
+	switch component {
+	case settings.API:
+		return &pulp.Spec.Api.Replicas, &pulp.Spec.Api.HPA
+	case settings.CONTENT:
+		return &pulp.Spec.Content.Replicas, &pulp.Spec.Content.HPA
+	case settings.WORKER:
+		return &pulp.Spec.Worker.Replicas, &pulp.Spec.Worker.HPA
+	case settings.WEB:
+		return &pulp.Spec.Web.Replicas, &pulp.Spec.Web.HPA
+	}
+	return nil, nil
+}
+
 // checkPulpServerSecretModification returns true if the settings.py from pulp-server secret
 // does not have the expected contents
 func checkPulpServerSecretModification(fields ...interface{}) bool {
@@ -1077,6 +1093,51 @@ func SetCAVolumeMounts(pulp *pulpv1.Pulp, volumeMounts []corev1.VolumeMount) []c
 	return append(volumeMounts, trustedCAMount)
 }
 
+// DatabaseCAMountPath is the directory where the CA of the database deployed by the operator with tls is mounted
+// in pulpcore containers. It is not mounted with subPath so that a renewed CA is propagated to the pods.
+const DatabaseCAMountPath = "/etc/pulp/database-tls"
+
+// DatabaseTLSSecret returns the name of the Secret with the certificate and the CA of the database
+func DatabaseTLSSecret(pulp pulpv1.Pulp) string {
+	if tls := pulp.Spec.Database.TLS; tls != nil && len(tls.Secret) > 0 {
+		return tls.Secret
+	}
+	return settings.DBTLSSecret(pulp.Name)
+}
+
+// DatabaseTLSEnabled returns true if the database is deployed by the operator with tls
+func DatabaseTLSEnabled(pulp pulpv1.Pulp) bool {
+	return pulp.Spec.Database.TLS != nil && len(pulp.Spec.Database.ExternalDBSecret) == 0
+}
+
+// SetDatabaseCAVolumes adds the CA of the database into []volume
+func SetDatabaseCAVolumes(pulp *pulpv1.Pulp, volumes []corev1.Volume) []corev1.Volume {
+	if !DatabaseTLSEnabled(*pulp) {
+		return volumes
+	}
+	return append(volumes, corev1.Volume{
+		Name: "database-ca",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: DatabaseTLSSecret(*pulp),
+				Items:      []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}},
+			},
+		},
+	})
+}
+
+// SetDatabaseCAVolumeMounts defines the container mount point for the CA of the database
+func SetDatabaseCAVolumeMounts(pulp *pulpv1.Pulp, volumeMounts []corev1.VolumeMount) []corev1.VolumeMount {
+	if !DatabaseTLSEnabled(*pulp) {
+		return volumeMounts
+	}
+	return append(volumeMounts, corev1.VolumeMount{
+		Name:      "database-ca",
+		MountPath: DatabaseCAMountPath,
+		ReadOnly:  true,
+	})
+}
+
 // SplitCAConfigMapNameKey returns the configmap name and the key from mount_trusted_ca_configmap_key
 func SplitCAConfigMapNameKey(pulp pulpv1.Pulp) (string, string) {
 
@@ -0,0 +1,235 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	"github.com/pulp/pulp-operator/controllers/settings"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// WALArchiveMountPath is where the WAL archive is mounted in the database pod and in the recovery
+	// job. The WAL segments are archived in its wal directory and the base backups in base/<epoch>/base.tar.gz,
+	// where epoch is the time the base backup was started.
+	WALArchiveMountPath = "/var/lib/postgresql/wal-archive"
+
+	// WALRecoveryDir is the directory of the WAL archive spool where the base backup and the WAL are
+	// downloaded from object storage to recover the database. It is removed by the base-backup sidecar
+	// after the first base backup taken once the recovery is finished.
+	WALRecoveryDir = WALArchiveMountPath + "/recovery"
+
+	// DatabaseRecoveryAnnotation is set in Pulp CR, with the name of the PulpRestore, to stop the
+	// database deployed by the operator while it is recovered from the WAL archive
+	DatabaseRecoveryAnnotation = "repo-manager.pulpproject.org/database-recovery"
+
+	// DefaultPostgresDataPath is the PGDATA of the database deployed by the operator
+	DefaultPostgresDataPath = "/var/lib/postgresql/data/pgdata"
+
+	// walArchiveVolume is the name of the volume with the PVC of wal_archive
+	walArchiveVolume = "wal-archive"
+
+	// walSpoolSubPath is the directory of the database volume where the WAL and the base backups are
+	// kept until they are uploaded to the wal_archive object_storage
+	walSpoolSubPath = "wal-archive"
+
+	defaultArchiveTimeout     = 60
+	defaultBaseBackupInterval = 24
+	defaultWALRetentionDays   = 7
+)
+
+// listBaseBackups filters the base backup directories, named after their epoch, from a listing and sorts them
+const listBaseBackups = "grep -E '^[0-9]+$' | sort -n"
+
+// PostgresDataPath returns the PGDATA of the database deployed by the operator
+func PostgresDataPath(pulp *pulpv1.Pulp) string {
+	if len(pulp.Spec.Database.PostgresDataPath) > 0 {
+		return pulp.Spec.Database.PostgresDataPath
+	}
+	return DefaultPostgresDataPath
+}
+
+// PostgresSecurityContext returns the pod security context of the database deployed by the operator
+func PostgresSecurityContext() *corev1.PodSecurityContext {
+	if isOpenshift, _ := IsOpenShift(); isOpenshift {
+		return &corev1.PodSecurityContext{}
+	}
+	runAsUser := int64(999)
+	fsGroup := int64(999)
+	fsGroupChangeOnRootMismatch := corev1.FSGroupChangeOnRootMismatch
+	return &corev1.PodSecurityContext{
+		RunAsUser:           &runAsUser,
+		RunAsGroup:          &fsGroup,
+		FSGroup:             &fsGroup,
+		FSGroupChangePolicy: &fsGroupChangeOnRootMismatch,
+	}
+}
+
+// WALArchiveMount returns the mount of the WAL archive in WALArchiveMountPath and, if the archive is
+// stored in the wal_archive pvc, its volume. The WAL archived to object storage is spooled in a
+// directory of the database volume (dbVolume).
+func WALArchiveMount(archive *pulpv1.WALArchive, dbVolume string) (corev1.VolumeMount, *corev1.Volume) {
+	if archive.ObjectStorage != nil {
+		return corev1.VolumeMount{Name: dbVolume, MountPath: WALArchiveMountPath, SubPath: walSpoolSubPath}, nil
+	}
+	return corev1.VolumeMount{Name: walArchiveVolume, MountPath: WALArchiveMountPath}, &corev1.Volume{
+		Name: walArchiveVolume,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: archive.PVC,
+			},
+		},
+	}
+}
+
+// WALArchiveArgs returns the postgres arguments that archive the WAL segments in WALArchiveMountPath.
+// A segment is copied to a temporary file before being renamed, so that an incomplete segment is never
+// archived, and a segment already archived is accepted only if it is identical.
+func WALArchiveArgs(archive *pulpv1.WALArchive) []string {
+	timeout := archive.ArchiveTimeout
+	if timeout == 0 {
+		timeout = defaultArchiveTimeout
+	}
+	wal := WALArchiveMountPath + "/wal"
+	return []string{
+		"-c", "wal_level=replica",
+		"-c", "archive_mode=on",
+		"-c", fmt.Sprintf("archive_timeout=%d", timeout),
+		"-c", fmt.Sprintf("archive_command=mkdir -p %[1]s && if [ -f %[1]s/%%f ]; then cmp -s %%p %[1]s/%%f; else cp %%p %[1]s/%%f.tmp && mv %[1]s/%%f.tmp %[1]s/%%f; fi", wal),
+	}
+}
+
+// BaseBackupScript returns the script of the base-backup sidecar of the database. It takes a base
+// backup every base_backup_interval hours, once the database is not in recovery, and, if prune is true,
+// removes the base backups and WAL segments older than retention_days (the latest base backup is always kept).
+func BaseBackupScript(archive *pulpv1.WALArchive, prune bool) string {
+	interval := archive.BaseBackupInterval
+	if interval == 0 {
+		interval = defaultBaseBackupInterval
+	}
+	pruneCommand := ""
+	if prune {
+		pruneCommand = fmt.Sprintf(`  BASES=$(ls $ARCHIVE/base | %[1]s)
+  LATEST=$(echo "$BASES" | tail -n 1)
+  for BASE in $BASES; do
+    if [ "$BASE" -lt $(( NOW - %[2]d )) ] && [ "$BASE" != "$LATEST" ]; then
+      echo "Removing base backup $BASE"
+      rm -rf "$ARCHIVE/base/$BASE"
+    fi
+  done
+  OLDEST=$(ls $ARCHIVE/base | %[1]s | head -n 1)
+  if [ -n "$OLDEST" ]; then
+    find $ARCHIVE/wal -type f -mmin +$(( (NOW - OLDEST) / 60 + 60 )) -delete
+  fi
+`, listBaseBackups, retentionSeconds(archive))
+	}
+	return fmt.Sprintf(`ARCHIVE=%[1]s
+mkdir -p $ARCHIVE/wal $ARCHIVE/base
+while true; do
+  NOW=$(date +%%s)
+  LAST=$(cat $ARCHIVE/.last-base-backup 2>/dev/null || echo 0)
+  if [ $(( NOW - LAST )) -ge %[2]d ] && [ "$(psql -h 127.0.0.1 -p 5432 -Atc 'SELECT pg_is_in_recovery()' 2>/dev/null)" = f ]; then
+    rm -rf $ARCHIVE/base/*.tmp
+    if pg_basebackup -h 127.0.0.1 -p 5432 -D $ARCHIVE/base/$NOW.tmp -Ft -z -X none -c fast; then
+      mv $ARCHIVE/base/$NOW.tmp $ARCHIVE/base/$NOW
+      echo $NOW > $ARCHIVE/.last-base-backup
+      echo "Base backup $NOW taken"
+      rm -rf %[4]s
+    fi
+  fi
+%[3]s  sleep 60
+done
+`, WALArchiveMountPath, interval*3600, pruneCommand, WALRecoveryDir)
+}
+
+// WALUploadScript returns the script of the wal-upload sidecar of the database. It moves the archived
+// WAL segments and the base backups to the object storage and, every hour, removes the ones older than
+// retention_days from it (the latest base backup is always kept).
+func WALUploadScript(archive *pulpv1.WALArchive, remote *ObjectStorageRemote) string {
+	return fmt.Sprintf(`ARCHIVE=%[1]s
+REMOTE=%[2]s
+PRUNED=0
+while true; do
+  rclone move $ARCHIVE/wal $REMOTE/wal --exclude '*.tmp'
+  rclone move $ARCHIVE/base $REMOTE/base --exclude '*.tmp/**' --delete-empty-src-dirs
+  NOW=$(date +%%s)
+  if [ $(( NOW - PRUNED )) -ge 3600 ]; then
+    PRUNED=$NOW
+    BASES=$(rclone lsf --dirs-only $REMOTE/base | tr -d / | %[3]s)
+    LATEST=$(echo "$BASES" | tail -n 1)
+    for BASE in $BASES; do
+      if [ "$BASE" -lt $(( NOW - %[4]d )) ] && [ "$BASE" != "$LATEST" ]; then
+        echo "Removing base backup $BASE"
+        rclone purge $REMOTE/base/$BASE
+      fi
+    done
+    OLDEST=$(rclone lsf --dirs-only $REMOTE/base | tr -d / | %[3]s | head -n 1)
+    if [ -n "$OLDEST" ]; then
+      rclone delete $REMOTE/wal --min-age $(( NOW - OLDEST + 3600 ))s
+    fi
+  fi
+  sleep 30
+done
+`, WALArchiveMountPath, remote.Root(), listBaseBackups, retentionSeconds(archive))
+}
+
+// retentionSeconds returns the retention_days of the WAL archive in seconds
+func retentionSeconds(archive *pulpv1.WALArchive) int32 {
+	days := archive.RetentionDays
+	if days == 0 {
+		days = defaultWALRetentionDays
+	}
+	return days * 86400
+}
+
+// SetWALArchive enables the WAL archiving in the database StatefulSet: it passes the archive settings to
+// postgres, mounts the archive and adds the base-backup sidecar and, for an archive in object storage
+// (remote), the wal-upload sidecar.
+func SetWALArchive(sts *appsv1.StatefulSet, pulp *pulpv1.Pulp, remote *ObjectStorageRemote) {
+	archive := pulp.Spec.Database.WALArchive
+	podSpec := &sts.Spec.Template.Spec
+	mount, volume := WALArchiveMount(archive, settings.DefaultDBPVC(pulp.Name))
+	if volume != nil {
+		podSpec.Volumes = append(podSpec.Volumes, *volume)
+	}
+
+	postgres := &podSpec.Containers[0]
+	postgres.Args = append(postgres.Args, WALArchiveArgs(archive)...)
+	postgres.VolumeMounts = append(postgres.VolumeMounts, mount)
+
+	podSpec.Containers = append(podSpec.Containers, corev1.Container{
+		Name:            "base-backup",
+		Image:           postgres.Image,
+		Command:         []string{"bash", "-c", BaseBackupScript(archive, remote == nil)},
+		Env:             PostgresEnv(settings.DefaultDBSecret(pulp.Name)),
+		VolumeMounts:    []corev1.VolumeMount{mount},
+		SecurityContext: SetDefaultSecurityContext(),
+	})
+	if remote != nil {
+		podSpec.Containers = append(podSpec.Containers, corev1.Container{
+			Name:            "wal-upload",
+			Image:           RcloneImage,
+			Command:         []string{"sh", "-c", WALUploadScript(archive, remote)},
+			Env:             append([]corev1.EnvVar{{Name: "RCLONE_CONFIG", Value: "/tmp/rclone.conf"}}, remote.Env...),
+			VolumeMounts:    []corev1.VolumeMount{mount},
+			SecurityContext: SetDefaultSecurityContext(),
+		})
+	}
+}
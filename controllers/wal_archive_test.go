@@ -0,0 +1,115 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestSetWALArchive verifies the postgres arguments, mounts and sidecars of the database StatefulSet
+// with the WAL archived to a PVC or to object storage
+func TestSetWALArchive(t *testing.T) {
+	remote := &ObjectStorageRemote{Bucket: "pulp-wal", Prefix: "pulp", name: objectStorageRemote}
+	tests := []struct {
+		name             string
+		archive          *pulpv1.WALArchive
+		remote           *ObjectStorageRemote
+		expectVolume     string
+		expectMount      corev1.VolumeMount
+		expectContainers []string
+		expectPrune      bool
+	}{
+		{
+			name:             "pvc",
+			archive:          &pulpv1.WALArchive{PVC: "pulp-wal", ArchiveTimeout: 30},
+			expectVolume:     "pulp-wal",
+			expectMount:      corev1.VolumeMount{Name: walArchiveVolume, MountPath: WALArchiveMountPath},
+			expectContainers: []string{"postgres", "base-backup"},
+			expectPrune:      true,
+		},
+		{
+			name:             "object storage",
+			archive:          &pulpv1.WALArchive{ObjectStorage: &pulpv1.BackupObjectStorage{S3Secret: "minio"}},
+			remote:           remote,
+			expectMount:      corev1.VolumeMount{Name: "pulp-postgres", MountPath: WALArchiveMountPath, SubPath: walSpoolSubPath},
+			expectContainers: []string{"postgres", "base-backup", "wal-upload"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pulp := &pulpv1.Pulp{
+				ObjectMeta: metav1.ObjectMeta{Name: "pulp", Namespace: "test-namespace"},
+				Spec:       pulpv1.PulpSpec{Database: pulpv1.Database{WALArchive: tt.archive}},
+			}
+			sts := &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "postgres", Image: "docker.io/library/postgres:15", Args: []string{"-c", "max_connections=200"}}},
+			}}}}
+			SetWALArchive(sts, pulp, tt.remote)
+
+			podSpec := sts.Spec.Template.Spec
+			containers := []string{}
+			for _, container := range podSpec.Containers {
+				containers = append(containers, container.Name)
+				mounts := container.VolumeMounts
+				if len(mounts) == 0 || mounts[len(mounts)-1] != tt.expectMount {
+					t.Errorf("expected %v mount %+v, got %+v", container.Name, tt.expectMount, mounts)
+				}
+			}
+			if strings.Join(containers, ",") != strings.Join(tt.expectContainers, ",") {
+				t.Errorf("expected containers %v, got %v", tt.expectContainers, containers)
+			}
+
+			args := strings.Join(podSpec.Containers[0].Args, " ")
+			timeout := tt.archive.ArchiveTimeout
+			if timeout == 0 {
+				timeout = defaultArchiveTimeout
+			}
+			for _, arg := range []string{"-c max_connections=200", "-c archive_mode=on", fmt.Sprintf("archive_timeout=%d", timeout), "archive_command=mkdir -p " + WALArchiveMountPath + "/wal"} {
+				if !strings.Contains(args, arg) {
+					t.Errorf("expected postgres args to contain %q, got %q", arg, args)
+				}
+			}
+
+			if tt.expectVolume != "" {
+				if len(podSpec.Volumes) != 1 || podSpec.Volumes[0].PersistentVolumeClaim.ClaimName != tt.expectVolume {
+					t.Errorf("expected the volume of PVC %v, got %+v", tt.expectVolume, podSpec.Volumes)
+				}
+			} else if len(podSpec.Volumes) != 0 {
+				t.Errorf("expected no volume, got %+v", podSpec.Volumes)
+			}
+
+			baseBackup := podSpec.Containers[1].Command[2]
+			if !strings.Contains(baseBackup, "-ge 86400 ]") {
+				t.Errorf("expected a base backup every 24 hours, got %v", baseBackup)
+			}
+			if strings.Contains(baseBackup, "find $ARCHIVE/wal") != tt.expectPrune {
+				t.Errorf("expected base-backup to prune the archive: %v, got %v", tt.expectPrune, baseBackup)
+			}
+			if tt.remote != nil && !strings.Contains(podSpec.Containers[2].Command[2], "REMOTE=backup:pulp-wal/pulp") {
+				t.Errorf("expected wal-upload to upload to backup:pulp-wal/pulp, got %v", podSpec.Containers[2].Command[2])
+			}
+		})
+	}
+}
@@ -45,6 +45,7 @@ import (
 
 	pulpv1 "github.com/pulp/pulp-operator/apis/repo-manager.pulpproject.org/v1"
 	repo_manager_backup "github.com/pulp/pulp-operator/controllers/backup"
+	repo_manager_backup_schedule "github.com/pulp/pulp-operator/controllers/backup_schedule"
 	repo_manager "github.com/pulp/pulp-operator/controllers/repo_manager"
 	repo_manager_restore "github.com/pulp/pulp-operator/controllers/restore"
 	//+kubebuilder:scaffold:imports
@@ -180,25 +181,37 @@ func main() {
 		os.Exit(1)
 	}
 	if err = (&repo_manager_backup.RepoManagerBackupReconciler{
-		Client:     mgr.GetClient(),
-		RawLogger:  mgr.GetLogger(),
-		RESTClient: restClient,
-		RESTConfig: mgr.GetConfig(),
-		Scheme:     mgr.GetScheme(),
+		Client:          mgr.GetClient(),
+		RawLogger:       mgr.GetLogger(),
+		RESTClient:      restClient,
+		RESTConfig:      mgr.GetConfig(),
+		Scheme:          mgr.GetScheme(),
+		OperatorVersion: Version,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "PulpBackup")
 		os.Exit(1)
 	}
 	if err = (&repo_manager_restore.RepoManagerRestoreReconciler{
-		Client:     mgr.GetClient(),
-		RawLogger:  mgr.GetLogger(),
-		RESTClient: restClient,
-		RESTConfig: mgr.GetConfig(),
-		Scheme:     mgr.GetScheme(),
+		Client:          mgr.GetClient(),
+		RawLogger:       mgr.GetLogger(),
+		RESTClient:      restClient,
+		RESTConfig:      mgr.GetConfig(),
+		Scheme:          mgr.GetScheme(),
+		OperatorVersion: Version,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "PulpRestore")
 		os.Exit(1)
 	}
+	if err = (&repo_manager_backup_schedule.RepoManagerBackupScheduleReconciler{
+		Client:         mgr.GetClient(),
+		RawLogger:      mgr.GetLogger(),
+		RESTClient:     restClient,
+		Scheme:         mgr.GetScheme(),
+		WatchNamespace: getWatchNamespace(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "PulpBackupSchedule")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {